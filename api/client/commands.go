@@ -51,6 +51,7 @@ import (
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/runconfig"
 	"github.com/docker/docker/utils"
+	"github.com/docker/libtrust"
 )
 
 const (
@@ -89,6 +90,8 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	rm := cmd.Bool([]string{"#rm", "-rm"}, true, "Remove intermediate containers after a successful build")
 	forceRm := cmd.Bool([]string{"-force-rm"}, false, "Always remove intermediate containers")
 	pull := cmd.Bool([]string{"-pull"}, false, "Always attempt to pull a newer version of the image")
+	squash := cmd.Bool([]string{"-squash"}, false, "Squash the layers created by the build into a single layer")
+	check := cmd.Bool([]string{"-check"}, false, "Check the Dockerfile for problems and print a JSON report without building")
 	dockerfileName := cmd.String([]string{"f", "-file"}, "", "Name of the Dockerfile (Default is 'PATH/Dockerfile')")
 
 	cmd.Require(flag.Exact, 1)
@@ -129,20 +132,12 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	} else {
 		root := cmd.Arg(0)
 		if urlutil.IsGitURL(root) {
-			remoteURL := cmd.Arg(0)
-			if !urlutil.IsGitTransport(remoteURL) {
-				remoteURL = "https://" + remoteURL
-			}
-
-			root, err = ioutil.TempDir("", "docker-build-git")
+			gitRoot, contextDir, err := utils.GitClone(root)
 			if err != nil {
 				return err
 			}
-			defer os.RemoveAll(root)
-
-			if output, err := exec.Command("git", "clone", "--recursive", remoteURL, root).CombinedOutput(); err != nil {
-				return fmt.Errorf("Error trying to use git: %s (%s)", err, output)
-			}
+			defer os.RemoveAll(gitRoot)
+			root = contextDir
 		}
 		if _, err := os.Stat(root); err != nil {
 			return err
@@ -233,9 +228,14 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	// FIXME: ProgressReader shouldn't be this annoying to use
 	if context != nil {
 		sf := utils.NewStreamFormatter(false)
+		progressOut := cli.out
+		if *check {
+			// keep stdout for the JSON report
+			progressOut = cli.err
+		}
 		body = progressreader.New(progressreader.Config{
 			In:        context,
-			Out:       cli.out,
+			Out:       progressOut,
 			Formatter: sf,
 			NewLines:  true,
 			ID:        "",
@@ -283,12 +283,23 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 		v.Set("pull", "1")
 	}
 
+	if *squash {
+		v.Set("squash", "1")
+	}
+
+	if *check {
+		v.Set("check", "1")
+	}
+
+	// Ask for per-step events, the text output is rendered from them.
+	v.Set("structured", "1")
+
 	v.Set("dockerfile", *dockerfileName)
 
 	cli.LoadConfigFile()
 
 	headers := http.Header(make(map[string][]string))
-	buf, err := json.Marshal(cli.configFile)
+	buf, err := json.Marshal(cli.configFile.AllCredentials())
 	if err != nil {
 		return err
 	}
@@ -345,10 +356,7 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 	}
 
 	cli.LoadConfigFile()
-	authconfig, ok := cli.configFile.Configs[serverAddress]
-	if !ok {
-		authconfig = registry.AuthConfig{}
-	}
+	authconfig := cli.configFile.GetAuthConfig(serverAddress)
 
 	if username == "" {
 		promptDefault("Username", authconfig.Username)
@@ -405,7 +413,7 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 
 	stream, statusCode, err := cli.call("POST", "/auth", cli.configFile.Configs[serverAddress], false)
 	if statusCode == 401 {
-		delete(cli.configFile.Configs, serverAddress)
+		registry.RemoveAuthConfig(cli.configFile, serverAddress)
 		registry.SaveConfig(cli.configFile)
 		return err
 	}
@@ -418,8 +426,14 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 		cli.configFile, _ = registry.LoadConfig(homedir.Get())
 		return err
 	}
-	registry.SaveConfig(cli.configFile)
-	fmt.Fprintf(cli.out, "WARNING: login credentials saved in %s.\n", path.Join(homedir.Get(), registry.CONFIGFILE))
+	if err := registry.SaveConfig(cli.configFile); err != nil {
+		return fmt.Errorf("Failed to save docker config: %v", err)
+	}
+	if authconfig.CredsHelper != "" {
+		fmt.Fprintf(cli.out, "Login credentials saved with docker-credential-%s.\n", authconfig.CredsHelper)
+	} else {
+		fmt.Fprintf(cli.out, "WARNING: login credentials saved in %s.\n", path.Join(homedir.Get(), registry.CONFIGFILE))
+	}
 
 	if out2.Get("Status") != "" {
 		fmt.Fprintf(cli.out, "%s\n", out2.Get("Status"))
@@ -439,11 +453,14 @@ func (cli *DockerCli) CmdLogout(args ...string) error {
 	}
 
 	cli.LoadConfigFile()
-	if _, ok := cli.configFile.Configs[serverAddress]; !ok {
+	_, ok := cli.configFile.Configs[serverAddress]
+	if authconfig := cli.configFile.GetAuthConfig(serverAddress); !ok || authconfig.Username == "" && authconfig.CredsHelper != "" {
 		fmt.Fprintf(cli.out, "Not logged in to %s\n", serverAddress)
 	} else {
 		fmt.Fprintf(cli.out, "Remove login credentials for %s\n", serverAddress)
-		delete(cli.configFile.Configs, serverAddress)
+		if err := registry.RemoveAuthConfig(cli.configFile, serverAddress); err != nil {
+			return err
+		}
 
 		if err := registry.SaveConfig(cli.configFile); err != nil {
 			return fmt.Errorf("Failed to save docker config: %v", err)
@@ -621,12 +638,52 @@ func (cli *DockerCli) CmdInfo(args ...string) error {
 	}
 	if len(remoteInfo.GetList("IndexServerAddress")) != 0 {
 		cli.LoadConfigFile()
-		u := cli.configFile.Configs[remoteInfo.Get("IndexServerAddress")].Username
+		u := cli.configFile.GetAuthConfig(remoteInfo.Get("IndexServerAddress")).Username
 		if len(u) > 0 {
 			fmt.Fprintf(cli.out, "Username: %v\n", u)
 			fmt.Fprintf(cli.out, "Registry: %v\n", remoteInfo.GetList("IndexServerAddress"))
 		}
 	}
+	if remoteInfo.Exists("RegistryConfig") {
+		var registryConfig registry.ServiceConfig
+		if err := remoteInfo.GetJson("RegistryConfig", &registryConfig); err != nil {
+			return err
+		}
+		mirrors := make(map[string][]string)
+		for name, m := range registryConfig.Mirrors {
+			mirrors[name] = m
+		}
+		if index, exists := registryConfig.IndexConfigs[registry.IndexServerName()]; exists && len(index.Mirrors) > 0 {
+			mirrors[index.Name] = index.Mirrors
+		}
+		if len(mirrors) > 0 {
+			names := make([]string, 0, len(mirrors))
+			for name := range mirrors {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintln(cli.out, "Registry Mirrors:")
+			for _, name := range names {
+				fmt.Fprintf(cli.out, " %s: %s\n", name, strings.Join(mirrors[name], ", "))
+			}
+		}
+	}
+	if remoteInfo.Exists("PullSources") {
+		var sources []graph.PullSource
+		if err := remoteInfo.GetJson("PullSources", &sources); err != nil {
+			return err
+		}
+		if len(sources) > 0 {
+			fmt.Fprintln(cli.out, "Recent Pulls:")
+			for _, source := range sources {
+				from := source.Mirror
+				if from == "" {
+					from = source.Registry
+				}
+				fmt.Fprintf(cli.out, " %s from %s\n", source.Name, from)
+			}
+		}
+	}
 	if remoteInfo.Exists("MemoryLimit") && !remoteInfo.GetBool("MemoryLimit") {
 		fmt.Fprintf(cli.err, "WARNING: No memory limit support\n")
 	}
@@ -1064,11 +1121,16 @@ func (cli *DockerCli) CmdRmi(args ...string) error {
 		cmd     = cli.Subcmd("rmi", "IMAGE [IMAGE...]", "Remove one or more images", true)
 		force   = cmd.Bool([]string{"f", "-force"}, false, "Force removal of the image")
 		noprune = cmd.Bool([]string{"-no-prune"}, false, "Do not delete untagged parents")
+		remote  = cmd.Bool([]string{"-remote"}, false, "Delete the manifest from the registry, not the image")
 	)
 	cmd.Require(flag.Min, 1)
 
 	utils.ParseFlags(cmd, args, true)
 
+	if *remote && (*force || *noprune) {
+		return fmt.Errorf("Conflicting options: --remote and -f or --no-prune")
+	}
+
 	v := url.Values{}
 	if *force {
 		v.Set("force", "1")
@@ -1076,6 +1138,9 @@ func (cli *DockerCli) CmdRmi(args ...string) error {
 	if *noprune {
 		v.Set("noprune", "1")
 	}
+	if *remote {
+		return cli.deleteRemote(cmd.Args())
+	}
 
 	var encounteredError error
 	for _, name := range cmd.Args() {
@@ -1102,6 +1167,52 @@ func (cli *DockerCli) CmdRmi(args ...string) error {
 	return encounteredError
 }
 
+// deleteRemote deletes the manifests of names from their registries, asking
+// to log in when a registry requires it.
+func (cli *DockerCli) deleteRemote(names []string) error {
+	cli.LoadConfigFile()
+
+	v := url.Values{}
+	v.Set("remote", "1")
+
+	var encounteredError error
+	for _, name := range names {
+		remote, _ := parsers.ParseRepositoryTag(name)
+		repoInfo, err := registry.ParseRepositoryInfo(remote)
+		if err != nil {
+			return err
+		}
+
+		del := func(authConfig registry.AuthConfig) error {
+			buf, err := json.Marshal(authConfig)
+			if err != nil {
+				return err
+			}
+			registryAuthHeader := []string{
+				base64.URLEncoding.EncodeToString(buf),
+			}
+
+			return cli.stream("DELETE", "/images/"+name+"?"+v.Encode(), nil, cli.out, map[string][]string{
+				"X-Registry-Auth": registryAuthHeader,
+			})
+		}
+
+		err = del(cli.configFile.ResolveAuthConfig(repoInfo.Index))
+		if err != nil && strings.Contains(err.Error(), "Authentication is required") {
+			fmt.Fprintln(cli.out, "\nPlease login prior to delete:")
+			if err := cli.CmdLogin(repoInfo.Index.GetAuthConfigKey()); err != nil {
+				return err
+			}
+			err = del(cli.configFile.ResolveAuthConfig(repoInfo.Index))
+		}
+		if err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			encounteredError = fmt.Errorf("Error: failed to remove one or more images")
+		}
+	}
+	return encounteredError
+}
+
 func (cli *DockerCli) CmdHistory(args ...string) error {
 	cmd := cli.Subcmd("history", "IMAGE", "Show the history of an image", true)
 	quiet := cmd.Bool([]string{"q", "-quiet"}, false, "Only show numeric IDs")
@@ -1251,10 +1362,16 @@ func (cli *DockerCli) CmdImport(args ...string) error {
 
 func (cli *DockerCli) CmdPush(args ...string) error {
 	cmd := cli.Subcmd("push", "NAME[:TAG]", "Push an image or a repository to the registry", true)
+	level := cmd.Int([]string{"-compression-level"}, 0, "Gzip level of the layers, 1 (fastest) to 9 (best)")
+	uncompressed := cmd.Bool([]string{"-uncompressed"}, false, "Push the layers as uncompressed tar archives")
 	cmd.Require(flag.Exact, 1)
 
 	utils.ParseFlags(cmd, args, true)
 
+	if *uncompressed && *level != 0 {
+		return fmt.Errorf("Conflicting options: --uncompressed and --compression-level")
+	}
+
 	name := cmd.Arg(0)
 
 	cli.LoadConfigFile()
@@ -1282,6 +1399,12 @@ func (cli *DockerCli) CmdPush(args ...string) error {
 
 	v := url.Values{}
 	v.Set("tag", tag)
+	if *level != 0 {
+		v.Set("level", strconv.Itoa(*level))
+	}
+	if *uncompressed {
+		v.Set("uncompressed", "1")
+	}
 
 	push := func(authConfig registry.AuthConfig) error {
 		buf, err := json.Marshal(authConfig)
@@ -1311,6 +1434,58 @@ func (cli *DockerCli) CmdPush(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdManifestPush(args ...string) error {
+	cmd := cli.Subcmd("manifest push", "NAME[:TAG] SOURCE [SOURCE...]", "Push a manifest list of images of the same repository built for\ndifferent platforms", true)
+	cmd.Require(flag.Min, 2)
+
+	utils.ParseFlags(cmd, args, true)
+
+	cli.LoadConfigFile()
+
+	remote, tag := parsers.ParseRepositoryTag(cmd.Arg(0))
+
+	// Resolve the Repository name from fqn to RepositoryInfo
+	repoInfo, err := registry.ParseRepositoryInfo(remote)
+	if err != nil {
+		return err
+	}
+	// Resolve the Auth config relevant for this server
+	authConfig := cli.configFile.ResolveAuthConfig(repoInfo.Index)
+
+	v := url.Values{}
+	v.Set("tag", tag)
+	for _, source := range cmd.Args()[1:] {
+		v.Add("source", source)
+	}
+
+	push := func(authConfig registry.AuthConfig) error {
+		buf, err := json.Marshal(authConfig)
+		if err != nil {
+			return err
+		}
+		registryAuthHeader := []string{
+			base64.URLEncoding.EncodeToString(buf),
+		}
+
+		return cli.stream("POST", "/images/"+remote+"/manifest?"+v.Encode(), nil, cli.out, map[string][]string{
+			"X-Registry-Auth": registryAuthHeader,
+		})
+	}
+
+	if err := push(authConfig); err != nil {
+		if strings.Contains(err.Error(), "Status 401") {
+			fmt.Fprintln(cli.out, "\nPlease login prior to push:")
+			if err := cli.CmdLogin(repoInfo.Index.GetAuthConfigKey()); err != nil {
+				return err
+			}
+			authConfig := cli.configFile.ResolveAuthConfig(repoInfo.Index)
+			return push(authConfig)
+		}
+		return err
+	}
+	return nil
+}
+
 func (cli *DockerCli) CmdPull(args ...string) error {
 	cmd := cli.Subcmd("pull", "NAME[:TAG|@DIGEST]", "Pull an image or a repository from the registry", true)
 	allTags := cmd.Bool([]string{"a", "-all-tags"}, false, "Download all tagged images in the repository")
@@ -1897,11 +2072,16 @@ func (cli *DockerCli) CmdEvents(args ...string) error {
 
 func (cli *DockerCli) CmdExport(args ...string) error {
 	cmd := cli.Subcmd("export", "CONTAINER", "Export the contents of a filesystem as a tar archive to STDOUT", true)
+	level := cmd.Int([]string{"-compression-level"}, 0, "Gzip the archive, from 1 (fastest) to 9 (best)")
 	cmd.Require(flag.Exact, 1)
 
 	utils.ParseFlags(cmd, args, true)
 
-	if err := cli.stream("GET", "/containers/"+cmd.Arg(0)+"/export", nil, cli.out, nil); err != nil {
+	v := url.Values{}
+	if *level != 0 {
+		v.Set("level", strconv.Itoa(*level))
+	}
+	if err := cli.stream("GET", "/containers/"+cmd.Arg(0)+"/export?"+v.Encode(), nil, cli.out, nil); err != nil {
 		return err
 	}
 	return nil
@@ -1938,6 +2118,74 @@ func (cli *DockerCli) CmdDiff(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdImageDiff(args ...string) error {
+	cmd := cli.Subcmd("image diff", "FROM TO", "Show the changes between the filesystems and configs of two images", true)
+	cmd.Require(flag.Exact, 2)
+
+	utils.ParseFlags(cmd, args, true)
+
+	v := url.Values{}
+	v.Set("from", cmd.Arg(0))
+	body, _, err := readBody(cli.call("GET", "/images/"+cmd.Arg(1)+"/diff?"+v.Encode(), nil, false))
+	if err != nil {
+		return err
+	}
+
+	out := &engine.Env{}
+	if err := out.Decode(bytes.NewReader(body)); err != nil {
+		return err
+	}
+	var (
+		changes       []graph.ImageChange
+		configChanges []graph.ConfigChange
+	)
+	if err := out.GetJson("Changes", &changes); err != nil {
+		return err
+	}
+	if err := out.GetJson("Config", &configChanges); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	for _, change := range changes {
+		size := units.HumanSize(float64(change.Size))
+		if change.Kind == archive.ChangeModify {
+			delta := change.SizeDelta
+			sign := "+"
+			if delta < 0 {
+				sign, delta = "-", -delta
+			}
+			size = fmt.Sprintf("%s (%s%s)", size, sign, units.HumanSize(float64(delta)))
+		}
+		fmt.Fprintf(w, "%s\t%s\n", &change.Change, size)
+	}
+	w.Flush()
+
+	if len(configChanges) > 0 && len(changes) > 0 {
+		fmt.Fprintln(cli.out)
+	}
+	for _, change := range configChanges {
+		name := change.Field
+		if change.Key != "" {
+			name += " " + change.Key
+		}
+		var kind, value string
+		switch change.Kind {
+		case archive.ChangeModify:
+			kind, value = "C", change.Old+" -> "+change.New
+		case archive.ChangeAdd:
+			kind, value = "A", change.New
+		case archive.ChangeDelete:
+			kind, value = "D", change.Old
+		}
+		if value != "" {
+			name += ": " + value
+		}
+		fmt.Fprintf(cli.out, "%s %s\n", kind, name)
+	}
+	return nil
+}
+
 func (cli *DockerCli) CmdLogs(args ...string) error {
 	var (
 		cmd    = cli.Subcmd("logs", "CONTAINER", "Fetch the logs of a container", true)
@@ -2059,13 +2307,21 @@ func (cli *DockerCli) CmdSearch(args ...string) error {
 	trusted := cmd.Bool([]string{"#t", "#trusted", "#-trusted"}, false, "Only show trusted builds")
 	automated := cmd.Bool([]string{"-automated"}, false, "Only show automated builds")
 	stars := cmd.Int([]string{"s", "#stars", "-stars"}, 0, "Only displays with at least x stars")
-	cmd.Require(flag.Exact, 1)
+	index := cmd.String([]string{"-registry"}, "", "List the repositories of a v2 registry instead")
+	cmd.Require(flag.Max, 1)
 
 	utils.ParseFlags(cmd, args, true)
 
 	v := url.Values{}
 	v.Set("term", cmd.Arg(0))
 
+	if *index != "" {
+		return cli.searchCatalog(*index, v)
+	}
+	if cmd.NArg() != 1 {
+		utils.ReportError(cmd, "\"search\" requires 1 argument", true)
+	}
+
 	body, _, err := readBody(cli.call("GET", "/images/search?"+v.Encode(), nil, true))
 
 	if err != nil {
@@ -2101,9 +2357,124 @@ func (cli *DockerCli) CmdSearch(args ...string) error {
 	return nil
 }
 
+// searchCatalog lists the repositories of a v2 registry whose name contains
+// the search term, if any.
+func (cli *DockerCli) searchCatalog(indexName string, v url.Values) error {
+	cli.LoadConfigFile()
+
+	index, err := registry.ParseIndexInfo(indexName)
+	if err != nil {
+		return err
+	}
+	authConfig := cli.configFile.ResolveAuthConfig(index)
+	v.Set("registry", indexName)
+
+	body, _, err := readBody(cli.callWithAuth("GET", "/images/search?"+v.Encode(), nil, &authConfig))
+	if err != nil {
+		return err
+	}
+	outs := engine.NewTable("", 0)
+	if _, err := outs.ReadListFrom(body); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cli.out, 10, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "NAME\n")
+	for _, out := range outs.Data {
+		fmt.Fprintf(w, "%s\n", out.Get("name"))
+	}
+	w.Flush()
+	return nil
+}
+
+func (cli *DockerCli) CmdTags(args ...string) error {
+	cmd := cli.Subcmd("tags", "NAME", "List the tags of a repository in its v2 registry", true)
+	cmd.Require(flag.Exact, 1)
+
+	utils.ParseFlags(cmd, args, true)
+
+	cli.LoadConfigFile()
+
+	name := cmd.Arg(0)
+	repoInfo, err := registry.ParseRepositoryInfo(name)
+	if err != nil {
+		return err
+	}
+	authConfig := cli.configFile.ResolveAuthConfig(repoInfo.Index)
+
+	body, _, err := readBody(cli.callWithAuth("GET", "/images/"+name+"/tags", nil, &authConfig))
+	if err != nil {
+		return err
+	}
+	outs := engine.NewTable("", 0)
+	if _, err := outs.ReadListFrom(body); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "REPOSITORY\tTAG\n")
+	for _, out := range outs.Data {
+		fmt.Fprintf(w, "%s\t%s\n", repoInfo.CanonicalName, out.Get("Tag"))
+	}
+	w.Flush()
+	return nil
+}
+
 // Ports type - Used to parse multiple -p flags
 type ports []int
 
+func (cli *DockerCli) CmdSign(args ...string) error {
+	cmd := cli.Subcmd("sign", "NAME[:TAG]", "Push an image and sign its manifest with your key", true)
+	cmd.Require(flag.Exact, 1)
+
+	utils.ParseFlags(cmd, args, true)
+
+	remote, tag := parsers.ParseRepositoryTag(cmd.Arg(0))
+	if tag == "" {
+		tag = graph.DEFAULTTAG
+	}
+	key, err := api.LoadOrCreateTrustKey(cli.keyFile)
+	if err != nil {
+		return err
+	}
+
+	// The layers have to be in the registry before the manifest
+	if err := cli.CmdPush(utils.ImageReference(remote, tag)); err != nil {
+		return err
+	}
+
+	v := url.Values{}
+	v.Set("tag", tag)
+	payload, _, err := readBody(cli.call("GET", "/images/"+remote+"/manifest?"+v.Encode(), nil, false))
+	if err != nil {
+		return err
+	}
+	js, err := libtrust.NewJSONSignature(payload)
+	if err != nil {
+		return err
+	}
+	if err := js.Sign(key); err != nil {
+		return err
+	}
+	signed, err := js.PrettySignature("signatures")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Signed the manifest of %s with key %s\n", utils.ImageReference(remote, tag), key.KeyID())
+
+	cli.LoadConfigFile()
+	repoInfo, err := registry.ParseRepositoryInfo(remote)
+	if err != nil {
+		return err
+	}
+	authConfig := cli.configFile.ResolveAuthConfig(repoInfo.Index)
+	buf, err := json.Marshal(authConfig)
+	if err != nil {
+		return err
+	}
+	return cli.stream("POST", "/images/"+remote+"/signed?"+v.Encode(), bytes.NewReader(signed), cli.out, map[string][]string{
+		"X-Registry-Auth": {base64.URLEncoding.EncodeToString(buf)},
+	})
+}
+
 func (cli *DockerCli) CmdTag(args ...string) error {
 	cmd := cli.Subcmd("tag", "IMAGE[:TAG] [REGISTRYHOST/][USERNAME/]NAME[:TAG]", "Tag an image into a repository", true)
 	force := cmd.Bool([]string{"f", "#force", "-force"}, false, "Force")
@@ -2211,12 +2582,27 @@ func (cid *cidFile) Write(id string) error {
 	return nil
 }
 
-func (cli *DockerCli) createContainer(config *runconfig.Config, hostConfig *runconfig.HostConfig, cidfile, name string) (*types.ContainerCreateResponse, error) {
+func (cli *DockerCli) createContainer(config *runconfig.Config, hostConfig *runconfig.HostConfig, cidfile, name, pull string) (*types.ContainerCreateResponse, error) {
+	if err := graph.ValidatePullPolicy(pull); err != nil {
+		return nil, err
+	}
 	containerValues := url.Values{}
 	if name != "" {
 		containerValues.Set("name", name)
 	}
 
+	// The daemon pulls the image itself when it must check for a newer one
+	var authConfig *registry.AuthConfig
+	if pull == graph.PullAlways {
+		containerValues.Set("pull", pull)
+		cli.LoadConfigFile()
+		repo, _ := parsers.ParseRepositoryTag(config.Image)
+		if repoInfo, err := registry.ParseRepositoryInfo(repo); err == nil {
+			resolved := cli.configFile.ResolveAuthConfig(repoInfo.Index)
+			authConfig = &resolved
+		}
+	}
+
 	mergedConfig := runconfig.MergeConfigs(config, hostConfig)
 
 	var containerIDFile *cidFile
@@ -2229,9 +2615,9 @@ func (cli *DockerCli) createContainer(config *runconfig.Config, hostConfig *runc
 	}
 
 	//create the container
-	stream, statusCode, err := cli.call("POST", "/containers/create?"+containerValues.Encode(), mergedConfig, false)
+	stream, statusCode, err := cli.callWithAuth("POST", "/containers/create?"+containerValues.Encode(), mergedConfig, authConfig)
 	//if image not found try to pull it
-	if statusCode == 404 {
+	if statusCode == 404 && pull != graph.PullNever {
 		repo, tag := parsers.ParseRepositoryTag(config.Image)
 		if tag == "" {
 			tag = graph.DEFAULTTAG
@@ -2271,6 +2657,7 @@ func (cli *DockerCli) CmdCreate(args ...string) error {
 	// These are flags not stored in Config/HostConfig
 	var (
 		flName = cmd.String([]string{"-name"}, "", "Assign a name to the container")
+		flPull = cmd.String([]string{"-pull"}, graph.PullMissing, "Pull the image: always, missing or never")
 	)
 
 	config, hostConfig, cmd, err := runconfig.Parse(cmd, args)
@@ -2281,7 +2668,7 @@ func (cli *DockerCli) CmdCreate(args ...string) error {
 		cmd.Usage()
 		return nil
 	}
-	response, err := cli.createContainer(config, hostConfig, hostConfig.ContainerIDFile, *flName)
+	response, err := cli.createContainer(config, hostConfig, hostConfig.ContainerIDFile, *flName, *flPull)
 	if err != nil {
 		return err
 	}
@@ -2299,6 +2686,7 @@ func (cli *DockerCli) CmdRun(args ...string) error {
 		flDetach     = cmd.Bool([]string{"d", "-detach"}, false, "Run container in background and print container ID")
 		flSigProxy   = cmd.Bool([]string{"#sig-proxy", "-sig-proxy"}, true, "Proxy received signals to the process")
 		flName       = cmd.String([]string{"#name", "-name"}, "", "Assign a name to the container")
+		flPull       = cmd.String([]string{"-pull"}, graph.PullMissing, "Pull the image: always, missing or never")
 		flAttach     *opts.ListOpts
 
 		ErrConflictAttachDetach               = fmt.Errorf("Conflicting options: -a and -d")
@@ -2355,7 +2743,7 @@ func (cli *DockerCli) CmdRun(args ...string) error {
 		sigProxy = false
 	}
 
-	createResponse, err := cli.createContainer(config, hostConfig, hostConfig.ContainerIDFile, *flName)
+	createResponse, err := cli.createContainer(config, hostConfig, hostConfig.ContainerIDFile, *flName, *flPull)
 	if err != nil {
 		return err
 	}
@@ -2538,14 +2926,31 @@ func (cli *DockerCli) CmdCp(args ...string) error {
 func (cli *DockerCli) CmdSave(args ...string) error {
 	cmd := cli.Subcmd("save", "IMAGE [IMAGE...]", "Save an image(s) to a tar archive (streamed to STDOUT by default)", true)
 	outfile := cmd.String([]string{"o", "-output"}, "", "Write to an file, instead of STDOUT")
+	format := cmd.String([]string{"-format"}, "", "Archive layout, \"oci\" for the OCI image layout")
+	compress := cmd.Bool([]string{"-compress"}, false, "Gzip the layers of OCI image layouts")
+	level := cmd.Int([]string{"-compression-level"}, 0, "Gzip at this level, from 1 (fastest) to 9 (best)")
 	cmd.Require(flag.Min, 1)
 
 	utils.ParseFlags(cmd, args, true)
 
+	if *compress && *format != "oci" {
+		return fmt.Errorf("--compress requires --format=oci")
+	}
+
 	var (
 		output io.Writer = cli.out
 		err    error
+		v      = url.Values{}
 	)
+	if *format != "" {
+		v.Set("format", *format)
+	}
+	if *compress {
+		v.Set("compress", "1")
+	}
+	if *level != 0 {
+		v.Set("level", strconv.Itoa(*level))
+	}
 	if *outfile != "" {
 		output, err = os.Create(*outfile)
 		if err != nil {
@@ -2557,11 +2962,10 @@ func (cli *DockerCli) CmdSave(args ...string) error {
 
 	if len(cmd.Args()) == 1 {
 		image := cmd.Arg(0)
-		if err := cli.stream("GET", "/images/"+image+"/get", nil, output, nil); err != nil {
+		if err := cli.stream("GET", "/images/"+image+"/get?"+v.Encode(), nil, output, nil); err != nil {
 			return err
 		}
 	} else {
-		v := url.Values{}
 		for _, arg := range cmd.Args() {
 			v.Add("names", arg)
 		}
@@ -2864,3 +3268,369 @@ func calculateCpuPercent(previousCpu, previousSystem uint64, v *types.Stats) flo
 	}
 	return cpuPercent
 }
+
+// confirm prints message and asks the user whether to continue. It returns
+// true if the answer is yes.
+func (cli *DockerCli) confirm(message string) bool {
+	fmt.Fprintf(cli.out, "%s\nAre you sure you want to continue? [y/N] ", message)
+	answer, _ := bufio.NewReader(cli.in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// prune calls one of the prune endpoints with the given filter flags.
+func (cli *DockerCli) prune(path string, flFilter opts.ListOpts, v url.Values) (*engine.Env, error) {
+	pruneFilterArgs := filters.Args{}
+	for _, f := range flFilter.GetAll() {
+		var err error
+		pruneFilterArgs, err = filters.ParseFlag(f, pruneFilterArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(pruneFilterArgs) > 0 {
+		filterJson, err := filters.ToParam(pruneFilterArgs)
+		if err != nil {
+			return nil, err
+		}
+		v.Set("filters", filterJson)
+	}
+
+	body, _, err := readBody(cli.call("POST", path+"?"+v.Encode(), nil, false))
+	if err != nil {
+		return nil, err
+	}
+	out := &engine.Env{}
+	if err := out.Decode(bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (cli *DockerCli) pruneContainers(flFilter opts.ListOpts) (int64, error) {
+	out, err := cli.prune("/containers/prune", flFilter, url.Values{})
+	if err != nil {
+		return 0, err
+	}
+	if deleted := out.GetList("ContainersDeleted"); len(deleted) > 0 {
+		fmt.Fprintln(cli.out, "Deleted Containers:")
+		for _, id := range deleted {
+			fmt.Fprintln(cli.out, id)
+		}
+		fmt.Fprintln(cli.out)
+	}
+	return out.GetInt64("SpaceReclaimed"), nil
+}
+
+func (cli *DockerCli) pruneImages(all bool, flFilter opts.ListOpts) (int64, error) {
+	v := url.Values{}
+	if all {
+		v.Set("all", "1")
+	}
+	out, err := cli.prune("/images/prune", flFilter, v)
+	if err != nil {
+		return 0, err
+	}
+	var deleted []map[string]string
+	if err := out.GetJson("ImagesDeleted", &deleted); err != nil {
+		return 0, err
+	}
+	if len(deleted) > 0 {
+		fmt.Fprintln(cli.out, "Deleted Images:")
+		for _, d := range deleted {
+			if d["Deleted"] != "" {
+				fmt.Fprintf(cli.out, "Deleted: %s\n", d["Deleted"])
+			} else {
+				fmt.Fprintf(cli.out, "Untagged: %s\n", d["Untagged"])
+			}
+		}
+		fmt.Fprintln(cli.out)
+	}
+	return out.GetInt64("SpaceReclaimed"), nil
+}
+
+func (cli *DockerCli) pruneVolumes(flFilter opts.ListOpts) (int64, error) {
+	out, err := cli.prune("/volumes/prune", flFilter, url.Values{})
+	if err != nil {
+		return 0, err
+	}
+	if deleted := out.GetList("VolumesDeleted"); len(deleted) > 0 {
+		fmt.Fprintln(cli.out, "Deleted Volumes:")
+		for _, id := range deleted {
+			fmt.Fprintln(cli.out, id)
+		}
+		fmt.Fprintln(cli.out)
+	}
+	return out.GetInt64("SpaceReclaimed"), nil
+}
+
+func (cli *DockerCli) CmdContainerPrune(args ...string) error {
+	cmd := cli.Subcmd("container prune", "", "Remove all stopped containers", true)
+	force := cmd.Bool([]string{"f", "-force"}, false, "Do not prompt for confirmation")
+	flFilter := opts.NewListOpts(nil)
+	cmd.Var(&flFilter, []string{"-filter"}, "Filter by until=<timestamp> or label=<key>[=<value>]")
+	cmd.Require(flag.Exact, 0)
+
+	utils.ParseFlags(cmd, args, true)
+
+	if !*force && !cli.confirm("WARNING! This will remove all stopped containers.") {
+		return nil
+	}
+	reclaimed, err := cli.pruneContainers(flFilter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Total reclaimed space: %s\n", units.HumanSize(float64(reclaimed)))
+	return nil
+}
+
+func (cli *DockerCli) CmdImagePrune(args ...string) error {
+	cmd := cli.Subcmd("image prune", "", "Remove unused images", true)
+	all := cmd.Bool([]string{"a", "-all"}, false, "Remove all unused images, not just dangling ones")
+	force := cmd.Bool([]string{"f", "-force"}, false, "Do not prompt for confirmation")
+	flFilter := opts.NewListOpts(nil)
+	cmd.Var(&flFilter, []string{"-filter"}, "Filter by until=<timestamp> or label=<key>[=<value>]")
+	cmd.Require(flag.Exact, 0)
+
+	utils.ParseFlags(cmd, args, true)
+
+	warning := "WARNING! This will remove all dangling images."
+	if *all {
+		warning = "WARNING! This will remove all images without at least one container associated to them."
+	}
+	if !*force && !cli.confirm(warning) {
+		return nil
+	}
+	reclaimed, err := cli.pruneImages(*all, flFilter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Total reclaimed space: %s\n", units.HumanSize(float64(reclaimed)))
+	return nil
+}
+
+func (cli *DockerCli) CmdImageRetention(args ...string) error {
+	cmd := cli.Subcmd("image retention", "", "Apply the image retention policy of the daemon", true)
+	dryRun := cmd.Bool([]string{"-dry-run"}, false, "Only list the tags and images to remove")
+	cmd.Require(flag.Exact, 0)
+
+	utils.ParseFlags(cmd, args, true)
+
+	v := url.Values{}
+	if *dryRun {
+		v.Set("dryrun", "1")
+	}
+	body, _, err := readBody(cli.call("POST", "/images/retention?"+v.Encode(), nil, false))
+	if err != nil {
+		return err
+	}
+	out := &engine.Env{}
+	if err := out.Decode(bytes.NewReader(body)); err != nil {
+		return err
+	}
+	var deleted []map[string]string
+	if err := out.GetJson("ImagesDeleted", &deleted); err != nil {
+		return err
+	}
+	untagged, removed := "Untagged", "Deleted"
+	if *dryRun {
+		untagged, removed = "Would untag", "Would delete"
+	}
+	for _, d := range deleted {
+		if d["Deleted"] != "" {
+			fmt.Fprintf(cli.out, "%s: %s\n", removed, d["Deleted"])
+		} else {
+			fmt.Fprintf(cli.out, "%s: %s\n", untagged, d["Untagged"])
+		}
+	}
+	if *dryRun {
+		fmt.Fprintf(cli.out, "Total reclaimable space: %s\n", units.HumanSize(float64(out.GetInt64("SpaceReclaimed"))))
+	} else {
+		fmt.Fprintf(cli.out, "Total reclaimed space: %s\n", units.HumanSize(float64(out.GetInt64("SpaceReclaimed"))))
+	}
+	return nil
+}
+
+func (cli *DockerCli) CmdVolumePrune(args ...string) error {
+	cmd := cli.Subcmd("volume prune", "", "Remove all volumes not used by a container", true)
+	force := cmd.Bool([]string{"f", "-force"}, false, "Do not prompt for confirmation")
+	flFilter := opts.NewListOpts(nil)
+	cmd.Var(&flFilter, []string{"-filter"}, "Filter by until=<timestamp>")
+	cmd.Require(flag.Exact, 0)
+
+	utils.ParseFlags(cmd, args, true)
+
+	if !*force && !cli.confirm("WARNING! This will remove all volumes not used by at least one container.") {
+		return nil
+	}
+	reclaimed, err := cli.pruneVolumes(flFilter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Total reclaimed space: %s\n", units.HumanSize(float64(reclaimed)))
+	return nil
+}
+
+func (cli *DockerCli) CmdSystemDf(args ...string) error {
+	cmd := cli.Subcmd("system df", "", "Show docker disk usage", true)
+	verbose := cmd.Bool([]string{"v", "-verbose"}, false, "Show detailed information on space usage")
+	cmd.Require(flag.Exact, 0)
+
+	utils.ParseFlags(cmd, args, true)
+
+	stream, _, err := cli.call("GET", "/system/df", nil, false)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	var du types.DiskUsage
+	if err := json.NewDecoder(stream).Decode(&du); err != nil {
+		return err
+	}
+
+	if *verbose {
+		return cli.printDiskUsageVerbose(&du)
+	}
+
+	var (
+		activeImages, activeContainers, activeVolumes int
+		imagesReclaimable, containersSize             int64
+		containersReclaimable, logsSize               int64
+		volumesSize, volumesReclaimable               int64
+	)
+	for _, img := range du.Images {
+		if img.Containers > 0 {
+			activeImages++
+		} else {
+			imagesReclaimable += img.Size - img.SharedSize
+		}
+	}
+	for _, c := range du.Containers {
+		containersSize += c.SizeRw
+		logsSize += c.LogSize
+		if c.Running {
+			activeContainers++
+		} else {
+			containersReclaimable += c.SizeRw
+		}
+	}
+	for _, v := range du.Volumes {
+		if v.Size > 0 {
+			volumesSize += v.Size
+		}
+		if v.Containers > 0 {
+			activeVolumes++
+		} else if v.Size > 0 {
+			volumesReclaimable += v.Size
+		}
+	}
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE")
+	fmt.Fprintf(w, "Images\t%d\t%d\t%s\t%s\n", len(du.Images), activeImages, units.HumanSize(float64(du.LayersSize)), reclaimable(imagesReclaimable, du.LayersSize))
+	fmt.Fprintf(w, "Containers\t%d\t%d\t%s\t%s\n", len(du.Containers), activeContainers, units.HumanSize(float64(containersSize)), reclaimable(containersReclaimable, containersSize))
+	fmt.Fprintf(w, "Local Volumes\t%d\t%d\t%s\t%s\n", len(du.Volumes), activeVolumes, units.HumanSize(float64(volumesSize)), reclaimable(volumesReclaimable, volumesSize))
+	fmt.Fprintf(w, "Logs\t\t\t%s\t\n", units.HumanSize(float64(logsSize)))
+	return w.Flush()
+}
+
+// reclaimable formats size as a human readable size and a percentage of
+// total.
+func reclaimable(size, total int64) string {
+	if total <= 0 {
+		return units.HumanSize(float64(size))
+	}
+	return fmt.Sprintf("%s (%d%%)", units.HumanSize(float64(size)), size*100/total)
+}
+
+func (cli *DockerCli) printDiskUsageVerbose(du *types.DiskUsage) error {
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprint(w, "Images space usage:\n\n")
+	fmt.Fprintln(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\tSHARED SIZE\tUNIQUE SIZE\tCONTAINERS")
+	for _, img := range du.Images {
+		repoTags := img.RepoTags
+		if len(repoTags) == 0 {
+			repoTags = []string{"<none>:<none>"}
+		}
+		for _, repoTag := range repoTags {
+			repo, tag := parsers.ParseRepositoryTag(repoTag)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\t%s\t%s\t%d\n", repo, tag, common.TruncateID(img.ID),
+				units.HumanDuration(time.Now().UTC().Sub(time.Unix(img.Created, 0))),
+				units.HumanSize(float64(img.Size)), units.HumanSize(float64(img.SharedSize)),
+				units.HumanSize(float64(img.Size-img.SharedSize)), img.Containers)
+		}
+	}
+
+	fmt.Fprint(w, "\nContainers space usage:\n\n")
+	fmt.Fprintln(w, "CONTAINER ID\tIMAGE\tCREATED\tSTATUS\tSIZE\tLOG SIZE\tNAMES")
+	for _, c := range du.Containers {
+		status := "Stopped"
+		if c.Running {
+			status = "Running"
+		}
+		names := make([]string, 0, len(c.Names))
+		for _, name := range c.Names {
+			names = append(names, strings.TrimPrefix(name, "/"))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s ago\t%s\t%s\t%s\t%s\n", common.TruncateID(c.ID), c.Image,
+			units.HumanDuration(time.Now().UTC().Sub(time.Unix(c.Created, 0))), status,
+			units.HumanSize(float64(c.SizeRw)), units.HumanSize(float64(c.LogSize)), strings.Join(names, ","))
+	}
+
+	fmt.Fprint(w, "\nLocal Volumes space usage:\n\n")
+	fmt.Fprintln(w, "VOLUME ID\tCONTAINERS\tSIZE")
+	for _, v := range du.Volumes {
+		size := "N/A"
+		if v.Size >= 0 {
+			size = units.HumanSize(float64(v.Size))
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", common.TruncateID(v.ID), v.Containers, size)
+	}
+	return w.Flush()
+}
+
+func (cli *DockerCli) CmdSystemPrune(args ...string) error {
+	cmd := cli.Subcmd("system prune", "", "Remove stopped containers, dangling images and, optionally, unused volumes", true)
+	all := cmd.Bool([]string{"a", "-all"}, false, "Remove all unused images, not just dangling ones")
+	volumes := cmd.Bool([]string{"-volumes"}, false, "Remove volumes not used by a container")
+	force := cmd.Bool([]string{"f", "-force"}, false, "Do not prompt for confirmation")
+	flFilter := opts.NewListOpts(nil)
+	cmd.Var(&flFilter, []string{"-filter"}, "Filter by until=<timestamp> or label=<key>[=<value>]")
+	cmd.Require(flag.Exact, 0)
+
+	utils.ParseFlags(cmd, args, true)
+
+	warning := "WARNING! This will remove:\n  - all stopped containers\n"
+	if *volumes {
+		warning += "  - all volumes not used by at least one container\n"
+	}
+	if *all {
+		warning += "  - all images without at least one container associated to them"
+	} else {
+		warning += "  - all dangling images"
+	}
+	if !*force && !cli.confirm(warning) {
+		return nil
+	}
+
+	// Containers go first, so that the images and volumes they used can be
+	// removed too.
+	reclaimed, err := cli.pruneContainers(flFilter)
+	if err != nil {
+		return err
+	}
+	if *volumes {
+		n, err := cli.pruneVolumes(flFilter)
+		if err != nil {
+			return err
+		}
+		reclaimed += n
+	}
+	n, err := cli.pruneImages(*all, flFilter)
+	if err != nil {
+		return err
+	}
+	reclaimed += n
+	fmt.Fprintf(cli.out, "Total reclaimed space: %s\n", units.HumanSize(float64(reclaimed)))
+	return nil
+}
@@ -55,6 +55,19 @@ func (cli *DockerCli) encodeData(data interface{}) (*bytes.Buffer, error) {
 }
 
 func (cli *DockerCli) call(method, path string, data interface{}, passAuthInfo bool) (io.ReadCloser, int, error) {
+	var authConfig *registry.AuthConfig
+	if passAuthInfo {
+		cli.LoadConfigFile()
+		// Resolve the Auth config relevant for this server
+		indexAuthConfig := cli.configFile.GetAuthConfig(registry.IndexServerAddress())
+		authConfig = &indexAuthConfig
+	}
+	return cli.callWithAuth(method, path, data, authConfig)
+}
+
+// callWithAuth is like call, but passes authConfig to the daemon, when it is
+// not nil, for requests to registries other than Docker Hub.
+func (cli *DockerCli) callWithAuth(method, path string, data interface{}, authConfig *registry.AuthConfig) (io.ReadCloser, int, error) {
 	params, err := cli.encodeData(data)
 	if err != nil {
 		return nil, -1, err
@@ -63,10 +76,7 @@ func (cli *DockerCli) call(method, path string, data interface{}, passAuthInfo b
 	if err != nil {
 		return nil, -1, err
 	}
-	if passAuthInfo {
-		cli.LoadConfigFile()
-		// Resolve the Auth config relevant for this server
-		authConfig := cli.configFile.Configs[registry.IndexServerAddress()]
+	if authConfig != nil {
 		getHeaders := func(authConfig registry.AuthConfig) (map[string][]string, error) {
 			buf, err := json.Marshal(authConfig)
 			if err != nil {
@@ -77,7 +87,7 @@ func (cli *DockerCli) call(method, path string, data interface{}, passAuthInfo b
 			}
 			return map[string][]string{"X-Registry-Auth": registryAuthHeader}, nil
 		}
-		if headers, err := getHeaders(authConfig); err == nil && headers != nil {
+		if headers, err := getHeaders(*authConfig); err == nil && headers != nil {
 			for k, v := range headers {
 				req.Header[k] = v
 			}
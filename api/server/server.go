@@ -256,7 +256,11 @@ func getContainersExport(eng *engine.Engine, version version.Version, w http.Res
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
 	job := eng.Job("export", vars["name"])
+	job.Setenv("compressionLevel", r.Form.Get("level"))
 	job.Stdout.Add(w)
 	if err := job.Run(); err != nil {
 		return err
@@ -328,6 +332,12 @@ func getInfo(eng *engine.Engine, version version.Version, w http.ResponseWriter,
 	return nil
 }
 
+func getSystemDF(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("system_df")
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
 func getEvents(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -355,6 +365,23 @@ func getImagesHistory(eng *engine.Engine, version version.Version, w http.Respon
 	return nil
 }
 
+func getImagesDiff(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	from := r.Form.Get("from")
+	if from == "" {
+		return fmt.Errorf("Missing parameter: from")
+	}
+
+	var job = eng.Job("image_diff", from, vars["name"])
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
 func getContainersChanges(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -624,7 +651,44 @@ func getImagesSearch(eng *engine.Engine, version version.Version, w http.Respons
 		}
 	}
 
-	var job = eng.Job("search", r.Form.Get("term"))
+	var job *engine.Job
+	if index := r.Form.Get("registry"); index != "" && version.GreaterThanOrEqualTo("1.18") {
+		job = eng.Job("catalog", index)
+		job.Setenv("term", r.Form.Get("term"))
+	} else {
+		job = eng.Job("search", r.Form.Get("term"))
+	}
+	job.SetenvJson("metaHeaders", metaHeaders)
+	job.SetenvJson("authConfig", authConfig)
+	streamJSON(job, w, false)
+
+	return job.Run()
+}
+
+func getImagesTags(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	var (
+		authEncoded = r.Header.Get("X-Registry-Auth")
+		authConfig  = &registry.AuthConfig{}
+		metaHeaders = map[string][]string{}
+	)
+
+	if authEncoded != "" {
+		authJson := base64.NewDecoder(base64.URLEncoding, strings.NewReader(authEncoded))
+		if err := json.NewDecoder(authJson).Decode(authConfig); err != nil {
+			// listing the tags of public repositories needs no auth
+			authConfig = &registry.AuthConfig{}
+		}
+	}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Meta-") {
+			metaHeaders[k] = v
+		}
+	}
+
+	var job = eng.Job("remote_tags", vars["name"])
 	job.SetenvJson("metaHeaders", metaHeaders)
 	job.SetenvJson("authConfig", authConfig)
 	streamJSON(job, w, false)
@@ -667,6 +731,8 @@ func postImagesPush(eng *engine.Engine, version version.Version, w http.Response
 	job.SetenvJson("metaHeaders", metaHeaders)
 	job.SetenvJson("authConfig", authConfig)
 	job.Setenv("tag", r.Form.Get("tag"))
+	job.Setenv("compressionLevel", r.Form.Get("level"))
+	job.Setenv("uncompressed", r.Form.Get("uncompressed"))
 	if version.GreaterThan("1.0") {
 		job.SetenvBool("json", true)
 		streamJSON(job, w, true)
@@ -684,6 +750,104 @@ func postImagesPush(eng *engine.Engine, version version.Version, w http.Response
 	return nil
 }
 
+func postImagesManifestList(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	metaHeaders := map[string][]string{}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Meta-") {
+			metaHeaders[k] = v
+		}
+	}
+	authConfig := &registry.AuthConfig{}
+	if authEncoded := r.Header.Get("X-Registry-Auth"); authEncoded != "" {
+		authJson := base64.NewDecoder(base64.URLEncoding, strings.NewReader(authEncoded))
+		if err := json.NewDecoder(authJson).Decode(authConfig); err != nil {
+			// to increase compatibility to existing api it is defaulting to be empty
+			authConfig = &registry.AuthConfig{}
+		}
+	}
+
+	name := vars["name"]
+	if tag := r.Form.Get("tag"); tag != "" {
+		name = utils.ImageReference(name, tag)
+	}
+	job := eng.Job("manifest_push", append([]string{name}, r.Form["source"]...)...)
+	job.SetenvJson("metaHeaders", metaHeaders)
+	job.SetenvJson("authConfig", authConfig)
+	job.SetenvBool("json", true)
+	streamJSON(job, w, true)
+
+	if err := job.Run(); err != nil {
+		if !job.Stdout.Used() {
+			return err
+		}
+		sf := utils.NewStreamFormatter(true)
+		w.Write(sf.FormatError(err))
+	}
+	return nil
+}
+
+func getImagesManifest(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("image_manifest", vars["name"])
+	job.Setenv("tag", r.Form.Get("tag"))
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
+func postImagesSigned(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	metaHeaders := map[string][]string{}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Meta-") {
+			metaHeaders[k] = v
+		}
+	}
+	authConfig := &registry.AuthConfig{}
+	if authEncoded := r.Header.Get("X-Registry-Auth"); authEncoded != "" {
+		authJson := base64.NewDecoder(base64.URLEncoding, strings.NewReader(authEncoded))
+		if err := json.NewDecoder(authJson).Decode(authConfig); err != nil {
+			// to increase compatibility to existing api it is defaulting to be empty
+			authConfig = &registry.AuthConfig{}
+		}
+	}
+
+	job := eng.Job("push_signed", vars["name"])
+	job.Setenv("tag", r.Form.Get("tag"))
+	job.SetenvJson("metaHeaders", metaHeaders)
+	job.SetenvJson("authConfig", authConfig)
+	job.SetenvBool("json", true)
+	job.Stdin.Add(r.Body)
+	streamJSON(job, w, true)
+
+	if err := job.Run(); err != nil {
+		if !job.Stdout.Used() {
+			return err
+		}
+		sf := utils.NewStreamFormatter(true)
+		w.Write(sf.FormatError(err))
+	}
+	return nil
+}
+
 func getImagesGet(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -700,6 +864,11 @@ func getImagesGet(eng *engine.Engine, version version.Version, w http.ResponseWr
 	} else {
 		job = eng.Job("image_export", r.Form["names"]...)
 	}
+	if version.GreaterThanOrEqualTo("1.18") {
+		job.Setenv("format", r.Form.Get("format"))
+		job.Setenv("compress", r.Form.Get("compress"))
+		job.Setenv("compressionLevel", r.Form.Get("level"))
+	}
 	job.Stdout.Add(w)
 	return job.Run()
 }
@@ -707,7 +876,20 @@ func getImagesGet(eng *engine.Engine, version version.Version, w http.ResponseWr
 func postImagesLoad(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	job := eng.Job("load")
 	job.Stdin.Add(r.Body)
-	return job.Run()
+	if version.LessThan("1.18") {
+		return job.Run()
+	}
+
+	job.SetenvBool("json", true)
+	streamJSON(job, w, true)
+	if err := job.Run(); err != nil {
+		if !job.Stdout.Used() {
+			return err
+		}
+		sf := utils.NewStreamFormatter(true)
+		w.Write(sf.FormatError(err))
+	}
+	return nil
 }
 
 func postContainersCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
@@ -727,6 +909,25 @@ func postContainersCreate(eng *engine.Engine, version version.Version, w http.Re
 	if err := job.DecodeEnv(r.Body); err != nil {
 		return err
 	}
+	if version.GreaterThanOrEqualTo("1.18") {
+		metaHeaders := map[string][]string{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Meta-") {
+				metaHeaders[k] = v
+			}
+		}
+		authConfig := &registry.AuthConfig{}
+		if authEncoded := r.Header.Get("X-Registry-Auth"); authEncoded != "" {
+			authJson := base64.NewDecoder(base64.URLEncoding, strings.NewReader(authEncoded))
+			if err := json.NewDecoder(authJson).Decode(authConfig); err != nil {
+				// to increase compatibility to existing api it is defaulting to be empty
+				authConfig = &registry.AuthConfig{}
+			}
+		}
+		job.Setenv("pull", r.Form.Get("pull"))
+		job.SetenvJson("authConfig", authConfig)
+		job.SetenvJson("metaHeaders", metaHeaders)
+	}
 	// Read container ID from the first line of stdout
 	job.Stdout.Add(stdoutBuffer)
 	// Read warnings from stderr
@@ -806,6 +1007,9 @@ func deleteImages(eng *engine.Engine, version version.Version, w http.ResponseWr
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
 	}
+	if r.Form.Get("remote") == "1" && version.GreaterThanOrEqualTo("1.18") {
+		return deleteImagesRemote(eng, w, r, vars["name"])
+	}
 	var job = eng.Job("image_delete", vars["name"])
 	streamJSON(job, w, false)
 	job.Setenv("force", r.Form.Get("force"))
@@ -814,6 +1018,81 @@ func deleteImages(eng *engine.Engine, version version.Version, w http.ResponseWr
 	return job.Run()
 }
 
+// deleteImagesRemote deletes the manifest of name from its registry instead
+// of removing the local image.
+func deleteImagesRemote(eng *engine.Engine, w http.ResponseWriter, r *http.Request, name string) error {
+	metaHeaders := map[string][]string{}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Meta-") {
+			metaHeaders[k] = v
+		}
+	}
+	authConfig := &registry.AuthConfig{}
+	if authEncoded := r.Header.Get("X-Registry-Auth"); authEncoded != "" {
+		authJson := base64.NewDecoder(base64.URLEncoding, strings.NewReader(authEncoded))
+		if err := json.NewDecoder(authJson).Decode(authConfig); err != nil {
+			// to increase compatibility to existing api it is defaulting to be empty
+			authConfig = &registry.AuthConfig{}
+		}
+	}
+
+	job := eng.Job("remote_delete", name)
+	job.SetenvJson("metaHeaders", metaHeaders)
+	job.SetenvJson("authConfig", authConfig)
+	job.SetenvBool("json", true)
+	streamJSON(job, w, true)
+
+	if err := job.Run(); err != nil {
+		if !job.Stdout.Used() {
+			return err
+		}
+		sf := utils.NewStreamFormatter(true)
+		w.Write(sf.FormatError(err))
+	}
+	return nil
+}
+
+func postContainersPrune(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("container_prune")
+	job.Setenv("filters", r.Form.Get("filters"))
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
+func postImagesPrune(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("image_prune")
+	job.Setenv("all", r.Form.Get("all"))
+	job.Setenv("filters", r.Form.Get("filters"))
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
+func postImagesRetention(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("image_retention")
+	job.Setenv("dryRun", r.Form.Get("dryrun"))
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
+func postVolumesPrune(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("volume_prune")
+	job.Setenv("filters", r.Form.Get("filters"))
+	streamJSON(job, w, false)
+	return job.Run()
+}
+
 func postContainersStart(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -1073,6 +1352,15 @@ func postBuild(eng *engine.Engine, version version.Version, w http.ResponseWrite
 	if r.FormValue("pull") == "1" && version.GreaterThanOrEqualTo("1.16") {
 		job.Setenv("pull", "1")
 	}
+	if r.FormValue("squash") == "1" && version.GreaterThanOrEqualTo("1.18") {
+		job.Setenv("squash", "1")
+	}
+	if r.FormValue("check") == "1" && version.GreaterThanOrEqualTo("1.18") {
+		job.Setenv("check", "1")
+	}
+	if r.FormValue("structured") == "1" && version.GreaterThanOrEqualTo("1.18") {
+		job.Setenv("structured", "1")
+	}
 	job.Stdin.Add(r.Body)
 	job.Setenv("remote", r.FormValue("remote"))
 	job.Setenv("dockerfile", r.FormValue("dockerfile"))
@@ -1313,6 +1601,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 			"/_ping":                          ping,
 			"/events":                         getEvents,
 			"/info":                           getInfo,
+			"/system/df":                      getSystemDF,
 			"/version":                        getVersion,
 			"/images/json":                    getImagesJSON,
 			"/images/viz":                     getImagesViz,
@@ -1320,6 +1609,9 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 			"/images/get":                     getImagesGet,
 			"/images/{name:.*}/get":           getImagesGet,
 			"/images/{name:.*}/history":       getImagesHistory,
+			"/images/{name:.*}/diff":          getImagesDiff,
+			"/images/{name:.*}/tags":          getImagesTags,
+			"/images/{name:.*}/manifest":      getImagesManifest,
 			"/images/{name:.*}/json":          getImagesByName,
 			"/containers/ps":                  getContainersJSON,
 			"/containers/json":                getContainersJSON,
@@ -1338,9 +1630,14 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 			"/build":                        postBuild,
 			"/images/create":                postImagesCreate,
 			"/images/load":                  postImagesLoad,
+			"/images/prune":                 postImagesPrune,
+			"/images/retention":             postImagesRetention,
 			"/images/{name:.*}/push":        postImagesPush,
+			"/images/{name:.*}/manifest":    postImagesManifestList,
+			"/images/{name:.*}/signed":      postImagesSigned,
 			"/images/{name:.*}/tag":         postImagesTag,
 			"/containers/create":            postContainersCreate,
+			"/containers/prune":             postContainersPrune,
 			"/containers/{name:.*}/kill":    postContainersKill,
 			"/containers/{name:.*}/pause":   postContainersPause,
 			"/containers/{name:.*}/unpause": postContainersUnpause,
@@ -1355,6 +1652,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 			"/exec/{name:.*}/start":         postContainerExecStart,
 			"/exec/{name:.*}/resize":        postContainerExecResize,
 			"/containers/{name:.*}/rename":  postContainerRename,
+			"/volumes/prune":                postVolumesPrune,
 		},
 		"DELETE": {
 			"/containers/{name:.*}": deleteContainers,
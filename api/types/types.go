@@ -9,3 +9,53 @@ type ContainerCreateResponse struct {
 	// Warnings are any warnings encountered during the creation of the container.
 	Warnings []string `json:"Warnings"`
 }
+
+// DiskUsage contains the disk space used by images, containers and volumes,
+// as returned by GET /system/df.
+type DiskUsage struct {
+	// LayersSize is the size of all the image layers, each counted once.
+	LayersSize int64
+	Images     []*ImageDiskUsage
+	Containers []*ContainerDiskUsage
+	Volumes    []*VolumeDiskUsage
+}
+
+// ImageDiskUsage contains the disk space used by an image that is tagged or
+// is not the parent of another image.
+type ImageDiskUsage struct {
+	ID       string `json:"Id"`
+	RepoTags []string
+	Created  int64
+
+	// Size is the size of the image and all its parents.
+	Size int64
+	// SharedSize is the size of the layers of the image that are also
+	// layers of other images.
+	SharedSize int64
+	// Containers is the number of containers based on the image.
+	Containers int
+}
+
+// ContainerDiskUsage contains the disk space used by a container.
+type ContainerDiskUsage struct {
+	ID      string `json:"Id"`
+	Names   []string
+	Image   string
+	Created int64
+	Running bool
+
+	// SizeRw is the size of the files the container created or changed.
+	SizeRw int64
+	// LogSize is the size of the container's log file.
+	LogSize int64
+}
+
+// VolumeDiskUsage contains the disk space used by a volume. Host
+// directories mounted in containers are not included.
+type VolumeDiskUsage struct {
+	ID   string `json:"Id"`
+	Path string
+	Size int64
+	// Containers is the number of containers using the volume.
+	Containers int
+}
@@ -126,7 +126,12 @@ func add(b *Builder, args []string, attributes map[string]bool, original string)
 		return fmt.Errorf("ADD requires at least two arguments")
 	}
 
-	return b.runContextCommand(args, true, true, "ADD")
+	chown, err := parseChown(b, "ADD")
+	if err != nil {
+		return err
+	}
+
+	return b.runContextCommand(args, true, true, "ADD", chown)
 }
 
 // COPY foo /path
@@ -138,7 +143,25 @@ func dispatchCopy(b *Builder, args []string, attributes map[string]bool, origina
 		return fmt.Errorf("COPY requires at least two arguments")
 	}
 
-	return b.runContextCommand(args, false, false, "COPY")
+	chown, err := parseChown(b, "COPY")
+	if err != nil {
+		return err
+	}
+
+	return b.runContextCommand(args, false, false, "COPY", chown)
+}
+
+// parseChown returns the user and group given with --chown=user:group to ADD
+// or COPY, or an empty string if the flag is missing.
+func parseChown(b *Builder, cmdName string) (string, error) {
+	var chown string
+	for _, flag := range b.flags {
+		chown = b.replaceEnv(strings.TrimPrefix(flag, "--chown="))
+		if chown == "" {
+			return "", fmt.Errorf("%s --chown requires a user and optionally a group", cmdName)
+		}
+	}
+	return chown, nil
 }
 
 // FROM imagename
@@ -154,6 +177,7 @@ func from(b *Builder, args []string, attributes map[string]bool, original string
 
 	if name == NoBaseImageSpecifier {
 		b.image = ""
+		b.fromImage = ""
 		b.noBaseImage = true
 		return nil
 	}
@@ -26,10 +26,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/api"
 	"github.com/docker/docker/builder/command"
+	"github.com/docker/docker/builder/lint"
 	"github.com/docker/docker/builder/parser"
 	"github.com/docker/docker/daemon"
 	"github.com/docker/docker/engine"
@@ -99,6 +101,11 @@ type Builder struct {
 	Remove      bool
 	ForceRemove bool
 	Pull        bool
+	Squash      bool
+
+	// send per-step progress events instead of the text messages of the
+	// builder. Only used when StreamFormatter is in JSON mode.
+	StructuredProgress bool
 
 	// set this to true if we want the builder to not commit between steps.
 	// This is useful when we only want to use the evaluator table to generate
@@ -120,11 +127,17 @@ type Builder struct {
 	dockerfileName string        // name of Dockerfile
 	dockerfile     *parser.Node  // the syntax tree of the dockerfile
 	image          string        // image name for commit processing
+	flags          []string      // flags of the instruction being dispatched, see parser.Node.Flags
+	fromImage      string        // image ID of the last FROM, the parent of a squashed image
 	maintainer     string        // maintainer name. could probably be removed.
 	cmdSet         bool          // indicates is CMD was set in current Dockerfile
 	context        tarsum.TarSum // the context is a tarball that is uploaded by the client
 	contextPath    string        // the path of the temporary directory the local context is unpacked to (server side)
 	noBaseImage    bool          // indicates that this build does not start from any base image, but is being built from an empty file system.
+	inTrigger      bool          // indicates that ONBUILD triggers of the base image are being run
+	step           int           // number of the current step for structured progress
+	stepCache      string        // cache result of the current step for structured progress
+	stepContainer  string        // container used by the current step for structured progress
 }
 
 // Run the builder with the context. This is the lynchpin of this package. This
@@ -165,7 +178,9 @@ func (b *Builder) Run(context io.Reader) (string, error) {
 			}
 			return "", err
 		}
-		fmt.Fprintf(b.OutStream, " ---> %s\n", common.TruncateID(b.image))
+		if !b.structured() {
+			fmt.Fprintf(b.OutStream, " ---> %s\n", common.TruncateID(b.image))
+		}
 		if b.Remove {
 			b.clearTmp()
 		}
@@ -175,10 +190,38 @@ func (b *Builder) Run(context io.Reader) (string, error) {
 		return "", fmt.Errorf("No image was generated. Is your Dockerfile empty?")
 	}
 
+	if b.Squash {
+		if err := b.squash(); err != nil {
+			return "", err
+		}
+	}
+
 	fmt.Fprintf(b.OutStream, "Successfully built %s\n", common.TruncateID(b.image))
 	return b.image, nil
 }
 
+// Check reads the context and the Dockerfile like Run does, but instead of
+// executing the Dockerfile it runs the rules of the lint package over it.
+func (b *Builder) Check(context io.Reader) (*lint.Report, error) {
+	if err := b.readContext(context); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := os.RemoveAll(b.contextPath); err != nil {
+			log.Debugf("[BUILDER] failed to remove temporary context: %s", err)
+		}
+	}()
+
+	if err := b.readDockerfile(); err != nil {
+		return nil, err
+	}
+
+	report := lint.Check(b.dockerfile)
+	report.File = b.dockerfileName
+	return report, nil
+}
+
 // Reads a Dockerfile from the current context. It assumes that the
 // 'filename' is a relative path from the root of the context
 func (b *Builder) readDockerfile() error {
@@ -263,6 +306,7 @@ func (b *Builder) dispatch(stepN int, ast *parser.Node) error {
 	cmd := ast.Value
 	attrs := ast.Attributes
 	original := ast.Original
+	line := ast.StartLine
 	strs := []string{}
 	msg := fmt.Sprintf("Step %d : %s", stepN, strings.ToUpper(cmd))
 
@@ -272,8 +316,8 @@ func (b *Builder) dispatch(stepN int, ast *parser.Node) error {
 		}
 		ast = ast.Next.Children[0]
 		strs = append(strs, ast.Value)
-		msg += " " + ast.Value
 	}
+	b.flags = ast.Flags
 
 	// count the number of nodes that we are going to traverse first
 	// so we can pre-create the argument and message array. This speeds up the
@@ -287,7 +331,8 @@ func (b *Builder) dispatch(stepN int, ast *parser.Node) error {
 	l := len(strs)
 	strList := make([]string, n+l)
 	copy(strList, strs)
-	msgList := make([]string, n)
+	msgList := make([]string, n+l)
+	copy(msgList, strs)
 
 	var i int
 	for ast.Next != nil {
@@ -298,18 +343,61 @@ func (b *Builder) dispatch(stepN int, ast *parser.Node) error {
 			str = b.replaceEnv(ast.Value)
 		}
 		strList[i+l] = str
-		msgList[i] = ast.Value
+		msgList[i+l] = ast.Value
 		i++
 	}
 
 	msg += " " + strings.Join(msgList, " ")
-	fmt.Fprintln(b.OutStream, msg)
 
 	// XXX yes, we skip any cmds that are not valid; the parser should have
 	// picked these out already.
-	if f, ok := evaluateTable[cmd]; ok {
+	f, ok := evaluateTable[cmd]
+	if !ok {
+		fmt.Fprintln(b.OutStream, msg)
+		return fmt.Errorf("Unknown instruction: %s", strings.ToUpper(cmd))
+	}
+
+	if !b.structured() {
+		fmt.Fprintln(b.OutStream, msg)
 		return f(b, strList, attrs, original)
 	}
 
-	return fmt.Errorf("Unknown instruction: %s", strings.ToUpper(cmd))
+	step := &utils.JSONBuildStep{
+		Event:       "start",
+		Step:        stepN,
+		Instruction: strings.ToUpper(cmd),
+		Args:        msgList,
+		Original:    original,
+		Line:        line,
+		Trigger:     b.inTrigger,
+	}
+	b.sendStep(step)
+
+	// ONBUILD triggers are dispatched from within FROM, so keep the state
+	// of the enclosing step around.
+	prevStep, prevCache, prevContainer := b.step, b.stepCache, b.stepContainer
+	b.step, b.stepCache, b.stepContainer = stepN, "", ""
+	defer func() { b.step, b.stepCache, b.stepContainer = prevStep, prevCache, prevContainer }()
+
+	start := time.Now()
+	if err := f(b, strList, attrs, original); err != nil {
+		return err
+	}
+
+	step.Event = "end"
+	step.Cache = b.stepCache
+	step.ContainerID = b.stepContainer
+	step.ImageID = b.image
+	step.Duration = time.Since(start)
+	b.sendStep(step)
+	return nil
+}
+
+// structured reports whether per-step progress events are sent.
+func (b *Builder) structured() bool {
+	return b.StructuredProgress && b.StreamFormatter != nil && b.StreamFormatter.Json()
+}
+
+func (b *Builder) sendStep(step *utils.JSONBuildStep) {
+	b.OutOld.Write(b.StreamFormatter.FormatBuildStep(step))
 }
@@ -15,13 +15,16 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/autogen/dockerversion"
 	"github.com/docker/docker/builder/parser"
 	"github.com/docker/docker/daemon"
+	"github.com/docker/docker/daemon/graphdriver"
 	imagepkg "github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/chrootarchive"
@@ -35,6 +38,7 @@ import (
 	"github.com/docker/docker/pkg/urlutil"
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/utils"
+	libcontainerUser "github.com/docker/libcontainer/user"
 )
 
 func (b *Builder) readContext(context io.Reader) error {
@@ -110,6 +114,73 @@ func (b *Builder) commit(id string, autoCmd []string, comment string) error {
 	return nil
 }
 
+// squash replaces the layers created since the last FROM with a single
+// layer holding their combined changes. The steps that produced the
+// original layers are kept in the history of the new image. The original
+// images are left in the graph so they can still be used as build cache.
+func (b *Builder) squash() error {
+	if b.disableCommit || b.image == b.fromImage {
+		return nil
+	}
+	graph := b.Daemon.Graph()
+	img, err := graph.Get(b.image)
+	if err != nil {
+		return err
+	}
+
+	var history []imagepkg.History
+	for cur := img; cur != nil && cur.ID != b.fromImage; {
+		history = append(history, imagepkg.History{
+			ID:        cur.ID,
+			Created:   cur.Created,
+			CreatedBy: strings.Join(cur.ContainerConfig.Cmd, " "),
+			Author:    cur.Author,
+			Comment:   cur.Comment,
+			Size:      cur.Size,
+		})
+		if cur, err = cur.GetParent(); err != nil {
+			return err
+		}
+	}
+	if len(history) < 2 {
+		// A single layer is already squashed.
+		return nil
+	}
+
+	fmt.Fprintf(b.OutStream, "Squashing %d layers\n", len(history))
+
+	// Not every driver honors the parent when producing a diff (aufs only
+	// looks at the layer itself), so compare the mounted filesystems.
+	diff, err := graphdriver.NaiveDiffDriver(graph.Driver()).Diff(img.ID, b.fromImage)
+	if err != nil {
+		return err
+	}
+	defer diff.Close()
+
+	containerConfig := img.ContainerConfig
+	containerConfig.Image = b.fromImage
+	containerConfig.Cmd = []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) squashed %d layers", len(history))}
+
+	squashed := &imagepkg.Image{
+		Parent:          b.fromImage,
+		Comment:         img.Comment,
+		Created:         time.Now().UTC(),
+		ContainerConfig: containerConfig,
+		DockerVersion:   dockerversion.VERSION,
+		Author:          img.Author,
+		Config:          img.Config,
+		Architecture:    img.Architecture,
+		OS:              img.OS,
+		SquashedHistory: history,
+	}
+	if err := graph.Register(squashed, diff); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.OutStream, " ---> %s\n", common.TruncateID(squashed.ID))
+	b.image = squashed.ID
+	return nil
+}
+
 type copyInfo struct {
 	origPath   string
 	destPath   string
@@ -118,7 +189,7 @@ type copyInfo struct {
 	tmpDir     string
 }
 
-func (b *Builder) runContextCommand(args []string, allowRemote bool, allowDecompression bool, cmdName string) error {
+func (b *Builder) runContextCommand(args []string, allowRemote bool, allowDecompression bool, cmdName string, chown string) error {
 	if b.context == nil {
 		return fmt.Errorf("No context given. Impossible to use %s", cmdName)
 	}
@@ -180,6 +251,12 @@ func (b *Builder) runContextCommand(args []string, allowRemote bool, allowDecomp
 		origPaths = strings.Join(origs, " ")
 	}
 
+	// Only add the owner when it is set, so that the cache of builds that
+	// do not use --chown is kept.
+	if chown != "" {
+		cmdName = fmt.Sprintf("%s --chown=%s", cmdName, chown)
+	}
+
 	cmd := b.Config.Cmd
 	b.Config.Cmd = []string{"/bin/sh", "-c", fmt.Sprintf("#(nop) %s %s in %s", cmdName, srcHash, dest)}
 	defer func(cmd []string) { b.Config.Cmd = cmd }(cmd)
@@ -198,14 +275,24 @@ func (b *Builder) runContextCommand(args []string, allowRemote bool, allowDecomp
 		return err
 	}
 	b.TmpContainers[container.ID] = struct{}{}
+	b.stepContainer = container.ID
 
 	if err := container.Mount(); err != nil {
 		return err
 	}
 	defer container.Unmount()
 
+	var chownOpts *archive.TarChownOptions
+	if chown != "" {
+		uid, gid, err := lookupOwner(container.RootfsPath(), chown)
+		if err != nil {
+			return err
+		}
+		chownOpts = &archive.TarChownOptions{UID: uid, GID: gid}
+	}
+
 	for _, ci := range copyInfos {
-		if err := b.addContext(container, ci.origPath, ci.destPath, ci.decompress); err != nil {
+		if err := b.addContext(container, ci.origPath, ci.destPath, ci.decompress, chownOpts); err != nil {
 			return err
 		}
 	}
@@ -461,6 +548,7 @@ func (b *Builder) pullImage(name string) (*imagepkg.Image, error) {
 
 func (b *Builder) processImageFrom(img *imagepkg.Image) error {
 	b.image = img.ID
+	b.fromImage = img.ID
 
 	if img.Config != nil {
 		b.Config = img.Config
@@ -496,7 +584,10 @@ func (b *Builder) processImageFrom(img *imagepkg.Image) error {
 
 			fmt.Fprintf(b.OutStream, "Trigger %d, %s\n", stepN, step)
 
-			if err := b.dispatch(i, n); err != nil {
+			b.inTrigger = true
+			err := b.dispatch(i, n)
+			b.inTrigger = false
+			if err != nil {
 				return err
 			}
 		}
@@ -522,10 +613,14 @@ func (b *Builder) probeCache() (bool, error) {
 	if cache == nil {
 		log.Debugf("[BUILDER] Cache miss")
 		b.cacheBusted = true
+		b.stepCache = "miss"
 		return false, nil
 	}
 
-	fmt.Fprintf(b.OutStream, " ---> Using cache\n")
+	b.stepCache = "hit"
+	if !b.structured() {
+		fmt.Fprintf(b.OutStream, " ---> Using cache\n")
+	}
 	log.Debugf("[BUILDER] Use cached version")
 	b.image = cache.ID
 	return true, nil
@@ -549,7 +644,12 @@ func (b *Builder) create() (*daemon.Container, error) {
 	}
 
 	b.TmpContainers[c.ID] = struct{}{}
-	fmt.Fprintf(b.OutStream, " ---> Running in %s\n", common.TruncateID(c.ID))
+	b.stepContainer = c.ID
+	if b.structured() {
+		b.sendStep(&utils.JSONBuildStep{Event: "container", Step: b.step, ContainerID: c.ID, Trigger: b.inTrigger})
+	} else {
+		fmt.Fprintf(b.OutStream, " ---> Running in %s\n", common.TruncateID(c.ID))
+	}
 
 	if len(config.Cmd) > 0 {
 		// override the entry point that may have been picked up from the base image
@@ -613,13 +713,49 @@ func (b *Builder) checkPathForAddition(orig string) error {
 	return nil
 }
 
-func (b *Builder) addContext(container *daemon.Container, orig, dest string, decompress bool) error {
+// lookupOwner resolves a user[:group] specification to numeric ids using the
+// /etc/passwd and /etc/group files of the given root filesystem. Numeric ids
+// do not need to exist in those files. Without a group, the primary group of
+// the user is used, or the uid for a numeric user that has no entry.
+func lookupOwner(rootfs, spec string) (int, int, error) {
+	passwdPath, err := symlink.FollowSymlinkInScope(filepath.Join(rootfs, "etc", "passwd"), rootfs)
+	if err != nil {
+		return 0, 0, err
+	}
+	groupPath, err := symlink.FollowSymlinkInScope(filepath.Join(rootfs, "etc", "group"), rootfs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defaults := &libcontainerUser.ExecUser{}
+	if !strings.Contains(spec, ":") {
+		if uid, err := strconv.Atoi(spec); err == nil {
+			defaults.Gid = uid
+		}
+	}
+
+	execUser, err := libcontainerUser.GetExecUserPath(spec, defaults, passwdPath, groupPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unable to resolve --chown=%s: %s", spec, err)
+	}
+	return execUser.Uid, execUser.Gid, nil
+}
+
+// addContext copies orig from the context to dest in the container. The
+// files are owned by chownOpts if set, by root otherwise, except for the
+// contents of extracted archives which keep their owners unless chownOpts
+// is set.
+func (b *Builder) addContext(container *daemon.Container, orig, dest string, decompress bool, chownOpts *archive.TarChownOptions) error {
 	var (
 		err        error
 		destExists = true
 		origPath   = path.Join(b.contextPath, orig)
 		destPath   = path.Join(container.RootfsPath(), dest)
+		uid, gid   = 0, 0
 	)
+	if chownOpts != nil {
+		uid, gid = chownOpts.UID, chownOpts.GID
+	}
 
 	if destPath != container.RootfsPath() {
 		destPath, err = symlink.FollowSymlinkInScope(destPath, container.RootfsPath())
@@ -650,7 +786,7 @@ func (b *Builder) addContext(container *daemon.Container, orig, dest string, dec
 	}
 
 	if fi.IsDir() {
-		return copyAsDirectory(origPath, destPath, destExists)
+		return copyAsDirectory(origPath, destPath, destExists, uid, gid)
 	}
 
 	// If we are adding a remote file (or we've been told not to decompress), do not try to untar it
@@ -665,7 +801,7 @@ func (b *Builder) addContext(container *daemon.Container, orig, dest string, dec
 		}
 
 		// try to successfully untar the orig
-		if err := chrootarchive.UntarPath(origPath, tarDest); err == nil {
+		if err := untarPath(origPath, tarDest, chownOpts); err == nil {
 			return nil
 		} else if err != io.EOF {
 			log.Debugf("Couldn't untar %s to %s: %s", origPath, tarDest, err)
@@ -684,14 +820,25 @@ func (b *Builder) addContext(container *daemon.Container, orig, dest string, dec
 		resPath = path.Join(destPath, path.Base(origPath))
 	}
 
-	return fixPermissions(origPath, resPath, 0, 0, destExists)
+	return fixPermissions(origPath, resPath, uid, gid, destExists)
+}
+
+// untarPath unpacks the archive at src to dst, owning the unpacked files by
+// chownOpts if set.
+func untarPath(src, dst string, chownOpts *archive.TarChownOptions) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return chrootarchive.Untar(f, dst, &archive.TarOptions{ChownOpts: chownOpts})
 }
 
-func copyAsDirectory(source, destination string, destExisted bool) error {
+func copyAsDirectory(source, destination string, destExisted bool, uid, gid int) error {
 	if err := chrootarchive.CopyWithTar(source, destination); err != nil {
 		return err
 	}
-	return fixPermissions(source, destination, 0, 0, destExisted)
+	return fixPermissions(source, destination, uid, gid, destExisted)
 }
 
 func fixPermissions(source, destination string, uid, gid int, destExisted bool) error {
@@ -1,12 +1,13 @@
 package builder
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/docker/docker/api"
@@ -22,6 +23,10 @@ import (
 	"github.com/docker/docker/utils"
 )
 
+// the size of a tar header, enough to tell whether a remote context is an
+// archive or a Dockerfile
+const tarHeaderSize = 512
+
 // whitelist of commands allowed for a commit/import
 var validCommitCommands = map[string]bool{
 	"entrypoint": true,
@@ -57,6 +62,9 @@ func (b *BuilderJob) CmdBuild(job *engine.Job) engine.Status {
 		rm             = job.GetenvBool("rm")
 		forceRm        = job.GetenvBool("forcerm")
 		pull           = job.GetenvBool("pull")
+		squash         = job.GetenvBool("squash")
+		check          = job.GetenvBool("check")
+		structured     = job.GetenvBool("structured")
 		authConfig     = &registry.AuthConfig{}
 		configFile     = &registry.ConfigFile{}
 		tag            string
@@ -81,20 +89,13 @@ func (b *BuilderJob) CmdBuild(job *engine.Job) engine.Status {
 	if remoteURL == "" {
 		context = ioutil.NopCloser(job.Stdin)
 	} else if urlutil.IsGitURL(remoteURL) {
-		if !urlutil.IsGitTransport(remoteURL) {
-			remoteURL = "https://" + remoteURL
-		}
-		root, err := ioutil.TempDir("", "docker-build-git")
+		root, contextDir, err := utils.GitClone(remoteURL)
 		if err != nil {
 			return job.Error(err)
 		}
 		defer os.RemoveAll(root)
 
-		if output, err := exec.Command("git", "clone", "--recursive", remoteURL, root).CombinedOutput(); err != nil {
-			return job.Errorf("Error trying to use git: %s (%s)", err, output)
-		}
-
-		c, err := archive.Tar(root, archive.Uncompressed)
+		c, err := archive.Tar(contextDir, archive.Uncompressed)
 		if err != nil {
 			return job.Error(err)
 		}
@@ -105,25 +106,48 @@ func (b *BuilderJob) CmdBuild(job *engine.Job) engine.Status {
 			return job.Error(err)
 		}
 		defer f.Body.Close()
-		dockerFile, err := ioutil.ReadAll(f.Body)
-		if err != nil {
-			return job.Error(err)
+
+		// A remote archive is the whole context. It is passed on as it is
+		// downloaded rather than being copied to disk first.
+		buf := bufio.NewReader(f.Body)
+		magic, err := buf.Peek(tarHeaderSize)
+		if err != nil && err != io.EOF {
+			return job.Errorf("failed to peek context header from %s: %v", remoteURL, err)
 		}
+		if archive.IsArchive(magic) {
+			context = ioutil.NopCloser(buf)
+		} else {
+			dockerFile, err := ioutil.ReadAll(buf)
+			if err != nil {
+				return job.Error(err)
+			}
 
-		// When we're downloading just a Dockerfile put it in
-		// the default name - don't allow the client to move/specify it
-		dockerfileName = api.DefaultDockerfileName
+			// When we're downloading just a Dockerfile put it in
+			// the default name - don't allow the client to move/specify it
+			dockerfileName = api.DefaultDockerfileName
 
-		c, err := archive.Generate(dockerfileName, string(dockerFile))
-		if err != nil {
-			return job.Error(err)
+			c, err := archive.Generate(dockerfileName, string(dockerFile))
+			if err != nil {
+				return job.Error(err)
+			}
+			context = c
 		}
-		context = c
 	}
 	defer context.Close()
 
 	sf := utils.NewStreamFormatter(job.GetenvBool("json"))
 
+	var errStream io.Writer = &utils.StderrFormater{
+		Writer:          job.Stdout,
+		StreamFormatter: sf,
+	}
+	if structured {
+		errStream = &utils.StructuredStderrFormater{
+			Writer:          job.Stdout,
+			StreamFormatter: sf,
+		}
+	}
+
 	builder := &Builder{
 		Daemon: b.Daemon,
 		Engine: b.Engine,
@@ -131,20 +155,35 @@ func (b *BuilderJob) CmdBuild(job *engine.Job) engine.Status {
 			Writer:          job.Stdout,
 			StreamFormatter: sf,
 		},
-		ErrStream: &utils.StderrFormater{
-			Writer:          job.Stdout,
-			StreamFormatter: sf,
-		},
-		Verbose:         !suppressOutput,
-		UtilizeCache:    !noCache,
-		Remove:          rm,
-		ForceRemove:     forceRm,
-		Pull:            pull,
-		OutOld:          job.Stdout,
-		StreamFormatter: sf,
-		AuthConfig:      authConfig,
-		AuthConfigFile:  configFile,
-		dockerfileName:  dockerfileName,
+		ErrStream:          errStream,
+		Verbose:            !suppressOutput,
+		UtilizeCache:       !noCache,
+		Remove:             rm,
+		ForceRemove:        forceRm,
+		Pull:               pull,
+		Squash:             squash,
+		StructuredProgress: structured,
+		OutOld:             job.Stdout,
+		StreamFormatter:    sf,
+		AuthConfig:         authConfig,
+		AuthConfigFile:     configFile,
+		dockerfileName:     dockerfileName,
+	}
+
+	if check {
+		report, err := builder.Check(context)
+		if err != nil {
+			return job.Error(err)
+		}
+		buf, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return job.Error(err)
+		}
+		fmt.Fprintf(builder.OutStream, "%s\n", buf)
+		if n := report.Errors(); n > 0 {
+			return job.Errorf("%s has %d error(s)", report.File, n)
+		}
+		return engine.StatusOK
 	}
 
 	id, err := builder.Run(context)
@@ -0,0 +1,247 @@
+// Package lint checks a parsed Dockerfile for common mistakes without
+// executing it.
+//
+// Each rule is a function over the top-level nodes produced by the parser
+// package. Rules report problems with the line number of the instruction
+// that caused them, so the result can be consumed by editors and CI tools.
+package lint
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/builder/command"
+	"github.com/docker/docker/builder/parser"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/urlutil"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem is a single finding reported by a rule.
+type Problem struct {
+	Line     int    `json:"line"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Report is the result of checking a Dockerfile.
+type Report struct {
+	File     string    `json:"file,omitempty"`
+	Problems []Problem `json:"problems"`
+}
+
+// Errors returns the number of problems with an error severity.
+func (r *Report) Errors() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// Rule is a named check run over the instructions of a Dockerfile.
+type Rule struct {
+	Name        string
+	Description string
+	Severity    string
+	check       func(instructions []*parser.Node) []Problem
+}
+
+// Rules is the list of rules applied by Check, in the order they are run.
+var Rules = []Rule{
+	{"unknown-instruction", "Instructions that the builder does not know about", SeverityError, checkUnknownInstruction},
+	{"missing-from", "The first instruction must be FROM", SeverityError, checkMissingFrom},
+	{"add-instead-of-copy", "ADD used for local files that COPY can handle", SeverityWarning, checkAddInsteadOfCopy},
+	{"shell-form-cmd", "Shell form CMD or ENTRYPOINT do not pass signals to the process", SeverityWarning, checkShellFormCmd},
+	{"unpinned-base-image", "FROM without a tag or digest, or with the latest tag", SeverityWarning, checkUnpinnedBaseImage},
+	{"apt-get-without-cleanup", "apt-get install without removing /var/lib/apt/lists", SeverityWarning, checkAptGetCleanup},
+}
+
+// Parse parses the Dockerfile read from r and checks it.
+func Parse(r io.Reader) (*Report, error) {
+	ast, err := parser.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return Check(ast), nil
+}
+
+// Check runs all the rules over the root node returned by parser.Parse.
+// Problems are sorted by line.
+func Check(ast *parser.Node) *Report {
+	report := &Report{Problems: []Problem{}}
+	for _, rule := range Rules {
+		for _, p := range rule.check(ast.Children) {
+			p.Rule = rule.Name
+			p.Severity = rule.Severity
+			report.Problems = append(report.Problems, p)
+		}
+	}
+	sort.Stable(byLine(report.Problems))
+	return report
+}
+
+type byLine []Problem
+
+func (p byLine) Len() int           { return len(p) }
+func (p byLine) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byLine) Less(i, j int) bool { return p[i].Line < p[j].Line }
+
+// instruction returns the command of n, looking through ONBUILD so that
+// triggers are checked like any other instruction.
+func instruction(n *parser.Node) *parser.Node {
+	if n.Value == command.Onbuild && n.Next != nil && len(n.Next.Children) > 0 && n.Next.Children[0] != nil {
+		return n.Next.Children[0]
+	}
+	return n
+}
+
+// args returns the arguments of an instruction node.
+func args(n *parser.Node) []string {
+	var a []string
+	for cur := n.Next; cur != nil; cur = cur.Next {
+		a = append(a, cur.Value)
+	}
+	return a
+}
+
+func checkUnknownInstruction(instructions []*parser.Node) []Problem {
+	var problems []Problem
+	for _, n := range instructions {
+		for _, cmd := range []*parser.Node{n, instruction(n)} {
+			if _, ok := command.Commands[cmd.Value]; !ok {
+				problems = append(problems, Problem{
+					Line:    n.StartLine,
+					Message: fmt.Sprintf("Unknown instruction: %s", strings.ToUpper(cmd.Value)),
+				})
+				break
+			}
+		}
+	}
+	return problems
+}
+
+func checkMissingFrom(instructions []*parser.Node) []Problem {
+	if len(instructions) == 0 {
+		return []Problem{{Line: 1, Message: "Dockerfile has no instructions"}}
+	}
+	if n := instructions[0]; n.Value != command.From {
+		return []Problem{{Line: n.StartLine, Message: fmt.Sprintf("The first instruction must be FROM, found %s", strings.ToUpper(n.Value))}}
+	}
+	return nil
+}
+
+var archiveExtensions = []string{".tar", ".tgz", ".tbz2", ".txz", ".gz", ".bz2", ".xz"}
+
+func checkAddInsteadOfCopy(instructions []*parser.Node) []Problem {
+	var problems []Problem
+	for _, n := range instructions {
+		cmd := instruction(n)
+		if cmd.Value != command.Add {
+			continue
+		}
+		a := args(cmd)
+		if len(a) < 2 {
+			continue
+		}
+		needsAdd := false
+		for _, src := range a[:len(a)-1] {
+			if urlutil.IsURL(src) {
+				needsAdd = true
+				break
+			}
+			for _, ext := range archiveExtensions {
+				if path.Ext(src) == ext {
+					needsAdd = true
+					break
+				}
+			}
+		}
+		if !needsAdd {
+			problems = append(problems, Problem{
+				Line:    n.StartLine,
+				Message: "Use COPY instead of ADD for files and directories that are not remote or archives",
+			})
+		}
+	}
+	return problems
+}
+
+func checkShellFormCmd(instructions []*parser.Node) []Problem {
+	var problems []Problem
+	for _, n := range instructions {
+		cmd := instruction(n)
+		if cmd.Value != command.Cmd && cmd.Value != command.Entrypoint {
+			continue
+		}
+		if cmd.Next == nil || cmd.Attributes["json"] {
+			continue
+		}
+		problems = append(problems, Problem{
+			Line:    n.StartLine,
+			Message: fmt.Sprintf("%s in shell form runs under /bin/sh -c, which does not forward signals such as SIGTERM; use the JSON array form", strings.ToUpper(cmd.Value)),
+		})
+	}
+	return problems
+}
+
+func checkUnpinnedBaseImage(instructions []*parser.Node) []Problem {
+	var problems []Problem
+	for _, n := range instructions {
+		if n.Value != command.From {
+			continue
+		}
+		a := args(n)
+		if len(a) != 1 || a[0] == "scratch" || strings.Contains(a[0], "@") {
+			continue
+		}
+		_, tag := parsers.ParseRepositoryTag(a[0])
+		switch tag {
+		case "":
+			problems = append(problems, Problem{
+				Line:    n.StartLine,
+				Message: fmt.Sprintf("Base image %s has no tag; pin it to a tag or digest", a[0]),
+			})
+		case "latest":
+			problems = append(problems, Problem{
+				Line:    n.StartLine,
+				Message: fmt.Sprintf("Base image %s uses the latest tag; pin it to a specific tag or digest", a[0]),
+			})
+		}
+	}
+	return problems
+}
+
+var (
+	aptGetInstall = regexp.MustCompile(`\bapt-get\s+(-\S+\s+)*install\b`)
+	aptListsClean = regexp.MustCompile(`\brm\s+(-\S+\s+)*/var/lib/apt/lists`)
+)
+
+func checkAptGetCleanup(instructions []*parser.Node) []Problem {
+	var problems []Problem
+	for _, n := range instructions {
+		cmd := instruction(n)
+		if cmd.Value != command.Run {
+			continue
+		}
+		line := strings.Join(args(cmd), " ")
+		if aptGetInstall.MatchString(line) && !aptListsClean.MatchString(line) {
+			problems = append(problems, Problem{
+				Line:    n.StartLine,
+				Message: "apt-get install without rm -rf /var/lib/apt/lists/* in the same RUN leaves the package lists in the layer",
+			})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,75 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkString(t *testing.T, dockerfile string) *Report {
+	report, err := Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestCheckClean(t *testing.T) {
+	report := checkString(t, `FROM busybox:1.0
+COPY . /src
+ADD http://example.com/file /file
+ADD rootfs.tar.gz /
+RUN apt-get update && apt-get install -y curl && rm -rf /var/lib/apt/lists/*
+CMD ["/bin/sh"]
+`)
+	if len(report.Problems) != 0 {
+		t.Fatalf("expected no problems, got %v", report.Problems)
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	report := checkString(t, `MAINTAINER someone
+FROM busybox
+FROM ubuntu:latest
+FROM registry.local:5000/base@sha256:4ea1c1f1
+FROM registry.local:5000/base
+ADD . /src
+RUN apt-get update && \
+    apt-get install -y curl
+FOO bar
+CMD /bin/sh
+ONBUILD ENTRYPOINT top
+`)
+
+	expected := []Problem{
+		{1, "missing-from", SeverityError, ""},
+		{2, "unpinned-base-image", SeverityWarning, ""},
+		{3, "unpinned-base-image", SeverityWarning, ""},
+		{5, "unpinned-base-image", SeverityWarning, ""},
+		{6, "add-instead-of-copy", SeverityWarning, ""},
+		{7, "apt-get-without-cleanup", SeverityWarning, ""},
+		{9, "unknown-instruction", SeverityError, ""},
+		{10, "shell-form-cmd", SeverityWarning, ""},
+		{11, "shell-form-cmd", SeverityWarning, ""},
+	}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), report.Problems)
+	}
+	for i, p := range report.Problems {
+		if p.Line != expected[i].Line || p.Rule != expected[i].Rule || p.Severity != expected[i].Severity {
+			t.Fatalf("expected %s on line %d, got %s on line %d", expected[i].Rule, expected[i].Line, p.Rule, p.Line)
+		}
+		if p.Message == "" {
+			t.Fatalf("%s on line %d has no message", p.Rule, p.Line)
+		}
+	}
+	if report.Errors() != 2 {
+		t.Fatalf("expected 2 errors, got %d", report.Errors())
+	}
+}
+
+func TestCheckEmpty(t *testing.T) {
+	report := checkString(t, "# nothing here\n")
+	if len(report.Problems) != 1 || report.Problems[0].Rule != "missing-from" || report.Problems[0].Line != 1 {
+		t.Fatalf("expected a missing-from problem on line 1, got %v", report.Problems)
+	}
+}
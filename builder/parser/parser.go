@@ -29,6 +29,9 @@ type Node struct {
 	Children   []*Node         // the children of this sexp
 	Attributes map[string]bool // special attributes for this node
 	Original   string          // original line used before parsing
+	Flags      []string        // flags such as --chown given before the arguments
+	StartLine  int             // the line in the original dockerfile where the node begins
+	EndLine    int             // the line in the original dockerfile where the node ends
 }
 
 var (
@@ -83,6 +86,10 @@ func parseLine(line string) (string, *Node, error) {
 	node := &Node{}
 	node.Value = cmd
 
+	if cmd == command.Add || cmd == command.Copy {
+		node.Flags, args = extractChownFlags(args)
+	}
+
 	sexp, attrs, err := fullDispatch(cmd, args)
 	if err != nil {
 		return "", nil, err
@@ -100,8 +107,11 @@ func parseLine(line string) (string, *Node, error) {
 func Parse(rwc io.Reader) (*Node, error) {
 	root := &Node{}
 	scanner := bufio.NewScanner(rwc)
+	currentLine := 0
 
 	for scanner.Scan() {
+		currentLine++
+		startLine := currentLine
 		scannedLine := strings.TrimLeftFunc(scanner.Text(), unicode.IsSpace)
 		line, child, err := parseLine(scannedLine)
 		if err != nil {
@@ -110,6 +120,7 @@ func Parse(rwc io.Reader) (*Node, error) {
 
 		if line != "" && child == nil {
 			for scanner.Scan() {
+				currentLine++
 				newline := scanner.Text()
 
 				if stripComments(strings.TrimSpace(newline)) == "" {
@@ -134,6 +145,8 @@ func Parse(rwc io.Reader) (*Node, error) {
 		}
 
 		if child != nil {
+			child.StartLine = startLine
+			child.EndLine = currentLine
 			root.Children = append(root.Children, child)
 		}
 	}
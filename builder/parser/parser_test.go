@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -73,3 +74,28 @@ func TestTestData(t *testing.T) {
 		}
 	}
 }
+
+func TestParseLineNumbers(t *testing.T) {
+	dockerfile := `# comment
+FROM busybox
+
+RUN echo hello \
+# continued comment
+    world
+CMD ["/bin/sh"]
+`
+	ast, err := Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][2]int{{2, 2}, {4, 6}, {7, 7}}
+	if len(ast.Children) != len(expected) {
+		t.Fatalf("expected %d nodes, got %d", len(expected), len(ast.Children))
+	}
+	for i, n := range ast.Children {
+		if n.StartLine != expected[i][0] || n.EndLine != expected[i][1] {
+			t.Fatalf("%s: expected lines %d-%d, got %d-%d", n.Value, expected[i][0], expected[i][1], n.StartLine, n.EndLine)
+		}
+	}
+}
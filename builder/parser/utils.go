@@ -11,6 +11,10 @@ func (node *Node) Dump() string {
 	str := ""
 	str += node.Value
 
+	if len(node.Flags) > 0 {
+		str += " " + strings.Join(node.Flags, " ")
+	}
+
 	for _, n := range node.Children {
 		str += "(" + n.Dump() + ")\n"
 	}
@@ -64,6 +68,22 @@ func splitCommand(line string) (string, string, error) {
 	return cmd, args, nil
 }
 
+// extractChownFlags splits the leading --chown= words off the arguments of
+// ADD and COPY. Other words starting with "--" are left in the arguments,
+// as sources named like flags were copied before --chown existed.
+func extractChownFlags(args string) ([]string, string) {
+	var flags []string
+	for strings.HasPrefix(args, "--chown=") {
+		words := TOKEN_WHITESPACE.Split(args, 2)
+		args = ""
+		if len(words) == 2 {
+			args = strings.TrimSpace(words[1])
+		}
+		flags = append(flags, words[0])
+	}
+	return flags, args
+}
+
 // covers comments and empty lines. Lines should be trimmed before passing to
 // this function.
 func stripComments(line string) string {
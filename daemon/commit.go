@@ -86,6 +86,9 @@ func (daemon *Daemon) Commit(container *Container, repository, tag, comment, aut
 	if err != nil {
 		return nil, err
 	}
+	if err := daemon.repositories.InheritImageTrust(img.ID, parentImageID); err != nil {
+		return img, err
+	}
 
 	// Register the image if needed
 	if repository != "" {
@@ -49,6 +49,10 @@ type Config struct {
 	Labels                      []string
 	Ulimits                     map[string]*ulimit.Ulimit
 	LogConfig                   runconfig.LogConfig
+	MaxConcurrentDownloads      int
+	MaxConcurrentUploads        int
+	RequireSignedImages         bool
+	RetentionPolicy             string
 }
 
 // InstallFlags adds command-line options to the top-level flag parser for
@@ -84,6 +88,10 @@ func (config *Config) InstallFlags() {
 	config.Ulimits = make(map[string]*ulimit.Ulimit)
 	opts.UlimitMapVar(config.Ulimits, []string{"-default-ulimit"}, "Set default ulimits for containers")
 	flag.StringVar(&config.LogConfig.Type, []string{"-log-driver"}, "json-file", "Containers logging driver(json-file/none)")
+	flag.IntVar(&config.MaxConcurrentDownloads, []string{"-max-concurrent-downloads"}, 3, "Set the max concurrent layer downloads")
+	flag.IntVar(&config.MaxConcurrentUploads, []string{"-max-concurrent-uploads"}, 5, "Set the max concurrent layer uploads")
+	flag.BoolVar(&config.RequireSignedImages, []string{"-require-signed-images"}, false, "Only pull and run images signed by trusted keys")
+	flag.StringVar(&config.RetentionPolicy, []string{"-retention-policy"}, "", "Path to the image retention policy file")
 }
 
 func getDefaultNetworkMtu() int {
@@ -9,6 +9,7 @@ import (
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/parsers"
 	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/utils"
 	"github.com/docker/libcontainer/label"
 )
 
@@ -44,6 +45,10 @@ func (daemon *Daemon) ContainerCreate(job *engine.Job) engine.Status {
 		return job.Errorf("You should always set the Memory limit when using Memoryswap limit, see usage.\n")
 	}
 
+	pullWarning, err := daemon.pullForCreate(job, config.Image, job.Getenv("pull"))
+	if err != nil {
+		return job.Error(err)
+	}
 	container, buildWarnings, err := daemon.Create(config, hostConfig, name)
 	if err != nil {
 		if daemon.Graph().IsNotExist(err) {
@@ -55,6 +60,9 @@ func (daemon *Daemon) ContainerCreate(job *engine.Job) engine.Status {
 		}
 		return job.Error(err)
 	}
+	if pullWarning != "" {
+		job.Errorf("%s\n", pullWarning)
+	}
 	if !container.Config.NetworkDisabled && daemon.SystemConfig().IPv4ForwardingDisabled {
 		job.Errorf("IPv4 forwarding is disabled.\n")
 	}
@@ -69,6 +77,53 @@ func (daemon *Daemon) ContainerCreate(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+// pullForCreate pulls the image of a new container if the pull policy asks
+// for it, and returns a warning telling whether a newer image was pulled.
+// Missing images are otherwise left to the client to pull, and images
+// referred to by ID or digest never change, so they are not pulled again.
+func (daemon *Daemon) pullForCreate(job *engine.Job, name, policy string) (string, error) {
+	if policy == "" || name == "" {
+		return "", nil
+	}
+	if err := graph.ValidatePullPolicy(policy); err != nil {
+		return "", err
+	}
+	if policy != graph.PullAlways {
+		return "", nil
+	}
+
+	img, err := daemon.repositories.LookupImage(name)
+	if err != nil && !daemon.Graph().IsNotExist(err) {
+		return "", err
+	}
+	repoName, tag := parsers.ParseRepositoryTag(name)
+	if img != nil && (strings.HasPrefix(img.ID, name) || utils.DigestReference(tag)) {
+		return "", nil
+	}
+	if tag == "" {
+		tag = graph.DEFAULTTAG
+	}
+	ref := utils.ImageReference(repoName, tag)
+
+	pull := job.Eng.Job("pull", repoName, tag)
+	pull.Setenv("authConfig", job.Getenv("authConfig"))
+	pull.Setenv("metaHeaders", job.Getenv("metaHeaders"))
+	if err := pull.Run(); err != nil {
+		return "", fmt.Errorf("Error pulling %s: %s", ref, err)
+	}
+	pulled, err := daemon.repositories.LookupImage(ref)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case img == nil:
+		return fmt.Sprintf("Pulled %s", ref), nil
+	case img.ID != pulled.ID:
+		return fmt.Sprintf("Pulled a newer image for %s", ref), nil
+	}
+	return fmt.Sprintf("Image %s is up to date", ref), nil
+}
+
 // Create creates a new container from the given configuration with a given name.
 func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.HostConfig, name string) (*Container, []string, error) {
 	var (
@@ -87,6 +142,11 @@ func (daemon *Daemon) Create(config *runconfig.Config, hostConfig *runconfig.Hos
 		if err = img.CheckDepth(); err != nil {
 			return nil, nil, err
 		}
+		// Builds create their containers here too, so the policy applies
+		// to the images they are based on
+		if err = daemon.repositories.CheckImageTrust(daemon.eng, config.Image, img.ID); err != nil {
+			return nil, nil, err
+		}
 		imgID = img.ID
 	}
 
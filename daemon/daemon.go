@@ -107,6 +107,8 @@ type Daemon struct {
 	trustStore       *trust.TrustStore
 	statsCollector   *statsCollector
 	defaultLogConfig runconfig.LogConfig
+	diskUsage        diskUsageCache
+	retention        *retentionPolicy
 }
 
 // Install installs daemon capabilities to eng.
@@ -136,6 +138,11 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 		"unpause":           daemon.ContainerUnpause,
 		"wait":              daemon.ContainerWait,
 		"image_delete":      daemon.ImageDelete, // FIXME: see above
+		"container_prune":   daemon.ContainerPrune,
+		"image_prune":       daemon.ImagePrune,
+		"image_retention":   daemon.ImageRetention,
+		"volume_prune":      daemon.VolumePrune,
+		"system_df":         daemon.SystemDiskUsage,
 		"execCreate":        daemon.ContainerExecCreate,
 		"execStart":         daemon.ContainerExecStart,
 		"execResize":        daemon.ContainerExecResize,
@@ -930,6 +937,8 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't create Tag store: %s", err)
 	}
+	repositories.SetMaxConcurrentTransfers(config.MaxConcurrentDownloads, config.MaxConcurrentUploads)
+	repositories.SetRequireSigned(config.RequireSignedImages)
 
 	trustDir := path.Join(config.Root, "trust")
 	if err := os.MkdirAll(trustDir, 0700); err != nil && !os.IsExist(err) {
@@ -1035,6 +1044,25 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 		return nil, err
 	}
 
+	// Images created by older versions have random IDs; move them to
+	// content-addressable IDs, except for those containers are based on.
+	var inUse []string
+	for _, container := range daemon.List() {
+		inUse = append(inUse, container.ImageID)
+	}
+	if err := daemon.repositories.MigrateImageIDs(inUse); err != nil {
+		log.Errorf("Error migrating images to content-addressable IDs: %v", err)
+	}
+
+	if config.RetentionPolicy != "" {
+		if daemon.retention, err = loadRetentionPolicy(config.RetentionPolicy); err != nil {
+			return nil, err
+		}
+		if daemon.retention.interval > 0 {
+			go daemon.applyRetentionPeriodically()
+		}
+	}
+
 	// set up filesystem watch on resolv.conf for network changes
 	if err := daemon.setupResolvconfWatcher(); err != nil {
 		return nil, err
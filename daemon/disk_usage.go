@@ -0,0 +1,214 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/directory"
+)
+
+// diskUsageCacheTTL is how long the sizes of containers and volumes are
+// reused. Computing them walks all their files, which is slow on hosts with
+// many of them; everything else is read from metadata on each call.
+const diskUsageCacheTTL = 30 * time.Second
+
+// diskUsageCache holds the sizes of the RW layers of containers and of
+// volumes, by ID.
+type diskUsageCache struct {
+	sync.Mutex
+	containers map[string]cachedSize
+	volumes    map[string]cachedSize
+}
+
+type cachedSize struct {
+	size    int64
+	updated time.Time
+}
+
+// lookupSize returns the size of id from sizes if it is recent enough, or
+// computes it. The size is also stored in next, which replaces sizes once
+// all the containers or volumes are listed, so that removed ones are dropped.
+func lookupSize(sizes map[string]cachedSize, id string, compute func() int64, next map[string]cachedSize) int64 {
+	if c, exists := sizes[id]; exists && time.Since(c.updated) < diskUsageCacheTTL {
+		next[id] = c
+		return c.size
+	}
+	c := cachedSize{size: compute(), updated: time.Now()}
+	next[id] = c
+	return c.size
+}
+
+// SystemDiskUsage reports the disk space used by images, containers and
+// volumes.
+func (daemon *Daemon) SystemDiskUsage(job *engine.Job) engine.Status {
+	usage, err := daemon.getDiskUsage()
+	if err != nil {
+		return job.Error(err)
+	}
+	if err := json.NewEncoder(job.Stdout).Encode(usage); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// getDiskUsage computes the disk usage, with the cached sizes of containers
+// and volumes. Concurrent callers wait for each other so that a size is
+// computed once.
+func (daemon *Daemon) getDiskUsage() (*types.DiskUsage, error) {
+	c := &daemon.diskUsage
+	c.Lock()
+	defer c.Unlock()
+
+	usage := &types.DiskUsage{
+		Images:     []*types.ImageDiskUsage{},
+		Containers: []*types.ContainerDiskUsage{},
+		Volumes:    []*types.VolumeDiskUsage{},
+	}
+	if err := daemon.imagesDiskUsage(usage); err != nil {
+		return nil, err
+	}
+	daemon.containersDiskUsage(usage)
+	daemon.volumesDiskUsage(usage)
+	return usage, nil
+}
+
+func (daemon *Daemon) imagesDiskUsage(usage *types.DiskUsage) error {
+	images, err := daemon.Graph().Map()
+	if err != nil {
+		return err
+	}
+	containers := make(map[string]int)
+	for _, container := range daemon.List() {
+		containers[container.ImageID]++
+	}
+	usage.LayersSize, usage.Images = imagesDiskUsage(images, daemon.Repositories().ByID(), containers)
+	return nil
+}
+
+// imagesDiskUsage lists the images shown by `docker images`, which are the
+// tagged images and the images that are not the parent of another one. A
+// layer is shared if it belongs to more than one of those images. It also
+// returns the size of all the layers.
+func imagesDiskUsage(images map[string]*image.Image, byID map[string][]string, containers map[string]int) (int64, []*types.ImageDiskUsage) {
+	var (
+		layersSize int64
+		list       = []*types.ImageDiskUsage{}
+		isParent   = make(map[string]bool)
+		refs       = make(map[string]int)
+	)
+	for _, img := range images {
+		isParent[img.Parent] = true
+	}
+	for id, img := range images {
+		layersSize += img.Size
+		if len(byID[id]) == 0 && isParent[id] {
+			continue
+		}
+		for p := img; p != nil; p = images[p.Parent] {
+			refs[p.ID]++
+		}
+		u := &types.ImageDiskUsage{
+			ID:         id,
+			RepoTags:   []string{},
+			Created:    img.Created.Unix(),
+			Containers: containers[id],
+		}
+		for _, name := range byID[id] {
+			if !strings.Contains(name, "@") {
+				u.RepoTags = append(u.RepoTags, name)
+			}
+		}
+		list = append(list, u)
+	}
+
+	for _, u := range list {
+		for p := images[u.ID]; p != nil; p = images[p.Parent] {
+			u.Size += p.Size
+			if refs[p.ID] > 1 {
+				u.SharedSize += p.Size
+			}
+		}
+	}
+	sort.Sort(imagesByCreated(list))
+	return layersSize, list
+}
+
+func (daemon *Daemon) containersDiskUsage(usage *types.DiskUsage) {
+	c := &daemon.diskUsage
+	sizes := make(map[string]cachedSize)
+	for _, container := range daemon.List() {
+		sizeRw := lookupSize(c.containers, container.ID, func() int64 {
+			sizeRw, _ := container.GetSize()
+			return sizeRw
+		}, sizes)
+		var logSize int64
+		if container.LogPath != "" {
+			if fi, err := os.Stat(container.LogPath); err == nil {
+				logSize = fi.Size()
+			}
+		}
+		usage.Containers = append(usage.Containers, &types.ContainerDiskUsage{
+			ID:      container.ID,
+			Names:   []string{container.Name},
+			Image:   container.Config.Image,
+			Created: container.Created.Unix(),
+			Running: container.IsRunning(),
+			SizeRw:  sizeRw,
+			LogSize: logSize,
+		})
+	}
+	c.containers = sizes
+	sort.Sort(containersByCreated(usage.Containers))
+}
+
+func (daemon *Daemon) volumesDiskUsage(usage *types.DiskUsage) {
+	c := &daemon.diskUsage
+	sizes := make(map[string]cachedSize)
+	for _, v := range daemon.volumes.List() {
+		if v.IsBindMount {
+			continue
+		}
+		size := lookupSize(c.volumes, v.ID, func() int64 {
+			size, err := directory.Size(v.Path)
+			if err != nil {
+				log.Debugf("Error computing the size of volume %s: %v", v.ID, err)
+				return -1
+			}
+			return size
+		}, sizes)
+		usage.Volumes = append(usage.Volumes, &types.VolumeDiskUsage{
+			ID:         v.ID,
+			Path:       v.Path,
+			Size:       size,
+			Containers: len(v.Containers()),
+		})
+	}
+	c.volumes = sizes
+	sort.Sort(volumesByID(usage.Volumes))
+}
+
+type imagesByCreated []*types.ImageDiskUsage
+
+func (r imagesByCreated) Len() int           { return len(r) }
+func (r imagesByCreated) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r imagesByCreated) Less(i, j int) bool { return r[i].Created > r[j].Created }
+
+type containersByCreated []*types.ContainerDiskUsage
+
+func (r containersByCreated) Len() int           { return len(r) }
+func (r containersByCreated) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r containersByCreated) Less(i, j int) bool { return r[i].Created > r[j].Created }
+
+type volumesByID []*types.VolumeDiskUsage
+
+func (r volumesByID) Len() int           { return len(r) }
+func (r volumesByID) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r volumesByID) Less(i, j int) bool { return r[i].ID < r[j].ID }
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/image"
+)
+
+func TestImagesDiskUsage(t *testing.T) {
+	now := time.Now()
+	images := map[string]*image.Image{
+		"base":   {ID: "base", Size: 100, Created: now.Add(-3 * time.Hour)},
+		"middle": {ID: "middle", Parent: "base", Size: 10, Created: now.Add(-2 * time.Hour)},
+		"app1":   {ID: "app1", Parent: "middle", Size: 1, Created: now.Add(-time.Hour)},
+		"app2":   {ID: "app2", Parent: "base", Size: 2, Created: now},
+	}
+	byID := map[string][]string{
+		"base": {"base:latest", "base@sha256:4a8f8d5c2b8e0f7a3b0c5a8b0d9e6f5c4b3a2d1e0f9e8d7c6b5a4f3e2d1c0b9a"},
+		"app1": {"app:1"},
+	}
+	containers := map[string]int{"app1": 2}
+
+	layersSize, list := imagesDiskUsage(images, byID, containers)
+	if layersSize != 113 {
+		t.Fatalf("Expected a layers size of 113, got %d", layersSize)
+	}
+
+	// middle is neither tagged nor a head, so it is not listed
+	expected := []struct {
+		id         string
+		repoTags   int
+		size       int64
+		sharedSize int64
+		containers int
+	}{
+		{"app2", 0, 102, 100, 0},
+		{"app1", 1, 111, 100, 2},
+		{"base", 1, 100, 100, 0},
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("Expected %d images, got %d", len(expected), len(list))
+	}
+	for i, e := range expected {
+		u := list[i]
+		if u.ID != e.id || len(u.RepoTags) != e.repoTags || u.Size != e.size || u.SharedSize != e.sharedSize || u.Containers != e.containers {
+			t.Errorf("Expected %+v, got %+v", e, *u)
+		}
+	}
+}
+
+func TestLookupSize(t *testing.T) {
+	computed := 0
+	compute := func() int64 {
+		computed++
+		return int64(computed)
+	}
+	sizes := map[string]cachedSize{
+		"recent":  {size: 10, updated: time.Now()},
+		"expired": {size: 20, updated: time.Now().Add(-2 * diskUsageCacheTTL)},
+		"removed": {size: 30, updated: time.Now()},
+	}
+	next := make(map[string]cachedSize)
+	if size := lookupSize(sizes, "recent", compute, next); size != 10 || computed != 0 {
+		t.Fatalf("Expected the cached size 10, got %d", size)
+	}
+	if size := lookupSize(sizes, "expired", compute, next); size != 1 {
+		t.Fatalf("Expected the expired size to be computed again, got %d", size)
+	}
+	if size := lookupSize(sizes, "new", compute, next); size != 2 {
+		t.Fatalf("Expected the new size to be computed, got %d", size)
+	}
+	if len(next) != 3 || next["expired"].size != 1 {
+		t.Fatalf("Expected the sizes of this call only, got %v", next)
+	}
+}
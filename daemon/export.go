@@ -4,6 +4,8 @@ import (
 	"io"
 
 	"github.com/docker/docker/engine"
+	"github.com/docker/docker/graph"
+	"github.com/docker/docker/pkg/archive"
 )
 
 func (daemon *Daemon) ContainerExport(job *engine.Job) engine.Status {
@@ -12,6 +14,15 @@ func (daemon *Daemon) ContainerExport(job *engine.Job) engine.Status {
 	}
 	name := job.Args[0]
 
+	var level int
+	compress := job.GetenvInt("compressionLevel") != 0
+	if compress {
+		var err error
+		if level, err = graph.CompressionLevel(job); err != nil {
+			return job.Error(err)
+		}
+	}
+
 	container, err := daemon.Get(name)
 	if err != nil {
 		return job.Error(err)
@@ -24,7 +35,19 @@ func (daemon *Daemon) ContainerExport(job *engine.Job) engine.Status {
 	defer data.Close()
 
 	// Stream the entire contents of the container (basically a volatile snapshot)
-	if _, err := io.Copy(job.Stdout, data); err != nil {
+	if compress {
+		gz, err := archive.NewParallelGzipWriter(job.Stdout, level)
+		if err != nil {
+			return job.Error(err)
+		}
+		if _, err := io.Copy(gz, data); err != nil {
+			gz.Close()
+			return job.Errorf("%s: %s", name, err)
+		}
+		if err := gz.Close(); err != nil {
+			return job.Errorf("%s: %s", name, err)
+		}
+	} else if _, err := io.Copy(job.Stdout, data); err != nil {
 		return job.Errorf("%s: %s", name, err)
 	}
 	// FIXME: factor job-specific LogEvent to engine.Job.Run()
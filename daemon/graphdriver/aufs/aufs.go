@@ -248,6 +248,27 @@ func (a *Driver) Remove(id string) error {
 	return nil
 }
 
+// Rename moves the directories and the layers file of id to newID. The
+// layers files of other layers only refer to their parents, so a layer
+// without children can be renamed.
+func (a *Driver) Rename(id, newID string) error {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.active[id] != 0 {
+		return fmt.Errorf("%s is in use", id)
+	}
+	if a.Exists(newID) {
+		return fmt.Errorf("%s already exists", newID)
+	}
+	for _, p := range []string{"mnt", "diff", "layers"} {
+		if err := os.Rename(path.Join(a.rootPath(), p, id), path.Join(a.rootPath(), p, newID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Return the rootfs path for the id
 // This will mount the dir at it's given path
 func (a *Driver) Get(id, mountLabel string) (string, error) {
@@ -206,6 +206,15 @@ func (d *Driver) Remove(id string) error {
 	return os.RemoveAll(dir)
 }
 
+// Rename moves the subvolume of id, subvolumes can be renamed like
+// directories.
+func (d *Driver) Rename(id, newID string) error {
+	if d.Exists(newID) {
+		return fmt.Errorf("%s already exists", newID)
+	}
+	return os.Rename(d.subvolumesDirId(id), d.subvolumesDirId(newID))
+}
+
 func (d *Driver) Get(id, mountLabel string) (string, error) {
 	dir := d.subvolumesDirId(id)
 	st, err := os.Stat(dir)
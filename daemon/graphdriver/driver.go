@@ -101,6 +101,15 @@ type ProtoDriver interface {
 	Cleanup() error
 }
 
+// Renamer is implemented by drivers that can move a layer to another ID, so
+// that a layer can be written before its ID is known. Only layers that have
+// no children and are not in use are renamed.
+type Renamer interface {
+	// Rename moves the layer with the specified id to newID. It fails if
+	// a layer with newID exists.
+	Rename(id, newID string) error
+}
+
 // Driver is the interface for layered/snapshot file system drivers.
 type Driver interface {
 	ProtoDriver
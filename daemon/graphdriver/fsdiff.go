@@ -27,10 +27,26 @@ type naiveDiffDriver struct {
 //     Changes(id, parent string) ([]archive.Change, error)
 //     ApplyDiff(id, parent string, diff archive.ArchiveReader) (size int64, err error)
 //     DiffSize(id, parent string) (size int64, err error)
+//
+// The returned driver is a Renamer if the given one is.
 func NaiveDiffDriver(driver ProtoDriver) Driver {
+	if renamer, ok := driver.(Renamer); ok {
+		return &naiveDiffRenamer{naiveDiffDriver: &naiveDiffDriver{ProtoDriver: driver}, renamer: renamer}
+	}
 	return &naiveDiffDriver{ProtoDriver: driver}
 }
 
+// naiveDiffRenamer is a naiveDiffDriver for a ProtoDriver that can rename
+// layers.
+type naiveDiffRenamer struct {
+	*naiveDiffDriver
+	renamer Renamer
+}
+
+func (gdw *naiveDiffRenamer) Rename(id, newID string) error {
+	return gdw.renamer.Rename(id, newID)
+}
+
 // Diff produces an archive of the changes between the specified
 // layer and its parent layer which may be "".
 func (gdw *naiveDiffDriver) Diff(id, parent string) (arch archive.Archive, err error) {
@@ -43,6 +43,10 @@ func NaiveDiffDriverWithApply(driver ApplyDiffProtoDriver) graphdriver.Driver {
 	}
 }
 
+func (d *naiveDiffDriverWithApply) Rename(id, newID string) error {
+	return d.applyDiff.(graphdriver.Renamer).Rename(id, newID)
+}
+
 func (d *naiveDiffDriverWithApply) ApplyDiff(id, parent string, diff archive.ArchiveReader) (int64, error) {
 	b, err := d.applyDiff.ApplyDiff(id, parent, diff)
 	if err == ErrApplyDiffFallback {
@@ -264,6 +268,15 @@ func (d *Driver) Remove(id string) error {
 	return os.RemoveAll(dir)
 }
 
+// Rename moves the directory of the layer id, which holds no reference to
+// its own ID.
+func (d *Driver) Rename(id, newID string) error {
+	if d.Exists(newID) {
+		return fmt.Errorf("%s already exists", newID)
+	}
+	return os.Rename(d.dir(id), d.dir(newID))
+}
+
 func (d *Driver) Get(id string, mountLabel string) (string, error) {
 	// Protect the d.active from concurrent access
 	d.Lock()
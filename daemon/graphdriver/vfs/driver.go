@@ -73,6 +73,13 @@ func (d *Driver) Remove(id string) error {
 	return os.RemoveAll(d.dir(id))
 }
 
+func (d *Driver) Rename(id, newID string) error {
+	if d.Exists(newID) {
+		return fmt.Errorf("%s already exists", newID)
+	}
+	return os.Rename(d.dir(id), d.dir(newID))
+}
+
 func (d *Driver) Get(id, mountLabel string) (string, error) {
 	dir := d.dir(id)
 	if st, err := os.Stat(dir); err != nil {
@@ -0,0 +1,235 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/directory"
+	"github.com/docker/docker/pkg/parsers/filters"
+	"github.com/docker/docker/pkg/timeutils"
+)
+
+var acceptedPruneFilterTags = map[string]struct{}{
+	"until": {},
+	"label": {},
+}
+
+// pruneFilters holds the filters shared by the prune jobs. Objects are only
+// pruned if they were created before until and match the label filters.
+type pruneFilters struct {
+	args  filters.Args
+	until time.Time
+}
+
+func newPruneFilters(param string) (*pruneFilters, error) {
+	args, err := filters.FromParam(param)
+	if err != nil {
+		return nil, err
+	}
+	for name := range args {
+		if _, ok := acceptedPruneFilterTags[name]; !ok {
+			return nil, fmt.Errorf("Invalid filter '%s'", name)
+		}
+	}
+	f := &pruneFilters{args: args}
+	if values := args["until"]; len(values) > 0 {
+		if len(values) > 1 {
+			return nil, fmt.Errorf("Only one until filter is allowed")
+		}
+		if f.until, err = timeutils.GetTimestamp(values[0], time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *pruneFilters) match(created time.Time, labels map[string]string) bool {
+	if !f.until.IsZero() && !created.Before(f.until) {
+		return false
+	}
+	return f.args.MatchKVList("label", labels)
+}
+
+// ContainerPrune removes all the stopped containers that match the
+// filters and reports their IDs and the size of their RW layers.
+func (daemon *Daemon) ContainerPrune(job *engine.Job) engine.Status {
+	filt, err := newPruneFilters(job.Getenv("filters"))
+	if err != nil {
+		return job.Error(err)
+	}
+
+	var (
+		deleted   = []string{}
+		reclaimed int64
+	)
+	for _, container := range daemon.List() {
+		if container.IsRunning() || container.IsRestarting() {
+			continue
+		}
+		if !filt.match(container.Created, container.Config.Labels) {
+			continue
+		}
+		sizeRw, _ := container.GetSize()
+		if err := daemon.Rm(container); err != nil {
+			log.Errorf("Cannot destroy container %s: %s", container.ID, err)
+			continue
+		}
+		container.LogEvent("destroy")
+		deleted = append(deleted, container.ID)
+		if sizeRw > 0 {
+			reclaimed += sizeRw
+		}
+	}
+
+	out := &engine.Env{}
+	out.SetList("ContainersDeleted", deleted)
+	out.SetInt64("SpaceReclaimed", reclaimed)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// ImagePrune removes the dangling images, which are untagged and have no
+// children, that match the filters. If all is set, tagged images that no
+// container uses are removed as well. The parents of removed images are
+// removed too when that leaves them dangling.
+func (daemon *Daemon) ImagePrune(job *engine.Job) engine.Status {
+	filt, err := newPruneFilters(job.Getenv("filters"))
+	if err != nil {
+		return job.Error(err)
+	}
+	all := job.GetenvBool("all")
+	used := daemon.usedImages()
+
+	// The images are deleted like with docker rmi --no-prune, so the
+	// images used by a container since the walk above are skipped.
+	var (
+		deleted   = []map[string]string{}
+		reclaimed int64
+	)
+	for {
+		heads, err := daemon.Graph().Heads()
+		if err != nil {
+			return writeImagePruneReport(job, deleted, reclaimed, err)
+		}
+		byID := daemon.Repositories().ByID()
+		pruned := false
+		for id, img := range heads {
+			if used[id] || (len(byID[id]) > 0 && !all) {
+				continue
+			}
+			var labels map[string]string
+			if img.Config != nil {
+				labels = img.Config.Labels
+			}
+			if !filt.match(img.Created, labels) {
+				continue
+			}
+			imgs := engine.NewTable("", 0)
+			if len(byID[id]) == 0 {
+				err = daemon.DeleteImage(job.Eng, id, imgs, false, false, true)
+			}
+			for _, name := range byID[id] {
+				if err = daemon.DeleteImage(job.Eng, name, imgs, true, false, true); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				log.Debugf("Keeping image %s: %s", id, err)
+			}
+			for _, out := range imgs.Data {
+				if name := out.Get("Untagged"); name != "" {
+					deleted = append(deleted, map[string]string{"Untagged": name})
+				} else if out.Exists("Deleted") {
+					deleted = append(deleted, map[string]string{"Deleted": id})
+					reclaimed += img.Size
+					pruned = true
+				}
+			}
+		}
+		// Removing an image can leave its parent dangling
+		if !pruned {
+			break
+		}
+	}
+	return writeImagePruneReport(job, deleted, reclaimed, nil)
+}
+
+// writeImagePruneReport writes the images deleted so far and the space
+// reclaimed, so that an error still reports the partial work, and then
+// returns the status for err.
+func writeImagePruneReport(job *engine.Job, deleted []map[string]string, reclaimed int64, err error) engine.Status {
+	out := &engine.Env{}
+	out.SetJson("ImagesDeleted", deleted)
+	out.SetInt64("SpaceReclaimed", reclaimed)
+	if _, werr := out.WriteTo(job.Stdout); werr != nil && err == nil {
+		err = werr
+	}
+	if err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// usedImages returns the IDs of the images used by containers and of their
+// parents, which are never pruned.
+func (daemon *Daemon) usedImages() map[string]bool {
+	used := make(map[string]bool)
+	for _, container := range daemon.List() {
+		img, err := daemon.Graph().Get(container.ImageID)
+		if err != nil {
+			continue
+		}
+		img.WalkHistory(func(p *image.Image) error {
+			used[p.ID] = true
+			return nil
+		})
+	}
+	return used
+}
+
+// VolumePrune removes the volumes that no container uses and that match
+// the filters. Bind mounts are never removed. Volumes do not have labels, so
+// they never match a label filter, and volumes created by older versions
+// match any until filter.
+func (daemon *Daemon) VolumePrune(job *engine.Job) engine.Status {
+	filt, err := newPruneFilters(job.Getenv("filters"))
+	if err != nil {
+		return job.Error(err)
+	}
+
+	var (
+		deleted   = []string{}
+		reclaimed int64
+	)
+	for _, v := range daemon.volumes.List() {
+		if v.IsBindMount || len(v.Containers()) > 0 {
+			continue
+		}
+		if !filt.match(v.Created, nil) {
+			continue
+		}
+		size, err := directory.Size(v.Path)
+		if err != nil {
+			log.Debugf("Error computing the size of volume %s: %v", v.ID, err)
+		}
+		if err := daemon.volumes.Delete(v.Path); err != nil {
+			log.Errorf("Cannot remove volume %s: %s", v.ID, err)
+			continue
+		}
+		deleted = append(deleted, v.ID)
+		reclaimed += size
+	}
+
+	out := &engine.Env{}
+	out.SetList("VolumesDeleted", deleted)
+	out.SetInt64("SpaceReclaimed", reclaimed)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
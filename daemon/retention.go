@@ -0,0 +1,354 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/units"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/utils"
+)
+
+// retentionPolicy holds the rules that decide which tags and images are
+// removed from the daemon, as read from the file given with
+// --retention-policy. Images that containers use, and images with one of
+// KeepLabels, are never removed.
+type retentionPolicy struct {
+	// Interval is how often the policy is applied; it is only applied on
+	// demand if empty.
+	Interval string
+	// Repositories limit the number of tags of repositories.
+	Repositories []repositoryRetention
+	// UntaggedOlderThan is the age after which untagged images are
+	// removed, such as "12h" or "7d". Untagged images are kept if empty.
+	UntaggedOlderThan string
+	// KeepLabels protect the images with one of these labels, given as
+	// key or key=value.
+	KeepLabels []string
+
+	interval    time.Duration
+	untaggedAge time.Duration
+}
+
+// repositoryRetention keeps the KeepTags most recent tags of the repository
+// Name, by the creation time of their images.
+type repositoryRetention struct {
+	Name     string
+	KeepTags int
+}
+
+// loadRetentionPolicy reads and validates the retention policy file at
+// path.
+func loadRetentionPolicy(path string) (*retentionPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &retentionPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("Invalid retention policy %s: %s", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid retention policy %s: %s", path, err)
+	}
+	return policy, nil
+}
+
+func (policy *retentionPolicy) validate() error {
+	var err error
+	if policy.Interval != "" {
+		if policy.interval, err = parseRetentionAge(policy.Interval); err != nil {
+			return err
+		}
+		if policy.interval == 0 {
+			return fmt.Errorf("Interval must not be zero")
+		}
+	}
+	if policy.UntaggedOlderThan != "" {
+		if policy.untaggedAge, err = parseRetentionAge(policy.UntaggedOlderThan); err != nil {
+			return err
+		}
+	}
+	for i, r := range policy.Repositories {
+		if r.Name == "" {
+			return fmt.Errorf("The name of a repository must not be empty")
+		}
+		if r.KeepTags < 1 {
+			return fmt.Errorf("KeepTags of %s must be at least 1", r.Name)
+		}
+		policy.Repositories[i].Name = registry.NormalizeLocalName(r.Name)
+	}
+	for _, label := range policy.KeepLabels {
+		if label == "" || strings.HasPrefix(label, "=") {
+			return fmt.Errorf("Invalid label %q", label)
+		}
+	}
+	return nil
+}
+
+// parseRetentionAge parses a duration, which may also be given in days,
+// such as "7d".
+func parseRetentionAge(value string) (time.Duration, error) {
+	var (
+		d   time.Duration
+		err error
+	)
+	if strings.HasSuffix(value, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(value, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("Invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// keeps tells whether labels protect an image from being removed.
+func (policy *retentionPolicy) keeps(labels map[string]string) bool {
+	for _, label := range policy.KeepLabels {
+		key, value := label, ""
+		hasValue := false
+		if i := strings.Index(label, "="); i >= 0 {
+			key, value, hasValue = label[:i], label[i+1:], true
+		}
+		if v, exists := labels[key]; exists && (!hasValue || v == value) {
+			return true
+		}
+	}
+	return false
+}
+
+// retentionPlan lists what applying a retention policy removes: the tags to
+// untag first, then the images to delete, children before their parents.
+type retentionPlan struct {
+	Untag  []string
+	Delete []string
+	Size   int64
+}
+
+// retentionTag is a tag of a repository and the image it refers to.
+type retentionTag struct {
+	tag     string
+	id      string
+	created time.Time
+}
+
+// byCreated sorts tags from the most recent image to the oldest one.
+type byCreated []retentionTag
+
+func (r byCreated) Len() int      { return len(r) }
+func (r byCreated) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byCreated) Less(i, j int) bool {
+	if r[i].created.Equal(r[j].created) {
+		return r[i].tag < r[j].tag
+	}
+	return r[i].created.After(r[j].created)
+}
+
+// planRetention computes what policy removes from images, given the
+// references of each image as returned by TagStore.ByID and the images
+// used by containers, with their parents.
+func planRetention(policy *retentionPolicy, images map[string]*image.Image, byID map[string][]string, used map[string]bool, now time.Time) *retentionPlan {
+	protected := func(id string) bool {
+		if used[id] {
+			return true
+		}
+		var labels map[string]string
+		if img := images[id]; img.Config != nil {
+			labels = img.Config.Labels
+		}
+		return policy.keeps(labels)
+	}
+
+	var (
+		plan     = &retentionPlan{}
+		refs     = make(map[string]int)
+		tags     = make(map[string][]retentionTag)
+		untagged = make(map[string]bool)
+	)
+	for id, names := range byID {
+		img, exists := images[id]
+		if !exists {
+			continue
+		}
+		refs[id] = len(names)
+		for _, name := range names {
+			repoName, tag := parsers.ParseRepositoryTag(name)
+			if tag != "" && !utils.DigestReference(tag) {
+				tags[repoName] = append(tags[repoName], retentionTag{tag, id, img.Created})
+			}
+		}
+	}
+
+	// The most recent tags of each repository are kept, not counting the
+	// ones of protected images.
+	for _, r := range policy.Repositories {
+		repoTags := tags[r.Name]
+		sort.Sort(byCreated(repoTags))
+		kept := 0
+		for _, t := range repoTags {
+			if protected(t.id) {
+				continue
+			}
+			if kept < r.KeepTags {
+				kept++
+				continue
+			}
+			plan.Untag = append(plan.Untag, utils.ImageReference(r.Name, t.tag))
+			refs[t.id]--
+			untagged[t.id] = true
+		}
+	}
+
+	children := make(map[string]int)
+	for _, img := range images {
+		if img.Parent != "" {
+			children[img.Parent]++
+		}
+	}
+	deleted := make(map[string]bool)
+	removable := func(id string) bool {
+		_, exists := images[id]
+		return exists && !deleted[id] && refs[id] == 0 && children[id] == 0 && !protected(id)
+	}
+
+	// Images left without references are removed, along with the parents
+	// that this leaves dangling as with docker rmi. Untagged images are
+	// removed once they are old enough.
+	var candidates []string
+	for id, img := range images {
+		if !removable(id) {
+			continue
+		}
+		if untagged[id] || (policy.UntaggedOlderThan != "" && img.Created.Before(now.Add(-policy.untaggedAge))) {
+			candidates = append(candidates, id)
+		}
+	}
+	sort.Strings(candidates)
+	for _, id := range candidates {
+		for removable(id) {
+			img := images[id]
+			plan.Delete = append(plan.Delete, id)
+			plan.Size += img.Size
+			deleted[id] = true
+			if img.Parent == "" {
+				break
+			}
+			children[img.Parent]--
+			id = img.Parent
+		}
+	}
+	return plan
+}
+
+// applyRetention removes the tags and images that the retention policy
+// selects, or only lists them if dryRun is set. It returns the removals in
+// the format of ImagePrune and the space reclaimed.
+func (daemon *Daemon) applyRetention(eng *engine.Engine, dryRun bool) ([]map[string]string, int64, error) {
+	images, err := daemon.Graph().Map()
+	if err != nil {
+		return nil, 0, err
+	}
+	used := daemon.usedImages()
+	plan := planRetention(daemon.retention, images, daemon.Repositories().ByID(), used, time.Now())
+
+	deleted := []map[string]string{}
+	if dryRun {
+		for _, name := range plan.Untag {
+			deleted = append(deleted, map[string]string{"Untagged": name})
+		}
+		for _, id := range plan.Delete {
+			deleted = append(deleted, map[string]string{"Deleted": id})
+		}
+		return deleted, plan.Size, nil
+	}
+
+	for _, name := range plan.Untag {
+		id := ""
+		if img, err := daemon.Repositories().LookupImage(name); err == nil {
+			id = img.ID
+		}
+		repoName, tag := parsers.ParseRepositoryTag(name)
+		tagDeleted, err := daemon.Repositories().Delete(repoName, tag)
+		if err != nil {
+			return deleted, 0, err
+		}
+		if tagDeleted {
+			deleted = append(deleted, map[string]string{"Untagged": name})
+			eng.Job("log", "untag", id, "").Run()
+		}
+	}
+
+	// The images are deleted like with docker rmi --no-prune, which skips
+	// the images tagged, used by a container or given a child since the
+	// plan was made. The parents of such images are then kept.
+	var (
+		reclaimed int64
+		kept      = make(map[string]bool)
+	)
+	for _, id := range plan.Delete {
+		img := images[id]
+		if kept[id] {
+			continue
+		}
+		imgs := engine.NewTable("", 0)
+		if err := daemon.DeleteImage(eng, id, imgs, false, false, true); err != nil || len(imgs.Data) == 0 {
+			if err != nil {
+				log.Debugf("Keeping image %s: %s", id, err)
+			}
+			for p := img; p != nil; p = images[p.Parent] {
+				kept[p.ID] = true
+			}
+			continue
+		}
+		deleted = append(deleted, map[string]string{"Deleted": id})
+		reclaimed += img.Size
+	}
+
+	return deleted, reclaimed, nil
+}
+
+// ImageRetention applies the retention policy of the daemon, removing the
+// tags and images it selects. With dryRun, they are only listed.
+func (daemon *Daemon) ImageRetention(job *engine.Job) engine.Status {
+	if daemon.retention == nil {
+		return job.Errorf("No retention policy is configured, start the daemon with --retention-policy")
+	}
+	deleted, reclaimed, err := daemon.applyRetention(job.Eng, job.GetenvBool("dryRun"))
+	if err != nil {
+		return job.Error(err)
+	}
+	out := &engine.Env{}
+	out.SetJson("ImagesDeleted", deleted)
+	out.SetInt64("SpaceReclaimed", reclaimed)
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// applyRetentionPeriodically applies the retention policy at its interval.
+func (daemon *Daemon) applyRetentionPeriodically() {
+	for _ = range time.Tick(daemon.retention.interval) {
+		deleted, reclaimed, err := daemon.applyRetention(daemon.eng, false)
+		if err != nil {
+			log.Errorf("Error applying the retention policy: %s", err)
+			continue
+		}
+		if len(deleted) > 0 {
+			log.Infof("Retention policy: removed %d tags and images, reclaimed %s", len(deleted), units.HumanSize(float64(reclaimed)))
+		}
+	}
+}
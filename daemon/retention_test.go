@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/runconfig"
+)
+
+func TestPlanRetention(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	images := map[string]*image.Image{
+		"base":      {ID: "base", Size: 100, Created: now.Add(-30 * day)},
+		"app0":      {ID: "app0", Parent: "base", Size: 1, Created: now.Add(-5 * time.Hour), Config: &runconfig.Config{Labels: map[string]string{"keep": "true"}}},
+		"app1":      {ID: "app1", Parent: "base", Size: 2, Created: now.Add(-4 * time.Hour)},
+		"app2":      {ID: "app2", Parent: "base", Size: 4, Created: now.Add(-3 * time.Hour)},
+		"app3":      {ID: "app3", Parent: "base", Size: 8, Created: now.Add(-2 * time.Hour)},
+		"app4":      {ID: "app4", Parent: "base", Size: 16, Created: now.Add(-time.Hour)},
+		"mid":       {ID: "mid", Parent: "base", Size: 32, Created: now.Add(-10 * day)},
+		"dangling1": {ID: "dangling1", Parent: "mid", Size: 64, Created: now.Add(-10 * day)},
+		"dangling2": {ID: "dangling2", Parent: "base", Size: 128, Created: now.Add(-day)},
+	}
+	byID := map[string][]string{
+		"base": {"base:latest"},
+		"app0": {"app:0"},
+		"app1": {"app:1"},
+		"app2": {"app:2"},
+		"app3": {"app:3"},
+		"app4": {"app:4", "app:latest"},
+	}
+	used := map[string]bool{"app2": true, "base": true}
+
+	policy := &retentionPolicy{
+		Repositories:      []repositoryRetention{{Name: "app", KeepTags: 2}},
+		UntaggedOlderThan: "7d",
+		KeepLabels:        []string{"keep=true"},
+	}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// app:4 and app:latest are the most recent tags, app:2 and app:0 are
+	// kept for their container and their label, and dangling1 is older than
+	// 7 days, which leaves mid dangling.
+	plan := planRetention(policy, images, byID, used, now)
+	if expected := []string{"app:3", "app:1"}; !reflect.DeepEqual(plan.Untag, expected) {
+		t.Fatalf("Expected to untag %v, got %v", expected, plan.Untag)
+	}
+	if expected := []string{"app1", "app3", "dangling1", "mid"}; !reflect.DeepEqual(plan.Delete, expected) {
+		t.Fatalf("Expected to delete %v, got %v", expected, plan.Delete)
+	}
+	if plan.Size != 106 {
+		t.Fatalf("Expected a size of 106, got %d", plan.Size)
+	}
+	if len(images) != 9 {
+		t.Fatal("Expected the images to be left untouched")
+	}
+
+	// Without rules for untagged images, only the untagged ones are deleted
+	policy.UntaggedOlderThan = ""
+	plan = planRetention(policy, images, byID, used, now)
+	if expected := []string{"app1", "app3"}; !reflect.DeepEqual(plan.Delete, expected) {
+		t.Fatalf("Expected to delete %v, got %v", expected, plan.Delete)
+	}
+}
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	policy := &retentionPolicy{Interval: "12h", UntaggedOlderThan: "7d"}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if policy.interval != 12*time.Hour || policy.untaggedAge != 7*24*time.Hour {
+		t.Fatalf("Unexpected durations %s and %s", policy.interval, policy.untaggedAge)
+	}
+
+	for _, invalid := range []*retentionPolicy{
+		{Interval: "0"},
+		{Interval: "weekly"},
+		{UntaggedOlderThan: "-1d"},
+		{Repositories: []repositoryRetention{{Name: "app"}}},
+		{Repositories: []repositoryRetention{{KeepTags: 1}}},
+		{KeepLabels: []string{"=true"}},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
@@ -79,6 +79,7 @@ func init() {
 			{"attach", "Attach to a running container"},
 			{"build", "Build an image from a Dockerfile"},
 			{"commit", "Create a new image from a container's changes"},
+			{"container prune", "Remove all stopped containers"},
 			{"cp", "Copy files/folders from a container's filesystem to the host path"},
 			{"create", "Create a new container"},
 			{"diff", "Inspect changes on a container's filesystem"},
@@ -86,6 +87,9 @@ func init() {
 			{"exec", "Run a command in a running container"},
 			{"export", "Stream the contents of a container as a tar archive"},
 			{"history", "Show the history of an image"},
+			{"image diff", "Show the changes between two images"},
+			{"image prune", "Remove unused images"},
+			{"image retention", "Apply the image retention policy of the daemon"},
 			{"images", "List images"},
 			{"import", "Create a new filesystem image from the contents of a tarball"},
 			{"info", "Display system-wide information"},
@@ -95,6 +99,7 @@ func init() {
 			{"login", "Register or log in to a Docker registry server"},
 			{"logout", "Log out from a Docker registry server"},
 			{"logs", "Fetch the logs of a container"},
+			{"manifest push", "Push a manifest list of images built for several platforms"},
 			{"port", "Lookup the public-facing port that is NAT-ed to PRIVATE_PORT"},
 			{"pause", "Pause all processes within a container"},
 			{"ps", "List containers"},
@@ -107,16 +112,21 @@ func init() {
 			{"run", "Run a command in a new container"},
 			{"save", "Save an image to a tar archive"},
 			{"search", "Search for an image on the Docker Hub"},
+			{"sign", "Push an image and sign its manifest with your key"},
 			{"start", "Start a stopped container"},
 			{"stats", "Display a stream of a containers' resource usage statistics"},
 			{"stop", "Stop a running container"},
+			{"system df", "Show docker disk usage"},
+			{"system prune", "Remove stopped containers and dangling images"},
 			{"tag", "Tag an image into a repository"},
+			{"tags", "List the tags of a repository in a registry"},
 			{"top", "Lookup the running processes of a container"},
 			{"unpause", "Unpause a paused container"},
 			{"version", "Show the Docker version information"},
+			{"volume prune", "Remove all volumes not used by a container"},
 			{"wait", "Block until a container stops, then print its exit code"},
 		} {
-			help += fmt.Sprintf("    %-10.10s%s\n", command[0], command[1])
+			help += fmt.Sprintf("    %-16.16s%s\n", command[0], command[1])
 		}
 		help += "\nRun 'docker COMMAND --help' for more information on a command."
 		fmt.Fprintf(os.Stdout, "%s\n", help)
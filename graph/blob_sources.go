@@ -0,0 +1,74 @@
+package graph
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// blobSourcesFile stores the repositories that layer blobs were pulled from
+// or pushed to, so that pushes to the same registry can mount them from
+// there instead of uploading them.
+const blobSourcesFile = "_blob_sources.json"
+
+// maxBlobSources is the number of repositories kept for each blob.
+const maxBlobSources = 5
+
+// blobSource is a repository of a registry that has a blob.
+type blobSource struct {
+	Registry   string
+	Repository string
+}
+
+// loadBlobSources reads the blob sources saved in the graph root.
+func (graph *Graph) loadBlobSources() error {
+	graph.blobSourcesLock.Lock()
+	defer graph.blobSourcesLock.Unlock()
+	jsonData, err := ioutil.ReadFile(path.Join(graph.Root, blobSourcesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(jsonData, &graph.blobSources)
+}
+
+// addBlobSource records that the blob with the given digest is in
+// repository on registry. The most recently recorded sources come first.
+func (graph *Graph) addBlobSource(digest, registry, repository string) error {
+	graph.blobSourcesLock.Lock()
+	defer graph.blobSourcesLock.Unlock()
+	if graph.blobSources == nil {
+		graph.blobSources = make(map[string][]blobSource)
+	}
+	source := blobSource{Registry: registry, Repository: repository}
+	sources := []blobSource{source}
+	for _, s := range graph.blobSources[digest] {
+		if s != source && len(sources) < maxBlobSources {
+			sources = append(sources, s)
+		}
+	}
+	graph.blobSources[digest] = sources
+
+	jsonData, err := json.Marshal(graph.blobSources)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(graph.Root, blobSourcesFile), jsonData, 0600)
+}
+
+// blobRepositories returns the repositories of registry known to have the
+// blob with the given digest, most recent first.
+func (graph *Graph) blobRepositories(digest, registry string) []string {
+	graph.blobSourcesLock.Lock()
+	defer graph.blobSourcesLock.Unlock()
+	var repositories []string
+	for _, s := range graph.blobSources[digest] {
+		if s.Registry == registry {
+			repositories = append(repositories, s.Repository)
+		}
+	}
+	return repositories
+}
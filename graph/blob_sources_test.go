@@ -0,0 +1,54 @@
+package graph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBlobSources(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-graph-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	graph := &Graph{Root: tmp}
+
+	digest := "tarsum.v1+sha256:" + testOfficialImageID
+	for _, source := range []blobSource{
+		{"docker.io", "library/busybox"},
+		{"registry.example.com", "base/busybox"},
+		{"docker.io", "myapp"},
+		{"docker.io", "library/busybox"},
+	} {
+		if err := graph.addBlobSource(digest, source.Registry, source.Repository); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if repos, expected := graph.blobRepositories(digest, "docker.io"), []string{"library/busybox", "myapp"}; !reflect.DeepEqual(repos, expected) {
+		t.Fatalf("Expected %v, got %v", expected, repos)
+	}
+	if repos := graph.blobRepositories("sha256:"+testPrivateImageID, "docker.io"); len(repos) != 0 {
+		t.Fatalf("Expected no repositories for an unknown blob, got %v", repos)
+	}
+
+	for i := 0; i < maxBlobSources; i++ {
+		if err := graph.addBlobSource(digest, "registry.example.com", fmt.Sprintf("app%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if repos := graph.blobRepositories(digest, "docker.io"); len(repos) != 0 {
+		t.Fatalf("Expected the oldest sources to be dropped, got %v", repos)
+	}
+
+	// The sources are kept across restarts
+	reloaded := &Graph{Root: tmp}
+	if err := reloaded.loadBlobSources(); err != nil {
+		t.Fatal(err)
+	}
+	if repos, expected := reloaded.blobRepositories(digest, "registry.example.com"), []string{"app4", "app3", "app2", "app1", "app0"}; !reflect.DeepEqual(repos, expected) {
+		t.Fatalf("Expected %v, got %v", expected, repos)
+	}
+}
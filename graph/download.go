@@ -0,0 +1,300 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/tarsum"
+)
+
+const (
+	// downloadsDir holds the layers being pulled, under the graph root, so
+	// that a download that was interrupted can be resumed.
+	downloadsDir = "_downloads"
+
+	// partialDownloadMaxAge is how long downloads interrupted by a restart of
+	// the daemon are kept. Younger ones are resumed by the next pull of the
+	// same layer.
+	partialDownloadMaxAge = 24 * time.Hour
+)
+
+var errDownloadAborted = errors.New("download aborted")
+
+// layerFetcher requests a layer from offset on. It returns the body, the
+// offset the body starts at, which is 0 if the server cannot resume
+// downloads, and the size of the whole layer, or 0 if it is unknown.
+type layerFetcher func(offset int64) (body io.ReadCloser, start int64, size int64, err error)
+
+// layerVerifier computes the checksum of a layer as it is downloaded.
+type layerVerifier interface {
+	io.Writer
+	// Sum ends the layer and returns its checksum.
+	Sum() (string, error)
+	// Close discards the verifier without computing the checksum.
+	Close() error
+}
+
+// layerDownload is a layer being downloaded to a file under the graph root.
+type layerDownload struct {
+	*os.File
+	size int64
+}
+
+// openDownload opens the download of the layer identified by key, keeping
+// the data already downloaded by a previous attempt.
+func (graph *Graph) openDownload(key string) (*layerDownload, error) {
+	dir := filepath.Join(graph.Root, downloadsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, key), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.Seek(0, 2)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &layerDownload{File: f, size: size}, nil
+}
+
+// cleanupDownloads removes the downloads left over by a previous daemon that
+// are too old to be resumed.
+func (graph *Graph) cleanupDownloads() {
+	dir := filepath.Join(graph.Root, downloadsDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range files {
+		if fi.Size() > 0 && time.Since(fi.ModTime()) < partialDownloadMaxAge {
+			log.Debugf("Keeping partial download %s (%d bytes)", fi.Name(), fi.Size())
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			log.Errorf("Error removing partial download %s: %s", fi.Name(), err)
+		}
+	}
+}
+
+// fetch downloads the rest of the layer. If a connection drops, the download
+// is resumed where it left off, up to retries times. The data already on disk
+// and the new data are written to verifier, if not nil, exactly once and in
+// order. wrap lets the caller report progress; it is given the body and the
+// offset and size of the layer. fetch returns the checksum computed by the
+// verifier, if any.
+func (d *layerDownload) fetch(get layerFetcher, newVerifier func() layerVerifier, wrap func(body io.ReadCloser, offset, size int64) io.Reader, retries int) (string, error) {
+	var verifier layerVerifier
+	reset := func() error {
+		if verifier != nil {
+			verifier.Close()
+		}
+		if newVerifier == nil {
+			return nil
+		}
+		verifier = newVerifier()
+		if _, err := d.Seek(0, 0); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(verifier, d.File, d.size); err != nil {
+			return err
+		}
+		return nil
+	}
+	if err := reset(); err != nil {
+		return "", err
+	}
+	defer func() {
+		if verifier != nil {
+			verifier.Close()
+		}
+	}()
+
+	for i := 1; ; i++ {
+		retry, err := d.fetchOnce(get, wrap, &verifier, reset)
+		if err == nil {
+			break
+		}
+		if !retry || i >= retries {
+			return "", err
+		}
+		log.Debugf("Error downloading layer, resuming at %d bytes: %s", d.size, err)
+		time.Sleep(time.Duration(i) * 500 * time.Millisecond)
+	}
+
+	if verifier == nil {
+		return "", nil
+	}
+	return verifier.Sum()
+}
+
+// fetchOnce makes a single request for the rest of the layer and writes it to
+// disk. It returns whether the error, if any, is worth retrying.
+func (d *layerDownload) fetchOnce(get layerFetcher, wrap func(io.ReadCloser, int64, int64) io.Reader, verifier *layerVerifier, reset func() error) (bool, error) {
+	body, start, size, err := get(d.size)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		_, isNetErr := err.(net.Error)
+		return isNetErr, err
+	}
+	defer body.Close()
+
+	if start != d.size {
+		// The server cannot resume, start over
+		if err := d.Truncate(0); err != nil {
+			return false, err
+		}
+		d.size = 0
+		if err := reset(); err != nil {
+			return false, err
+		}
+	}
+	if _, err := d.Seek(d.size, 0); err != nil {
+		return false, err
+	}
+
+	var w io.Writer = d.File
+	if *verifier != nil {
+		w = io.MultiWriter(d.File, *verifier)
+	}
+	r := wrap(body, d.size, size)
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				// The data on disk cannot be trusted anymore
+				d.Truncate(0)
+				d.size = 0
+				return false, err
+			}
+			d.size += int64(n)
+		}
+		if rerr == io.EOF {
+			return false, nil
+		}
+		if rerr != nil {
+			return true, rerr
+		}
+	}
+}
+
+// compression detects the compression of the downloaded layer.
+func (d *layerDownload) compression() (archive.Compression, error) {
+	buf := make([]byte, 10)
+	n, err := d.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return archive.Uncompressed, err
+	}
+	return archive.DetectCompression(buf[:n]), nil
+}
+
+// reader returns the downloaded layer, from the start.
+func (d *layerDownload) reader() (io.ReadCloser, error) {
+	if _, err := d.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(d.File), nil
+}
+
+// digest returns the sha256 digest of the downloaded layer.
+func (d *layerDownload) digest() (string, error) {
+	r, err := d.reader()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remove closes and removes the download.
+func (d *layerDownload) remove() error {
+	d.Close()
+	return os.Remove(d.Name())
+}
+
+// newLayerVerifier returns a verifier for layers whose checksum is of type
+// sumType: a TarSum label, or sha256 for the digest of the blob itself.
+func newLayerVerifier(sumType string) layerVerifier {
+	if sumType == "sha256" {
+		return &digestVerifier{Hash: sha256.New()}
+	}
+	return newTarSumVerifier(sumType)
+}
+
+// digestVerifier computes the sha256 digest of the data written to it.
+type digestVerifier struct {
+	hash.Hash
+}
+
+func (v *digestVerifier) Sum() (string, error) {
+	return "sha256:" + hex.EncodeToString(v.Hash.Sum(nil)), nil
+}
+
+func (v *digestVerifier) Close() error {
+	return nil
+}
+
+// tarSumVerifier computes the tarsum of the data written to it.
+type tarSumVerifier struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	sum  string
+	err  error
+}
+
+func newTarSumVerifier(label string) *tarSumVerifier {
+	pr, pw := io.Pipe()
+	v := &tarSumVerifier{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(v.done)
+		ts, err := tarsum.NewTarSumForLabel(pr, true, label)
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, ts)
+		}
+		if err == nil {
+			// Consume the padding after the end of the archive
+			_, err = io.Copy(ioutil.Discard, pr)
+		}
+		if err != nil {
+			v.err = err
+			pr.CloseWithError(err)
+			return
+		}
+		v.sum = ts.Sum(nil)
+	}()
+	return v
+}
+
+func (v *tarSumVerifier) Write(p []byte) (int, error) {
+	return v.pw.Write(p)
+}
+
+func (v *tarSumVerifier) Sum() (string, error) {
+	v.pw.Close()
+	<-v.done
+	return v.sum, v.err
+}
+
+func (v *tarSumVerifier) Close() error {
+	v.pw.CloseWithError(errDownloadAborted)
+	<-v.done
+	return nil
+}
@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/tarsum"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+const testTarSumLabel = "tarsum.v1+sha256"
+
+func testLayer(t *testing.T) ([]byte, string) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	for _, name := range []string{"a", "b"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(content)), Mode: 0644}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	ts, err := tarsum.NewTarSumForLabel(bytes.NewReader(buf.Bytes()), true, testTarSumLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), ts.Sum(nil)
+}
+
+// flakyLayerServer serves layer, supporting ranges if ranges is set. The
+// connection is cut in the middle of the response to the first cuts requests.
+type flakyLayerServer struct {
+	layer  []byte
+	ranges bool
+	cuts   int
+	offset []int64
+}
+
+func (s *flakyLayerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var start int64
+	if s.ranges {
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+	}
+	s.offset = append(s.offset, start)
+	body := s.layer[start:]
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	if start > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(s.layer)-1, len(s.layer)))
+		w.WriteHeader(206)
+	}
+	if s.cuts == 0 {
+		w.Write(body)
+		return
+	}
+	s.cuts--
+	w.Write(body[:len(body)/2])
+	w.(http.Flusher).Flush()
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func (s *flakyLayerServer) fetcher(url string) layerFetcher {
+	return func(offset int64) (io.ReadCloser, int64, int64, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if res.StatusCode != 206 {
+			offset = 0
+		}
+		return res.Body, offset, int64(len(s.layer)), nil
+	}
+}
+
+func noProgress(body io.ReadCloser, offset, size int64) io.Reader {
+	return body
+}
+
+func newTestVerifier() layerVerifier {
+	return newTarSumVerifier(testTarSumLabel)
+}
+
+func fetchTestLayer(t *testing.T, graph *Graph, s *flakyLayerServer) []byte {
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	dl, err := graph.openDownload("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dl.remove()
+	_, sum := testLayer(t)
+	checksum, err := dl.fetch(s.fetcher(server.URL), newTestVerifier, noProgress, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum != sum {
+		t.Fatalf("Expected checksum %s, got %s", sum, checksum)
+	}
+	r, err := dl.reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pushes look the layer up by the digest of the blob
+	if digest, err := dl.digest(); err != nil {
+		t.Fatal(err)
+	} else if expected := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); digest != expected {
+		t.Fatalf("Expected digest %s, got %s", expected, digest)
+	}
+	return data
+}
+
+func TestLayerDownloadResume(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-graph-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	graph := &Graph{Root: tmp}
+
+	layer, _ := testLayer(t)
+	s := &flakyLayerServer{layer: layer, ranges: true, cuts: 2}
+	if data := fetchTestLayer(t, graph, s); !bytes.Equal(data, layer) {
+		t.Fatal("Downloaded layer does not match")
+	}
+	if len(s.offset) != 3 || s.offset[0] != 0 || s.offset[1] == 0 || s.offset[2] <= s.offset[1] {
+		t.Fatalf("Expected the download to be resumed twice, got requests at %v", s.offset)
+	}
+}
+
+func TestLayerDownloadResumeFromDisk(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-graph-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	graph := &Graph{Root: tmp}
+
+	// Left over by a previous daemon
+	layer, _ := testLayer(t)
+	if err := os.MkdirAll(filepath.Join(tmp, downloadsDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, downloadsDir, "test"), layer[:1000], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &flakyLayerServer{layer: layer, ranges: true}
+	if data := fetchTestLayer(t, graph, s); !bytes.Equal(data, layer) {
+		t.Fatal("Downloaded layer does not match")
+	}
+	if len(s.offset) != 1 || s.offset[0] != 1000 {
+		t.Fatalf("Expected the download to be resumed at 1000, got requests at %v", s.offset)
+	}
+}
+
+func TestLayerDownloadWithoutRanges(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-graph-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	graph := &Graph{Root: tmp}
+
+	layer, _ := testLayer(t)
+	s := &flakyLayerServer{layer: layer, cuts: 1}
+	if data := fetchTestLayer(t, graph, s); !bytes.Equal(data, layer) {
+		t.Fatal("Downloaded layer does not match")
+	}
+	if len(s.offset) != 2 {
+		t.Fatalf("Expected the download to be restarted once, got requests at %v", s.offset)
+	}
+}
+
+func TestCleanupDownloads(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-graph-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	graph := &Graph{Root: tmp}
+	dir := filepath.Join(tmp, downloadsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{"recent": "data", "old": "data", "empty": ""} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * partialDownloadMaxAge)
+	if err := os.Chtimes(filepath.Join(dir, "old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	graph.cleanupDownloads()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "recent" {
+		t.Fatalf("Expected only the recent download to be kept, got %v", files)
+	}
+}
+
+func TestDigestLayerVerifier(t *testing.T) {
+	v := newLayerVerifier("sha256")
+	if _, err := v.Write([]byte("layer")); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := v.Sum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// echo -n layer | sha256sum
+	if expected := "sha256:dac1d7cfa95021764849fd102524e141488c5e3a90f861dbb5a12d9ac8584f85"; sum != expected {
+		t.Fatalf("Expected %s, got %s", expected, sum)
+	}
+}
@@ -1,7 +1,11 @@
 package graph
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -16,13 +20,24 @@ import (
 
 // CmdImageExport exports all images with the given tag. All versions
 // containing the same tag are exported. The resulting output is an
-// uncompressed tar ball.
+// uncompressed tar ball, gzipped at compressionLevel if it is set.
 // name is the set of tags to export.
 // out is the writer where the images are written to.
+// If format is "oci", the images are written in the OCI image layout, with
+// layers gzipped at compressionLevel if compress or compressionLevel is
+// set, and the tar ball is left uncompressed.
 func (s *TagStore) CmdImageExport(job *engine.Job) engine.Status {
 	if len(job.Args) < 1 {
 		return job.Errorf("Usage: %s IMAGE [IMAGE...]\n", job.Name)
 	}
+	level, err := CompressionLevel(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	compression := archive.Uncompressed
+	if job.GetenvInt("compressionLevel") != 0 {
+		compression = archive.Gzip
+	}
 	// get image json
 	tempdir, err := ioutil.TempDir("", "docker-export-")
 	if err != nil {
@@ -30,6 +45,22 @@ func (s *TagStore) CmdImageExport(job *engine.Job) engine.Status {
 	}
 	defer os.RemoveAll(tempdir)
 
+	exportImage := func(id string) error {
+		return s.exportImage(job.Eng, id, tempdir)
+	}
+	var oci *ociWriter
+	switch format := job.Getenv("format"); format {
+	case "", "legacy":
+	case "oci":
+		if oci, err = newOCIWriter(s, tempdir, job.GetenvBool("compress") || compression == archive.Gzip, level); err != nil {
+			return job.Error(err)
+		}
+		exportImage = oci.addImage
+		compression = archive.Uncompressed
+	default:
+		return job.Errorf("Unknown image archive format: %s", format)
+	}
+
 	rootRepoMap := map[string]Repository{}
 	addKey := func(name string, tag string, id string) {
 		log.Debugf("add key [%s:%s]", name, tag)
@@ -47,7 +78,7 @@ func (s *TagStore) CmdImageExport(job *engine.Job) engine.Status {
 			// this is a base repo name, like 'busybox'
 			for tag, id := range rootRepo {
 				addKey(name, tag, id)
-				if err := s.exportImage(job.Eng, id, tempdir); err != nil {
+				if err := exportImage(id); err != nil {
 					return job.Error(err)
 				}
 			}
@@ -66,21 +97,25 @@ func (s *TagStore) CmdImageExport(job *engine.Job) engine.Status {
 				if len(repoTag) > 0 {
 					addKey(repoName, repoTag, img.ID)
 				}
-				if err := s.exportImage(job.Eng, img.ID, tempdir); err != nil {
+				if err := exportImage(img.ID); err != nil {
 					return job.Error(err)
 				}
 
 			} else {
 				// this must be an ID that didn't get looked up just right?
-				if err := s.exportImage(job.Eng, name, tempdir); err != nil {
+				if err := exportImage(name); err != nil {
 					return job.Error(err)
 				}
 			}
 		}
 		log.Debugf("End Serializing %s", name)
 	}
-	// write repositories, if there is something to write
-	if len(rootRepoMap) > 0 {
+	if oci != nil {
+		if err := oci.writeIndex(rootRepoMap); err != nil {
+			return job.Error(err)
+		}
+	} else if len(rootRepoMap) > 0 {
+		// write repositories, if there is something to write
 		rootRepoJson, _ := json.Marshal(rootRepoMap)
 		if err := ioutil.WriteFile(path.Join(tempdir, "repositories"), rootRepoJson, os.FileMode(0644)); err != nil {
 			return job.Error(err)
@@ -89,7 +124,7 @@ func (s *TagStore) CmdImageExport(job *engine.Job) engine.Status {
 		log.Debugf("There were no repositories to write")
 	}
 
-	fs, err := archive.Tar(tempdir, archive.Uncompressed)
+	fs, err := archive.TarWithOptions(tempdir, &archive.TarOptions{Compression: compression, CompressionLevel: level})
 	if err != nil {
 		return job.Error(err)
 	}
@@ -122,13 +157,10 @@ func (s *TagStore) exportImage(eng *engine.Engine, name, tempdir string) error {
 		}
 
 		// serialize json
-		json, err := os.Create(path.Join(tmpImageDir, "json"))
-		if err != nil {
-			return err
-		}
+		imgJSON := bytes.NewBuffer(nil)
 		job := eng.Job("image_inspect", n)
 		job.SetenvBool("raw", true)
-		job.Stdout.Add(json)
+		job.Stdout.Add(imgJSON)
 		if err := job.Run(); err != nil {
 			return err
 		}
@@ -138,9 +170,20 @@ func (s *TagStore) exportImage(eng *engine.Engine, name, tempdir string) error {
 		if err != nil {
 			return err
 		}
+		h := sha256.New()
 		job = eng.Job("image_tarlayer", n)
-		job.Stdout.Add(fsTar)
-		if err := job.Run(); err != nil {
+		job.Stdout.Add(io.MultiWriter(fsTar, h))
+		err = job.Run()
+		fsTar.Close()
+		if err != nil {
+			return err
+		}
+
+		b, err := setDiffID(imgJSON.Bytes(), fmt.Sprintf("sha256:%x", h.Sum(nil)))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(tmpImageDir, "json"), b, os.FileMode(0644)); err != nil {
 			return err
 		}
 
@@ -154,3 +197,33 @@ func (s *TagStore) exportImage(eng *engine.Engine, name, tempdir string) error {
 	}
 	return nil
 }
+
+// setDiffID returns imgJSON with the digest of the exported layer of the
+// image. Layers are exported from the graph driver, so their digest may differ
+// from the one of the layer the image was registered with. Loading the image
+// gives it a new ID then, and the ID in imgJSON refers to it.
+func setDiffID(imgJSON []byte, diffID string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(imgJSON, &fields); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(diffID)
+	if err != nil {
+		return nil, err
+	}
+	fields["diff_id"] = b
+	return json.Marshal(fields)
+}
+
+// CompressionLevel returns the gzip level given as the compressionLevel
+// environment of job, or the default level if it is not set.
+func CompressionLevel(job *engine.Job) (int, error) {
+	level := job.GetenvInt("compressionLevel")
+	if level == 0 {
+		return gzip.DefaultCompression, nil
+	}
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return 0, fmt.Errorf("Invalid compression level %d, it must be between 1 and 9", level)
+	}
+	return level, nil
+}
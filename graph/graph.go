@@ -1,6 +1,8 @@
 package graph
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,6 +11,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -24,11 +27,22 @@ import (
 	"github.com/docker/docker/utils"
 )
 
+// legacyIDsFile stores the mapping from the random IDs images were
+// registered or created with to their content-addressable IDs.
+const legacyIDsFile = "_legacy_ids.json"
+
 // A Graph is a store for versioned filesystem images and the relationship between them.
 type Graph struct {
 	Root    string
 	idIndex *truncindex.TruncIndex
 	driver  graphdriver.Driver
+
+	legacyIndex *truncindex.TruncIndex
+	legacyIDs   map[string]string // legacy ID -> content-addressable ID
+	legacyLock  sync.Mutex
+
+	blobSources     map[string][]blobSource // blob digest -> repositories having it
+	blobSourcesLock sync.Mutex
 }
 
 // NewGraph instantiates a new graph at the given root path in the filesystem.
@@ -44,13 +58,19 @@ func NewGraph(root string, driver graphdriver.Driver) (*Graph, error) {
 	}
 
 	graph := &Graph{
-		Root:    abspath,
-		idIndex: truncindex.NewTruncIndex([]string{}),
-		driver:  driver,
+		Root:        abspath,
+		idIndex:     truncindex.NewTruncIndex([]string{}),
+		driver:      driver,
+		legacyIndex: truncindex.NewTruncIndex([]string{}),
+		legacyIDs:   make(map[string]string),
 	}
 	if err := graph.restore(); err != nil {
 		return nil, err
 	}
+	if err := graph.loadBlobSources(); err != nil {
+		return nil, err
+	}
+	graph.cleanupDownloads()
 	return graph, nil
 }
 
@@ -68,9 +88,87 @@ func (graph *Graph) restore() error {
 	}
 	graph.idIndex = truncindex.NewTruncIndex(ids)
 	log.Debugf("Restored %d elements", len(dir))
+
+	jsonData, err := ioutil.ReadFile(path.Join(graph.Root, legacyIDsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(jsonData, &graph.legacyIDs); err != nil {
+		return err
+	}
+	var legacyIDs = []string{}
+	for legacyID := range graph.legacyIDs {
+		legacyIDs = append(legacyIDs, legacyID)
+	}
+	graph.legacyIndex = truncindex.NewTruncIndex(legacyIDs)
 	return nil
 }
 
+// lookupLegacyID returns the content-addressable ID of the image that was
+// registered with the given legacy ID.
+func (graph *Graph) lookupLegacyID(legacyID string) (string, bool) {
+	graph.legacyLock.Lock()
+	defer graph.legacyLock.Unlock()
+	id, exists := graph.legacyIDs[legacyID]
+	return id, exists
+}
+
+func (graph *Graph) addLegacyID(legacyID, id string) error {
+	graph.legacyLock.Lock()
+	defer graph.legacyLock.Unlock()
+	if _, exists := graph.legacyIDs[legacyID]; !exists {
+		if err := graph.legacyIndex.Add(legacyID); err != nil {
+			return err
+		}
+	}
+	graph.legacyIDs[legacyID] = id
+	return graph.saveLegacyIDs()
+}
+
+// deleteLegacyIDs forgets all the legacy IDs of the image with the given ID.
+func (graph *Graph) deleteLegacyIDs(id string) error {
+	graph.legacyLock.Lock()
+	defer graph.legacyLock.Unlock()
+	deleted := false
+	for legacyID, target := range graph.legacyIDs {
+		if target == id {
+			delete(graph.legacyIDs, legacyID)
+			graph.legacyIndex.Delete(legacyID)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return nil
+	}
+	return graph.saveLegacyIDs()
+}
+
+func (graph *Graph) saveLegacyIDs() error {
+	jsonData, err := json.Marshal(graph.legacyIDs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(graph.Root, legacyIDsFile), jsonData, 0600)
+}
+
+// resolve returns the full ID of the image named by an ID, a legacy ID or
+// a unique prefix of either. Content-addressable IDs take precedence.
+func (graph *Graph) resolve(name string) (string, error) {
+	id, err := graph.idIndex.Get(name)
+	if err == nil {
+		return id, nil
+	}
+	graph.legacyLock.Lock()
+	defer graph.legacyLock.Unlock()
+	if legacyID, lerr := graph.legacyIndex.Get(name); lerr == nil {
+		return graph.legacyIDs[legacyID], nil
+	}
+	return "", err
+}
+
 // FIXME: Implement error subclass instead of looking at the error text
 // Note: This is the way golang implements os.IsNotExists on Plan9
 func (graph *Graph) IsNotExist(err error) bool {
@@ -88,7 +186,7 @@ func (graph *Graph) Exists(id string) bool {
 
 // Get returns the image with the given id, or an error if the image doesn't exist.
 func (graph *Graph) Get(name string) (*image.Image, error) {
-	id, err := graph.idIndex.Get(name)
+	id, err := graph.resolve(name)
 	if err != nil {
 		return nil, fmt.Errorf("could not find image: %v", err)
 	}
@@ -118,7 +216,6 @@ func (graph *Graph) Get(name string) (*image.Image, error) {
 // Create creates a new image and registers it in the graph.
 func (graph *Graph) Create(layerData archive.ArchiveReader, containerID, containerImage, comment, author string, containerConfig, config *runconfig.Config) (*image.Image, error) {
 	img := &image.Image{
-		ID:            common.GenerateRandomID(),
 		Comment:       comment,
 		Created:       time.Now().UTC(),
 		DockerVersion: dockerversion.VERSION,
@@ -141,21 +238,110 @@ func (graph *Graph) Create(layerData archive.ArchiveReader, containerID, contain
 }
 
 // Register imports a pre-existing image into the graph.
-func (graph *Graph) Register(img *image.Image, layerData archive.ArchiveReader) (err error) {
+//
+// The image is stored under its content-addressable ID, which is computed
+// from the layer data and the image configuration and written back to
+// img.ID. If img.ID was already set to another value, it is kept as a legacy
+// ID that still resolves to the image. Registering content that is already
+// in the graph is not an error.
+func (graph *Graph) Register(img *image.Image, layerData archive.ArchiveReader) error {
+	legacyID := img.ID
+	if legacyID != "" {
+		if err := utils.ValidateID(legacyID); err != nil {
+			return err
+		}
+		// (This is a convenience to save time. Race conditions are taken care of by os.Rename)
+		if graph.Exists(legacyID) {
+			return fmt.Errorf("Image %s already exists", legacyID)
+		}
+	}
+	return graph.register(img, layerData, legacyID)
+}
+
+func (graph *Graph) register(img *image.Image, layerData archive.ArchiveReader, legacyID string) (err error) {
+	if id, exists := graph.lookupLegacyID(img.Parent); exists {
+		img.Parent = id
+	}
+	renamer, canRename := graph.driver.(graphdriver.Renamer)
+	if layerData != nil && img.DiffID == "" && !canRename {
+		// The driver cannot move a layer once it is written, so the layer
+		// is read once to compute its digest, and the ID, before the
+		// driver creates it. It is buffered to disk for that.
+		f, err := graph.newTempFile()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			f.Close()
+			os.RemoveAll(filepath.Dir(f.Name()))
+		}()
+		if img.DiffID, err = digestLayer(f, layerData); err != nil {
+			return err
+		}
+		layerData = f
+	}
+
+	// exists tells whether the image is already in the graph, in which case
+	// img is set to it.
+	exists := func() (bool, error) {
+		existing, err := graph.Get(img.ID)
+		if err != nil {
+			return false, nil
+		}
+		*img = *existing
+		if legacyID != "" && legacyID != img.ID {
+			return true, graph.addLegacyID(legacyID, img.ID)
+		}
+		return true, nil
+	}
+
+	// The layer is written under the ID of the image when its digest is
+	// known. Otherwise it is written under a temporary ID while its digest
+	// is computed, and moved to the ID of the image afterwards.
+	layerID := "tmp-" + common.GenerateRandomID()
+	if layerData == nil || img.DiffID != "" {
+		if img.ID, err = img.ComputeID(); err != nil {
+			return err
+		}
+		if found, err := exists(); found {
+			return err
+		}
+		layerID = img.ID
+	}
+
 	defer func() {
-		// If any error occurs, remove the new dir from the driver.
-		// Don't check for errors since the dir might not have been created.
+		// If any error occurs, remove the new layer from the driver.
 		// FIXME: this leaves a possible race condition.
-		if err != nil {
-			graph.driver.Remove(img.ID)
+		if err != nil && (layerID != img.ID || !graph.Exists(img.ID)) {
+			graph.driver.Remove(layerID)
 		}
 	}()
-	if err := utils.ValidateID(img.ID); err != nil {
+	diffID, size, err := graph.writeLayer(layerID, img.Parent, layerData)
+	if err != nil {
 		return err
 	}
-	// (This is a convenience to save time. Race conditions are taken care of by os.Rename)
-	if graph.Exists(img.ID) {
-		return fmt.Errorf("Image %s already exists", img.ID)
+	if layerData != nil {
+		if img.DiffID != "" && img.DiffID != diffID {
+			return fmt.Errorf("Layer digest mismatch for image %s: expected %s, got %s", common.TruncateID(legacyID), img.DiffID, diffID)
+		}
+		img.DiffID = diffID
+		img.Size = size
+	}
+	if layerID != img.ID {
+		if img.ID, err = img.ComputeID(); err != nil {
+			return err
+		}
+		if found, err := exists(); found {
+			graph.driver.Remove(layerID)
+			return err
+		}
+		// The graph is the source of truth, a layer the driver has
+		// under this ID is stale.
+		graph.driver.Remove(img.ID)
+		if err := renamer.Rename(layerID, img.ID); err != nil {
+			return err
+		}
+		layerID = img.ID
 	}
 
 	// Ensure that the image root does not exist on the filesystem
@@ -165,25 +351,13 @@ func (graph *Graph) Register(img *image.Image, layerData archive.ArchiveReader)
 		return err
 	}
 
-	// If the driver has this ID but the graph doesn't, remove it from the driver to start fresh.
-	// (the graph is the source of truth).
-	// Ignore errors, since we don't know if the driver correctly returns ErrNotExist.
-	// (FIXME: make that mandatory for drivers).
-	graph.driver.Remove(img.ID)
-
 	tmp, err := graph.Mktemp("")
 	defer os.RemoveAll(tmp)
 	if err != nil {
 		return fmt.Errorf("Mktemp failed: %s", err)
 	}
-
-	// Create root filesystem in the driver
-	if err := graph.driver.Create(img.ID, img.Parent); err != nil {
-		return fmt.Errorf("Driver %s failed to create image rootfs %s: %s", graph.driver, img.ID, err)
-	}
-	// Apply the diff/layer
 	img.SetGraph(graph)
-	if err := image.StoreImage(img, layerData, tmp); err != nil {
+	if err := image.StoreImage(img, nil, tmp); err != nil {
 		return err
 	}
 	// Commit
@@ -191,9 +365,68 @@ func (graph *Graph) Register(img *image.Image, layerData archive.ArchiveReader)
 		return err
 	}
 	graph.idIndex.Add(img.ID)
+	if legacyID != "" && legacyID != img.ID {
+		return graph.addLegacyID(legacyID, img.ID)
+	}
 	return nil
 }
 
+// writeLayer creates the layer id on top of parent in the driver and applies
+// layerData to it, if not nil. It returns the digest of the uncompressed
+// layer, computed as it is applied, and the size of the layer.
+func (graph *Graph) writeLayer(id, parent string, layerData archive.ArchiveReader) (diffID string, size int64, err error) {
+	// If the driver has this ID but the graph doesn't, remove it from the driver to start fresh.
+	// (the graph is the source of truth).
+	// Ignore errors, since we don't know if the driver correctly returns ErrNotExist.
+	// (FIXME: make that mandatory for drivers).
+	graph.driver.Remove(id)
+
+	if err := graph.driver.Create(id, parent); err != nil {
+		return "", 0, fmt.Errorf("Driver %s failed to create image rootfs %s: %s", graph.driver, id, err)
+	}
+	if layerData == nil {
+		return "", 0, nil
+	}
+	decompressed, err := archive.DecompressStream(layerData)
+	if err != nil {
+		return "", 0, err
+	}
+	defer decompressed.Close()
+	h := sha256.New()
+	layer := io.TeeReader(decompressed, h)
+	if size, err = graph.driver.ApplyDiff(id, parent, layer); err != nil {
+		return "", 0, err
+	}
+	// The digest covers the padding the driver may have left unread
+	if _, err := io.Copy(ioutil.Discard, layer); err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), size, nil
+}
+
+// digestLayer copies the uncompressed content of layerData to dst and
+// returns its digest.
+func digestLayer(dst io.Writer, layerData io.Reader) (string, error) {
+	decompressed, err := archive.DecompressStream(layerData)
+	if err != nil {
+		return "", err
+	}
+	defer decompressed.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, h), decompressed); err != nil {
+		return "", err
+	}
+	if f, ok := dst.(*os.File); ok {
+		if err := f.Sync(); err != nil {
+			return "", err
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
 // TempLayerArchive creates a temporary archive of the given image's filesystem layer.
 //   The archive is stored on disk and will be automatically deleted as soon as has been read.
 //   If output is not nil, a human-readable progress bar will be written to it.
@@ -329,10 +562,13 @@ func isNotEmpty(err error) bool {
 
 // Delete atomically removes an image from the graph.
 func (graph *Graph) Delete(name string) error {
-	id, err := graph.idIndex.Get(name)
+	id, err := graph.resolve(name)
 	if err != nil {
 		return err
 	}
+	if err := graph.deleteLegacyIDs(id); err != nil {
+		return err
+	}
 	tmp, err := graph.Mktemp("")
 	graph.idIndex.Delete(id)
 	if err == nil {
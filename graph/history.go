@@ -38,6 +38,18 @@ func (s *TagStore) CmdHistory(job *engine.Job) engine.Status {
 		out.SetList("Tags", lookupMap[img.ID])
 		out.SetInt64("Size", img.Size)
 		outs.Add(out)
+
+		// Squashed images carry the steps that were folded into their
+		// layer. The data lives in the squashed layer, so they have no size.
+		for _, h := range img.SquashedHistory {
+			out := &engine.Env{}
+			out.SetJson("Id", "<missing>")
+			out.SetInt64("Created", h.Created.Unix())
+			out.Set("CreatedBy", h.CreatedBy)
+			out.SetList("Tags", nil)
+			out.SetInt64("Size", 0)
+			outs.Add(out)
+		}
 		return nil
 	})
 	if _, err := outs.WriteListTo(job.Stdout); err != nil {
@@ -0,0 +1,392 @@
+package graph
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+// ImageChange is a path that differs between the filesystems of two images.
+// Size is the size of the path in the newer image, or in the older one if
+// the path was deleted.
+type ImageChange struct {
+	archive.Change
+	Size      int64
+	SizeDelta int64
+}
+
+// ConfigChange is a setting that differs between the configs of two images.
+// Key names the variable, label or port for the map-like settings.
+type ConfigChange struct {
+	Field string
+	Key   string `json:",omitempty"`
+	Kind  archive.ChangeType
+	Old   string `json:",omitempty"`
+	New   string `json:",omitempty"`
+}
+
+// CmdImageDiff compares the filesystems and configs of two images.
+//
+// Syntax: image_diff FROM TO
+// Output: an object with the Changes and the Config changes going from FROM to TO.
+func (s *TagStore) CmdImageDiff(job *engine.Job) engine.Status {
+	if n := len(job.Args); n != 2 {
+		return job.Errorf("Usage: %s FROM TO", job.Name)
+	}
+	from, err := s.LookupImage(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	to, err := s.LookupImage(job.Args[1])
+	if err != nil {
+		return job.Error(err)
+	}
+	changes, err := imageChanges(from, to)
+	if err != nil {
+		return job.Error(err)
+	}
+
+	out := &engine.Env{}
+	out.SetJson("Changes", changes)
+	out.SetJson("Config", configChanges(from.Config, to.Config))
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// layerState is the state of the paths touched by one or more layers.
+// A nil header means the path was whited out. Replaced holds the paths
+// that were whited out or written as another kind of file than a
+// directory, which hides whatever the layers below had under them.
+type layerState struct {
+	entries  map[string]*tar.Header
+	replaced map[string]bool
+}
+
+func newLayerState() *layerState {
+	return &layerState{
+		entries:  make(map[string]*tar.Header),
+		replaced: make(map[string]bool),
+	}
+}
+
+// apply reads the layer of img on top of the current state.
+func (l *layerState) apply(img *image.Image) error {
+	layer, err := img.TarLayer()
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+
+	tr := tar.NewReader(layer)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		l.add(hdr)
+	}
+}
+
+// add records an entry of a layer on top of the current state.
+func (l *layerState) add(hdr *tar.Header) {
+	name := filepath.Clean("/" + hdr.Name)
+	base := filepath.Base(name)
+	switch {
+	case strings.HasPrefix(base, ".wh..wh."):
+		// Skip AUFS metadata
+	case strings.HasPrefix(base, ".wh."):
+		l.replace(filepath.Join(filepath.Dir(name), base[len(".wh."):]), nil)
+	case hdr.Typeflag == tar.TypeDir:
+		l.entries[name] = hdr
+	default:
+		// Only a directory has entries under it to drop, which saves
+		// scanning all of them for every file
+		if old := l.entries[name]; old != nil && old.Typeflag == tar.TypeDir {
+			l.replace(name, hdr)
+		} else {
+			l.entries[name] = hdr
+			l.replaced[name] = true
+		}
+	}
+}
+
+// replace records a path that hides everything the layers below had at
+// and under it, and drops the entries under it.
+func (l *layerState) replace(name string, hdr *tar.Header) {
+	prefix := name + "/"
+	for p := range l.entries {
+		if strings.HasPrefix(p, prefix) {
+			delete(l.entries, p)
+		}
+	}
+	l.entries[name] = hdr
+	l.replaced[name] = true
+}
+
+// lookup returns the header of name, and whether this state determines it
+// at all. Paths it doesn't determine come from the layers below.
+func (l *layerState) lookup(name string) (*tar.Header, bool) {
+	if hdr, exists := l.entries[name]; exists {
+		return hdr, true
+	}
+	for dir := filepath.Dir(name); dir != "/"; dir = filepath.Dir(dir) {
+		if l.replaced[dir] {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+// imageChanges lists the paths that differ between from and to. Only the
+// layers above their closest shared ancestor are compared; the shared
+// layers are read just far enough to know what the changed paths were
+// before either image touched them.
+func imageChanges(from, to *image.Image) ([]ImageChange, error) {
+	fromHistory, err := from.History()
+	if err != nil {
+		return nil, err
+	}
+	toHistory, err := to.History()
+	if err != nil {
+		return nil, err
+	}
+	shared := make(map[string]bool, len(toHistory))
+	for _, img := range toHistory {
+		shared[img.ID] = true
+	}
+	var fromLayers, ancestors []*image.Image
+	for i, img := range fromHistory {
+		if shared[img.ID] {
+			ancestors = fromHistory[i:]
+			break
+		}
+		fromLayers = append(fromLayers, img)
+	}
+	var toLayers []*image.Image
+	for _, img := range toHistory {
+		if len(ancestors) > 0 && img.ID == ancestors[0].ID {
+			break
+		}
+		toLayers = append(toLayers, img)
+	}
+
+	fromState, err := chainState(fromLayers)
+	if err != nil {
+		return nil, err
+	}
+	toState, err := chainState(toLayers)
+	if err != nil {
+		return nil, err
+	}
+
+	touched := make(map[string]bool)
+	for _, l := range []*layerState{fromState, toState} {
+		for p := range l.entries {
+			touched[p] = true
+		}
+	}
+	base, err := resolvePaths(ancestors, touched)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []ImageChange{}
+	for p := range touched {
+		oldHdr, exists := fromState.lookup(p)
+		if !exists {
+			oldHdr = base[p]
+		}
+		newHdr, exists := toState.lookup(p)
+		if !exists {
+			newHdr = base[p]
+		}
+		change := ImageChange{Change: archive.Change{Path: p}}
+		switch {
+		case oldHdr == nil && newHdr == nil:
+			continue
+		case oldHdr == nil:
+			change.Kind = archive.ChangeAdd
+			change.Size = newHdr.Size
+			change.SizeDelta = newHdr.Size
+		case newHdr == nil:
+			change.Kind = archive.ChangeDelete
+			change.Size = oldHdr.Size
+			change.SizeDelta = -oldHdr.Size
+		default:
+			if sameHeader(oldHdr, newHdr) {
+				continue
+			}
+			change.Kind = archive.ChangeModify
+			change.Size = newHdr.Size
+			change.SizeDelta = newHdr.Size - oldHdr.Size
+		}
+		changes = append(changes, change)
+	}
+	sort.Sort(imageChangesByPath(changes))
+	return changes, nil
+}
+
+// chainState applies the layers of history, which is ordered from the
+// newest image to the oldest, onto a single state.
+func chainState(history []*image.Image) (*layerState, error) {
+	state := newLayerState()
+	for i := len(history) - 1; i >= 0; i-- {
+		if err := state.apply(history[i]); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// resolvePaths looks up paths in the shared ancestors, newest first,
+// and stops reading layers once every path is known.
+func resolvePaths(ancestors []*image.Image, paths map[string]bool) (map[string]*tar.Header, error) {
+	resolved := make(map[string]*tar.Header)
+	for _, img := range ancestors {
+		if len(resolved) == len(paths) {
+			break
+		}
+		state := newLayerState()
+		if err := state.apply(img); err != nil {
+			return nil, err
+		}
+		for p := range paths {
+			if _, exists := resolved[p]; exists {
+				continue
+			}
+			if hdr, exists := state.lookup(p); exists {
+				resolved[p] = hdr
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// sameHeader compares two tar headers the way the container changes are
+// compared, using the second precision of the tar format for mtimes.
+func sameHeader(a, b *tar.Header) bool {
+	return a.Typeflag == b.Typeflag &&
+		a.Mode == b.Mode &&
+		a.Uid == b.Uid &&
+		a.Gid == b.Gid &&
+		a.Size == b.Size &&
+		a.Linkname == b.Linkname &&
+		a.ModTime.Unix() == b.ModTime.Unix()
+}
+
+type imageChangesByPath []ImageChange
+
+func (c imageChangesByPath) Less(i, j int) bool { return c[i].Path < c[j].Path }
+func (c imageChangesByPath) Len() int           { return len(c) }
+func (c imageChangesByPath) Swap(i, j int)      { c[j], c[i] = c[i], c[j] }
+
+// configChanges lists the differences in the environment, command,
+// entrypoint, labels and exposed ports of two image configs.
+func configChanges(from, to *runconfig.Config) []ConfigChange {
+	if from == nil {
+		from = &runconfig.Config{}
+	}
+	if to == nil {
+		to = &runconfig.Config{}
+	}
+	changes := []ConfigChange{}
+	changes = append(changes, diffMaps("Env", envMap(from.Env), envMap(to.Env))...)
+	for _, f := range []struct {
+		field    string
+		old, new []string
+	}{
+		{"Cmd", from.Cmd, to.Cmd},
+		{"Entrypoint", from.Entrypoint, to.Entrypoint},
+	} {
+		old, new := jsonList(f.old), jsonList(f.new)
+		if old == new {
+			continue
+		}
+		change := ConfigChange{Field: f.field, Kind: archive.ChangeModify, Old: old, New: new}
+		if old == "" {
+			change.Kind = archive.ChangeAdd
+		} else if new == "" {
+			change.Kind = archive.ChangeDelete
+		}
+		changes = append(changes, change)
+	}
+	changes = append(changes, diffMaps("Labels", from.Labels, to.Labels)...)
+	changes = append(changes, diffMaps("ExposedPorts", portMap(from.ExposedPorts), portMap(to.ExposedPorts))...)
+	return changes
+}
+
+func diffMaps(field string, from, to map[string]string) []ConfigChange {
+	keys := make(map[string]bool)
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []ConfigChange
+	for _, k := range sorted {
+		old, inFrom := from[k]
+		new, inTo := to[k]
+		switch {
+		case !inFrom:
+			changes = append(changes, ConfigChange{Field: field, Key: k, Kind: archive.ChangeAdd, New: new})
+		case !inTo:
+			changes = append(changes, ConfigChange{Field: field, Key: k, Kind: archive.ChangeDelete, Old: old})
+		case old != new:
+			changes = append(changes, ConfigChange{Field: field, Key: k, Kind: archive.ChangeModify, Old: old, New: new})
+		}
+	}
+	return changes
+}
+
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 1 {
+			m[parts[0]] = ""
+		} else {
+			m[parts[0]] = parts[1]
+		}
+	}
+	return m
+}
+
+func portMap(ports map[nat.Port]struct{}) map[string]string {
+	m := make(map[string]string, len(ports))
+	for p := range ports {
+		m[string(p)] = ""
+	}
+	return m
+}
+
+func jsonList(l []string) string {
+	if len(l) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return strings.Join(l, " ")
+	}
+	return string(b)
+}
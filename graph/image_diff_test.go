@@ -0,0 +1,175 @@
+package graph
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/nat"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/utils"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+const (
+	testDiffFromID = "d1ff0f5c3a1b2e4d6c8a0b1e3f5d7c9a2b4e6f8a0c2e4a6b8d0f2a4c6e8a0b2d"
+	testDiffToID   = "d1ff705c3a1b2e4d6c8a0b1e3f5d7c9a2b4e6f8a0c2e4a6b8d0f2a4c6e8a0b2d"
+)
+
+// testDiffLayer builds a layer from a map of paths to their content. An
+// empty content whites the path out.
+func testDiffLayer(t *testing.T, files map[string]string) io.Reader {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Size: int64(len(content)), Uid: os.Getuid(), Gid: os.Getgid()}
+		if content == "" {
+			hdr.Name = filepath.Join(filepath.Dir(name), ".wh."+filepath.Base(name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestImageChanges(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	from := &image.Image{ID: testDiffFromID, Parent: testOfficialImageID}
+	if err := store.graph.Register(from, testDiffLayer(t, map[string]string{
+		"/etc/passwd": "root:x:0:0::/root:/bin/sh\n",
+		"/etc/from":   "from\n",
+	})); err != nil {
+		t.Fatal(err)
+	}
+	to := &image.Image{ID: testDiffToID, Parent: testOfficialImageID}
+	if err := store.graph.Register(to, testDiffLayer(t, map[string]string{
+		"/etc/passwd": "",
+		"/etc/to":     "to\n",
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	fromImg, err := store.LookupImage(testDiffFromID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toImg, err := store.LookupImage(testDiffToID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseImg, err := store.LookupImage(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		from, to *image.Image
+		expected map[string]ImageChange
+	}{
+		{fromImg, toImg, map[string]ImageChange{
+			"/etc/from":   {archive.Change{Path: "/etc/from", Kind: archive.ChangeDelete}, 5, -5},
+			"/etc/passwd": {archive.Change{Path: "/etc/passwd", Kind: archive.ChangeDelete}, 26, -26},
+			"/etc/to":     {archive.Change{Path: "/etc/to", Kind: archive.ChangeAdd}, 3, 3},
+		}},
+		{baseImg, fromImg, map[string]ImageChange{
+			"/etc/from":   {archive.Change{Path: "/etc/from", Kind: archive.ChangeAdd}, 5, 5},
+			"/etc/passwd": {archive.Change{Path: "/etc/passwd", Kind: archive.ChangeModify}, 26, 13},
+		}},
+		{toImg, toImg, map[string]ImageChange{}},
+	} {
+		changes, err := imageChanges(c.from, c.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := make(map[string]ImageChange)
+		for _, change := range changes {
+			// Directories change along with the files in them
+			if change.Path != "/etc" {
+				files[change.Path] = change
+			}
+		}
+		if !reflect.DeepEqual(files, c.expected) {
+			t.Fatalf("Expected %v, got %v", c.expected, files)
+		}
+	}
+}
+
+func TestLayerStateReplace(t *testing.T) {
+	l := newLayerState()
+	for _, hdr := range []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeDir},
+		{Name: "a/b", Typeflag: tar.TypeReg},
+		{Name: "c", Typeflag: tar.TypeReg},
+		{Name: "c", Typeflag: tar.TypeReg, Size: 1},
+		// A file replaces the directory and what was in it
+		{Name: "a", Typeflag: tar.TypeReg, Size: 2},
+		{Name: "d/.wh.e", Typeflag: tar.TypeReg},
+	} {
+		l.add(hdr)
+	}
+	for name, size := range map[string]int64{"/a": 2, "/c": 1} {
+		if hdr, exists := l.lookup(name); !exists || hdr == nil || hdr.Size != size {
+			t.Fatalf("Expected %s of size %d, got %v", name, size, hdr)
+		}
+	}
+	for _, name := range []string{"/a/b", "/d/e", "/d/e/f"} {
+		if hdr, exists := l.lookup(name); !exists || hdr != nil {
+			t.Fatalf("Expected %s to be hidden, got %v", name, hdr)
+		}
+	}
+	if _, exists := l.entries["/a/b"]; exists {
+		t.Fatal("Expected the entries under /a to be dropped")
+	}
+	if _, exists := l.lookup("/d/f"); exists {
+		t.Fatal("Expected /d/f to come from the layers below")
+	}
+}
+
+func TestConfigChanges(t *testing.T) {
+	from := &runconfig.Config{
+		Env:          []string{"PATH=/bin", "HOME=/root"},
+		Cmd:          []string{"sh"},
+		Labels:       map[string]string{"version": "1"},
+		ExposedPorts: map[nat.Port]struct{}{"80/tcp": {}},
+	}
+	to := &runconfig.Config{
+		Env:          []string{"PATH=/usr/bin:/bin", "LANG=C"},
+		Cmd:          []string{"sh"},
+		Entrypoint:   []string{"/init"},
+		Labels:       map[string]string{"version": "2"},
+		ExposedPorts: map[nat.Port]struct{}{"80/tcp": {}, "443/tcp": {}},
+	}
+	expected := []ConfigChange{
+		{Field: "Env", Key: "HOME", Kind: archive.ChangeDelete, Old: "/root"},
+		{Field: "Env", Key: "LANG", Kind: archive.ChangeAdd, New: "C"},
+		{Field: "Env", Key: "PATH", Kind: archive.ChangeModify, Old: "/bin", New: "/usr/bin:/bin"},
+		{Field: "Entrypoint", Kind: archive.ChangeAdd, New: `["/init"]`},
+		{Field: "Labels", Key: "version", Kind: archive.ChangeModify, Old: "1", New: "2"},
+		{Field: "ExposedPorts", Key: "443/tcp", Kind: archive.ChangeAdd},
+	}
+	if changes := configChanges(from, to); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Expected %v, got %v", expected, changes)
+	}
+	if changes := configChanges(nil, nil); len(changes) != 0 {
+		t.Fatalf("Expected no changes, got %v", changes)
+	}
+}
@@ -3,133 +3,445 @@
 package graph
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/image"
 	"github.com/docker/docker/pkg/archive"
-	"github.com/docker/docker/pkg/chrootarchive"
+	"github.com/docker/docker/pkg/common"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/pkg/progressreader"
+	"github.com/docker/docker/registry"
 	"github.com/docker/docker/utils"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
 )
 
+// TruncatedArchiveError is returned when the archive given to load ends in
+// the middle of an entry.
+type TruncatedArchiveError struct {
+	// Name is the entry being read, if any.
+	Name string
+}
+
+func (e *TruncatedArchiveError) Error() string {
+	if e.Name == "" {
+		return "The archive is truncated"
+	}
+	return fmt.Sprintf("The archive is truncated: unexpected end of %s", e.Name)
+}
+
+// endReader remembers whether its reader reached its end.
+type endReader struct {
+	io.Reader
+	ended bool
+}
+
+func (r *endReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		r.ended = true
+	}
+	return n, err
+}
+
+// pendingImage is an image of the archive which is not registered yet.
+type pendingImage struct {
+	img *image.Image
+	// layer is the file the layer was spooled to when it arrived before
+	// its parent or its json.
+	layer string
+}
+
+// imageLoader registers the images of an archive as their entries arrive.
+type imageLoader struct {
+	s       *TagStore
+	in      *endReader
+	out     io.Writer
+	sf      *utils.StreamFormatter
+	tmpDir  string
+	pending map[string]*pendingImage
+	// index is the index of archives in the OCI image layout, whose blobs
+	// are spooled until it is read.
+	index *ociIndex
+}
+
 // Loads a set of images into the repository. This is the complementary of ImageExport.
 // The input stream is an uncompressed tar ball containing images and metadata.
+// It is read once: layers whose parent is present are applied as they
+// arrive, the others are spooled until their parent is loaded.
 func (s *TagStore) CmdLoad(job *engine.Job) engine.Status {
-	tmpImageDir, err := ioutil.TempDir("", "docker-import-")
+	l := &imageLoader{
+		s:       s,
+		in:      &endReader{Reader: job.Stdin},
+		out:     job.Stdout,
+		sf:      utils.NewStreamFormatter(job.GetenvBool("json")),
+		pending: make(map[string]*pendingImage),
+	}
+	defer l.cleanup()
+
+	repositories, err := l.load()
 	if err != nil {
 		return job.Error(err)
 	}
-	defer os.RemoveAll(tmpImageDir)
+
+	for imageName, tagMap := range repositories {
+		for tag, address := range tagMap {
+			if err := s.Set(imageName, tag, address, true); err != nil {
+				return job.Error(err)
+			}
+			job.Stdout.Write(l.sf.FormatStatus("", "Loaded image: %s", utils.ImageReference(imageName, tag)))
+		}
+	}
+
+	return engine.StatusOK
+}
+
+// load reads the archive and returns its repositories.
+func (l *imageLoader) load() (map[string]Repository, error) {
+	// The archive may be compressed
+	decompressed, err := archive.DecompressStream(l.in)
+	if err != nil {
+		return nil, l.entryError("", err)
+	}
+	defer decompressed.Close()
 
 	var (
-		repoDir = path.Join(tmpImageDir, "repo")
+		tr           = tar.NewReader(decompressed)
+		repositories = map[string]Repository{}
 	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, l.entryError("", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := strings.Trim(path.Clean(hdr.Name), "/")
 
-	if err := os.Mkdir(repoDir, os.ModeDir); err != nil {
-		return job.Error(err)
+		switch {
+		case name == ociLayoutFile:
+			var layout ociLayout
+			if err := json.NewDecoder(tr).Decode(&layout); err != nil {
+				return nil, l.entryError(name, err)
+			}
+			if !strings.HasPrefix(layout.ImageLayoutVersion, "1.") {
+				return nil, fmt.Errorf("Unsupported OCI image layout version %q", layout.ImageLayoutVersion)
+			}
+		case name == ociIndexFile:
+			l.index = &ociIndex{}
+			if err := json.NewDecoder(tr).Decode(l.index); err != nil {
+				return nil, l.entryError(name, err)
+			}
+		case strings.HasPrefix(name, ociBlobsDir+"/"):
+			if err := l.spoolBlob(name, tr); err != nil {
+				return nil, l.entryError(name, err)
+			}
+		case name == "repositories":
+			if err := json.NewDecoder(tr).Decode(&repositories); err != nil {
+				return nil, l.entryError(name, err)
+			}
+		case path.Base(name) == "json" && path.Dir(name) != ".":
+			if err := l.loadJSON(path.Dir(name), tr); err != nil {
+				return nil, l.entryError(name, err)
+			}
+		case path.Base(name) == "layer.tar" && path.Dir(name) != ".":
+			if err := l.loadLayer(path.Dir(name), tr, hdr.Size); err != nil {
+				return nil, l.entryError(name, err)
+			}
+		}
+	}
+
+	if l.index != nil {
+		if err := l.loadOCI(repositories); err != nil {
+			return nil, err
+		}
+	}
+
+	for id, p := range l.pending {
+		switch {
+		case p.img == nil:
+			return nil, fmt.Errorf("The archive has no json for image %s", id)
+		case p.layer == "":
+			return nil, fmt.Errorf("The archive has no layer for image %s", id)
+		default:
+			return nil, fmt.Errorf("Cannot load image %s: its parent %s is not in the archive", id, p.img.Parent)
+		}
+	}
+	return repositories, nil
+}
+
+// entryError returns a TruncatedArchiveError if reading the entry name
+// failed because the input ended in its middle.
+func (l *imageLoader) entryError(name string, err error) error {
+	if err == io.ErrUnexpectedEOF || l.in.ended {
+		return &TruncatedArchiveError{Name: name}
+	}
+	return err
+}
+
+func (l *imageLoader) get(id string) *pendingImage {
+	p, exists := l.pending[id]
+	if !exists {
+		p = &pendingImage{}
+		l.pending[id] = p
 	}
-	images, err := s.graph.Map()
+	return p
+}
+
+// loadJSON reads the json of image id, and registers the image if its layer
+// was spooled.
+func (l *imageLoader) loadJSON(id string, r io.Reader) error {
+	if err := utils.ValidateID(id); err != nil {
+		return err
+	}
+	imageJson, err := ioutil.ReadAll(r)
 	if err != nil {
-		return job.Error(err)
+		return err
 	}
-	excludes := make([]string, len(images))
-	i := 0
-	for k := range images {
-		excludes[i] = k
-		i++
+	img, err := image.NewImgJSON(imageJson)
+	if err != nil {
+		return err
 	}
-	if err := chrootarchive.Untar(job.Stdin, repoDir, &archive.TarOptions{ExcludePatterns: excludes}); err != nil {
-		return job.Error(err)
+	if err := utils.ValidateID(img.ID); err != nil {
+		return err
+	}
+	if l.s.graph.Exists(id) {
+		delete(l.pending, id)
+		l.out.Write(l.sf.FormatProgress(common.TruncateID(id), "Already exists", nil))
+		return nil
+	}
+	p := l.get(id)
+	p.img = img
+	if p.layer != "" && l.parentLoaded(img) {
+		return l.registerSpooled(id)
 	}
+	return nil
+}
 
-	dirs, err := ioutil.ReadDir(repoDir)
+// loadLayer registers image id with the layer in r if its parent is loaded
+// already, and spools the layer otherwise.
+func (l *imageLoader) loadLayer(id string, r io.Reader, size int64) error {
+	if err := utils.ValidateID(id); err != nil {
+		return err
+	}
+	if l.s.graph.Exists(id) {
+		return nil
+	}
+	p := l.get(id)
+	if p.img != nil && l.parentLoaded(p.img) {
+		return l.register(id, r, size)
+	}
+
+	if err := l.mkTmpDir(); err != nil {
+		return err
+	}
+	f, err := os.Create(path.Join(l.tmpDir, id))
 	if err != nil {
-		return job.Error(err)
+		return err
 	}
+	defer f.Close()
+	log.Debugf("Spooling the layer of %s until its parent is loaded", id)
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	p.layer = f.Name()
+	l.out.Write(l.sf.FormatProgress(common.TruncateID(id), "Waiting for parent layer", nil))
+	return nil
+}
 
-	for _, d := range dirs {
-		if d.IsDir() {
-			if err := s.recursiveLoad(job.Eng, d.Name(), tmpImageDir); err != nil {
-				return job.Error(err)
-			}
+// mkTmpDir creates the directory where entries are spooled.
+func (l *imageLoader) mkTmpDir() error {
+	if l.tmpDir != "" {
+		return nil
+	}
+	tmpDir, err := ioutil.TempDir("", "docker-load-")
+	if err != nil {
+		return err
+	}
+	l.tmpDir = tmpDir
+	return nil
+}
+
+func (l *imageLoader) parentLoaded(img *image.Image) bool {
+	return img.Parent == "" || l.s.graph.Exists(img.Parent)
+}
+
+// registerSpooled registers image id from its spooled layer.
+func (l *imageLoader) registerSpooled(id string) error {
+	p := l.pending[id]
+	f, err := os.Open(p.layer)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(p.layer)
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return l.register(id, f, fi.Size())
+}
+
+// register adds image id to the graph with layer, then the pending images
+// waiting for it.
+func (l *imageLoader) register(id string, layer io.Reader, size int64) error {
+	// ensure no two downloads of the same layer happen at the same time
+	if c, err := l.s.poolAdd("pull", "layer:"+id); err != nil {
+		if c == nil {
+			return err
 		}
+		log.Debugf("Image (id: %s) load is already running, waiting: %v", id, err)
+		<-c
+	} else {
+		defer l.s.poolRemove("pull", "layer:"+id)
 	}
 
-	repositoriesJson, err := ioutil.ReadFile(path.Join(tmpImageDir, "repo", "repositories"))
-	if err == nil {
-		repositories := map[string]Repository{}
-		if err := json.Unmarshal(repositoriesJson, &repositories); err != nil {
-			return job.Error(err)
+	if !l.s.graph.Exists(id) {
+		log.Debugf("Loading %s", id)
+		progressReader := progressreader.New(progressreader.Config{
+			In:        ioutil.NopCloser(layer),
+			Out:       l.out,
+			Formatter: l.sf,
+			Size:      int(size),
+			NewLines:  false,
+			ID:        common.TruncateID(id),
+			Action:    "Loading layer",
+		})
+		defer progressReader.Close()
+		// Register moves the image to its content-addressable ID
+		if err := l.s.graph.Register(l.pending[id].img, progressReader); err != nil {
+			return err
 		}
+		l.out.Write(l.sf.FormatProgress(common.TruncateID(id), "Load complete", nil))
+	}
+	delete(l.pending, id)
+	log.Debugf("Completed processing %s", id)
 
-		for imageName, tagMap := range repositories {
-			for tag, address := range tagMap {
-				if err := s.Set(imageName, tag, address, true); err != nil {
-					return job.Error(err)
-				}
+	for childID, p := range l.pending {
+		if p.img != nil && p.img.Parent == id && p.layer != "" {
+			if err := l.registerSpooled(childID); err != nil {
+				return err
 			}
 		}
-	} else if !os.IsNotExist(err) {
-		return job.Error(err)
 	}
+	return nil
+}
 
-	return engine.StatusOK
+// spoolBlob stores the blob name of an OCI image layout after checking its
+// digest.
+func (l *imageLoader) spoolBlob(name string, r io.Reader) error {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[1] != "sha256" || utils.ValidateID(parts[2]) != nil {
+		return fmt.Errorf("Invalid blob %s", name)
+	}
+	if err := l.mkTmpDir(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Join(l.tmpDir, ociBlobsDir, "sha256"), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(path.Join(l.tmpDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return err
+	}
+	if sum := fmt.Sprintf("%x", h.Sum(nil)); sum != parts[2] {
+		return fmt.Errorf("Blob %s has digest sha256:%s", name, sum)
+	}
+	return nil
 }
 
-func (s *TagStore) recursiveLoad(eng *engine.Engine, address, tmpImageDir string) error {
-	if err := eng.Job("image_get", address).Run(); err != nil {
-		log.Debugf("Loading %s", address)
+// openBlob opens the spooled blob of desc.
+func (l *imageLoader) openBlob(desc registry.Descriptor) (*os.File, error) {
+	if !strings.HasPrefix(desc.Digest, "sha256:") || utils.ValidateID(strings.TrimPrefix(desc.Digest, "sha256:")) != nil {
+		return nil, fmt.Errorf("Unsupported digest %q", desc.Digest)
+	}
+	f, err := os.Open(path.Join(l.tmpDir, ociBlobsDir, "sha256", strings.TrimPrefix(desc.Digest, "sha256:")))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("The archive has no blob %s", desc.Digest)
+	}
+	return f, err
+}
 
-		imageJson, err := ioutil.ReadFile(path.Join(tmpImageDir, "repo", address, "json"))
-		if err != nil {
-			log.Debugf("Error reading json", err)
-			return err
-		}
+func (l *imageLoader) readJSONBlob(desc registry.Descriptor, v interface{}) error {
+	f, err := l.openBlob(desc)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
 
-		layer, err := os.Open(path.Join(tmpImageDir, "repo", address, "layer.tar"))
-		if err != nil {
-			log.Debugf("Error reading embedded tar", err)
-			return err
+// loadOCI registers the images of the index of an OCI image layout, and adds
+// their references to repositories.
+func (l *imageLoader) loadOCI(repositories map[string]Repository) error {
+	for _, desc := range l.index.Manifests {
+		if desc.MediaType != "" && desc.MediaType != ociMediaTypeManifest && desc.MediaType != registry.MediaTypeManifest {
+			return fmt.Errorf("Unsupported manifest %s of type %s", desc.Digest, desc.MediaType)
 		}
-		img, err := image.NewImgJSON(imageJson)
-		if err != nil {
-			log.Debugf("Error unmarshalling json", err)
+		var (
+			manifest registry.Schema2Manifest
+			config   imageConfig
+		)
+		if err := l.readJSONBlob(desc.Descriptor, &manifest); err != nil {
 			return err
 		}
-		if err := utils.ValidateID(img.ID); err != nil {
-			log.Debugf("Error validating ID: %s", err)
+		if err := l.readJSONBlob(manifest.Config, &config); err != nil {
 			return err
 		}
+		images, err := configImages(&manifest, &config)
+		if err != nil {
+			return fmt.Errorf("Invalid manifest %s: %s", desc.Digest, err)
+		}
 
-		// ensure no two downloads of the same layer happen at the same time
-		if c, err := s.poolAdd("pull", "layer:"+img.ID); err != nil {
-			if c != nil {
-				log.Debugf("Image (id: %s) load is already running, waiting: %v", img.ID, err)
-				<-c
-				return nil
+		for i, img := range images {
+			if l.s.graph.Exists(img.ID) {
+				l.out.Write(l.sf.FormatProgress(common.TruncateID(img.ID), "Already exists", nil))
+				continue
+			}
+			f, err := l.openBlob(manifest.Layers[i])
+			if err != nil {
+				return err
+			}
+			l.pending[img.ID] = &pendingImage{img: img}
+			err = l.register(img.ID, f, manifest.Layers[i].Size)
+			f.Close()
+			if err != nil {
+				return err
 			}
-
-			return err
 		}
 
-		defer s.poolRemove("pull", "layer:"+img.ID)
-
-		if img.Parent != "" {
-			if !s.graph.Exists(img.Parent) {
-				if err := s.recursiveLoad(eng, img.Parent, tmpImageDir); err != nil {
-					return err
-				}
+		ref := desc.Annotations[ociRefNameAnnotation]
+		if name, tag := parsers.ParseRepositoryTag(ref); tag != "" {
+			if _, exists := repositories[name]; !exists {
+				repositories[name] = Repository{}
 			}
-		}
-		if err := s.graph.Register(img, layer); err != nil {
-			return err
+			repositories[name][tag] = images[len(images)-1].ID
+		} else if ref != "" {
+			log.Debugf("Not tagging %s: %q is not a NAME:TAG reference", desc.Digest, ref)
 		}
 	}
-	log.Debugf("Completed processing %s", address)
-
 	return nil
 }
+
+func (l *imageLoader) cleanup() {
+	if l.tmpDir != "" {
+		os.RemoveAll(l.tmpDir)
+	}
+}
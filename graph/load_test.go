@@ -0,0 +1,183 @@
+// +build linux
+
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/utils"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+)
+
+const (
+	testLoadParentID = "8e2a9a10c7b7d8d9f4e9be5e6d61f0b2a6c6f0cbdaf39e1e9b2a2c0cf1b36d51"
+	testLoadChildID  = "3f0a6e86e3b4ab9f1f5e8f2d1f0b9f1a4a6c0d0b1c2e3f4a5b6c7d8e9f0a1b2c"
+)
+
+// testLoadArchive returns a save archive of a child image and its parent, in
+// this order, and the offset of the child's layer in the archive.
+func testLoadArchive(t *testing.T) ([]byte, int) {
+	var (
+		buf         bytes.Buffer
+		tw          = tar.NewWriter(&buf)
+		layerOffset int
+	)
+	add := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if name == testLoadChildID+"/layer.tar" {
+			layerOffset = buf.Len()
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, img := range []struct{ id, json string }{
+		{testLoadChildID, fmt.Sprintf(`{"id": %q, "parent": %q}`, testLoadChildID, testLoadParentID)},
+		{testLoadParentID, fmt.Sprintf(`{"id": %q}`, testLoadParentID)},
+	} {
+		layer, err := fakeTar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		layerBytes, err := ioutil.ReadAll(layer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		add(img.id+"/VERSION", []byte("1.0"))
+		add(img.id+"/json", []byte(img.json))
+		add(img.id+"/layer.tar", layerBytes)
+	}
+	add("repositories", []byte(fmt.Sprintf(`{"loaded": {"latest": %q}}`, testLoadChildID)))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), layerOffset
+}
+
+func newTestImageLoader(store *TagStore, archive []byte) *imageLoader {
+	return &imageLoader{
+		s:       store,
+		in:      &endReader{Reader: bytes.NewReader(archive)},
+		out:     ioutil.Discard,
+		sf:      utils.NewStreamFormatter(false),
+		pending: make(map[string]*pendingImage),
+	}
+}
+
+func TestLoadOutOfOrder(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	archive, _ := testLoadArchive(t)
+	l := newTestImageLoader(store, archive)
+	defer l.cleanup()
+	repositories, err := l.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := repositories["loaded"]["latest"]; id != testLoadChildID {
+		t.Fatalf("Expected loaded:latest to be %s, got %s", testLoadChildID, id)
+	}
+	for _, id := range []string{testLoadParentID, testLoadChildID} {
+		if !store.graph.Exists(id) {
+			t.Fatalf("Expected %s to be loaded", id)
+		}
+	}
+	if len(l.pending) != 0 {
+		t.Fatalf("Expected no pending image, got %d", len(l.pending))
+	}
+
+	// Loading again skips the images already there
+	l = newTestImageLoader(store, archive)
+	defer l.cleanup()
+	if _, err := l.load(); err != nil {
+		t.Fatal(err)
+	}
+	if l.tmpDir != "" {
+		t.Fatal("Expected no layer to be spooled")
+	}
+}
+
+func TestLoadTruncated(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	archive, layerOffset := testLoadArchive(t)
+	l := newTestImageLoader(store, archive[:layerOffset+100])
+	defer l.cleanup()
+	_, err = l.load()
+	truncated, ok := err.(*TruncatedArchiveError)
+	if !ok {
+		t.Fatalf("Expected a TruncatedArchiveError, got %v", err)
+	}
+	if truncated.Name != testLoadChildID+"/layer.tar" {
+		t.Fatalf("Expected the child's layer to be truncated, got %q", truncated.Name)
+	}
+	if store.graph.Exists(testLoadChildID) {
+		t.Fatal("Expected the truncated image not to be loaded")
+	}
+}
+
+func TestLoadSavedImage(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := mkTestTagStore(path.Join(tmp, "src"), t)
+	defer src.graph.driver.Cleanup()
+	eng := engine.New()
+	if err := src.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+
+	saveDir := path.Join(tmp, "save")
+	if err := os.Mkdir(saveDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.exportImage(eng, testOfficialImageID, saveDir); err != nil {
+		t.Fatal(err)
+	}
+	tarStream, err := archive.Tar(saveDir, archive.Uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadAll(tarStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := mkEmptyTagStore(path.Join(tmp, "dst"), t)
+	defer dst.graph.driver.Cleanup()
+	l := newTestImageLoader(dst, saved)
+	defer l.cleanup()
+	if _, err := l.load(); err != nil {
+		t.Fatal(err)
+	}
+	img, err := src.graph.Get(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dst.graph.Exists(img.ID) {
+		t.Fatalf("Expected %s to be loaded", img.ID)
+	}
+}
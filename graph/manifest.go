@@ -2,73 +2,187 @@ package graph
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
 	"github.com/docker/docker/registry"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/utils"
 	"github.com/docker/libtrust"
 )
 
-// loadManifest loads a manifest from a byte array and verifies its content.
-// The signature must be verified or an error is returned. If the manifest
-// contains no signatures by a trusted key for the name in the manifest, the
-// image is not considered verified. The parsed manifest object and a boolean
-// for whether the manifest is verified is returned.
-func (s *TagStore) loadManifest(eng *engine.Engine, manifestBytes []byte) (*registry.ManifestData, bool, error) {
+// imageConfig is the config blob of schema 2 manifests and of images in the
+// OCI layout. Images pushed or saved by docker also keep the JSON of the
+// image of each layer, so that they keep their IDs. Images of other tools
+// are described by the config of the top image and the history of the
+// layers.
+type imageConfig struct {
+	registry.ImageConfig
+	Created time.Time         `json:"created"`
+	Author  string            `json:"author,omitempty"`
+	Config  *runconfig.Config `json:"config,omitempty"`
+	History []configHistory   `json:"history,omitempty"`
+}
+
+// configHistory describes the step that created a layer, or an empty layer
+// such as the one of an ENV instruction.
+type configHistory struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// newImageConfig returns the config of img, without its layers.
+func newImageConfig(img *image.Image) *imageConfig {
+	return &imageConfig{
+		ImageConfig: registry.ImageConfig{
+			Architecture: img.Architecture,
+			OS:           img.OS,
+			RootFS:       registry.RootFS{Type: "layers"},
+		},
+		Created: img.Created,
+		Author:  img.Author,
+		Config:  img.Config,
+	}
+}
+
+// addLayer adds the layer of img, whose uncompressed digest is diffID, on
+// top of the layers of the config. imgJSON is the JSON of img.
+func (c *imageConfig) addLayer(img *image.Image, diffID string, imgJSON []byte) {
+	c.RootFS.DiffIDs = append(c.RootFS.DiffIDs, diffID)
+	c.Images = append(c.Images, json.RawMessage(imgJSON))
+	c.History = append(c.History, configHistory{
+		Created:   img.Created,
+		CreatedBy: strings.Join(img.ContainerConfig.Cmd, " "),
+		Author:    img.Author,
+		Comment:   img.Comment,
+	})
+}
+
+// configImages returns the images of the layers of manifest, from the base
+// layer to the top one. Images pushed or saved by docker are read from the
+// config. The images of other tools get IDs derived from the digests of
+// their layers, and of the config for the top one, and are described by
+// the history of the config.
+func configImages(manifest *registry.Schema2Manifest, config *imageConfig) ([]*image.Image, error) {
+	if len(manifest.Layers) == 0 {
+		return nil, fmt.Errorf("the image has no layers")
+	}
+	images := make([]*image.Image, len(manifest.Layers))
+	if len(config.Images) > 0 {
+		if len(config.Images) != len(manifest.Layers) {
+			return nil, fmt.Errorf("the config has %d images for %d layers", len(config.Images), len(manifest.Layers))
+		}
+		for i, imgJSON := range config.Images {
+			img, err := image.NewImgJSON(imgJSON)
+			if err != nil {
+				return nil, err
+			}
+			if err := utils.ValidateID(img.ID); err != nil {
+				return nil, err
+			}
+			if i > 0 && img.Parent != images[i-1].ID {
+				return nil, fmt.Errorf("the parent of %s is not %s", img.ID, images[i-1].ID)
+			}
+			images[i] = img
+		}
+		return images, nil
+	}
+
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("the config has %d layer digests for %d layers", len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	// Steps that did not create a layer are left out
+	var history []configHistory
+	for _, h := range config.History {
+		if !h.EmptyLayer {
+			history = append(history, h)
+		}
+	}
+	if len(history) != len(images) {
+		history = nil
+	}
+	parent := ""
+	for i, diffID := range config.RootFS.DiffIDs {
+		key := parent + " " + diffID
+		if i == len(images)-1 {
+			// Images that only differ by their config have different IDs
+			key += " " + manifest.Config.Digest
+		}
+		img := &image.Image{
+			ID:           fmt.Sprintf("%x", sha256.Sum256([]byte(key))),
+			Parent:       parent,
+			Created:      config.Created,
+			Author:       config.Author,
+			Architecture: config.Architecture,
+			OS:           config.OS,
+			DiffID:       diffID,
+		}
+		if history != nil {
+			h := history[i]
+			img.Created, img.Author, img.Comment = h.Created, h.Author, h.Comment
+			if h.CreatedBy != "" {
+				img.ContainerConfig.Cmd = []string{h.CreatedBy}
+			}
+		}
+		if i == len(images)-1 {
+			img.Config = config.Config
+		}
+		images[i] = img
+		parent = img.ID
+	}
+	return images, nil
+}
+
+// loadManifest loads the manifest of remoteName:tag from a byte array and
+// verifies its content. The signature must be verified and the manifest must
+// be the one of remoteName:tag or an error is returned; the tag is not
+// checked for manifests requested by digest. If the manifest contains no
+// signatures by a trusted key for remoteName, the image is not considered
+// verified. The parsed manifest object and the trust of its signatures are
+// returned.
+func (s *TagStore) loadManifest(eng *engine.Engine, manifestBytes []byte, remoteName, tag string) (*registry.ManifestData, *ImageTrust, error) {
 	sig, err := libtrust.ParsePrettySignature(manifestBytes, "signatures")
 	if err != nil {
-		return nil, false, fmt.Errorf("error parsing payload: %s", err)
+		return nil, nil, fmt.Errorf("error parsing payload: %s", err)
 	}
 
 	keys, err := sig.Verify()
 	if err != nil {
-		return nil, false, fmt.Errorf("error verifying payload: %s", err)
+		return nil, nil, fmt.Errorf("error verifying payload: %s", err)
 	}
 
 	payload, err := sig.Payload()
 	if err != nil {
-		return nil, false, fmt.Errorf("error retrieving payload: %s", err)
+		return nil, nil, fmt.Errorf("error retrieving payload: %s", err)
 	}
 
 	var manifest registry.ManifestData
 	if err := json.Unmarshal(payload, &manifest); err != nil {
-		return nil, false, fmt.Errorf("error unmarshalling manifest: %s", err)
+		return nil, nil, fmt.Errorf("error unmarshalling manifest: %s", err)
 	}
 	if manifest.SchemaVersion != 1 {
-		return nil, false, fmt.Errorf("unsupported schema version: %d", manifest.SchemaVersion)
+		return nil, nil, fmt.Errorf("unsupported schema version: %d", manifest.SchemaVersion)
 	}
-
-	var verified bool
-	for _, key := range keys {
-		job := eng.Job("trust_key_check")
-		b, err := key.MarshalJSON()
-		if err != nil {
-			return nil, false, fmt.Errorf("error marshalling public key: %s", err)
-		}
-		namespace := manifest.Name
-		if namespace[0] != '/' {
-			namespace = "/" + namespace
-		}
-		stdoutBuffer := bytes.NewBuffer(nil)
-
-		job.Args = append(job.Args, namespace)
-		job.Setenv("PublicKey", string(b))
-		// Check key has read/write permission (0x03)
-		job.SetenvInt("Permission", 0x03)
-		job.Stdout.Add(stdoutBuffer)
-		if err = job.Run(); err != nil {
-			return nil, false, fmt.Errorf("error running key check: %s", err)
-		}
-		result := engine.Tail(stdoutBuffer, 1)
-		log.Debugf("Key check result: %q", result)
-		if result == "verified" {
-			verified = true
-		}
+	if manifest.Name != remoteName {
+		return nil, nil, fmt.Errorf("manifest is for %s, not %s", manifest.Name, remoteName)
+	}
+	if !utils.DigestReference(tag) && manifest.Tag != tag {
+		return nil, nil, fmt.Errorf("manifest is for tag %s, not %s", manifest.Tag, tag)
 	}
 
-	return &manifest, verified, nil
+	trust, err := trustedKeys(eng, trustNamespace(remoteName), keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &manifest, trust, nil
 }
 
 func checkValidManifest(manifest *registry.ManifestData) error {
@@ -82,3 +196,71 @@ func checkValidManifest(manifest *registry.ManifestData) error {
 
 	return nil
 }
+
+// parseSchema2Manifest returns the schema 2 manifest in manifestBytes, or nil
+// if manifestBytes holds another kind of manifest.
+func parseSchema2Manifest(manifestBytes []byte) *registry.Schema2Manifest {
+	var manifest registry.Schema2Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil || manifest.MediaType != registry.MediaTypeManifest {
+		return nil
+	}
+	return &manifest
+}
+
+// fetchImageConfig fetches and verifies the config blob of a schema 2
+// manifest.
+func fetchImageConfig(r *registry.Session, endpoint *registry.Endpoint, remoteName string, manifest *registry.Schema2Manifest, auth *registry.RequestAuthorization) (*imageConfig, error) {
+	chunks := strings.SplitN(manifest.Config.Digest, ":", 2)
+	if len(chunks) < 2 || chunks[0] != "sha256" {
+		return nil, fmt.Errorf("unsupported config digest: %s", manifest.Config.Digest)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := r.GetV2ImageBlob(endpoint, remoteName, chunks[0], chunks[1], buf, auth); err != nil {
+		return nil, fmt.Errorf("error fetching image config: %s", err)
+	}
+	if sum := fmt.Sprintf("%x", sha256.Sum256(buf.Bytes())); sum != chunks[1] {
+		return nil, fmt.Errorf("image config digest mismatch: expected %s, got sha256:%s", manifest.Config.Digest, sum)
+	}
+	var config imageConfig
+	if err := json.Unmarshal(buf.Bytes(), &config); err != nil {
+		return nil, fmt.Errorf("error unmarshalling image config: %s", err)
+	}
+	return &config, nil
+}
+
+// schema1FromSchema2 converts a schema 2 manifest and its config to the
+// schema 1 layout, from the top layer to the base one, so that both are
+// pulled the same way.
+func schema1FromSchema2(name string, manifest *registry.Schema2Manifest, config *imageConfig) (*registry.ManifestData, error) {
+	v1Images := config.Images
+	if len(v1Images) == 0 {
+		// Images pushed by other tools
+		images, err := configImages(manifest, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range images {
+			imgJSON, err := json.Marshal(img)
+			if err != nil {
+				return nil, err
+			}
+			v1Images = append(v1Images, json.RawMessage(imgJSON))
+		}
+	}
+	if len(v1Images) != len(manifest.Layers) {
+		return nil, fmt.Errorf("image config has %d images for %d layers", len(v1Images), len(manifest.Layers))
+	}
+	m := &registry.ManifestData{
+		SchemaVersion: 1,
+		Name:          name,
+		Architecture:  config.Architecture,
+	}
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		m.FSLayers = append(m.FSLayers, &registry.FSLayer{BlobSum: manifest.Layers[i].Digest})
+		m.History = append(m.History, &registry.ManifestHistory{V1Compatibility: string(v1Images[i])})
+	}
+	if err := checkValidManifest(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
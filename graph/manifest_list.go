@@ -0,0 +1,210 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/utils"
+	"github.com/docker/libtrust"
+)
+
+// hostPlatform returns the platform the daemon runs on.
+func hostPlatform() registry.Platform {
+	return registry.Platform{
+		OS:           runtime.GOOS,
+		Architecture: runtime.GOARCH,
+	}
+}
+
+// parseManifestList returns the manifest list in manifestBytes, or nil if
+// manifestBytes holds a single manifest.
+func parseManifestList(manifestBytes []byte) *registry.ManifestList {
+	var list registry.ManifestList
+	if err := json.Unmarshal(manifestBytes, &list); err != nil || list.MediaType != registry.MediaTypeManifestList {
+		return nil
+	}
+	return &list
+}
+
+// selectManifest picks the first manifest of list for platform. The variant
+// is only compared if both have one.
+func selectManifest(list *registry.ManifestList, platform registry.Platform) (*registry.ManifestDescriptor, error) {
+	for _, m := range list.Manifests {
+		if m.Platform.OS != platform.OS || m.Platform.Architecture != platform.Architecture {
+			continue
+		}
+		if m.Platform.Variant != "" && platform.Variant != "" && m.Platform.Variant != platform.Variant {
+			continue
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("no matching manifest for %s in the manifest list entries", platform)
+}
+
+// manifestPlatform returns the platform of the image described by a signed
+// schema 1 manifest.
+func manifestPlatform(manifestBytes []byte) (registry.Platform, error) {
+	var platform registry.Platform
+
+	sig, err := libtrust.ParsePrettySignature(manifestBytes, "signatures")
+	if err != nil {
+		return platform, fmt.Errorf("error parsing payload: %s", err)
+	}
+	payload, err := sig.Payload()
+	if err != nil {
+		return platform, fmt.Errorf("error retrieving payload: %s", err)
+	}
+	var manifest registry.ManifestData
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return platform, fmt.Errorf("error unmarshalling manifest: %s", err)
+	}
+	if err := checkValidManifest(&manifest); err != nil {
+		return platform, err
+	}
+
+	// The top image comes first in schema 1 manifests
+	img, err := image.NewImgJSON([]byte(manifest.History[0].V1Compatibility))
+	if err != nil {
+		return platform, fmt.Errorf("failed to parse json: %s", err)
+	}
+	platform.OS = img.OS
+	platform.Architecture = manifest.Architecture
+	if platform.Architecture == "" {
+		platform.Architecture = img.Architecture
+	}
+	if platform.OS == "" || platform.Architecture == "" {
+		return platform, fmt.Errorf("the image does not record its platform")
+	}
+	return platform, nil
+}
+
+// CmdManifestPush creates a manifest list from images already pushed to a v2
+// repository, one per platform, and pushes it to a tag of that repository.
+func (s *TagStore) CmdManifestPush(job *engine.Job) engine.Status {
+	if len(job.Args) < 2 {
+		return job.Errorf("Usage: %s NAME[:TAG] SOURCE [SOURCE...]", job.Name)
+	}
+	var (
+		sf          = utils.NewStreamFormatter(job.GetenvBool("json"))
+		authConfig  = &registry.AuthConfig{}
+		metaHeaders map[string][]string
+	)
+
+	remote, tag := parsers.ParseRepositoryTag(job.Args[0])
+	if tag == "" {
+		tag = DEFAULTTAG
+	}
+	if utils.DigestReference(tag) {
+		return job.Errorf("Cannot push a manifest list by digest: %s", job.Args[0])
+	}
+	repoInfo, err := registry.ResolveRepositoryInfo(job, remote)
+	if err != nil {
+		return job.Error(err)
+	}
+
+	// The manifests of the list must be in the same repository
+	refs := make([]string, 0, len(job.Args)-1)
+	for _, source := range job.Args[1:] {
+		name, ref := parsers.ParseRepositoryTag(source)
+		if ref == "" {
+			ref = DEFAULTTAG
+		}
+		sourceInfo, err := registry.ResolveRepositoryInfo(job, name)
+		if err != nil {
+			return job.Error(err)
+		}
+		if sourceInfo.CanonicalName != repoInfo.CanonicalName {
+			return job.Errorf("%s is not in repository %s", source, repoInfo.CanonicalName)
+		}
+		refs = append(refs, ref)
+	}
+
+	job.GetenvJson("authConfig", authConfig)
+	job.GetenvJson("metaHeaders", &metaHeaders)
+
+	endpoint, err := repoInfo.GetEndpoint()
+	if err != nil {
+		return job.Error(err)
+	}
+	r, err := registry.NewSession(authConfig, registry.HTTPRequestFactory(metaHeaders), endpoint, false)
+	if err != nil {
+		return job.Error(err)
+	}
+	endpoint, err = r.V2RegistryEndpoint(repoInfo.Index)
+	if err != nil {
+		return job.Errorf("Manifest lists require a v2 registry: %s", err)
+	}
+	auth, err := r.GetV2Authorization(endpoint, repoInfo.RemoteName, false)
+	if err != nil {
+		return job.Errorf("error getting authorization: %s", err)
+	}
+
+	list := &registry.ManifestList{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifestList,
+	}
+	platforms := make(map[string]string)
+	for i, ref := range refs {
+		source := job.Args[i+1]
+		manifestBytes, digest, err := r.GetV2ImageManifest(endpoint, repoInfo.RemoteName, ref, auth)
+		if err != nil {
+			return job.Errorf("Error fetching the manifest of %s: %s", source, err)
+		}
+		if parseManifestList(manifestBytes) != nil {
+			return job.Errorf("%s is a manifest list", source)
+		}
+		if digest == "" {
+			return job.Errorf("The registry did not return the digest of %s", source)
+		}
+		var (
+			platform  registry.Platform
+			mediaType = registry.MediaTypeSignedManifest
+		)
+		if m := parseSchema2Manifest(manifestBytes); m != nil {
+			mediaType = registry.MediaTypeManifest
+			config, err := fetchImageConfig(r, endpoint, repoInfo.RemoteName, m, auth)
+			if err != nil {
+				return job.Errorf("Error reading the manifest of %s: %s", source, err)
+			}
+			platform = registry.Platform{OS: config.OS, Architecture: config.Architecture}
+			if platform.OS == "" || platform.Architecture == "" {
+				return job.Errorf("Error reading the manifest of %s: the image does not record its platform", source)
+			}
+		} else if platform, err = manifestPlatform(manifestBytes); err != nil {
+			return job.Errorf("Error reading the manifest of %s: %s", source, err)
+		}
+		if other, exists := platforms[platform.String()]; exists {
+			return job.Errorf("%s and %s are both for %s", other, source, platform)
+		}
+		platforms[platform.String()] = source
+
+		list.Manifests = append(list.Manifests, &registry.ManifestDescriptor{
+			Descriptor: registry.Descriptor{
+				MediaType: mediaType,
+				Size:      int64(len(manifestBytes)),
+				Digest:    digest,
+			},
+			Platform: platform,
+		})
+		job.Stdout.Write(sf.FormatStatus("", "%s: %s %s", source, platform, digest))
+	}
+
+	listBytes, err := json.MarshalIndent(list, "", "   ")
+	if err != nil {
+		return job.Error(err)
+	}
+	digest, err := r.PutV2ManifestList(endpoint, repoInfo.RemoteName, tag, bytes.NewReader(listBytes), auth)
+	if err != nil {
+		return job.Errorf("Error pushing the manifest list: %s", err)
+	}
+	if len(digest) > 0 {
+		job.Stdout.Write(sf.FormatStatus("", "Digest: %s", digest))
+	}
+	return engine.StatusOK
+}
@@ -0,0 +1,231 @@
+package graph
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/registry/v2"
+	"github.com/docker/docker/utils"
+	"github.com/docker/libtrust"
+)
+
+func testManifestList() *registry.ManifestList {
+	return &registry.ManifestList{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifestList,
+		Manifests: []*registry.ManifestDescriptor{
+			{Descriptor: registry.Descriptor{Digest: "sha256:arm"}, Platform: registry.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+			{Descriptor: registry.Descriptor{Digest: "sha256:amd64"}, Platform: registry.Platform{OS: "linux", Architecture: "amd64"}},
+			{Descriptor: registry.Descriptor{Digest: "sha256:windows"}, Platform: registry.Platform{OS: "windows", Architecture: "amd64"}},
+		},
+	}
+}
+
+func TestParseManifestList(t *testing.T) {
+	b, err := json.Marshal(testManifestList())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := parseManifestList(b)
+	if list == nil || len(list.Manifests) != 3 {
+		t.Fatalf("Expected a manifest list with 3 manifests, got %v", list)
+	}
+
+	manifest := []byte(`{"schemaVersion": 1, "name": "foo", "tag": "latest", "fsLayers": [], "history": []}`)
+	if list := parseManifestList(manifest); list != nil {
+		t.Fatalf("Expected no manifest list, got %v", list)
+	}
+}
+
+func TestSelectManifest(t *testing.T) {
+	list := testManifestList()
+	for platform, digest := range map[registry.Platform]string{
+		{OS: "linux", Architecture: "amd64"}:              "sha256:amd64",
+		{OS: "windows", Architecture: "amd64"}:            "sha256:windows",
+		{OS: "linux", Architecture: "arm"}:                "sha256:arm",
+		{OS: "linux", Architecture: "arm", Variant: "v7"}: "sha256:arm",
+		{OS: "linux", Architecture: "arm", Variant: "v6"}: "",
+		{OS: "linux", Architecture: "ppc64le"}:            "",
+		{OS: "freebsd", Architecture: "amd64"}:            "",
+	} {
+		m, err := selectManifest(list, platform)
+		if digest == "" {
+			if err == nil {
+				t.Fatalf("Expected no manifest for %s, got %s", platform, m.Digest)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Digest != digest {
+			t.Fatalf("Expected %s for %s, got %s", digest, platform, m.Digest)
+		}
+	}
+}
+
+func TestManifestPlatform(t *testing.T) {
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(m *registry.ManifestData) []byte {
+		b, err := json.MarshalIndent(m, "", "   ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		js, err := libtrust.NewJSONSignature(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := js.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		signed, err := js.PrettySignature("signatures")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	m := &registry.ManifestData{
+		SchemaVersion: 1,
+		Name:          "foo",
+		Tag:           "arm",
+		Architecture:  "arm",
+		FSLayers:      []*registry.FSLayer{{BlobSum: "tarsum.dev+sha256:top"}, {BlobSum: "tarsum.dev+sha256:base"}},
+		History: []*registry.ManifestHistory{
+			{V1Compatibility: `{"id": "top", "parent": "base", "os": "linux", "architecture": "arm"}`},
+			{V1Compatibility: `{"id": "base"}`},
+		},
+	}
+	platform, err := manifestPlatform(sign(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if platform.String() != "linux/arm" {
+		t.Fatalf("Expected linux/arm, got %s", platform)
+	}
+
+	// Older images do not record their platform
+	m.Architecture = ""
+	m.History[0].V1Compatibility = `{"id": "top", "parent": "base"}`
+	if _, err := manifestPlatform(sign(m)); err == nil {
+		t.Fatal("Expected an error for a manifest without platform")
+	}
+}
+
+// testManifestListRegistry serves a manifest list with a schema 2 manifest
+// of a single layer for the host's platform as foo/bar:latest.
+func testManifestListRegistry(t *testing.T, layer []byte) *httptest.Server {
+	content := make(map[string][]byte)
+	add := func(b []byte) string {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+		content[digest] = b
+		return digest
+	}
+	marshal := func(v interface{}) []byte {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	config := marshal(&imageConfig{ImageConfig: registry.ImageConfig{
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		Images:       []json.RawMessage{json.RawMessage(fmt.Sprintf(`{"id":"%s"}`, strings.Repeat("5", 64)))},
+	}})
+	manifest := marshal(&registry.Schema2Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifest,
+		Config:        registry.Descriptor{MediaType: registry.MediaTypeImageConfig, Size: int64(len(config)), Digest: add(config)},
+		Layers:        []registry.Descriptor{{MediaType: registry.MediaTypeLayer, Size: int64(len(layer)), Digest: add(layer)}},
+	})
+	list := marshal(&registry.ManifestList{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifestList,
+		Manifests: []*registry.ManifestDescriptor{
+			{Descriptor: registry.Descriptor{MediaType: registry.MediaTypeManifest, Size: int64(len(manifest)), Digest: add(manifest)}, Platform: hostPlatform()},
+		},
+	})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		switch {
+		case r.URL.Path == "/v2/foo/bar/manifests/latest":
+			w.Write(list)
+		case strings.HasPrefix(r.URL.Path, "/v2/foo/bar/manifests/") && content[ref] != nil:
+			w.Header().Set(registry.DockerDigestHeader, ref)
+			w.Write(content[ref])
+		case strings.HasPrefix(r.URL.Path, "/v2/foo/bar/blobs/") && content[ref] != nil:
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content[ref]))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestPullManifestListTwice(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	layer, _ := testLayer(t)
+	server := testManifestListRegistry(t, layer)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := &registry.Endpoint{URL: u, Version: registry.APIVersion2, URLBuilder: v2.NewURLBuilder(u)}
+	r, err := registry.NewSession(&registry.AuthConfig{}, utils.NewHTTPRequestFactory(), endpoint, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repoInfo := &registry.RepositoryInfo{
+		Index:         &registry.IndexInfo{Name: u.Host},
+		RemoteName:    "foo/bar",
+		LocalName:     u.Host + "/foo/bar",
+		CanonicalName: u.Host + "/foo/bar",
+	}
+	auth := registry.NewRequestAuthorization(&registry.AuthConfig{}, endpoint, "repository", repoInfo.RemoteName, []string{"pull"})
+
+	// The second pull finds the layer already registered under its
+	// legacy ID
+	for i := 0; i < 2; i++ {
+		if _, err := store.pullV2Tag(nil, r, ioutil.Discard, endpoint, repoInfo, "latest", utils.NewStreamFormatter(false), false, auth); err != nil {
+			t.Fatalf("Pull %d: %s", i+1, err)
+		}
+	}
+
+	img, err := store.LookupImage(repoInfo.LocalName + ":latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	platform, err := img.GetPlatform(store.graph.ImageRoot(img.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := hostPlatform().String(); platform != expected {
+		t.Fatalf("Expected platform %s, got %q", expected, platform)
+	}
+}
@@ -139,7 +139,7 @@ func TestManifestTarsumCache(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if cs, err := img.GetCheckSum(store.graph.ImageRoot(testManifestImageID)); err != nil {
+	if cs, err := img.GetCheckSum(store.graph.ImageRoot(img.ID)); err != nil {
 		t.Fatal(err)
 	} else if cs != "" {
 		t.Fatalf("Non-empty checksum file after register")
@@ -151,7 +151,7 @@ func TestManifestTarsumCache(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	manifestChecksum, err := img.GetCheckSum(store.graph.ImageRoot(testManifestImageID))
+	manifestChecksum, err := img.GetCheckSum(store.graph.ImageRoot(img.ID))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -181,3 +181,102 @@ func TestManifestTarsumCache(t *testing.T) {
 		t.Fatalf("Unexpected json value\nExpected:\n%s\nActual:\n%s", v1compat, manifest.History[0].V1Compatibility)
 	}
 }
+
+func TestSchema1FromSchema2(t *testing.T) {
+	manifestJSON, err := json.Marshal(&registry.Schema2Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifest,
+		Config:        registry.Descriptor{MediaType: registry.MediaTypeImageConfig, Digest: "sha256:config"},
+		Layers: []registry.Descriptor{
+			{MediaType: registry.MediaTypeLayer, Size: 10, Digest: "tarsum.v1+sha256:base"},
+			{MediaType: registry.MediaTypeLayer, Size: 20, Digest: "tarsum.v1+sha256:top"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := parseSchema2Manifest(manifestJSON)
+	if manifest == nil {
+		t.Fatal("Expected a schema 2 manifest")
+	}
+	if parseSchema2Manifest([]byte(`{"schemaVersion": 1, "fsLayers": []}`)) != nil {
+		t.Fatal("Expected a schema 1 manifest not to be parsed as schema 2")
+	}
+
+	config := &imageConfig{ImageConfig: registry.ImageConfig{
+		Architecture: "amd64",
+		OS:           "linux",
+		Images:       []json.RawMessage{json.RawMessage(`{"id":"base"}`), json.RawMessage(`{"id":"top","parent":"base"}`)},
+	}}
+	m, err := schema1FromSchema2(testManifestImageName, manifest, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Architecture != "amd64" || m.Name != testManifestImageName {
+		t.Fatalf("Unexpected manifest %+v", m)
+	}
+	// Schema 1 lists the top layer first
+	if m.FSLayers[0].BlobSum != "tarsum.v1+sha256:top" || m.History[0].V1Compatibility != `{"id":"top","parent":"base"}` {
+		t.Fatalf("Expected the top layer first, got %s %s", m.FSLayers[0].BlobSum, m.History[0].V1Compatibility)
+	}
+	if m.FSLayers[1].BlobSum != "tarsum.v1+sha256:base" || m.History[1].V1Compatibility != `{"id":"base"}` {
+		t.Fatalf("Expected the base layer last, got %s %s", m.FSLayers[1].BlobSum, m.History[1].V1Compatibility)
+	}
+
+	config.Images = config.Images[:1]
+	if _, err := schema1FromSchema2(testManifestImageName, manifest, config); err == nil {
+		t.Fatal("Expected an error for a config that does not match the layers")
+	}
+
+	// Images pushed by other tools have no v1 images in their config
+	config = &imageConfig{
+		ImageConfig: registry.ImageConfig{
+			Architecture: "amd64",
+			OS:           "linux",
+			RootFS:       registry.RootFS{Type: "layers", DiffIDs: []string{"sha256:base", "sha256:top"}},
+		},
+		Config: &runconfig.Config{Cmd: []string{"sh"}},
+		History: []configHistory{
+			{CreatedBy: "ADD rootfs.tar /"},
+			{CreatedBy: "ENV FOO=bar", EmptyLayer: true},
+			{CreatedBy: "RUN make"},
+		},
+	}
+	if m, err = schema1FromSchema2(testManifestImageName, manifest, config); err != nil {
+		t.Fatal(err)
+	}
+	top, err := image.NewImgJSON([]byte(m.History[0].V1Compatibility))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := image.NewImgJSON([]byte(m.History[1].V1Compatibility))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top.Parent != base.ID || base.Parent != "" || top.ID == base.ID {
+		t.Fatalf("Expected %s to be the parent of %s", base.ID, top.ID)
+	}
+	if err := utils.ValidateID(top.ID); err != nil {
+		t.Fatal(err)
+	}
+	if top.Config == nil || top.Config.Cmd[0] != "sh" || base.Config != nil {
+		t.Fatalf("Expected the config on the top image only, got %v and %v", top.Config, base.Config)
+	}
+	if top.ContainerConfig.Cmd[0] != "RUN make" || base.ContainerConfig.Cmd[0] != "ADD rootfs.tar /" {
+		t.Fatalf("Unexpected history %v %v", top.ContainerConfig.Cmd, base.ContainerConfig.Cmd)
+	}
+}
+
+func TestManifestRejected(t *testing.T) {
+	for err, rejected := range map[error]bool{
+		&utils.JSONError{Code: 400, Message: "manifest invalid"}: true,
+		&utils.JSONError{Code: 415, Message: "unsupported"}:      true,
+		&utils.JSONError{Code: 500, Message: "server error"}:     false,
+		errUnknownBlobSize:  true,
+		io.ErrUnexpectedEOF: false,
+	} {
+		if manifestRejected(err) != rejected {
+			t.Fatalf("Expected manifestRejected(%v) to be %v", err, rejected)
+		}
+	}
+}
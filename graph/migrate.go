@@ -0,0 +1,134 @@
+package graph
+
+import (
+	"io/ioutil"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/common"
+)
+
+// Migrate moves images created before image IDs were content-addressable
+// to their content-addressable IDs. Each image is registered again under
+// its new ID and the old ID is kept as a legacy ID of the new image.
+//
+// The layers of the images in inUse and of their parents are left in place,
+// since containers are stacked on them: those images keep their ID, and
+// only get their DiffID recorded. They are migrated on a later start once
+// no container uses them anymore.
+//
+// An interrupted migration resumes where it stopped: images that were
+// already registered under their new ID are not copied again, and their old
+// layers are removed by the next migration.
+func (graph *Graph) Migrate(inUse []string) error {
+	images, err := graph.Map()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Checking %d images for legacy IDs", len(images))
+
+	pinned := make(map[string]bool)
+	for _, id := range inUse {
+		for id != "" && !pinned[id] {
+			img, exists := images[id]
+			if !exists {
+				break
+			}
+			pinned[id] = true
+			id = img.Parent
+		}
+	}
+
+	var (
+		visited  = make(map[string]bool)
+		migrated []string
+		migrate  func(img *image.Image) error
+	)
+	start := time.Now()
+	migrate = func(img *image.Image) error {
+		if visited[img.ID] {
+			return nil
+		}
+		visited[img.ID] = true
+		// Parents are migrated first so that children can point at their new ID.
+		if parent, exists := images[img.Parent]; exists {
+			if err := migrate(parent); err != nil {
+				return err
+			}
+		}
+		id, err := graph.migrateImage(img, pinned[img.ID])
+		if err != nil {
+			return err
+		}
+		if id != img.ID {
+			migrated = append(migrated, img.ID)
+			log.Infof("Migrated image %s to %s (%d/%d images checked)", common.TruncateID(img.ID), common.TruncateID(id), len(visited), len(images))
+		}
+		return nil
+	}
+	for _, img := range images {
+		if err := migrate(img); err != nil {
+			return err
+		}
+	}
+
+	// The old layers are only removed once everything is migrated, children
+	// first, since the layers of their children may be stacked on them.
+	for i := len(migrated) - 1; i >= 0; i-- {
+		if err := graph.Delete(migrated[i]); err != nil {
+			return err
+		}
+	}
+	if len(migrated) > 0 {
+		log.Infof("Migrated %d images to content-addressable IDs in %s", len(migrated), time.Since(start))
+	}
+	return nil
+}
+
+// migrateImage registers img under its content-addressable ID and returns
+// that ID. If keep is true, only the DiffID of img is recorded.
+func (graph *Graph) migrateImage(img *image.Image, keep bool) (string, error) {
+	parent := img.Parent
+	if id, exists := graph.lookupLegacyID(img.Parent); exists {
+		parent = id
+	}
+	if img.DiffID != "" && parent == img.Parent {
+		if id, err := img.ComputeID(); err == nil && id == img.ID {
+			return img.ID, nil
+		}
+	}
+	if keep {
+		if img.DiffID != "" {
+			return img.ID, nil
+		}
+		layer, err := img.TarLayer()
+		if err != nil {
+			return "", err
+		}
+		defer layer.Close()
+		if img.DiffID, err = digestLayer(ioutil.Discard, layer); err != nil {
+			return "", err
+		}
+		return img.ID, image.StoreImage(img, nil, graph.ImageRoot(img.ID))
+	}
+	// Registered by a migration that was interrupted before removing the
+	// old layer
+	if id, exists := graph.lookupLegacyID(img.ID); exists {
+		if _, err := graph.idIndex.Get(id); err == nil {
+			return id, nil
+		}
+	}
+
+	layer, err := img.TarLayer()
+	if err != nil {
+		return "", err
+	}
+	defer layer.Close()
+	newImg := *img
+	newImg.Parent = parent
+	if err := graph.register(&newImg, layer, img.ID); err != nil {
+		return "", err
+	}
+	return newImg.ID, nil
+}
@@ -0,0 +1,266 @@
+package graph
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/common"
+	"github.com/docker/docker/utils"
+)
+
+// registerLegacyImage stores img under its own ID, the way images were
+// stored before IDs were content-addressable.
+func registerLegacyImage(graph *Graph, img *image.Image, layer io.Reader) error {
+	if err := graph.driver.Create(img.ID, img.Parent); err != nil {
+		return err
+	}
+	root := graph.ImageRoot(img.ID)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return err
+	}
+	img.SetGraph(graph)
+	if err := image.StoreImage(img, layer, root); err != nil {
+		return err
+	}
+	return graph.idIndex.Add(img.ID)
+}
+
+func TestRegisterContentAddressable(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	img, err := graph.Get(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.ValidateID(img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(img.DiffID, "sha256:") {
+		t.Fatalf("Expected a sha256 DiffID, got %q", img.DiffID)
+	}
+	if id, err := img.ComputeID(); err != nil || id != img.ID {
+		t.Fatalf("Expected ID %s to match the configuration, got %s (%v)", img.ID, id, err)
+	}
+
+	// The same content registered under another ID is the same image.
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyID := common.GenerateRandomID()
+	same := &image.Image{ID: legacyID}
+	if err := graph.Register(same, layer); err != nil {
+		t.Fatal(err)
+	}
+	if same.ID != img.ID {
+		t.Fatalf("Expected ID %s, got %s", img.ID, same.ID)
+	}
+	if resolved, err := graph.Get(common.TruncateID(legacyID)); err != nil {
+		t.Fatal(err)
+	} else if resolved.ID != img.ID {
+		t.Fatalf("Expected legacy ID to resolve to %s, got %s", img.ID, resolved.ID)
+	}
+
+	// A different configuration is a different image.
+	layer, err = fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &image.Image{Comment: "other"}
+	if err := graph.Register(other, layer); err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == img.ID || other.DiffID != img.DiffID {
+		t.Fatalf("Expected a new ID and the same DiffID, got %s and %s", other.ID, other.DiffID)
+	}
+
+	if err := graph.Delete(img.ID); err != nil {
+		t.Fatal(err)
+	}
+	if graph.Exists(legacyID) {
+		t.Fatal("Legacy ID should not resolve after the image is deleted")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	var legacy []*image.Image
+	parent := ""
+	for _, comment := range []string{"base", "child", "in use"} {
+		layer, err := fakeTar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := &image.Image{ID: common.GenerateRandomID(), Parent: parent, Comment: comment}
+		if err := registerLegacyImage(graph, img, layer); err != nil {
+			t.Fatal(err)
+		}
+		legacy = append(legacy, img)
+		parent = img.ID
+	}
+	base, child, inUse := legacy[0], legacy[1], legacy[2]
+	if err := store.Set("legacy", "", child.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first migration keeps the images a container is based on.
+	if err := store.MigrateImageIDs([]string{inUse.ID}); err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range legacy {
+		migrated, err := graph.Get(img.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migrated.ID != img.ID {
+			t.Fatalf("Expected %s to keep its ID, got %s", img.ID, migrated.ID)
+		}
+		if migrated.DiffID == "" {
+			t.Fatalf("Expected a DiffID to be recorded for %s", img.ID)
+		}
+	}
+
+	if err := store.MigrateImageIDs(nil); err != nil {
+		t.Fatal(err)
+	}
+	var migrated []*image.Image
+	for _, img := range legacy {
+		if _, err := graph.idIndex.Get(img.ID); err == nil {
+			t.Fatalf("Expected %s to be migrated", img.ID)
+		}
+		m, err := graph.Get(img.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := m.ComputeID(); err != nil || id != m.ID {
+			t.Fatalf("Expected %s to be content-addressable", m.ID)
+		}
+		if m.Comment != img.Comment {
+			t.Fatalf("Expected comment %q, got %q", img.Comment, m.Comment)
+		}
+		migrated = append(migrated, m)
+	}
+	if migrated[0].Parent != "" || migrated[1].Parent != migrated[0].ID || migrated[2].Parent != migrated[1].ID {
+		t.Fatalf("Parents were not migrated: %s <- %s <- %s", base.ID, child.ID, inUse.ID)
+	}
+	if img, err := store.GetImage("legacy", DEFAULTTAG); err != nil {
+		t.Fatal(err)
+	} else if repo, _ := store.Get("legacy"); repo[DEFAULTTAG] != migrated[1].ID || img.ID != migrated[1].ID {
+		t.Fatalf("Expected the tag to point to %s, got %s", migrated[1].ID, repo[DEFAULTTAG])
+	}
+}
+
+// noRenameDriver hides the Rename method of the driver it wraps.
+type noRenameDriver struct {
+	graphdriver.Driver
+}
+
+func TestRegisterWithoutRename(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	img, err := graph.Get(testOfficialImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := graph.driver.(graphdriver.Renamer); !ok {
+		t.Fatalf("Expected %s to rename layers", graph.driver)
+	}
+	// Layers written under a temporary ID are moved to the ID of the image
+	if dirs, err := ioutil.ReadDir(filepath.Join(tmp, "vfs", "dir")); err != nil {
+		t.Fatal(err)
+	} else {
+		for _, dir := range dirs {
+			if strings.HasPrefix(dir.Name(), "tmp-") {
+				t.Fatalf("Temporary layer %s was left behind", dir.Name())
+			}
+		}
+	}
+
+	// Drivers that cannot rename layers get the same IDs
+	graph.driver = noRenameDriver{graph.driver}
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &image.Image{Comment: "other"}
+	if err := graph.Register(other, layer); err != nil {
+		t.Fatal(err)
+	}
+	if other.DiffID != img.DiffID {
+		t.Fatalf("Expected DiffID %s, got %s", img.DiffID, other.DiffID)
+	}
+	if id, err := other.ComputeID(); err != nil || id != other.ID {
+		t.Fatalf("Expected ID %s to match the configuration, got %s (%v)", other.ID, id, err)
+	}
+}
+
+func TestMigrateResume(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	graph := store.graph
+
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := &image.Image{ID: common.GenerateRandomID(), Comment: "legacy"}
+	if err := registerLegacyImage(graph, img, layer); err != nil {
+		t.Fatal(err)
+	}
+	// A migration that stopped before removing the old layer
+	newID, err := graph.migrateImage(img, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := graph.Get(newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old layer is not read again
+	if err := graph.driver.Remove(img.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := graph.Migrate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.idIndex.Get(img.ID); err == nil {
+		t.Fatalf("Expected the old layer of %s to be removed", img.ID)
+	}
+	if resolved, err := graph.Get(img.ID); err != nil || resolved.ID != migrated.ID {
+		t.Fatalf("Expected %s to resolve to %s, got %v (%v)", img.ID, migrated.ID, resolved, err)
+	}
+}
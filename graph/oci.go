@@ -0,0 +1,282 @@
+package graph
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/utils"
+)
+
+// The OCI image layout stores content-addressed blobs under blobs/sha256,
+// and an index.json pointing to the manifest of each image. A manifest points
+// to the config and the layers of the image, and the ref name annotation of
+// the index entry holds the NAME:TAG reference of the image.
+const (
+	ociLayoutFile    = "oci-layout"
+	ociLayoutVersion = "1.0.0"
+	ociIndexFile     = "index.json"
+	ociBlobsDir      = "blobs"
+
+	ociMediaTypeIndex     = "application/vnd.oci.image.index.v1+json"
+	ociMediaTypeManifest  = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeConfig    = "application/vnd.oci.image.config.v1+json"
+	ociMediaTypeLayer     = "application/vnd.oci.image.layer.v1.tar"
+	ociMediaTypeLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociDescriptor struct {
+	registry.Descriptor
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociWriter writes images in the OCI layout to a directory.
+type ociWriter struct {
+	s        *TagStore
+	root     string
+	compress bool
+	level    int
+	// layers are the blobs written for the layer of each image.
+	layers map[string]ociLayer
+	// manifests are the manifests of the top images, in export order.
+	manifests map[string]registry.Descriptor
+	ids       []string
+}
+
+type ociLayer struct {
+	blob   registry.Descriptor
+	diffID string
+}
+
+func newOCIWriter(s *TagStore, root string, compress bool, level int) (*ociWriter, error) {
+	if err := os.MkdirAll(path.Join(root, ociBlobsDir, "sha256"), 0755); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(ociLayout{ImageLayoutVersion: ociLayoutVersion})
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path.Join(root, ociLayoutFile), b, 0644); err != nil {
+		return nil, err
+	}
+	return &ociWriter{
+		s:         s,
+		root:      root,
+		compress:  compress,
+		level:     level,
+		layers:    make(map[string]ociLayer),
+		manifests: make(map[string]registry.Descriptor),
+	}, nil
+}
+
+// writeBlob stores the content of r as a blob. If compress is set, the blob
+// is gzipped at the level of w. It returns the blob's descriptor and the
+// digest of the uncompressed content.
+func (w *ociWriter) writeBlob(mediaType string, r io.Reader, compress bool) (registry.Descriptor, string, error) {
+	var desc registry.Descriptor
+	f, err := ioutil.TempFile(path.Join(w.root, ociBlobsDir), "tmp-")
+	if err != nil {
+		return desc, "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var (
+		h                 = sha256.New()
+		counter           = &countingWriter{w: io.MultiWriter(f, h)}
+		content hash.Hash = h
+		dst     io.Writer = counter
+		gz      *archive.ParallelGzipWriter
+	)
+	if compress {
+		content = sha256.New()
+		if gz, err = archive.NewParallelGzipWriter(counter, w.level); err != nil {
+			return desc, "", err
+		}
+		dst = io.MultiWriter(gz, content)
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		return desc, "", err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return desc, "", err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return desc, "", err
+	}
+
+	hex := fmt.Sprintf("%x", h.Sum(nil))
+	if err := os.Rename(f.Name(), path.Join(w.root, ociBlobsDir, "sha256", hex)); err != nil {
+		return desc, "", err
+	}
+	desc = registry.Descriptor{
+		MediaType: mediaType,
+		Size:      counter.n,
+		Digest:    "sha256:" + hex,
+	}
+	return desc, fmt.Sprintf("sha256:%x", content.Sum(nil)), nil
+}
+
+// writeJSONBlob stores v as a JSON blob.
+func (w *ociWriter) writeJSONBlob(mediaType string, v interface{}) (registry.Descriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return registry.Descriptor{}, err
+	}
+	desc, _, err := w.writeBlob(mediaType, bytes.NewReader(b), false)
+	return desc, err
+}
+
+// writeLayer stores the layer of img, once per image.
+func (w *ociWriter) writeLayer(img *image.Image) (ociLayer, error) {
+	if layer, exists := w.layers[img.ID]; exists {
+		return layer, nil
+	}
+	arch, err := img.TarLayer()
+	if err != nil {
+		return ociLayer{}, err
+	}
+	defer arch.Close()
+	mediaType := ociMediaTypeLayer
+	if w.compress {
+		mediaType = ociMediaTypeLayerGzip
+	}
+	blob, diffID, err := w.writeBlob(mediaType, arch, w.compress)
+	if err != nil {
+		return ociLayer{}, err
+	}
+	layer := ociLayer{blob: blob, diffID: diffID}
+	w.layers[img.ID] = layer
+	return layer, nil
+}
+
+// addImage writes the layers, config and manifest of the image id.
+func (w *ociWriter) addImage(id string) error {
+	img, err := w.s.graph.Get(id)
+	if err != nil {
+		return err
+	}
+	if _, exists := w.manifests[img.ID]; exists {
+		return nil
+	}
+	history, err := img.History()
+	if err != nil {
+		return err
+	}
+
+	config := newImageConfig(img)
+	manifest := &registry.Schema2Manifest{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeManifest,
+	}
+	// The history goes from the top image to the base one
+	for i := len(history) - 1; i >= 0; i-- {
+		layer, err := w.writeLayer(history[i])
+		if err != nil {
+			return err
+		}
+		imgJSON, err := history[i].RawJson()
+		if err != nil {
+			return err
+		}
+		if imgJSON, err = setDiffID(imgJSON, layer.diffID); err != nil {
+			return err
+		}
+		config.addLayer(history[i], layer.diffID, imgJSON)
+		manifest.Layers = append(manifest.Layers, layer.blob)
+	}
+
+	if manifest.Config, err = w.writeJSONBlob(ociMediaTypeConfig, config); err != nil {
+		return err
+	}
+	desc, err := w.writeJSONBlob(ociMediaTypeManifest, manifest)
+	if err != nil {
+		return err
+	}
+	w.manifests[img.ID] = desc
+	w.ids = append(w.ids, img.ID)
+	return nil
+}
+
+// writeIndex writes index.json with an entry per reference in repositories,
+// and one for each image exported by ID only.
+func (w *ociWriter) writeIndex(repositories map[string]Repository) error {
+	index := ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeIndex,
+		Manifests:     []ociDescriptor{},
+	}
+	var refs []string
+	for name, repo := range repositories {
+		for tag := range repo {
+			refs = append(refs, utils.ImageReference(name, tag))
+		}
+	}
+	sort.Strings(refs)
+
+	referenced := make(map[string]bool)
+	for _, ref := range refs {
+		name, tag := parsers.ParseRepositoryTag(ref)
+		img, err := w.s.graph.Get(repositories[name][tag])
+		if err != nil {
+			return err
+		}
+		desc, exists := w.manifests[img.ID]
+		if !exists {
+			return fmt.Errorf("No manifest written for %s", ref)
+		}
+		referenced[img.ID] = true
+		index.Manifests = append(index.Manifests, ociDescriptor{
+			Descriptor:  desc,
+			Annotations: map[string]string{ociRefNameAnnotation: ref},
+		})
+	}
+	for _, id := range w.ids {
+		if !referenced[id] {
+			index.Manifests = append(index.Manifests, ociDescriptor{Descriptor: w.manifests[id]})
+		}
+	}
+
+	b, err := json.MarshalIndent(index, "", "   ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(w.root, ociIndexFile), b, 0644)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
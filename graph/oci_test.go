@@ -0,0 +1,167 @@
+// +build linux
+
+package graph
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/utils"
+)
+
+const testOCIChildID = "0c7ed6c9ad3b1b1d2a4e8e8de94e5f8a8e4f3b2c1d0e9f8a7b6c5d4e3f2a1b0c"
+
+func mkEmptyTagStore(root string, t *testing.T) *TagStore {
+	driver, err := graphdriver.New(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := NewGraph(root, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewTagStore(path.Join(root, "tags"), graph, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestOCILayoutRoundTrip(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := mkTestTagStore(path.Join(tmp, "src"), t)
+	defer src.graph.driver.Cleanup()
+
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &image.Image{ID: testOCIChildID, Parent: testOfficialImageID, OS: "linux", Config: &runconfig.Config{Cmd: []string{"true"}}}
+	if err := src.graph.Register(child, layer); err != nil {
+		t.Fatal(err)
+	}
+
+	layoutDir := path.Join(tmp, "layout")
+	w, err := newOCIWriter(src, layoutDir, true, gzip.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.addImage(testOCIChildID); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeIndex(map[string]Repository{"child": {"latest": testOCIChildID}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{ociLayoutFile, ociIndexFile} {
+		if _, err := os.Stat(path.Join(layoutDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two gzipped layers, a config and a manifest
+	blobs, err := ioutil.ReadDir(path.Join(layoutDir, ociBlobsDir, "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 4 {
+		t.Fatalf("Expected 4 blobs, got %d", len(blobs))
+	}
+
+	tarStream, err := archive.Tar(layoutDir, archive.Uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, err := ioutil.ReadAll(tarStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := mkEmptyTagStore(path.Join(tmp, "dst"), t)
+	defer dst.graph.driver.Cleanup()
+	l := newTestImageLoader(dst, layout)
+	defer l.cleanup()
+	repositories, err := l.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The images can still be referred to by their IDs
+	loaded, err := dst.graph.Get(child.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := repositories["child"]["latest"]; id != loaded.ID {
+		t.Fatalf("Expected child:latest to be %s, got %s", loaded.ID, id)
+	}
+	parent, err := dst.graph.Get(child.Parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Parent != parent.ID || loaded.Config == nil || len(loaded.Config.Cmd) != 1 {
+		t.Fatalf("Unexpected image loaded: %+v", loaded)
+	}
+}
+
+func TestOCIImagesWithoutDockerConfig(t *testing.T) {
+	manifest := &registry.Schema2Manifest{
+		Layers: []registry.Descriptor{{Digest: "sha256:base"}, {Digest: "sha256:top"}},
+	}
+	config := &imageConfig{
+		ImageConfig: registry.ImageConfig{
+			OS:     "linux",
+			RootFS: registry.RootFS{Type: "layers", DiffIDs: []string{"sha256:basediff", "sha256:topdiff"}},
+		},
+		Config: &runconfig.Config{Cmd: []string{"sh"}},
+	}
+	images, err := configImages(manifest, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("Expected 2 images, got %d", len(images))
+	}
+	for _, img := range images {
+		if err := utils.ValidateID(img.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if images[0].Parent != "" || images[1].Parent != images[0].ID {
+		t.Fatalf("Expected %s to be the parent of %s", images[0].ID, images[1].ID)
+	}
+	if images[1].DiffID != "sha256:topdiff" || images[1].Config == nil || images[0].Config != nil {
+		t.Fatalf("Unexpected top image: %+v", images[1])
+	}
+
+	// The IDs only depend on the layers and the config
+	again, err := configImages(manifest, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[1].ID != images[1].ID {
+		t.Fatalf("Expected the same IDs, got %s and %s", images[1].ID, again[1].ID)
+	}
+	manifest.Config.Digest = "sha256:otherconfig"
+	other, err := configImages(manifest, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other[0].ID != images[0].ID || other[1].ID == images[1].ID {
+		t.Fatalf("Expected another ID for the top image only, got %s and %s", other[0].ID, other[1].ID)
+	}
+
+	config.RootFS.DiffIDs = config.RootFS.DiffIDs[:1]
+	if _, err := configImages(manifest, config); err == nil {
+		t.Fatal("Expected an error for missing layer digests")
+	}
+}
@@ -3,23 +3,40 @@ package graph
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net"
 	"net/url"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/common"
-	"github.com/docker/docker/pkg/progressreader"
-	"github.com/docker/docker/pkg/tarsum"
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/utils"
 )
 
+// Pull policies of new containers, for images which may already be in the
+// graph.
+const (
+	// PullAlways pulls the image when the registry has a newer one.
+	PullAlways = "always"
+	// PullMissing only pulls images which are not in the graph.
+	PullMissing = "missing"
+	// PullNever never pulls the image.
+	PullNever = "never"
+)
+
+// ValidatePullPolicy checks that policy is a valid pull policy.
+func ValidatePullPolicy(policy string) error {
+	switch policy {
+	case PullAlways, PullMissing, PullNever:
+		return nil
+	}
+	return fmt.Errorf("Invalid pull policy: %q, must be %s, %s or %s", policy, PullAlways, PullMissing, PullNever)
+}
+
 func (s *TagStore) CmdPull(job *engine.Job) engine.Status {
 	if n := len(job.Args); n != 1 && n != 2 {
 		return job.Errorf("Usage: %s IMAGE [TAG|DIGEST]", job.Name)
@@ -58,72 +75,128 @@ func (s *TagStore) CmdPull(job *engine.Job) engine.Status {
 	}
 	defer s.poolRemove("pull", utils.ImageReference(repoInfo.LocalName, tag))
 
-	log.Debugf("pulling image from host %q with remote name %q", repoInfo.Index.Name, repoInfo.RemoteName)
-	endpoint, err := repoInfo.GetEndpoint()
-	if err != nil {
-		return job.Error(err)
+	logName := repoInfo.LocalName
+	if tag != "" {
+		logName = utils.ImageReference(logName, tag)
 	}
 
-	r, err := registry.NewSession(authConfig, registry.HTTPRequestFactory(metaHeaders), endpoint, true)
-	if err != nil {
-		return job.Error(err)
+	// Registries other than the official index are mirrored as a whole: the
+	// mirrors are tried in order, then the registry itself.
+	mirror, pulled := "", false
+	if !repoInfo.Index.Official {
+		for _, m := range repoInfo.Index.Mirrors {
+			log.Debugf("pulling image with remote name %q from mirror %s", repoInfo.RemoteName, m)
+			if err := s.pullFromMirror(job, m, repoInfo, tag, sf, metaHeaders); err != nil {
+				// Don't report errors when pulling from mirrors.
+				log.Debugf("Error pulling %s from mirror %s: %s", logName, m, err)
+				continue
+			}
+			mirror, pulled = m, true
+			break
+		}
 	}
 
-	logName := repoInfo.LocalName
-	if tag != "" {
-		logName = utils.ImageReference(logName, tag)
+	if !pulled {
+		log.Debugf("pulling image from host %q with remote name %q", repoInfo.Index.Name, repoInfo.RemoteName)
+		endpoint, err := repoInfo.GetEndpoint()
+		if err != nil {
+			return job.Error(err)
+		}
+
+		r, err := registry.NewSession(authConfig, registry.HTTPRequestFactory(metaHeaders), endpoint, true)
+		if err != nil {
+			return job.Error(err)
+		}
+
+		if mirror, err = s.pull(job.Eng, r, endpoint, job.Stdout, repoInfo, tag, sf, job.GetenvBool("parallel")); err != nil {
+			return job.Error(err)
+		}
+	}
+	s.recordPull(logName, repoInfo.Index.Name, mirror)
+
+	if err = job.Eng.Job("log", "pull", logName, "").Run(); err != nil {
+		log.Errorf("Error logging event 'pull' for %s: %s", logName, err)
+	}
+
+	return engine.StatusOK
+}
+
+// pullFromMirror pulls a repository from a mirror of its registry. The mirror
+// is accessed anonymously, with the scheme of its URL and its own TLS
+// settings.
+func (s *TagStore) pullFromMirror(job *engine.Job, mirror string, repoInfo *registry.RepositoryInfo, tag string, sf *utils.StreamFormatter, metaHeaders map[string][]string) error {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return err
+	}
+	index, err := registry.ResolveIndexInfo(job, u.Host)
+	if err != nil {
+		return err
+	}
+	endpoint, err := registry.NewMirrorEndpoint(index, u.Scheme)
+	if err != nil {
+		return err
 	}
+	r, err := registry.NewSession(&registry.AuthConfig{}, registry.HTTPRequestFactory(metaHeaders), endpoint, true)
+	if err != nil {
+		return err
+	}
+
+	// The images are still named after the registry they come from
+	mirrorInfo := *repoInfo
+	mirrorInfo.Index = index
+	_, err = s.pull(job.Eng, r, endpoint, job.Stdout, &mirrorInfo, tag, sf, job.GetenvBool("parallel"))
+	return err
+}
 
-	if len(repoInfo.Index.Mirrors) == 0 && ((repoInfo.Official && repoInfo.Index.Official) || endpoint.Version == registry.APIVersion2) {
+// pull pulls a repository from endpoint, with the v2 protocol if possible.
+// It returns the mirror of the official index the layers were pulled from,
+// if any.
+func (s *TagStore) pull(eng *engine.Engine, r *registry.Session, endpoint *registry.Endpoint, out io.Writer, repoInfo *registry.RepositoryInfo, tag string, sf *utils.StreamFormatter, parallel bool) (string, error) {
+	if (repoInfo.Official && repoInfo.Index.Official && len(repoInfo.Index.Mirrors) == 0) || endpoint.Version == registry.APIVersion2 {
 		if repoInfo.Official {
-			j := job.Eng.Job("trust_update_base")
-			if err = j.Run(); err != nil {
+			j := eng.Job("trust_update_base")
+			if err := j.Run(); err != nil {
 				log.Errorf("error updating trust base graph: %s", err)
 			}
 		}
 
 		log.Debugf("pulling v2 repository with local name %q", repoInfo.LocalName)
-		if err := s.pullV2Repository(job.Eng, r, job.Stdout, repoInfo, tag, sf, job.GetenvBool("parallel")); err == nil {
-			if err = job.Eng.Job("log", "pull", logName, "").Run(); err != nil {
-				log.Errorf("Error logging event 'pull' for %s: %s", logName, err)
-			}
-			return engine.StatusOK
+		if err := s.pullV2Repository(eng, r, out, repoInfo, tag, sf, parallel); err == nil {
+			return "", nil
+		} else if s.RequireSigned() {
+			// Only v2 manifests are signed
+			return "", err
 		} else if err != registry.ErrDoesNotExist && err != ErrV2RegistryUnavailable {
 			log.Errorf("Error from V2 registry: %s", err)
 		}
 
 		log.Debug("image does not exist on v2 registry, falling back to v1")
+	} else if s.RequireSigned() {
+		return "", fmt.Errorf("Cannot pull %s: only signed images are allowed, and %s is not a v2 registry", repoInfo.CanonicalName, repoInfo.Index.Name)
 	}
 
 	log.Debugf("pulling v1 repository with local name %q", repoInfo.LocalName)
-	if err = s.pullRepository(r, job.Stdout, repoInfo, tag, sf, job.GetenvBool("parallel")); err != nil {
-		return job.Error(err)
-	}
-
-	if err = job.Eng.Job("log", "pull", logName, "").Run(); err != nil {
-		log.Errorf("Error logging event 'pull' for %s: %s", logName, err)
-	}
-
-	return engine.StatusOK
+	return s.pullRepository(r, out, repoInfo, tag, sf, parallel)
 }
 
-func (s *TagStore) pullRepository(r *registry.Session, out io.Writer, repoInfo *registry.RepositoryInfo, askedTag string, sf *utils.StreamFormatter, parallel bool) error {
+func (s *TagStore) pullRepository(r *registry.Session, out io.Writer, repoInfo *registry.RepositoryInfo, askedTag string, sf *utils.StreamFormatter, parallel bool) (string, error) {
 	out.Write(sf.FormatStatus("", "Pulling repository %s", repoInfo.CanonicalName))
 
 	repoData, err := r.GetRepositoryData(repoInfo.RemoteName)
 	if err != nil {
 		if strings.Contains(err.Error(), "HTTP code: 404") {
-			return fmt.Errorf("Error: image %s not found", utils.ImageReference(repoInfo.RemoteName, askedTag))
+			return "", fmt.Errorf("Error: image %s not found", utils.ImageReference(repoInfo.RemoteName, askedTag))
 		}
 		// Unexpected HTTP error
-		return err
+		return "", err
 	}
 
 	log.Debugf("Retrieving the tag list")
 	tagsList, err := r.GetRemoteTags(repoData.Endpoints, repoInfo.RemoteName, repoData.Tokens)
 	if err != nil {
 		log.Errorf("unable to get remote tags: %s", err)
-		return err
+		return "", err
 	}
 
 	for tag, id := range tagsList {
@@ -144,13 +217,23 @@ func (s *TagStore) pullRepository(r *registry.Session, out io.Writer, repoInfo *
 		// Otherwise, check that the tag exists and use only that one
 		id, exists := tagsList[askedTag]
 		if !exists {
-			return fmt.Errorf("Tag %s not found in repository %s", askedTag, repoInfo.CanonicalName)
+			return "", fmt.Errorf("Tag %s not found in repository %s", askedTag, repoInfo.CanonicalName)
 		}
 		repoData.ImgList[id].Tag = askedTag
 	}
 
 	errors := make(chan error)
 
+	// Only mirrors of the official index serve layers
+	var (
+		mirrors  []string
+		mirror   string
+		mirrorMu sync.Mutex
+	)
+	if repoInfo.Index.Official {
+		mirrors = repoInfo.Index.Mirrors
+	}
+
 	layers_downloaded := false
 	for _, image := range repoData.ImgList {
 		downloadImage := func(img *registry.ImgData) {
@@ -189,13 +272,18 @@ func (s *TagStore) pullRepository(r *registry.Session, out io.Writer, repoInfo *
 			success := false
 			var lastErr, err error
 			var is_downloaded bool
-			for _, ep := range repoInfo.Index.Mirrors {
+			for _, ep := range mirrors {
 				out.Write(sf.FormatProgress(common.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s, mirror: %s", img.Tag, repoInfo.CanonicalName, ep), nil))
 				if is_downloaded, err = s.pullImage(r, out, img.ID, ep, repoData.Tokens, sf); err != nil {
 					// Don't report errors when pulling from mirrors.
 					log.Debugf("Error pulling image (%s) from %s, mirror: %s, %s", img.Tag, repoInfo.CanonicalName, ep, err)
 					continue
 				}
+				mirrorMu.Lock()
+				if mirror == "" {
+					mirror = ep
+				}
+				mirrorMu.Unlock()
 				layers_downloaded = layers_downloaded || is_downloaded
 				success = true
 				break
@@ -244,7 +332,7 @@ func (s *TagStore) pullRepository(r *registry.Session, out io.Writer, repoInfo *
 			}
 		}
 		if lastError != nil {
-			return lastError
+			return "", lastError
 		}
 
 	}
@@ -253,7 +341,7 @@ func (s *TagStore) pullRepository(r *registry.Session, out io.Writer, repoInfo *
 			continue
 		}
 		if err := s.Set(repoInfo.LocalName, tag, id, true); err != nil {
-			return err
+			return "", err
 		}
 	}
 
@@ -262,7 +350,7 @@ func (s *TagStore) pullRepository(r *registry.Session, out io.Writer, repoInfo *
 		requestedTag = utils.ImageReference(repoInfo.CanonicalName, askedTag)
 	}
 	WriteStatus(requestedTag, out, sf, layers_downloaded)
-	return nil
+	return mirror, nil
 }
 
 func (s *TagStore) pullImage(r *registry.Session, out io.Writer, imgID, endpoint string, token []string, sf *utils.StreamFormatter) (bool, error) {
@@ -277,92 +365,89 @@ func (s *TagStore) pullImage(r *registry.Session, out io.Writer, imgID, endpoint
 	layers_downloaded := false
 	for i := len(history) - 1; i >= 0; i-- {
 		id := history[i]
-
-		// ensure no two downloads of the same layer happen at the same time
-		if c, err := s.poolAdd("pull", "layer:"+id); err != nil {
-			log.Debugf("Image (id: %s) pull is already running, skipping: %v", id, err)
-			<-c
-		}
-		defer s.poolRemove("pull", "layer:"+id)
-
 		if !s.graph.Exists(id) {
-			out.Write(sf.FormatProgress(common.TruncateID(id), "Pulling metadata", nil))
-			var (
-				imgJSON []byte
-				imgSize int
-				err     error
-				img     *image.Image
-			)
-			retries := 5
-			for j := 1; j <= retries; j++ {
-				imgJSON, imgSize, err = r.GetRemoteImageJSON(id, endpoint, token)
-				if err != nil && j == retries {
-					out.Write(sf.FormatProgress(common.TruncateID(id), "Error pulling dependent layers", nil))
-					return layers_downloaded, err
-				} else if err != nil {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				}
-				img, err = image.NewImgJSON(imgJSON)
-				layers_downloaded = true
-				if err != nil && j == retries {
-					out.Write(sf.FormatProgress(common.TruncateID(id), "Error pulling dependent layers", nil))
-					return layers_downloaded, fmt.Errorf("Failed to parse json: %s", err)
-				} else if err != nil {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				} else {
-					break
-				}
-			}
-
-			for j := 1; j <= retries; j++ {
-				// Get the layer
-				status := "Pulling fs layer"
-				if j > 1 {
-					status = fmt.Sprintf("Pulling fs layer [retries: %d]", j)
-				}
-				out.Write(sf.FormatProgress(common.TruncateID(id), status, nil))
-				layer, err := r.GetRemoteImageLayer(img.ID, endpoint, token, int64(imgSize))
-				if uerr, ok := err.(*url.Error); ok {
-					err = uerr.Err
-				}
-				if terr, ok := err.(net.Error); ok && terr.Timeout() && j < retries {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				} else if err != nil {
-					out.Write(sf.FormatProgress(common.TruncateID(id), "Error pulling dependent layers", nil))
-					return layers_downloaded, err
-				}
-				layers_downloaded = true
-				defer layer.Close()
-
-				err = s.graph.Register(img,
-					progressreader.New(progressreader.Config{
-						In:        layer,
-						Out:       out,
-						Formatter: sf,
-						Size:      imgSize,
-						NewLines:  false,
-						ID:        common.TruncateID(id),
-						Action:    "Downloading",
-					}))
-				if terr, ok := err.(net.Error); ok && terr.Timeout() && j < retries {
-					time.Sleep(time.Duration(j) * 500 * time.Millisecond)
-					continue
-				} else if err != nil {
-					out.Write(sf.FormatProgress(common.TruncateID(id), "Error downloading dependent layers", nil))
-					return layers_downloaded, err
-				} else {
-					break
-				}
+			// Pulls of images that share this layer share its download
+			w := s.downloads.transfer(id, common.TruncateID(id), s.pullV1Layer(r, id, endpoint, token), out, sf)
+			_, err := w.wait()
+			w.detach()
+			if err != nil {
+				return layers_downloaded, err
 			}
+			layers_downloaded = true
 		}
 		out.Write(sf.FormatProgress(common.TruncateID(id), "Download complete", nil))
 	}
 	return layers_downloaded, nil
 }
 
+// pullV1Layer returns a transfer that downloads the layer of image id from a
+// v1 registry and registers it. The parent of the image must already be
+// registered.
+func (s *TagStore) pullV1Layer(r *registry.Session, id, endpoint string, token []string) transferFunc {
+	return func(t *transfer) (interface{}, error) {
+		t.Progress("Pulling metadata")
+		var (
+			imgSize int
+			err     error
+			img     *image.Image
+		)
+		retries := 5
+		for j := 1; j <= retries; j++ {
+			var imgJSON []byte
+			imgJSON, imgSize, err = r.GetRemoteImageJSON(id, endpoint, token)
+			if err != nil && j == retries {
+				t.Progress("Error pulling dependent layers")
+				return nil, err
+			} else if err != nil {
+				time.Sleep(time.Duration(j) * 500 * time.Millisecond)
+				continue
+			}
+			img, err = image.NewImgJSON(imgJSON)
+			if err != nil && j == retries {
+				t.Progress("Error pulling dependent layers")
+				return nil, fmt.Errorf("Failed to parse json: %s", err)
+			} else if err != nil {
+				time.Sleep(time.Duration(j) * 500 * time.Millisecond)
+				continue
+			} else {
+				break
+			}
+		}
+
+		t.Progress("Pulling fs layer")
+		dl, err := s.graph.openDownload("v1-" + id)
+		if err != nil {
+			return nil, err
+		}
+		if dl.size > 0 {
+			t.Progress("Resuming download")
+		}
+		_, err = dl.fetch(t.fetcher(func(offset int64) (io.ReadCloser, int64, int64, error) {
+			layer, start, err := r.GetRemoteImageLayerAt(img.ID, endpoint, token, offset)
+			return layer, start, int64(imgSize), err
+		}), nil, func(body io.ReadCloser, offset, size int64) io.Reader {
+			return t.reader(body, "Downloading", offset, size)
+		}, retries)
+		if err != nil {
+			dl.Close()
+			t.Progress("Error pulling dependent layers")
+			return nil, err
+		}
+		t.releaseSlot()
+
+		layer, err := dl.reader()
+		if err == nil {
+			err = s.graph.Register(img, layer)
+		}
+		dl.remove()
+		if err != nil {
+			t.Progress("Error downloading dependent layers")
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
 func WriteStatus(requestedTag string, out io.Writer, sf *utils.StreamFormatter, layers_downloaded bool) {
 	if layers_downloaded {
 		out.Write(sf.FormatStatus("", "Status: Downloaded newer image for %s", requestedTag))
@@ -373,12 +458,85 @@ func WriteStatus(requestedTag string, out io.Writer, sf *utils.StreamFormatter,
 
 // downloadInfo is used to pass information from download to extractor
 type downloadInfo struct {
-	imgJSON    []byte
-	img        *image.Image
-	tmpFile    *os.File
-	length     int64
-	downloaded bool
-	err        chan error
+	img     *image.Image
+	watcher *transferWatcher
+}
+
+// pullV2Layer returns a transfer that downloads the layer of img from a v2
+// registry and registers it once parent, if any, is registered. The result
+// of the transfer tells whether the TarSum of the layer matches.
+func (s *TagStore) pullV2Layer(r *registry.Session, endpoint *registry.Endpoint, repoInfo *registry.RepositoryInfo, img *image.Image, sumType, checksum string, auth *registry.RequestAuthorization, parent *transfer) transferFunc {
+	sumStr := sumType + ":" + checksum
+	return func(t *transfer) (interface{}, error) {
+		log.Debugf("pulling blob %q to V1 img %s", sumStr, img.ID)
+		dl, err := s.graph.openDownload("v2-" + img.ID)
+		if err != nil {
+			return nil, err
+		}
+		if dl.size > 0 {
+			t.Progress("Resuming download")
+		}
+
+		// The TarSum of the layer is computed as it is downloaded.
+		finalChecksum, err := dl.fetch(t.fetcher(func(offset int64) (io.ReadCloser, int64, int64, error) {
+			return r.GetV2ImageBlobReaderAt(endpoint, repoInfo.RemoteName, sumType, checksum, offset, auth)
+		}), func() layerVerifier {
+			return newLayerVerifier(sumType)
+		}, func(body io.ReadCloser, offset, size int64) io.Reader {
+			return t.reader(body, "Downloading", offset, size)
+		}, 5)
+		if err != nil {
+			dl.Close()
+			return nil, fmt.Errorf("unable to download v2 image blob data: %s", err)
+		}
+		defer dl.remove()
+		t.releaseSlot()
+
+		t.Progress("Verifying Checksum")
+		layerVerified := true
+		if !strings.EqualFold(finalChecksum, sumStr) {
+			log.Infof("Image verification failed: checksum mismatch - expected %q but got %q", sumStr, finalChecksum)
+			layerVerified = false
+		}
+		t.Progress("Download complete")
+		log.Debugf("Downloaded %s to %s", img.ID, dl.Name())
+
+		if parent != nil {
+			if _, err := t.waitFor(parent); err != nil {
+				return nil, err
+			}
+		}
+		layer, err := dl.reader()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.graph.Register(img, t.reader(layer, "Extracting", 0, dl.size)); err != nil {
+			return nil, err
+		}
+		if layerVerified {
+			// Pushes of the layer can then look it up by the digest of
+			// its blob, as a gzipped blob. Layers pulled by their tarsum
+			// are read once more to compute it.
+			compression, err := dl.compression()
+			if err != nil {
+				return nil, err
+			}
+			if compression == archive.Gzip {
+				checksum := sumStr
+				if sumType != "sha256" {
+					if checksum, err = dl.digest(); err != nil {
+						return nil, err
+					}
+					s.recordBlobSource(checksum, repoInfo)
+				}
+				if err := img.SaveCheckSum(s.graph.ImageRoot(img.ID), checksum); err != nil {
+					return nil, err
+				}
+			}
+		}
+		t.Progress("Pull complete")
+		return layerVerified, nil
+	}
 }
 
 func (s *TagStore) pullV2Repository(eng *engine.Engine, r *registry.Session, out io.Writer, repoInfo *registry.RepositoryInfo, tag string, sf *utils.StreamFormatter, parallel bool) error {
@@ -434,22 +592,72 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 		return false, err
 	}
 
-	manifest, verified, err := s.loadManifest(eng, manifestBytes)
-	if err != nil {
-		return false, fmt.Errorf("error verifying manifest: %s", err)
+	// Pick the manifest of the host's platform from manifest lists
+	var platform string
+	if list := parseManifestList(manifestBytes); list != nil {
+		m, err := selectManifest(list, hostPlatform())
+		if err != nil {
+			return false, err
+		}
+		platform = m.Platform.String()
+		log.Debugf("Pulling manifest %s for %s from the manifest list", m.Digest, platform)
+		var manifestDigest string
+		manifestBytes, manifestDigest, err = r.GetV2ImageManifest(endpoint, repoInfo.RemoteName, m.Digest, auth)
+		if err != nil {
+			return false, err
+		}
+		if manifestDigest != "" && manifestDigest != m.Digest {
+			return false, fmt.Errorf("manifest digest mismatch: expected %s, got %s", m.Digest, manifestDigest)
+		}
+		if parseManifestList(manifestBytes) != nil {
+			return false, fmt.Errorf("manifest list %s points to another manifest list", utils.ImageReference(repoInfo.CanonicalName, tag))
+		}
 	}
 
-	if err := checkValidManifest(manifest); err != nil {
-		return false, err
+	var (
+		manifest *registry.ManifestData
+		trust    = &ImageTrust{Namespace: trustNamespace(repoInfo.RemoteName)}
+		name     = utils.ImageReference(repoInfo.CanonicalName, tag)
+	)
+	if m := parseSchema2Manifest(manifestBytes); m != nil {
+		// Schema 2 manifests are not signed, the layers are only checked
+		// against their digests.
+		config, err := fetchImageConfig(r, endpoint, repoInfo.RemoteName, m, auth)
+		if err != nil {
+			return false, err
+		}
+		if manifest, err = schema1FromSchema2(repoInfo.RemoteName, m, config); err != nil {
+			return false, err
+		}
+	} else {
+		if manifest, trust, err = s.loadManifest(eng, manifestBytes, repoInfo.RemoteName, tag); err != nil {
+			return false, fmt.Errorf("error verifying manifest: %s", err)
+		}
+		if err := checkValidManifest(manifest); err != nil {
+			return false, err
+		}
 	}
 
+	verified := trust.Verified
 	if verified {
-		log.Printf("Image manifest for %s has been verified", utils.ImageReference(repoInfo.CanonicalName, tag))
+		log.Printf("Image manifest for %s has been verified", name)
+	} else if s.RequireSigned() {
+		logTrustEvent(eng, "untrusted", name)
+		return false, ErrUntrusted{Name: name, Namespace: trust.Namespace}
 	}
 	out.Write(sf.FormatStatus(tag, "Pulling from %s", repoInfo.CanonicalName))
 
 	downloads := make([]downloadInfo, len(manifest.FSLayers))
+	defer func() {
+		// Stop waiting for the layers still needed if the pull failed
+		for _, d := range downloads {
+			if d.watcher != nil {
+				d.watcher.detach()
+			}
+		}
+	}()
 
+	var parent *transfer
 	for i := len(manifest.FSLayers) - 1; i >= 0; i-- {
 		var (
 			sumStr  = manifest.FSLayers[i].BlobSum
@@ -465,6 +673,8 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 		// Check if exists
 		if s.graph.Exists(img.ID) {
 			log.Debugf("Image already exists: %s", img.ID)
+			out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Already exists", nil))
+			parent = nil
 			continue
 		}
 
@@ -472,78 +682,14 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 		if len(chunks) < 2 {
 			return false, fmt.Errorf("expected 2 parts in the sumStr, got %#v", chunks)
 		}
-		sumType, checksum := chunks[0], chunks[1]
 		out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Pulling fs layer", nil))
 
-		downloadFunc := func(di *downloadInfo) error {
-			log.Debugf("pulling blob %q to V1 img %s", sumStr, img.ID)
-
-			if c, err := s.poolAdd("pull", "img:"+img.ID); err != nil {
-				if c != nil {
-					out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Layer already being pulled by another client. Waiting.", nil))
-					<-c
-					out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Download complete", nil))
-				} else {
-					log.Debugf("Image (id: %s) pull is already running, skipping: %v", img.ID, err)
-				}
-			} else {
-				defer s.poolRemove("pull", "img:"+img.ID)
-				tmpFile, err := ioutil.TempFile("", "GetV2ImageBlob")
-				if err != nil {
-					return err
-				}
-
-				r, l, err := r.GetV2ImageBlobReader(endpoint, repoInfo.RemoteName, sumType, checksum, auth)
-				if err != nil {
-					return err
-				}
-				defer r.Close()
-
-				// Wrap the reader with the appropriate TarSum reader.
-				tarSumReader, err := tarsum.NewTarSumForLabel(r, true, sumType)
-				if err != nil {
-					return fmt.Errorf("unable to wrap image blob reader with TarSum: %s", err)
-				}
-
-				if _, err := io.Copy(tmpFile, progressreader.New(progressreader.Config{
-					In:        ioutil.NopCloser(tarSumReader),
-					Out:       out,
-					Formatter: sf,
-					Size:      int(l),
-					NewLines:  false,
-					ID:        common.TruncateID(img.ID),
-					Action:    "Downloading",
-				})); err != nil {
-					return fmt.Errorf("unable to copy v2 image blob data: %s", err)
-				}
-
-				out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Verifying Checksum", nil))
-
-				if finalChecksum := tarSumReader.Sum(nil); !strings.EqualFold(finalChecksum, sumStr) {
-					log.Infof("Image verification failed: checksum mismatch - expected %q but got %q", sumStr, finalChecksum)
-					verified = false
-				}
-
-				out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Download complete", nil))
-
-				log.Debugf("Downloaded %s to tempfile %s", img.ID, tmpFile.Name())
-				di.tmpFile = tmpFile
-				di.length = l
-				di.downloaded = true
-			}
-			di.imgJSON = imgJSON
-
-			return nil
-		}
-
-		if parallel {
-			downloads[i].err = make(chan error)
-			go func(di *downloadInfo) {
-				di.err <- downloadFunc(di)
-			}(&downloads[i])
-		} else {
-			err := downloadFunc(&downloads[i])
-			if err != nil {
+		// Pulls of images that share this layer share its download
+		w := s.downloads.transfer(img.ID, common.TruncateID(img.ID), s.pullV2Layer(r, endpoint, repoInfo, img, chunks[0], chunks[1], auth, parent), out, sf)
+		downloads[i].watcher = w
+		parent = w.t
+		if !parallel {
+			if _, err := w.wait(); err != nil {
 				return false, err
 			}
 		}
@@ -552,39 +698,21 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 	var tagUpdated bool
 	for i := len(downloads) - 1; i >= 0; i-- {
 		d := &downloads[i]
-		if d.err != nil {
-			err := <-d.err
-			if err != nil {
-				return false, err
-			}
+		if d.watcher == nil {
+			continue
 		}
-		if d.downloaded {
-			// if tmpFile is empty assume download and extracted elsewhere
-			defer os.Remove(d.tmpFile.Name())
-			defer d.tmpFile.Close()
-			d.tmpFile.Seek(0, 0)
-			if d.tmpFile != nil {
-				err = s.graph.Register(d.img,
-					progressreader.New(progressreader.Config{
-						In:        d.tmpFile,
-						Out:       out,
-						Formatter: sf,
-						Size:      int(d.length),
-						ID:        common.TruncateID(d.img.ID),
-						Action:    "Extracting",
-					}))
-				if err != nil {
-					return false, err
-				}
-
-				// FIXME: Pool release here for parallel tag pull (ensures any downloads block until fully extracted)
-			}
-			out.Write(sf.FormatProgress(common.TruncateID(d.img.ID), "Pull complete", nil))
-			tagUpdated = true
-		} else {
-			out.Write(sf.FormatProgress(common.TruncateID(d.img.ID), "Already exists", nil))
+		layerVerified, err := d.watcher.wait()
+		if err != nil {
+			return false, err
+		}
+		if !layerVerified.(bool) {
+			verified = false
 		}
+		tagUpdated = true
+	}
 
+	for _, layer := range manifest.FSLayers {
+		s.recordBlobSource(layer.BlobSum, repoInfo)
 	}
 
 	// Check for new tag if no layers downloaded
@@ -600,14 +728,39 @@ func (s *TagStore) pullV2Tag(eng *engine.Engine, r *registry.Session, out io.Wri
 		}
 	}
 
+	// The signatures only cover the layers if they match their blob sums
+	if trust.Verified && !verified {
+		trust = &ImageTrust{Namespace: trust.Namespace}
+	}
+	if err := s.saveImageTrust(downloads[0].img.ID, trust); err != nil {
+		return false, err
+	}
+	if trust.Verified {
+		logTrustEvent(eng, "verified", name)
+	} else if s.RequireSigned() {
+		logTrustEvent(eng, "untrusted", name)
+		return false, fmt.Errorf("The layers of %s do not match its signed manifest", name)
+	}
+
 	if verified && tagUpdated {
-		out.Write(sf.FormatStatus(utils.ImageReference(repoInfo.CanonicalName, tag), "The image you are pulling has been verified. Important: image verification is a tech preview feature and should not be relied on to provide security."))
+		out.Write(sf.FormatStatus(name, "The image you are pulling has been verified. Important: image verification is a tech preview feature and should not be relied on to provide security."))
 	}
 
 	if len(digest) > 0 {
 		out.Write(sf.FormatStatus("", "Digest: %s", digest))
 	}
 
+	if platform != "" {
+		// The image may already exist under its legacy ID
+		img, err := s.graph.Get(downloads[0].img.ID)
+		if err != nil {
+			return false, err
+		}
+		if err := img.SavePlatform(s.graph.ImageRoot(img.ID), platform); err != nil {
+			return false, err
+		}
+	}
+
 	if utils.DigestReference(tag) {
 		if err = s.SetDigest(repoInfo.LocalName, tag, downloads[0].img.ID); err != nil {
 			return false, err
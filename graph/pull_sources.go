@@ -0,0 +1,43 @@
+package graph
+
+import "time"
+
+// maxPullSources is the number of recent pulls whose source is kept.
+const maxPullSources = 10
+
+// PullSource records where a pull was served from.
+type PullSource struct {
+	Name     string
+	Registry string
+	// Mirror is the mirror that served the pull, or empty if the registry
+	// itself did.
+	Mirror string
+	Time   time.Time
+}
+
+// recordPull records that name was pulled from registry, through mirror.
+func (s *TagStore) recordPull(name, registry, mirror string) {
+	s.Lock()
+	defer s.Unlock()
+	s.pullSources = append(s.pullSources, PullSource{
+		Name:     name,
+		Registry: registry,
+		Mirror:   mirror,
+		Time:     time.Now().UTC(),
+	})
+	if len(s.pullSources) > maxPullSources {
+		s.pullSources = s.pullSources[len(s.pullSources)-maxPullSources:]
+	}
+}
+
+// PullSources returns where the recent pulls were served from, most recent
+// first.
+func (s *TagStore) PullSources() []PullSource {
+	s.Lock()
+	defer s.Unlock()
+	sources := make([]PullSource, 0, len(s.pullSources))
+	for i := len(s.pullSources) - 1; i >= 0; i-- {
+		sources = append(sources, s.pullSources[i])
+	}
+	return sources
+}
@@ -0,0 +1,25 @@
+package graph
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPullSources(t *testing.T) {
+	store := &TagStore{}
+	for i := 0; i < maxPullSources+2; i++ {
+		store.recordPull(fmt.Sprintf("app:%d", i), "registry.corp", "https://mirror")
+	}
+	store.recordPull("app:latest", "registry.corp", "")
+
+	sources := store.PullSources()
+	if len(sources) != maxPullSources {
+		t.Fatalf("Expected %d pull sources, got %d", maxPullSources, len(sources))
+	}
+	if sources[0].Name != "app:latest" || sources[0].Mirror != "" {
+		t.Fatalf("Expected the last pull first, got %+v", sources[0])
+	}
+	if sources[1].Name != fmt.Sprintf("app:%d", maxPullSources+1) || sources[1].Mirror != "https://mirror" {
+		t.Fatalf("Expected the pulls in reverse order, got %+v", sources[1])
+	}
+}
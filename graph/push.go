@@ -2,6 +2,7 @@ package graph
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,9 +16,9 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/engine"
 	"github.com/docker/docker/image"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/common"
 	"github.com/docker/docker/pkg/progressreader"
-	"github.com/docker/docker/pkg/tarsum"
 	"github.com/docker/docker/registry"
 	"github.com/docker/docker/runconfig"
 	"github.com/docker/docker/utils"
@@ -26,6 +27,10 @@ import (
 
 var ErrV2RegistryUnavailable = errors.New("error v2 registry unavailable")
 
+// errUnknownBlobSize is returned when a schema 2 manifest cannot be made
+// because the registry does not tell the size of a layer.
+var errUnknownBlobSize = errors.New("unknown blob size")
+
 // Retrieve the all the images to be uploaded in the correct order
 func (s *TagStore) getImageList(localRepo map[string]string, requestedTag string) ([]string, map[string][]string, error) {
 	var (
@@ -292,7 +297,9 @@ func (s *TagStore) pushImage(r *registry.Session, out io.Writer, imgID, ep strin
 	return imgData.Checksum, nil
 }
 
-func (s *TagStore) pushV2Repository(r *registry.Session, localRepo Repository, out io.Writer, repoInfo *registry.RepositoryInfo, tag string, sf *utils.StreamFormatter) error {
+// pushV2Repository pushes the tags of localRepo, and the layers the
+// registry does not have, compressed with compression at level.
+func (s *TagStore) pushV2Repository(r *registry.Session, localRepo Repository, out io.Writer, repoInfo *registry.RepositoryInfo, tag string, compression archive.Compression, level int, sf *utils.StreamFormatter) error {
 	endpoint, err := r.V2RegistryEndpoint(repoInfo.Index)
 	if err != nil {
 		if repoInfo.Index.Official {
@@ -316,188 +323,399 @@ func (s *TagStore) pushV2Repository(r *registry.Session, localRepo Repository, o
 	}
 
 	for _, tag := range tags {
-		log.Debugf("Pushing repository: %s:%s", repoInfo.CanonicalName, tag)
-
-		layerId, exists := localRepo[tag]
-		if !exists {
-			return fmt.Errorf("tag does not exist: %s", tag)
+		if err := s.pushV2Tag(r, endpoint, localRepo, out, repoInfo, tag, compression, level, sf, auth); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		layer, err := s.graph.Get(layerId)
+// pushV2Tag pushes the layers of the image of tag that the registry does not
+// have yet, and then its manifest.
+func (s *TagStore) pushV2Tag(r *registry.Session, endpoint *registry.Endpoint, localRepo Repository, out io.Writer, repoInfo *registry.RepositoryInfo, tag string, compression archive.Compression, level int, sf *utils.StreamFormatter, auth *registry.RequestAuthorization) error {
+	log.Debugf("Pushing repository: %s:%s", repoInfo.CanonicalName, tag)
+
+	layerId, exists := localRepo[tag]
+	if !exists {
+		return fmt.Errorf("tag does not exist: %s", tag)
+	}
+
+	layer, err := s.graph.Get(layerId)
+	if err != nil {
+		return err
+	}
+
+	m := &registry.ManifestData{
+		SchemaVersion: 1,
+		Name:          repoInfo.RemoteName,
+		Tag:           tag,
+		Architecture:  layer.Architecture,
+	}
+	var metadata runconfig.Config
+	if layer.Config != nil {
+		metadata = *layer.Config
+	}
+
+	layersSeen := make(map[string]bool)
+	layers := []*image.Image{layer}
+	for ; layer != nil; layer, err = layer.GetParent() {
 		if err != nil {
 			return err
 		}
 
-		m := &registry.ManifestData{
-			SchemaVersion: 1,
-			Name:          repoInfo.RemoteName,
-			Tag:           tag,
-			Architecture:  layer.Architecture,
+		if layersSeen[layer.ID] {
+			break
 		}
-		var metadata runconfig.Config
-		if layer.Config != nil {
-			metadata = *layer.Config
+		layers = append(layers, layer)
+		layersSeen[layer.ID] = true
+	}
+	m.FSLayers = make([]*registry.FSLayer, len(layers))
+	m.History = make([]*registry.ManifestHistory, len(layers))
+
+	sizes := make([]int64, len(layers))
+	mediaTypes := make([]string, len(layers))
+	uploads := make(map[int]*transferWatcher)
+	defer func() {
+		// Stop waiting for the layers still needed if the push failed
+		for _, w := range uploads {
+			w.detach()
 		}
+	}()
+
+	// Schema version 1 requires layer ordering from top to root
+	for i, layer := range layers {
+		log.Debugf("Pushing layer: %s", layer.ID)
 
-		layersSeen := make(map[string]bool)
-		layers := []*image.Image{layer}
-		for ; layer != nil; layer, err = layer.GetParent() {
+		if layer.Config != nil && metadata.Image != layer.ID {
+			err = runconfig.Merge(&metadata, layer.Config)
 			if err != nil {
 				return err
 			}
-
-			if layersSeen[layer.ID] {
-				break
-			}
-			layers = append(layers, layer)
-			layersSeen[layer.ID] = true
 		}
-		m.FSLayers = make([]*registry.FSLayer, len(layers))
-		m.History = make([]*registry.ManifestHistory, len(layers))
+		jsonData, err := layer.RawJson()
+		if err != nil {
+			return fmt.Errorf("cannot retrieve the path for %s: %s", layer.ID, err)
+		}
 
-		// Schema version 1 requires layer ordering from top to root
-		for i, layer := range layers {
-			log.Debugf("Pushing layer: %s", layer.ID)
+		checksum, err := layer.GetCheckSum(s.graph.ImageRoot(layer.ID))
+		if err != nil {
+			return fmt.Errorf("error getting image checksum: %s", err)
+		}
+		// Schema 2 manifests refer to layers by the sha256 digest of
+		// their blob. The sha256 checksums cached by pushes and pulls
+		// are of gzipped blobs. Layers only cached with their tarsum,
+		// by older versions, are compressed again and pushV2Image looks
+		// the new blob up by its digest before uploading it.
+		if strings.HasPrefix(checksum, "tarsum") {
+			checksum = ""
+		}
+		mediaTypes[i] = registry.MediaTypeLayerGzip
 
-			if layer.Config != nil && metadata.Image != layer.ID {
-				err = runconfig.Merge(&metadata, layer.Config)
-				if err != nil {
-					return err
-				}
-			}
-			jsonData, err := layer.RawJson()
-			if err != nil {
-				return fmt.Errorf("cannot retrieve the path for %s: %s", layer.ID, err)
+		var exists bool
+		if len(checksum) > 0 {
+			sumParts := strings.SplitN(checksum, ":", 2)
+			if len(sumParts) < 2 {
+				return fmt.Errorf("Invalid checksum: %s", checksum)
 			}
 
-			checksum, err := layer.GetCheckSum(s.graph.ImageRoot(layer.ID))
+			// Call mount blob
+			exists, sizes[i], err = r.StatV2ImageBlob(endpoint, repoInfo.RemoteName, sumParts[0], sumParts[1], auth)
 			if err != nil {
-				return fmt.Errorf("error getting image checksum: %s", err)
-			}
-
-			var exists bool
-			if len(checksum) > 0 {
-				sumParts := strings.SplitN(checksum, ":", 2)
-				if len(sumParts) < 2 {
-					return fmt.Errorf("Invalid checksum: %s", checksum)
-				}
-
-				// Call mount blob
-				exists, err = r.HeadV2ImageBlob(endpoint, repoInfo.RemoteName, sumParts[0], sumParts[1], auth)
-				if err != nil {
-					out.Write(sf.FormatProgress(common.TruncateID(layer.ID), "Image push failed", nil))
-					return err
-				}
+				out.Write(sf.FormatProgress(common.TruncateID(layer.ID), "Image push failed", nil))
+				return err
 			}
-			if !exists {
-				if cs, err := s.pushV2Image(r, layer, endpoint, repoInfo.RemoteName, sf, out, auth); err != nil {
-					return err
-				} else if cs != checksum {
-					// Cache new checksum
-					if err := layer.SaveCheckSum(s.graph.ImageRoot(layer.ID), cs); err != nil {
-						return err
-					}
-					checksum = cs
-				}
-			} else {
+			if exists {
 				out.Write(sf.FormatProgress(common.TruncateID(layer.ID), "Image already exists", nil))
+			} else if from, size, mounted := s.mountV2Blob(r, endpoint, repoInfo, sumParts[0], sumParts[1], auth); mounted {
+				out.Write(sf.FormatProgress(common.TruncateID(layer.ID), "Mounted from "+from, nil))
+				exists, sizes[i] = true, size
 			}
-			m.FSLayers[i] = &registry.FSLayer{BlobSum: checksum}
-			m.History[i] = &registry.ManifestHistory{V1Compatibility: string(jsonData)}
 		}
-
-		if err := checkValidManifest(m); err != nil {
-			return fmt.Errorf("invalid manifest: %s", err)
+		if !exists {
+			// Pushes of images that share this layer to the same
+			// repository share its upload
+			key := endpoint.String() + repoInfo.RemoteName + "/" + layer.ID
+			uploads[i] = s.uploads.transfer(key, common.TruncateID(layer.ID), s.pushV2Image(r, layer, endpoint, repoInfo.RemoteName, compression, level, auth), out, sf)
+		} else {
+			s.recordBlobSource(checksum, repoInfo)
 		}
+		m.FSLayers[i] = &registry.FSLayer{BlobSum: checksum}
+		m.History[i] = &registry.ManifestHistory{V1Compatibility: string(jsonData)}
+	}
 
-		log.Debugf("Pushing %s:%s to v2 repository", repoInfo.LocalName, tag)
-		mBytes, err := json.MarshalIndent(m, "", "   ")
-		if err != nil {
-			return err
-		}
-		js, err := libtrust.NewJSONSignature(mBytes)
+	for i, w := range uploads {
+		result, err := w.wait()
 		if err != nil {
 			return err
 		}
+		blob := result.(pushedBlob)
+		m.FSLayers[i].BlobSum = blob.checksum
+		sizes[i] = blob.size
+		mediaTypes[i] = blob.mediaType
+		s.recordBlobSource(blob.checksum, repoInfo)
+	}
+
+	if err := checkValidManifest(m); err != nil {
+		return fmt.Errorf("invalid manifest: %s", err)
+	}
 
-		if err = js.Sign(s.trustKey); err != nil {
+	// Schema 2 manifests are preferred for their stable digests, schema 1
+	// is the fallback for registries that reject them.
+	log.Debugf("Pushing %s:%s to v2 repository", repoInfo.LocalName, tag)
+	digest, err := s.pushV2Schema2Manifest(r, endpoint, repoInfo.RemoteName, tag, layers, m, sizes, mediaTypes, auth)
+	if err != nil {
+		if !manifestRejected(err) {
 			return err
 		}
-
-		signedBody, err := js.PrettySignature("signatures")
-		if err != nil {
+		log.Debugf("Registry rejected the schema 2 manifest of %s:%s, falling back to schema 1: %s", repoInfo.LocalName, tag, err)
+		if digest, err = s.pushV2Schema1Manifest(r, endpoint, repoInfo, tag, m, auth); err != nil {
 			return err
 		}
-		log.Infof("Signed manifest for %s:%s using daemon's key: %s", repoInfo.LocalName, tag, s.trustKey.KeyID())
+	}
 
-		// push the manifest
-		digest, err := r.PutV2ImageManifest(endpoint, repoInfo.RemoteName, tag, bytes.NewReader(signedBody), auth)
+	if len(digest) > 0 {
+		out.Write(sf.FormatStatus("", "Digest: %s", digest))
+	}
+	return nil
+}
+
+// mountV2Blob tries to mount a blob that the repository does not have from
+// the other repositories of the registry that it was pulled from or pushed
+// to. It returns the repository the blob was mounted from and its size.
+func (s *TagStore) mountV2Blob(r *registry.Session, endpoint *registry.Endpoint, repoInfo *registry.RepositoryInfo, sumType, sum string, auth *registry.RequestAuthorization) (string, int64, bool) {
+	for _, from := range s.graph.blobRepositories(sumType+":"+sum, repoInfo.Index.Name) {
+		if from == repoInfo.RemoteName {
+			continue
+		}
+		// The other requests of the push do not need access to from
+		mountAuth := auth.WithScope("repository", from, []string{"pull"})
+		mounted, err := r.MountV2ImageBlob(endpoint, repoInfo.RemoteName, from, sumType, sum, mountAuth)
 		if err != nil {
-			return err
+			log.Debugf("Error mounting blob %s:%s from %s: %s", sumType, sum, from, err)
+			continue
 		}
-
-		if len(digest) > 0 {
-			out.Write(sf.FormatStatus("", "Digest: %s", digest))
+		if !mounted {
+			continue
+		}
+		// The size of the blob is needed for the manifest
+		exists, size, err := r.StatV2ImageBlob(endpoint, repoInfo.RemoteName, sumType, sum, auth)
+		if err != nil || !exists {
+			log.Debugf("Mounted blob %s:%s from %s is not available: %v", sumType, sum, from, err)
+			continue
 		}
+		return from, size, true
 	}
-	return nil
+	return "", 0, false
+}
+
+// recordBlobSource records that the repository has the blob, for later
+// pushes of the blob to other repositories of the registry to mount it.
+func (s *TagStore) recordBlobSource(checksum string, repoInfo *registry.RepositoryInfo) {
+	if err := s.graph.addBlobSource(checksum, repoInfo.Index.Name, repoInfo.RemoteName); err != nil {
+		log.Debugf("Error recording the source of blob %s: %s", checksum, err)
+	}
+}
+
+// manifestRejected tells whether a schema 2 manifest cannot be pushed to the
+// registry, as opposed to the registry failing to handle the request.
+func manifestRejected(err error) bool {
+	if err == errUnknownBlobSize {
+		return true
+	}
+	jerr, ok := err.(*utils.JSONError)
+	return ok && jerr.Code >= 400 && jerr.Code < 500
 }
 
-// PushV2Image pushes the image content to the v2 registry, first buffering the contents to disk
-func (s *TagStore) pushV2Image(r *registry.Session, img *image.Image, endpoint *registry.Endpoint, imageName string, sf *utils.StreamFormatter, out io.Writer, auth *registry.RequestAuthorization) (string, error) {
-	out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Buffering to Disk", nil))
+// pushV2Schema2Manifest pushes the config blob of the image, then a schema 2
+// manifest that refers to it and to the layers of m. layers, sizes and
+// mediaTypes are the images, blob sizes and blob media types of the layers
+// of m, from the top to the base.
+func (s *TagStore) pushV2Schema2Manifest(r *registry.Session, endpoint *registry.Endpoint, remoteName, tag string, layers []*image.Image, m *registry.ManifestData, sizes []int64, mediaTypes []string, auth *registry.RequestAuthorization) (string, error) {
+	config := newImageConfig(layers[0])
+	manifest := &registry.Schema2Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifest,
+	}
+	seen := make(map[string]bool)
+	for i := len(layers) - 1; i >= 0; i-- {
+		if seen[layers[i].ID] {
+			continue
+		}
+		seen[layers[i].ID] = true
+		if sizes[i] < 0 {
+			return "", errUnknownBlobSize
+		}
+		config.addLayer(layers[i], layers[i].DiffID, []byte(m.History[i].V1Compatibility))
+		manifest.Layers = append(manifest.Layers, registry.Descriptor{
+			MediaType: mediaTypes[i],
+			Size:      sizes[i],
+			Digest:    m.FSLayers[i].BlobSum,
+		})
+	}
 
-	image, err := s.graph.Get(img.ID)
+	configJSON, err := json.Marshal(config)
 	if err != nil {
 		return "", err
 	}
-	arch, err := image.TarLayer()
+	configSum := fmt.Sprintf("%x", sha256.Sum256(configJSON))
+	manifest.Config = registry.Descriptor{
+		MediaType: registry.MediaTypeImageConfig,
+		Size:      int64(len(configJSON)),
+		Digest:    "sha256:" + configSum,
+	}
+	exists, err := r.HeadV2ImageBlob(endpoint, remoteName, "sha256", configSum, auth)
 	if err != nil {
 		return "", err
 	}
-	defer arch.Close()
+	if !exists {
+		if err := r.PutV2ImageBlob(endpoint, remoteName, "sha256", configSum, bytes.NewReader(configJSON), auth); err != nil {
+			return "", err
+		}
+	}
 
-	tf, err := s.graph.newTempFile()
+	manifestJSON, err := json.MarshalIndent(manifest, "", "   ")
 	if err != nil {
 		return "", err
 	}
-	defer func() {
-		tf.Close()
-		os.Remove(tf.Name())
-	}()
+	return r.PutV2Schema2Manifest(endpoint, remoteName, tag, bytes.NewReader(manifestJSON), auth)
+}
 
-	ts, err := tarsum.NewTarSum(arch, true, tarsum.Version1)
+// pushV2Schema1Manifest signs m with the daemon's key and pushes it.
+func (s *TagStore) pushV2Schema1Manifest(r *registry.Session, endpoint *registry.Endpoint, repoInfo *registry.RepositoryInfo, tag string, m *registry.ManifestData, auth *registry.RequestAuthorization) (string, error) {
+	mBytes, err := json.MarshalIndent(m, "", "   ")
 	if err != nil {
 		return "", err
 	}
-	size, err := bufferToFile(tf, ts)
+	js, err := libtrust.NewJSONSignature(mBytes)
 	if err != nil {
 		return "", err
 	}
-	checksum := ts.Sum(nil)
-	sumParts := strings.SplitN(checksum, ":", 2)
-	if len(sumParts) < 2 {
-		return "", fmt.Errorf("Invalid checksum: %s", checksum)
-	}
 
-	// Send the layer
-	log.Debugf("rendered layer for %s of [%d] size", img.ID, size)
+	if err = js.Sign(s.trustKey); err != nil {
+		return "", err
+	}
 
-	if err := r.PutV2ImageBlob(endpoint, imageName, sumParts[0], sumParts[1],
-		progressreader.New(progressreader.Config{
-			In:        tf,
-			Out:       out,
-			Formatter: sf,
-			Size:      int(size),
-			NewLines:  false,
-			ID:        common.TruncateID(img.ID),
-			Action:    "Pushing",
-		}), auth); err != nil {
-		out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Image push failed", nil))
+	signedBody, err := js.PrettySignature("signatures")
+	if err != nil {
 		return "", err
 	}
-	out.Write(sf.FormatProgress(common.TruncateID(img.ID), "Image successfully pushed", nil))
-	return checksum, nil
+	log.Infof("Signed manifest for %s:%s using daemon's key: %s", repoInfo.LocalName, tag, s.trustKey.KeyID())
+
+	// push the manifest
+	return r.PutV2ImageManifest(endpoint, repoInfo.RemoteName, tag, bytes.NewReader(signedBody), auth)
+}
+
+// pushedBlob is the result of a layer upload.
+type pushedBlob struct {
+	checksum  string
+	size      int64
+	mediaType string
+}
+
+// pushV2Image returns a transfer that pushes the image content to the v2
+// registry, first buffering the contents to disk. Layers are pushed with the
+// sha256 digest of their blob, gzipped or not. The result of the transfer is
+// the pushedBlob of the layer.
+func (s *TagStore) pushV2Image(r *registry.Session, img *image.Image, endpoint *registry.Endpoint, imageName string, compression archive.Compression, level int, auth *registry.RequestAuthorization) transferFunc {
+	return func(t *transfer) (interface{}, error) {
+		t.Progress("Buffering to Disk")
+
+		image, err := s.graph.Get(img.ID)
+		if err != nil {
+			return nil, err
+		}
+		arch, err := image.TarLayer()
+		if err != nil {
+			return nil, err
+		}
+		defer arch.Close()
+
+		tf, err := s.graph.newTempFile()
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			tf.Close()
+			os.Remove(tf.Name())
+		}()
+
+		var (
+			checksum  string
+			size      int64
+			mediaType = registry.MediaTypeLayerGzip
+		)
+		if compression == archive.Gzip {
+			checksum, size, err = bufferGzipToFile(tf, arch, level)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			h := sha256.New()
+			if size, err = bufferToFile(tf, io.TeeReader(arch, h)); err != nil {
+				return nil, err
+			}
+			checksum = fmt.Sprintf("sha256:%x", h.Sum(nil))
+			mediaType = registry.MediaTypeLayer
+		}
+		sumParts := strings.SplitN(checksum, ":", 2)
+
+		// Send the layer
+		log.Debugf("rendered layer for %s of [%d] size", img.ID, size)
+
+		// Uncompressed blobs are not cached, the registry may have them
+		exists, _, err := r.StatV2ImageBlob(endpoint, imageName, sumParts[0], sumParts[1], auth)
+		if err != nil {
+			t.Progress("Image push failed")
+			return nil, err
+		}
+		if exists {
+			t.Progress("Image already exists")
+		} else {
+			if err := r.PutV2ImageBlob(endpoint, imageName, sumParts[0], sumParts[1],
+				t.reader(tf, "Pushing", 0, size), auth); err != nil {
+				t.Progress("Image push failed")
+				return nil, err
+			}
+			t.Progress("Image successfully pushed")
+		}
+
+		// Cache new checksum, only for gzipped blobs as the cached
+		// checksums are pushed as such
+		if mediaType == registry.MediaTypeLayerGzip {
+			if err := img.SaveCheckSum(s.graph.ImageRoot(img.ID), checksum); err != nil {
+				return nil, err
+			}
+		}
+		return pushedBlob{checksum: checksum, size: size, mediaType: mediaType}, nil
+	}
+}
+
+// bufferGzipToFile gzips the layer archive arch at level into f, and
+// returns the digest and size of the compressed layer.
+func bufferGzipToFile(f *os.File, arch io.Reader, level int) (string, int64, error) {
+	h := sha256.New()
+	gz, err := archive.NewParallelGzipWriter(io.MultiWriter(f, h), level)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := io.Copy(gz, arch); err != nil {
+		gz.Close()
+		return "", 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return "", 0, err
+	}
+	size, err := f.Seek(0, 1)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), size, nil
 }
 
 // FIXME: Allow to interrupt current push when new push of same image is done.
@@ -522,6 +740,17 @@ func (s *TagStore) CmdPush(job *engine.Job) engine.Status {
 	job.GetenvJson("authConfig", authConfig)
 	job.GetenvJson("metaHeaders", &metaHeaders)
 
+	// Layers are gzipped, unless they are pushed to a registry on the local
+	// network, where compressing them takes longer than sending them.
+	compression := archive.Gzip
+	if job.GetenvBool("uncompressed") {
+		compression = archive.Uncompressed
+	}
+	level, err := CompressionLevel(job)
+	if err != nil {
+		return job.Error(err)
+	}
+
 	if _, err := s.poolAdd("push", repoInfo.LocalName); err != nil {
 		return job.Error(err)
 	}
@@ -549,7 +778,7 @@ func (s *TagStore) CmdPush(job *engine.Job) engine.Status {
 	}
 
 	if endpoint.Version == registry.APIVersion2 {
-		err := s.pushV2Repository(r, localRepo, job.Stdout, repoInfo, tag, sf)
+		err := s.pushV2Repository(r, localRepo, job.Stdout, repoInfo, tag, compression, level, sf)
 		if err == nil {
 			return engine.StatusOK
 		}
@@ -0,0 +1,76 @@
+package graph
+
+import (
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/parsers"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/utils"
+)
+
+// CmdDeleteRemote deletes the manifest of an image from a v2 registry.
+// Registries only delete manifests by digest, so a tag is resolved to the
+// digest of its manifest first. Deleting the manifest removes every tag
+// referring to it, but leaves the local images alone.
+func (s *TagStore) CmdDeleteRemote(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s NAME[:TAG|@DIGEST]", job.Name)
+	}
+	var (
+		sf          = utils.NewStreamFormatter(job.GetenvBool("json"))
+		authConfig  = &registry.AuthConfig{}
+		metaHeaders map[string][]string
+	)
+
+	remote, ref := parsers.ParseRepositoryTag(job.Args[0])
+	if ref == "" {
+		ref = DEFAULTTAG
+	}
+	name := utils.ImageReference(remote, ref)
+	repoInfo, err := registry.ResolveRepositoryInfo(job, remote)
+	if err != nil {
+		return job.Error(err)
+	}
+
+	job.GetenvJson("authConfig", authConfig)
+	job.GetenvJson("metaHeaders", &metaHeaders)
+
+	endpoint, err := repoInfo.GetEndpoint()
+	if err != nil {
+		return job.Error(err)
+	}
+	r, err := registry.NewSession(authConfig, registry.HTTPRequestFactory(metaHeaders), endpoint, false)
+	if err != nil {
+		return job.Error(err)
+	}
+	endpoint, err = r.V2RegistryEndpoint(repoInfo.Index)
+	if err != nil {
+		return job.Errorf("Deleting from a registry requires a v2 registry: %s", err)
+	}
+	auth, err := r.GetV2DeleteAuthorization(endpoint, repoInfo.RemoteName)
+	if err != nil {
+		return job.Errorf("error getting authorization: %s", err)
+	}
+
+	digest := ref
+	if !utils.DigestReference(ref) {
+		_, digest, err = r.GetV2ImageManifest(endpoint, repoInfo.RemoteName, ref, auth)
+		if err == registry.ErrDoesNotExist {
+			return job.Errorf("No such manifest: %s", name)
+		} else if err != nil {
+			return job.Errorf("Error fetching the manifest of %s: %s", name, err)
+		}
+		if digest == "" {
+			return job.Errorf("The registry did not return the digest of %s", name)
+		}
+	}
+
+	if err := r.DeleteV2ImageManifest(endpoint, repoInfo.RemoteName, digest, auth); err != nil {
+		if err == registry.ErrDoesNotExist {
+			return job.Errorf("No such manifest: %s", name)
+		}
+		return job.Errorf("Error deleting %s from %s: %s", name, repoInfo.Index.Name, err)
+	}
+
+	job.Stdout.Write(sf.FormatStatus("", "Deleted: %s", utils.ImageReference(repoInfo.CanonicalName, digest)))
+	return engine.StatusOK
+}
@@ -24,6 +24,11 @@ func (s *TagStore) Install(eng *engine.Engine) error {
 		"import":         s.CmdImport,
 		"pull":           s.CmdPull,
 		"push":           s.CmdPush,
+		"manifest_push":  s.CmdManifestPush,
+		"remote_delete":  s.CmdDeleteRemote,
+		"image_diff":     s.CmdImageDiff,
+		"image_manifest": s.CmdImageManifest,
+		"push_signed":    s.CmdPushSigned,
 	} {
 		if err := eng.Register(name, handler); err != nil {
 			return fmt.Errorf("Could not register %q: %v", name, err)
@@ -149,6 +154,17 @@ func (s *TagStore) CmdLookup(job *engine.Job) engine.Status {
 		out.Set("Os", image.OS)
 		out.SetInt64("Size", image.Size)
 		out.SetInt64("VirtualSize", image.GetParentsSize(0)+image.Size)
+		out.Set("DiffId", image.DiffID)
+		if platform, err := image.GetPlatform(s.graph.ImageRoot(image.ID)); err != nil {
+			return job.Error(err)
+		} else if platform != "" {
+			out.Set("Platform", platform)
+		}
+		if trust, err := s.ImageTrust(image.ID); err != nil {
+			return job.Error(err)
+		} else if trust != nil {
+			out.SetJson("Trust", trust)
+		}
 		if _, err = out.WriteTo(job.Stdout); err != nil {
 			return job.Error(err)
 		}
@@ -0,0 +1,172 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/registry"
+	"github.com/docker/docker/utils"
+)
+
+// schema1Manifest builds the schema 1 manifest of img for tag, from its top
+// layer to the base one. The layers must have been pushed already, for their
+// blob sums to be known.
+func (s *TagStore) schema1Manifest(img *image.Image, remoteName, tag string) (*registry.ManifestData, error) {
+	m := &registry.ManifestData{
+		SchemaVersion: 1,
+		Name:          remoteName,
+		Tag:           tag,
+		Architecture:  img.Architecture,
+	}
+	err := img.WalkHistory(func(layer *image.Image) error {
+		checksum, err := layer.GetCheckSum(s.graph.ImageRoot(layer.ID))
+		if err != nil {
+			return fmt.Errorf("error getting image checksum: %s", err)
+		}
+		if checksum == "" {
+			return fmt.Errorf("The blob sum of layer %s is unknown, push the image first", layer.ID)
+		}
+		jsonData, err := layer.RawJson()
+		if err != nil {
+			return fmt.Errorf("cannot retrieve the path for %s: %s", layer.ID, err)
+		}
+		m.FSLayers = append(m.FSLayers, &registry.FSLayer{BlobSum: checksum})
+		m.History = append(m.History, &registry.ManifestHistory{V1Compatibility: string(jsonData)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// signingTarget resolves the image of a tag that is signed and pushed by
+// a client.
+func (s *TagStore) signingTarget(job *engine.Job) (*registry.RepositoryInfo, string, *image.Image, error) {
+	remote := job.Args[0]
+	tag := job.Getenv("tag")
+	if tag == "" {
+		tag = DEFAULTTAG
+	}
+	if utils.DigestReference(tag) {
+		return nil, "", nil, fmt.Errorf("Cannot sign a manifest by digest: %s", utils.ImageReference(remote, tag))
+	}
+	repoInfo, err := registry.ResolveRepositoryInfo(job, remote)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	img, err := s.GetImage(repoInfo.LocalName, tag)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if img == nil {
+		return nil, "", nil, fmt.Errorf("No such image: %s", utils.ImageReference(repoInfo.LocalName, tag))
+	}
+	return repoInfo, tag, img, nil
+}
+
+// CmdImageManifest outputs the unsigned schema 1 manifest of a pushed tag,
+// for a client to sign it with its own key.
+//
+// Syntax: image_manifest NAME
+// Environment: tag, the tag to describe, "latest" by default.
+func (s *TagStore) CmdImageManifest(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s NAME", job.Name)
+	}
+	repoInfo, tag, img, err := s.signingTarget(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	m, err := s.schema1Manifest(img, repoInfo.RemoteName, tag)
+	if err != nil {
+		return job.Error(err)
+	}
+	mBytes, err := json.MarshalIndent(m, "", "   ")
+	if err != nil {
+		return job.Error(err)
+	}
+	job.Stdout.Write(mBytes)
+	return engine.StatusOK
+}
+
+// CmdPushSigned pushes a manifest that a client signed with its own key, in
+// place of the one pushed with the daemon's key. The manifest must be the
+// one of the local tag, as output by CmdImageManifest.
+//
+// Syntax: push_signed NAME
+// Input: the signed manifest, on stdin.
+// Environment: tag, authConfig, metaHeaders and json, as for push.
+func (s *TagStore) CmdPushSigned(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s NAME", job.Name)
+	}
+	var (
+		sf          = utils.NewStreamFormatter(job.GetenvBool("json"))
+		authConfig  = &registry.AuthConfig{}
+		metaHeaders map[string][]string
+	)
+	repoInfo, tag, img, err := s.signingTarget(job)
+	if err != nil {
+		return job.Error(err)
+	}
+	name := utils.ImageReference(repoInfo.CanonicalName, tag)
+
+	signed, err := ioutil.ReadAll(job.Stdin)
+	if err != nil {
+		return job.Error(err)
+	}
+	m, trust, err := s.loadManifest(job.Eng, signed, repoInfo.RemoteName, tag)
+	if err != nil {
+		return job.Errorf("error verifying manifest: %s", err)
+	}
+	expected, err := s.schema1Manifest(img, repoInfo.RemoteName, tag)
+	if err != nil {
+		return job.Error(err)
+	}
+	if !reflect.DeepEqual(m, expected) {
+		return job.Errorf("The signed manifest is not the manifest of %s", name)
+	}
+
+	job.GetenvJson("authConfig", authConfig)
+	job.GetenvJson("metaHeaders", &metaHeaders)
+
+	endpoint, err := repoInfo.GetEndpoint()
+	if err != nil {
+		return job.Error(err)
+	}
+	r, err := registry.NewSession(authConfig, registry.HTTPRequestFactory(metaHeaders), endpoint, false)
+	if err != nil {
+		return job.Error(err)
+	}
+	endpoint, err = r.V2RegistryEndpoint(repoInfo.Index)
+	if err != nil {
+		return job.Errorf("Signed manifests require a v2 registry: %s", err)
+	}
+	auth, err := r.GetV2Authorization(endpoint, repoInfo.RemoteName, false)
+	if err != nil {
+		return job.Errorf("error getting authorization: %s", err)
+	}
+	digest, err := r.PutV2ImageManifest(endpoint, repoInfo.RemoteName, tag, bytes.NewReader(signed), auth)
+	if err != nil {
+		return job.Error(err)
+	}
+
+	if err := s.saveImageTrust(img.ID, trust); err != nil {
+		return job.Error(err)
+	}
+	if trust.Verified {
+		logTrustEvent(job.Eng, "verified", name)
+	} else {
+		job.Stdout.Write(sf.FormatStatus("", "Warning: %s is not signed by a key trusted for %s", name, trust.Namespace))
+	}
+	if len(digest) > 0 {
+		job.Stdout.Write(sf.FormatStatus("", "Digest: %s", digest))
+	}
+	return engine.StatusOK
+}
@@ -38,6 +38,11 @@ type TagStore struct {
 	// to a helper type
 	pullingPool map[string]chan struct{}
 	pushingPool map[string]chan struct{}
+	downloads   *transferManager
+	uploads     *transferManager
+	pullSources []PullSource
+	// requireSigned refuses images not signed by keys of the trust store
+	requireSigned bool
 }
 
 type Repository map[string]string
@@ -73,6 +78,8 @@ func NewTagStore(path string, graph *Graph, key libtrust.PrivateKey) (*TagStore,
 		Repositories: make(map[string]Repository),
 		pullingPool:  make(map[string]chan struct{}),
 		pushingPool:  make(map[string]chan struct{}),
+		downloads:    newTransferManager(defaultMaxConcurrentDownloads),
+		uploads:      newTransferManager(defaultMaxConcurrentUploads),
 	}
 	// Load the json file if it exists, otherwise create it.
 	if err := store.reload(); os.IsNotExist(err) {
@@ -108,6 +115,32 @@ func (store *TagStore) reload() error {
 	return nil
 }
 
+// MigrateImageIDs migrates the graph to content-addressable image IDs, see
+// Graph.Migrate, and updates the tags to point at the new IDs.
+func (store *TagStore) MigrateImageIDs(inUse []string) error {
+	if err := store.graph.Migrate(inUse); err != nil {
+		return err
+	}
+	store.Lock()
+	defer store.Unlock()
+	if err := store.reload(); err != nil {
+		return err
+	}
+	updated := false
+	for _, repo := range store.Repositories {
+		for ref, id := range repo {
+			if newID, exists := store.graph.lookupLegacyID(id); exists {
+				repo[ref] = newID
+				updated = true
+			}
+		}
+	}
+	if !updated {
+		return nil
+	}
+	return store.save()
+}
+
 func (store *TagStore) LookupImage(name string) (*image.Image, error) {
 	// FIXME: standardize on returning nil when the image doesn't exist, and err for everything else
 	// (so we can pass all errors here)
@@ -322,6 +355,15 @@ func (store *TagStore) GetImage(repoName, refOrID string) (*image.Image, error)
 		}
 	}
 
+	// The image may also be referred to by one of its legacy IDs
+	if id, err := store.graph.resolve(refOrID); err == nil {
+		for _, revision := range repo {
+			if revision == id {
+				return store.graph.Get(revision)
+			}
+		}
+	}
+
 	return nil, nil
 }
 
@@ -371,6 +413,13 @@ func validateDigest(dgst string) error {
 	return nil
 }
 
+// SetMaxConcurrentTransfers sets how many layers are downloaded and uploaded
+// at the same time, across all pulls and pushes. 0 means no limit.
+func (store *TagStore) SetMaxConcurrentTransfers(downloads, uploads int) {
+	store.downloads.setLimit(downloads)
+	store.uploads.setLimit(uploads)
+}
+
 func (store *TagStore) poolAdd(kind, key string) (chan struct{}, error) {
 	store.Lock()
 	defer store.Unlock()
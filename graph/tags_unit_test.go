@@ -100,6 +100,17 @@ func TestLookupImage(t *testing.T) {
 	store := mkTestTagStore(tmp, t)
 	defer store.graph.driver.Cleanup()
 
+	// The images are stored under their content-addressable IDs, the IDs
+	// they were registered with are legacy IDs.
+	officialID, exists := store.graph.lookupLegacyID(testOfficialImageID)
+	if !exists {
+		t.Fatalf("No legacy ID recorded for %s", testOfficialImageID)
+	}
+	privateID, exists := store.graph.lookupLegacyID(testPrivateImageID)
+	if !exists {
+		t.Fatalf("No legacy ID recorded for %s", testPrivateImageID)
+	}
+
 	officialLookups := []string{
 		testOfficialImageID,
 		testOfficialImageIDShort,
@@ -142,8 +153,8 @@ func TestLookupImage(t *testing.T) {
 			t.Errorf("Error looking up %s: %s", name, err)
 		} else if img == nil {
 			t.Errorf("Expected 1 image, none found: %s", name)
-		} else if img.ID != testOfficialImageID {
-			t.Errorf("Expected ID '%s' found '%s'", testOfficialImageID, img.ID)
+		} else if img.ID != officialID {
+			t.Errorf("Expected ID '%s' found '%s'", officialID, img.ID)
 		}
 	}
 
@@ -152,8 +163,8 @@ func TestLookupImage(t *testing.T) {
 			t.Errorf("Error looking up %s: %s", name, err)
 		} else if img == nil {
 			t.Errorf("Expected 1 image, none found: %s", name)
-		} else if img.ID != testPrivateImageID {
-			t.Errorf("Expected ID '%s' found '%s'", testPrivateImageID, img.ID)
+		} else if img.ID != privateID {
+			t.Errorf("Expected ID '%s' found '%s'", privateID, img.ID)
 		}
 	}
 
@@ -170,8 +181,8 @@ func TestLookupImage(t *testing.T) {
 			t.Errorf("Error looking up %s: %s", name, err)
 		} else if img == nil {
 			t.Errorf("Expected 1 image, none found: %s", name)
-		} else if img.ID != testPrivateImageID {
-			t.Errorf("Expected ID '%s' found '%s'", testPrivateImageID, img.ID)
+		} else if img.ID != privateID {
+			t.Errorf("Expected ID '%s' found '%s'", privateID, img.ID)
 		}
 	}
 }
@@ -0,0 +1,401 @@
+package graph
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/docker/docker/pkg/progressreader"
+	"github.com/docker/docker/utils"
+)
+
+const (
+	defaultMaxConcurrentDownloads = 3
+	defaultMaxConcurrentUploads   = 5
+)
+
+var (
+	// errTransferCancelled is returned by transfers that nobody waits for
+	// anymore.
+	errTransferCancelled = errors.New("transfer cancelled")
+	// errTransferDetached is returned to a client that stopped waiting for
+	// a transfer.
+	errTransferDetached = errors.New("stopped waiting for transfer")
+)
+
+// transferFunc does a transfer, reporting its progress through t. It
+// returns the result of the transfer, which is handed to every client
+// waiting for it. It should give up with errTransferCancelled once
+// t.cancelled is closed.
+type transferFunc func(t *transfer) (interface{}, error)
+
+// transferManager runs layer transfers in the background, with a bounded
+// number of concurrent transfers. Clients asking for a transfer that is
+// already running share it instead of starting another one.
+type transferManager struct {
+	sync.Mutex
+	limit     int
+	active    int
+	queue     []chan struct{}
+	transfers map[string]*transfer
+}
+
+func newTransferManager(limit int) *transferManager {
+	return &transferManager{
+		limit:     limit,
+		transfers: make(map[string]*transfer),
+	}
+}
+
+// setLimit changes the number of concurrent transfers. A limit of 0 or less
+// means no limit.
+func (m *transferManager) setLimit(limit int) {
+	m.Lock()
+	m.limit = limit
+	for len(m.queue) > 0 && (m.limit <= 0 || m.active < m.limit) {
+		m.wakeNext()
+	}
+	m.Unlock()
+}
+
+// transfer watches the transfer identified by key, and starts it with run if
+// it is not running, or if it was cancelled and is still stopping. Progress
+// is written to out with sf, under id.
+func (m *transferManager) transfer(key, id string, run transferFunc, out io.Writer, sf *utils.StreamFormatter) *transferWatcher {
+	m.Lock()
+	defer m.Unlock()
+	prev := m.transfers[key]
+	if prev != nil {
+		if w := prev.watch(out, sf); w != nil {
+			return w
+		}
+	}
+	t := &transfer{
+		id:        id,
+		manager:   m,
+		done:      make(chan struct{}),
+		cancelled: make(chan struct{}),
+		watchers:  make(map[*transferWatcher]struct{}),
+	}
+	m.transfers[key] = t
+	w := t.watch(out, sf)
+	go t.run(key, run, prev)
+	return w
+}
+
+// acquire waits for a free slot, or until cancelled is closed. queued is
+// called if there is no free slot right away.
+func (m *transferManager) acquire(cancelled <-chan struct{}, queued func()) error {
+	m.Lock()
+	if m.limit <= 0 || m.active < m.limit {
+		m.active++
+		m.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	m.queue = append(m.queue, ready)
+	m.Unlock()
+	queued()
+
+	select {
+	case <-ready:
+		return nil
+	case <-cancelled:
+	}
+	m.Lock()
+	defer m.Unlock()
+	for i, c := range m.queue {
+		if c == ready {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			return errTransferCancelled
+		}
+	}
+	// The slot was handed over at the same time, pass it on
+	m.releaseLocked()
+	return errTransferCancelled
+}
+
+func (m *transferManager) release() {
+	m.Lock()
+	m.releaseLocked()
+	m.Unlock()
+}
+
+func (m *transferManager) releaseLocked() {
+	m.active--
+	if len(m.queue) > 0 && (m.limit <= 0 || m.active < m.limit) {
+		m.wakeNext()
+	}
+}
+
+// wakeNext hands a slot to the first queued transfer.
+func (m *transferManager) wakeNext() {
+	m.active++
+	close(m.queue[0])
+	m.queue = m.queue[1:]
+}
+
+// transfer is a transfer shared by the clients waiting for it.
+type transfer struct {
+	id        string
+	manager   *transferManager
+	done      chan struct{}
+	result    interface{}
+	err       error
+	cancelled chan struct{}
+	hasSlot   bool
+
+	mu       sync.Mutex
+	watchers map[*transferWatcher]struct{}
+	last     func(sf *utils.StreamFormatter) []byte
+}
+
+// run runs the transfer once prev, a cancelled transfer of the same key,
+// has stopped, so that they do not write the same files.
+func (t *transfer) run(key string, run transferFunc, prev *transfer) {
+	defer close(t.done)
+
+	if prev != nil {
+		select {
+		case <-prev.done:
+		case <-t.cancelled:
+		}
+	}
+	if t.err = t.manager.acquire(t.cancelled, func() { t.Progress("Waiting") }); t.err == nil {
+		t.hasSlot = true
+		t.result, t.err = run(t)
+		t.releaseSlot()
+	}
+
+	t.manager.Lock()
+	if t.manager.transfers[key] == t {
+		delete(t.manager.transfers, key)
+	}
+	t.manager.Unlock()
+}
+
+// releaseSlot lets another transfer start, for transfers that go on with
+// work that does not use the network, such as extracting a layer.
+func (t *transfer) releaseSlot() {
+	if t.hasSlot {
+		t.hasSlot = false
+		t.manager.release()
+	}
+}
+
+// watch adds a client waiting for the transfer. The last progress message is
+// sent to it right away. It returns nil if the transfer was cancelled.
+func (t *transfer) watch(out io.Writer, sf *utils.StreamFormatter) *transferWatcher {
+	t.mu.Lock()
+	select {
+	case <-t.cancelled:
+		t.mu.Unlock()
+		return nil
+	default:
+	}
+	w := &transferWatcher{t: t, out: out, sf: sf, gone: make(chan struct{})}
+	t.watchers[w] = struct{}{}
+	last := t.last
+	t.mu.Unlock()
+
+	if last != nil {
+		w.send(last)
+	}
+	return w
+}
+
+// waitFor waits for another transfer that this one depends on, keeping it
+// from being cancelled meanwhile.
+func (t *transfer) waitFor(other *transfer) (interface{}, error) {
+	w := other.watch(nil, nil)
+	if w == nil {
+		return nil, errTransferCancelled
+	}
+	defer w.detach()
+	select {
+	case <-other.done:
+		return other.result, other.err
+	case <-t.cancelled:
+		return nil, errTransferCancelled
+	}
+}
+
+// detach removes a client. The transfer is cancelled once no client waits
+// for it.
+func (t *transfer) detach(w *transferWatcher) {
+	if _, exists := t.watchers[w]; !exists {
+		return
+	}
+	delete(t.watchers, w)
+	close(w.gone)
+	if len(t.watchers) == 0 {
+		select {
+		case <-t.done:
+		case <-t.cancelled:
+		default:
+			close(t.cancelled)
+		}
+	}
+}
+
+// broadcast sends a progress message to every client, in its own format.
+// The clients are written to outside of t.mu, so that a slow client does
+// not hold up the others from watching or detaching.
+func (t *transfer) broadcast(format func(sf *utils.StreamFormatter) []byte) {
+	t.mu.Lock()
+	t.last = format
+	watchers := make([]*transferWatcher, 0, len(t.watchers))
+	for w := range t.watchers {
+		watchers = append(watchers, w)
+	}
+	t.mu.Unlock()
+
+	for _, w := range watchers {
+		if err := w.send(format); err != nil {
+			// The client went away
+			w.detach()
+		}
+	}
+}
+
+// Progress reports the status of the transfer to the clients.
+func (t *transfer) Progress(action string) {
+	t.broadcast(func(sf *utils.StreamFormatter) []byte {
+		return sf.FormatProgress(t.id, action, nil)
+	})
+}
+
+// FormatProg, FormatStatus and FormatError make transfer a
+// progressreader.StreamFormatter that sends the progress to the clients.
+func (t *transfer) FormatProg(id, action string, p interface{}) []byte {
+	t.broadcast(func(sf *utils.StreamFormatter) []byte {
+		return sf.FormatProg(id, action, p)
+	})
+	return nil
+}
+
+func (t *transfer) FormatStatus(id, format string, a ...interface{}) []byte {
+	t.broadcast(func(sf *utils.StreamFormatter) []byte {
+		return sf.FormatStatus(id, format, a...)
+	})
+	return nil
+}
+
+func (t *transfer) FormatError(err error) []byte {
+	return nil
+}
+
+// reader wraps in to report the progress of action to the clients. It fails
+// with errTransferCancelled once the transfer is cancelled.
+func (t *transfer) reader(in io.ReadCloser, action string, offset, size int64) io.ReadCloser {
+	r := &cancellableReader{
+		ReadCloser: progressreader.New(progressreader.Config{
+			In:        in,
+			Out:       ioutil.Discard,
+			Formatter: t,
+			Size:      int(size),
+			Current:   int(offset),
+			NewLines:  false,
+			ID:        t.id,
+			Action:    action,
+		}),
+		cancelled: t.cancelled,
+		closed:    make(chan struct{}),
+	}
+	// Interrupt reads that are blocked when the transfer is cancelled
+	go func() {
+		select {
+		case <-t.cancelled:
+			r.Close()
+		case <-r.closed:
+		case <-t.done:
+		}
+	}()
+	return r
+}
+
+// fetcher makes get fail once the transfer is cancelled, so that the
+// download is not retried.
+func (t *transfer) fetcher(get layerFetcher) layerFetcher {
+	return func(offset int64) (io.ReadCloser, int64, int64, error) {
+		select {
+		case <-t.cancelled:
+			return nil, 0, 0, errTransferCancelled
+		default:
+		}
+		return get(offset)
+	}
+}
+
+type cancellableReader struct {
+	io.ReadCloser
+	cancelled <-chan struct{}
+	closed    chan struct{}
+	once      sync.Once
+}
+
+func (r *cancellableReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	select {
+	case <-r.cancelled:
+		return n, errTransferCancelled
+	default:
+	}
+	return n, err
+}
+
+func (r *cancellableReader) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.closed)
+		err = r.ReadCloser.Close()
+	})
+	return err
+}
+
+// transferWatcher is a client waiting for a transfer.
+type transferWatcher struct {
+	t    *transfer
+	out  io.Writer
+	sf   *utils.StreamFormatter
+	gone chan struct{}
+}
+
+// send writes a progress message to the client, unless it has no output or
+// has detached.
+func (w *transferWatcher) send(format func(sf *utils.StreamFormatter) []byte) error {
+	if w.out == nil {
+		return nil
+	}
+	select {
+	case <-w.gone:
+		return nil
+	default:
+	}
+	_, err := w.out.Write(format(w.sf))
+	return err
+}
+
+// wait waits for the transfer to end and returns its result. It returns
+// errTransferDetached if the client stopped waiting.
+func (w *transferWatcher) wait() (interface{}, error) {
+	select {
+	case <-w.t.done:
+	case <-w.gone:
+	}
+	select {
+	case <-w.gone:
+		return nil, errTransferDetached
+	default:
+		return w.t.result, w.t.err
+	}
+}
+
+// detach stops waiting for the transfer. The transfer is cancelled if no
+// other client waits for it.
+func (w *transferWatcher) detach() {
+	w.t.mu.Lock()
+	w.t.detach(w)
+	w.t.mu.Unlock()
+}
@@ -0,0 +1,209 @@
+package graph
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/utils"
+)
+
+// blockingTransfer returns a transfer that reports its progress and waits
+// for release to be closed or for the transfer to be cancelled.
+func blockingTransfer(started chan<- string, release <-chan struct{}, name string) transferFunc {
+	return func(t *transfer) (interface{}, error) {
+		t.Progress("Downloading")
+		started <- name
+		select {
+		case <-release:
+			return name, nil
+		case <-t.cancelled:
+			return nil, errTransferCancelled
+		}
+	}
+}
+
+type syncBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.String()
+}
+
+func TestTransferSharing(t *testing.T) {
+	m := newTransferManager(0)
+	started := make(chan string, 2)
+	release := make(chan struct{})
+
+	var (
+		raw  syncBuffer
+		json syncBuffer
+	)
+	w1 := m.transfer("layer", "layer", blockingTransfer(started, release, "first"), &raw, utils.NewStreamFormatter(false))
+	<-started
+	w2 := m.transfer("layer", "layer", blockingTransfer(started, release, "second"), &json, utils.NewStreamFormatter(true))
+	close(release)
+
+	for _, w := range []*transferWatcher{w1, w2} {
+		result, err := w.wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != "first" {
+			t.Fatalf("Expected the first transfer to be shared, got %v", result)
+		}
+	}
+	select {
+	case name := <-started:
+		t.Fatalf("Expected a single transfer, %s was started too", name)
+	default:
+	}
+
+	// Each client gets the progress in its own format
+	if raw.String() != "Downloading \r\n" {
+		t.Fatalf("Expected raw progress, got %q", raw.String())
+	}
+	if !strings.Contains(json.String(), `"status":"Downloading"`) {
+		t.Fatalf("Expected JSON progress, got %q", json.String())
+	}
+}
+
+func TestTransferLimit(t *testing.T) {
+	m := newTransferManager(2)
+	started := make(chan string, 5)
+	release := make(chan struct{})
+	sf := utils.NewStreamFormatter(false)
+
+	var watchers []*transferWatcher
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		watchers = append(watchers, m.transfer(name, name, blockingTransfer(started, release, name), nil, sf))
+	}
+	<-started
+	<-started
+	select {
+	case name := <-started:
+		t.Fatalf("Expected at most 2 transfers at the same time, %s was started too", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	m.setLimit(3)
+	<-started
+	close(release)
+	for _, w := range watchers {
+		if _, err := w.wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(started) != 2 {
+		t.Fatalf("Expected the last 2 transfers to run, %d did", len(started))
+	}
+}
+
+func TestTransferCancel(t *testing.T) {
+	m := newTransferManager(0)
+	started := make(chan string, 1)
+	sf := utils.NewStreamFormatter(false)
+
+	w1 := m.transfer("layer", "layer", blockingTransfer(started, nil, "layer"), nil, sf)
+	w2 := m.transfer("layer", "layer", blockingTransfer(started, nil, "layer"), nil, sf)
+	<-started
+
+	// Still needed by the second client
+	w1.detach()
+	if _, err := w1.wait(); err != errTransferDetached {
+		t.Fatalf("Expected %v, got %v", errTransferDetached, err)
+	}
+	select {
+	case <-w2.t.cancelled:
+		t.Fatal("Expected the transfer not to be cancelled")
+	default:
+	}
+
+	w2.detach()
+	select {
+	case <-w2.t.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the transfer to be cancelled")
+	}
+	if w2.t.err != errTransferCancelled {
+		t.Fatalf("Expected %v, got %v", errTransferCancelled, w2.t.err)
+	}
+}
+
+func TestTransferAfterCancel(t *testing.T) {
+	m := newTransferManager(0)
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	stopping := make(chan struct{})
+	sf := utils.NewStreamFormatter(false)
+
+	// The first transfer takes a while to stop once cancelled
+	w1 := m.transfer("layer", "layer", func(t *transfer) (interface{}, error) {
+		started <- "first"
+		<-t.cancelled
+		<-stopping
+		return nil, errTransferCancelled
+	}, nil, sf)
+	<-started
+	w1.detach()
+
+	// A client asking for the layer meanwhile gets a new transfer, which
+	// starts once the cancelled one has stopped
+	w2 := m.transfer("layer", "layer", blockingTransfer(started, release, "second"), nil, sf)
+	if w2.t == w1.t {
+		t.Fatal("Expected a new transfer after the cancelled one")
+	}
+	select {
+	case name := <-started:
+		t.Fatalf("Expected %s to wait for the cancelled transfer to stop", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(stopping)
+	if name := <-started; name != "second" {
+		t.Fatalf("Expected the second transfer to start, got %s", name)
+	}
+
+	// The cancelled transfer does not remove the new one as it stops
+	<-w1.t.done
+	if w3 := m.transfer("layer", "layer", blockingTransfer(started, release, "third"), nil, sf); w3.t != w2.t {
+		t.Fatal("Expected the new transfer to be shared")
+	}
+	close(release)
+	if result, err := w2.wait(); err != nil || result != "second" {
+		t.Fatalf("Expected the result of the second transfer, got %v, %v", result, err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("client went away")
+}
+
+func TestTransferClientGone(t *testing.T) {
+	m := newTransferManager(0)
+	started := make(chan string, 1)
+
+	w := m.transfer("layer", "layer", blockingTransfer(started, nil, "layer"), failingWriter{}, utils.NewStreamFormatter(false))
+	<-started
+	if _, err := w.wait(); err != errTransferDetached {
+		t.Fatalf("Expected %v, got %v", errTransferDetached, err)
+	}
+	<-w.t.done
+	if w.t.err != errTransferCancelled {
+		t.Fatalf("Expected %v, got %v", errTransferCancelled, w.t.err)
+	}
+}
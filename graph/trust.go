@@ -0,0 +1,169 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/engine"
+	"github.com/docker/libtrust"
+)
+
+// imageTrustFile stores the ImageTrust of an image in its root.
+const imageTrustFile = "trust"
+
+// ImageTrust records whether the manifest an image was pulled or pushed with
+// is signed by keys that the trust store grants access to its repository
+// namespace.
+type ImageTrust struct {
+	Verified  bool
+	Namespace string
+	// Keys are the IDs of the trusted keys the manifest is signed with.
+	Keys []string `json:",omitempty"`
+}
+
+// SetRequireSigned sets whether only images with manifests signed by trusted
+// keys can be pulled and used to create containers.
+func (store *TagStore) SetRequireSigned(requireSigned bool) {
+	store.Lock()
+	defer store.Unlock()
+	store.requireSigned = requireSigned
+}
+
+// RequireSigned tells whether only images signed by trusted keys are allowed.
+func (store *TagStore) RequireSigned() bool {
+	store.Lock()
+	defer store.Unlock()
+	return store.requireSigned
+}
+
+// ImageTrust returns the trust recorded for the image with the given ID, or
+// nil if it was neither pulled nor pushed with a v2 manifest.
+func (store *TagStore) ImageTrust(id string) (*ImageTrust, error) {
+	jsonData, err := ioutil.ReadFile(path.Join(store.graph.ImageRoot(id), imageTrustFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var trust ImageTrust
+	if err := json.Unmarshal(jsonData, &trust); err != nil {
+		return nil, err
+	}
+	return &trust, nil
+}
+
+// saveImageTrust records the trust of the image with the given ID, which
+// may be a legacy ID.
+func (store *TagStore) saveImageTrust(id string, trust *ImageTrust) error {
+	img, err := store.graph.Get(id)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(trust)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(store.graph.ImageRoot(img.ID), imageTrustFile), jsonData, 0600)
+}
+
+// InheritImageTrust records the trust of the image with the given parent
+// ID for the image with the given ID, if the parent was verified. Images
+// committed from containers of verified images, such as the steps of a
+// build, can then be used like their parent.
+func (store *TagStore) InheritImageTrust(id, parentID string) error {
+	if parentID == "" {
+		return nil
+	}
+	trust, err := store.ImageTrust(parentID)
+	if err != nil || trust == nil || !trust.Verified {
+		return err
+	}
+	return store.saveImageTrust(id, trust)
+}
+
+// trustedKeys checks the keys that signed a manifest against the grants of
+// the trust store for namespace.
+func trustedKeys(eng *engine.Engine, namespace string, keys []libtrust.PublicKey) (*ImageTrust, error) {
+	trust := &ImageTrust{Namespace: namespace}
+	for _, key := range keys {
+		job := eng.Job("trust_key_check")
+		b, err := key.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling public key: %s", err)
+		}
+		stdoutBuffer := bytes.NewBuffer(nil)
+
+		job.Args = append(job.Args, namespace)
+		job.Setenv("PublicKey", string(b))
+		// Check key has read/write permission (0x03)
+		job.SetenvInt("Permission", 0x03)
+		job.Stdout.Add(stdoutBuffer)
+		if err = job.Run(); err != nil {
+			return nil, fmt.Errorf("error running key check: %s", err)
+		}
+		result := engine.Tail(stdoutBuffer, 1)
+		log.Debugf("Key check result: %q", result)
+		if result == "verified" {
+			trust.Verified = true
+			trust.Keys = append(trust.Keys, key.KeyID())
+		}
+	}
+	return trust, nil
+}
+
+// trustNamespace is the namespace of the trust graph that grants access to
+// a repository.
+func trustNamespace(remoteName string) string {
+	if remoteName != "" && remoteName[0] == '/' {
+		return remoteName
+	}
+	return "/" + remoteName
+}
+
+// logTrustEvent logs a "verified" or "untrusted" event for name.
+func logTrustEvent(eng *engine.Engine, action, name string) {
+	if err := eng.Job("log", action, name, "").Run(); err != nil {
+		log.Errorf("Error logging event '%s' for %s: %s", action, name, err)
+	}
+}
+
+// ErrUntrusted is returned when the trust policy refuses an image.
+type ErrUntrusted struct {
+	Name      string
+	Namespace string
+}
+
+func (e ErrUntrusted) Error() string {
+	if e.Namespace == "" {
+		return fmt.Sprintf("%s is not signed by a trusted key", e.Name)
+	}
+	return fmt.Sprintf("%s is not signed by a key trusted for %s", e.Name, e.Namespace)
+}
+
+// CheckImageTrust returns an ErrUntrusted error if only signed images are
+// allowed and the image with the given ID was not verified. The refusal is
+// logged as an "untrusted" event for name.
+func (store *TagStore) CheckImageTrust(eng *engine.Engine, name, id string) error {
+	if !store.RequireSigned() {
+		return nil
+	}
+	trust, err := store.ImageTrust(id)
+	if err != nil {
+		return err
+	}
+	if trust != nil && trust.Verified {
+		return nil
+	}
+	untrusted := ErrUntrusted{Name: name}
+	if trust != nil {
+		untrusted.Namespace = trust.Namespace
+	}
+	logTrustEvent(eng, "untrusted", name)
+	return untrusted
+}
@@ -0,0 +1,212 @@
+package graph
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/utils"
+	"github.com/docker/libtrust"
+)
+
+func TestCheckImageTrust(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	eng := engine.New()
+	var events []string
+	eng.Register("log", func(job *engine.Job) engine.Status {
+		events = append(events, job.Args[0]+" "+job.Args[1])
+		return engine.StatusOK
+	})
+
+	img, err := store.LookupImage(testOfficialImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckImageTrust(eng, testOfficialImageName, img.ID); err != nil {
+		t.Fatalf("Expected any image to be allowed without the policy, got %s", err)
+	}
+
+	store.SetRequireSigned(true)
+	if err := store.CheckImageTrust(eng, testOfficialImageName, img.ID); err == nil {
+		t.Fatal("Expected an image without trust to be refused")
+	}
+	if err := store.saveImageTrust(testOfficialImageID, &ImageTrust{Namespace: "/library/myapp"}); err != nil {
+		t.Fatal(err)
+	}
+	err = store.CheckImageTrust(eng, testOfficialImageName, img.ID)
+	if expected := (ErrUntrusted{Name: testOfficialImageName, Namespace: "/library/myapp"}); err != expected {
+		t.Fatalf("Expected %v, got %v", expected, err)
+	}
+	trust := &ImageTrust{Verified: true, Namespace: "/library/myapp", Keys: []string{"ABCD"}}
+	if err := store.saveImageTrust(testOfficialImageID, trust); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CheckImageTrust(eng, testOfficialImageName, img.ID); err != nil {
+		t.Fatalf("Expected a verified image to be allowed, got %s", err)
+	}
+	if saved, err := store.ImageTrust(img.ID); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(saved, trust) {
+		t.Fatalf("Expected %v, got %v", trust, saved)
+	}
+
+	if expected := []string{"untrusted " + testOfficialImageName, "untrusted " + testOfficialImageName}; !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Expected events %v, got %v", expected, events)
+	}
+}
+
+func TestInheritImageTrust(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	parent, err := store.LookupImage(testOfficialImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &image.Image{ID: testManifestImageID, Parent: parent.ID}
+	if err := store.graph.Register(child, layer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is inherited from parents that were not verified
+	if err := store.saveImageTrust(parent.ID, &ImageTrust{Namespace: "/library/myapp"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InheritImageTrust(child.ID, parent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if trust, err := store.ImageTrust(child.ID); err != nil || trust != nil {
+		t.Fatalf("Expected no trust, got %v (%v)", trust, err)
+	}
+
+	verified := &ImageTrust{Verified: true, Namespace: "/library/myapp", Keys: []string{"ABCD"}}
+	if err := store.saveImageTrust(parent.ID, verified); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InheritImageTrust(child.ID, parent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if trust, err := store.ImageTrust(child.ID); err != nil || !reflect.DeepEqual(trust, verified) {
+		t.Fatalf("Expected %v, got %v (%v)", verified, trust, err)
+	}
+}
+
+func TestSignedManifest(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := engine.New()
+	eng.Register("trust_key_check", func(job *engine.Job) engine.Status {
+		if job.Args[0] != "/library/myapp" {
+			t.Errorf("Unexpected namespace %s", job.Args[0])
+		}
+		pk, err := libtrust.UnmarshalPublicKeyJWK([]byte(job.Getenv("PublicKey")))
+		if err != nil {
+			return job.Error(err)
+		}
+		if pk.KeyID() == key.KeyID() {
+			job.Stdout.Write([]byte("verified"))
+		} else {
+			job.Stdout.Write([]byte("not verified"))
+		}
+		return engine.StatusOK
+	})
+
+	img, err := store.LookupImage(testOfficialImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.schema1Manifest(img, "library/myapp", DEFAULTTAG); err == nil {
+		t.Fatal("Expected the manifest of an image that was not pushed to fail")
+	}
+	checksum := "tarsum.v1+sha256:" + testOfficialImageID
+	if err := img.SaveCheckSum(store.graph.ImageRoot(img.ID), checksum); err != nil {
+		t.Fatal(err)
+	}
+	m, err := store.schema1Manifest(img, "library/myapp", DEFAULTTAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.FSLayers) != 1 || m.FSLayers[0].BlobSum != checksum {
+		t.Fatalf("Unexpected layers in %v", m.FSLayers)
+	}
+
+	for _, c := range []struct {
+		key      libtrust.PrivateKey
+		verified bool
+	}{
+		{key, true},
+		{otherKey, false},
+	} {
+		payload, err := json.MarshalIndent(m, "", "   ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		js, err := libtrust.NewJSONSignature(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := js.Sign(c.key); err != nil {
+			t.Fatal(err)
+		}
+		signed, err := js.PrettySignature("signatures")
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, trust, err := store.loadManifest(eng, signed, "library/myapp", DEFAULTTAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The manifest of another repository or tag is refused
+		if _, _, err := store.loadManifest(eng, signed, "library/other", DEFAULTTAG); err == nil {
+			t.Fatal("Expected the manifest of another repository to be refused")
+		}
+		if _, _, err := store.loadManifest(eng, signed, "library/myapp", "other"); err == nil {
+			t.Fatal("Expected the manifest of another tag to be refused")
+		}
+		if _, _, err := store.loadManifest(eng, signed, "library/myapp", "sha256:"+testOfficialImageID); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(loaded, m) {
+			t.Fatalf("Expected %v, got %v", m, loaded)
+		}
+		if trust.Verified != c.verified {
+			t.Fatalf("Expected verified to be %t with key %s", c.verified, c.key.KeyID())
+		}
+		if c.verified && !reflect.DeepEqual(trust.Keys, []string{key.KeyID()}) {
+			t.Fatalf("Expected the trusted keys to be %s, got %v", key.KeyID(), trust.Keys)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -31,11 +32,24 @@ type Image struct {
 	Config          *runconfig.Config `json:"config,omitempty"`
 	Architecture    string            `json:"architecture,omitempty"`
 	OS              string            `json:"os,omitempty"`
+	SquashedHistory []History         `json:"squashed_history,omitempty"`
+	DiffID          string            `json:"diff_id,omitempty"`
 	Size            int64
 
 	graph Graph
 }
 
+// History records a build step whose layer was folded into another image,
+// for example by `docker build --squash`. Entries are ordered newest first.
+type History struct {
+	ID        string    `json:"id,omitempty"`
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	Size      int64     `json:"size"`
+}
+
 func LoadImage(root string) (*Image, error) {
 	// Open the JSON file to decode by streaming
 	jsonSource, err := os.Open(jsonPath(root))
@@ -101,6 +115,21 @@ func StoreImage(img *Image, layerData archive.ArchiveReader, root string) (err e
 	return json.NewEncoder(f).Encode(img)
 }
 
+// ComputeID returns the content-addressable ID of the image: the hex
+// encoded SHA-256 of its configuration. The configuration includes the
+// parent ID and DiffID, the digest of the uncompressed layer tar, so two images only share an ID if they
+// have the same content and the same history.
+func (img *Image) ComputeID() (string, error) {
+	config := *img
+	config.ID = ""
+	config.Size = 0
+	buf, err := json.Marshal(&config)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(buf)), nil
+}
+
 func (img *Image) SetGraph(graph Graph) {
 	img.graph = graph
 }
@@ -131,6 +160,28 @@ func (img *Image) GetCheckSum(root string) (string, error) {
 	return string(cs), err
 }
 
+// SavePlatform records the platform that was picked for the image from a
+// manifest list.
+func (img *Image) SavePlatform(root, platform string) error {
+	if err := ioutil.WriteFile(path.Join(root, "platform"), []byte(platform), 0600); err != nil {
+		return fmt.Errorf("Error storing platform in %s/platform: %s", root, err)
+	}
+	return nil
+}
+
+// GetPlatform returns the platform that was picked for the image from a
+// manifest list, or an empty string if it was not pulled from one.
+func (img *Image) GetPlatform(root string) (string, error) {
+	platform, err := ioutil.ReadFile(path.Join(root, "platform"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(platform), nil
+}
+
 func jsonPath(root string) string {
 	return path.Join(root, "json")
 }
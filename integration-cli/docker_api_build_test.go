@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/utils"
+)
+
+func TestBuildApiStructuredProgress(t *testing.T) {
+	name := "testbuildapistructured"
+
+	defer deleteAllContainers()
+	defer deleteImages(name)
+
+	ctx, err := fakeContext(`FROM busybox
+RUN echo out && echo err >&2`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	context, err := archive.Tar(ctx.Dir, archive.Uncompressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer context.Close()
+
+	body, err := sockRequestRaw("POST", "/build?structured=1&t="+name, context, "application/tar")
+	if err != nil {
+		t.Fatalf("build failed: %s, %v", body, err)
+	}
+
+	var (
+		dec    = json.NewDecoder(bytes.NewReader(body))
+		starts = map[int]utils.JSONBuildStep{}
+		ends   = map[int]utils.JSONBuildStep{}
+		stderr string
+	)
+	for {
+		var jm utils.JSONMessage
+		if err := dec.Decode(&jm); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if jm.Error != nil {
+			t.Fatalf("unexpected error in the build stream: %v", jm.Error)
+		}
+		stderr += jm.Stderr
+		if jm.BuildStep == nil {
+			continue
+		}
+		switch jm.BuildStep.Event {
+		case "start":
+			starts[jm.BuildStep.Step] = *jm.BuildStep
+		case "end":
+			ends[jm.BuildStep.Step] = *jm.BuildStep
+		}
+	}
+
+	if len(starts) != 2 || len(ends) != 2 {
+		t.Fatalf("expected start and end events for 2 steps, got %v and %v", starts, ends)
+	}
+	if s := starts[1]; s.Instruction != "RUN" || s.Line != 2 || s.Original != "RUN echo out && echo err >&2" {
+		t.Fatalf("unexpected start event for the RUN step: %+v", s)
+	}
+	imageID, err := getIDByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := ends[1]; e.ImageID != imageID || e.ContainerID == "" || e.Cache == "" {
+		t.Fatalf("unexpected end event for the RUN step: %+v", e)
+	}
+	if stderr != "err\n" {
+		t.Fatalf("expected stderr of the RUN step to be kept apart, got %q", stderr)
+	}
+
+	logDone("build - structured progress through the API")
+}
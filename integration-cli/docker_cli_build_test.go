@@ -5276,3 +5276,262 @@ RUN [ "/hello" ]`, map[string]string{})
 
 	logDone("build - RUN with one JSON arg")
 }
+
+func TestBuildSquash(t *testing.T) {
+	name := "testbuildsquash"
+
+	defer deleteAllContainers()
+	defer deleteImages(name)
+
+	ctx, err := fakeContext(`FROM busybox
+RUN dd if=/dev/zero of=/bigfile bs=1M count=10
+RUN rm /bigfile
+RUN echo hello > /hello`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	out, _, err := dockerCmdInDir(t, ctx.Dir, "build", "--squash", "-t", name, ".")
+	if err != nil {
+		t.Fatalf("failed to build the image: %s, %v", out, err)
+	}
+	if !strings.Contains(out, "Squashing 3 layers") {
+		t.Fatalf("expected the build to squash 3 layers: %s", out)
+	}
+
+	baseID, err := getIDByName("busybox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := inspectField(name, "Parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent != baseID {
+		t.Fatalf("expected the squashed image to sit on top of %s, got parent %s", baseID, parent)
+	}
+
+	size, err := inspectField(name, "Size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := strconv.Atoi(size); err != nil || n > 1024*1024 {
+		t.Fatalf("expected the deleted file not to be in the squashed layer, size is %s", size)
+	}
+
+	out, _, err = dockerCmd(t, "history", "--no-trunc", name)
+	if err != nil {
+		t.Fatalf("failed to get the history: %s, %v", out, err)
+	}
+	for _, step := range []string{"squashed 3 layers", "dd if=/dev/zero", "rm /bigfile", "echo hello"} {
+		if !strings.Contains(out, step) {
+			t.Fatalf("expected %q in the history of the squashed image: %s", step, out)
+		}
+	}
+
+	out, _, err = dockerCmd(t, "run", "--rm", name, "cat", "/hello")
+	if err != nil || strings.TrimSpace(out) != "hello" {
+		t.Fatalf("expected the squashed image to contain /hello: %s, %v", out, err)
+	}
+
+	logDone("build - squash layers into one")
+}
+
+func TestBuildCheck(t *testing.T) {
+	name := "testbuildcheck"
+
+	defer deleteImages(name)
+
+	ctx, err := fakeContext(`FROM busybox
+ADD foo /foo
+BOGUS instruction
+CMD top`, map[string]string{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	buildCmd := exec.Command(dockerBinary, "build", "--check", "-t", name, ".")
+	buildCmd.Dir = ctx.Dir
+	stdout, _, exitCode, err := runCommandWithStdoutStderr(buildCmd)
+	if err == nil || exitCode == 0 {
+		t.Fatalf("expected the check to fail on the unknown instruction: %s", stdout)
+	}
+
+	var report struct {
+		Problems []struct {
+			Line int
+			Rule string
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("expected a JSON report, got %q: %v", stdout, err)
+	}
+	expected := map[int]string{1: "unpinned-base-image", 2: "add-instead-of-copy", 3: "unknown-instruction", 4: "shell-form-cmd"}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), report.Problems)
+	}
+	for _, p := range report.Problems {
+		if expected[p.Line] != p.Rule {
+			t.Fatalf("expected %s on line %d, got %s", expected[p.Line], p.Line, p.Rule)
+		}
+	}
+
+	if _, err := getIDByName(name); err == nil {
+		t.Fatal("the check must not build an image")
+	}
+
+	logDone("build - check a Dockerfile without building it")
+}
+
+func TestBuildCopyChown(t *testing.T) {
+	name := "testbuildcopychown"
+
+	defer deleteImages(name)
+
+	ctx, err := fakeContext(`FROM busybox
+RUN echo 'app:x:1234:4321::/app:/bin/sh' >> /etc/passwd && echo 'appgroup:x:5678:' >> /etc/group
+COPY --chown=app dir /app/
+ADD --chown=app:appgroup file /app/byname
+COPY --chown=1000:1001 file /app/bynumber
+COPY file /app/byroot
+RUN [ $(stat -c %u:%g /app) = '1234:4321' ]
+RUN [ $(stat -c %u:%g /app/nested) = '1234:4321' ]
+RUN [ $(stat -c %u:%g /app/byname) = '1234:5678' ]
+RUN [ $(stat -c %u:%g /app/bynumber) = '1000:1001' ]
+RUN [ $(stat -c %u:%g /app/byroot) = '0:0' ]`,
+		map[string]string{
+			"dir/nested": "nested",
+			"file":       "file",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	if _, err := buildImageFromContext(name, ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx.Add("Dockerfile", `FROM busybox
+COPY --chown=nosuchuser file /file`)
+	if _, err := buildImageFromContext(name, ctx, true); err == nil || !strings.Contains(err.Error(), "nosuchuser") {
+		t.Fatalf("expected the build to fail on an unknown user, got %v", err)
+	}
+
+	// Other words starting with -- are still sources
+	ctx.Add("--mode=0755", "mode")
+	ctx.Add("Dockerfile", `FROM busybox
+COPY --mode=0755 /file
+RUN [ "$(cat /file)" = mode ]`)
+	if _, err := buildImageFromContext(name, ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The contents of extracted archives are owned by --chown too
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "archived", Size: 8, Mode: 0644, Uid: 4242, Gid: 4242}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("archived")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Add("archive.tar", buf.String())
+	ctx.Add("Dockerfile", `FROM busybox
+ADD --chown=1000:1001 archive.tar /owned/
+ADD archive.tar /kept/
+RUN [ $(stat -c %u:%g /owned/archived) = '1000:1001' ]
+RUN [ $(stat -c %u:%g /kept/archived) = '4242:4242' ]`)
+	if _, err := buildImageFromContext(name, ctx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	logDone("build - COPY and ADD with --chown")
+}
+
+func TestBuildFromGITWithRefAndSubdirectory(t *testing.T) {
+	name := "testbuildfromgitsubdir"
+	defer deleteImages(name)
+	git, err := fakeGIT("repo", map[string]string{
+		"Dockerfile": `FROM busybox
+					MAINTAINER root`,
+		"subdir/Dockerfile": `FROM busybox
+					ADD first /first
+					RUN [ -f /first ]
+					MAINTAINER subdir`,
+		"subdir/first": "test git data",
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer git.Close()
+
+	for _, fragment := range []string{"#:subdir", "#master:subdir"} {
+		if _, err := buildImageFromPath(name, git.RepoURL+fragment, true); err != nil {
+			t.Fatal(err)
+		}
+		res, err := inspectField(name, "Author")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != "subdir" {
+			t.Fatalf("%s: Maintainer should be subdir, got %s", fragment, res)
+		}
+	}
+
+	if _, err := buildImageFromPath(name, git.RepoURL+"#nosuchbranch", true); err == nil {
+		t.Fatal("expected the build to fail for a missing ref")
+	}
+
+	logDone("build - build from GIT with a ref and a subdirectory")
+}
+
+func TestBuildFromRemoteTarball(t *testing.T) {
+	name := "testbuildfromremotetarball"
+	defer deleteImages(name)
+
+	ctx, err := fakeContext(`FROM busybox
+ADD first /first
+RUN [ -f /first ]
+MAINTAINER tarball`, map[string]string{"first": "test tarball data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	context, err := archive.Tar(ctx.Dir, archive.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer context.Close()
+	buf, err := ioutil.ReadAll(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := fakeBinaryStorage(map[string]*bytes.Buffer{
+		"context.tar.gz": bytes.NewBuffer(buf),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	if _, err := buildImageFromPath(name, server.URL()+"/context.tar.gz", true); err != nil {
+		t.Fatal(err)
+	}
+	res, err := inspectField(name, "Author")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "tarball" {
+		t.Fatalf("Maintainer should be tarball, got %s", res)
+	}
+
+	logDone("build - build from a remote tarball")
+}
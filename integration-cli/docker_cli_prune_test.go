@@ -0,0 +1,134 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContainerPrune(t *testing.T) {
+	defer deleteAllContainers()
+
+	out, _, _ := dockerCmd(t, "run", "-d", "busybox", "true")
+	stopped := strings.TrimSpace(out)
+	out, _, _ = dockerCmd(t, "run", "-d", "busybox", "top")
+	running := strings.TrimSpace(out)
+	dockerCmd(t, "wait", stopped)
+
+	out, _, _ = dockerCmd(t, "container", "prune", "--force")
+	if !strings.Contains(out, stopped) {
+		t.Fatalf("Expected %s to be pruned, got:\n%s", stopped, out)
+	}
+	if strings.Contains(out, running) {
+		t.Fatalf("Expected %s not to be pruned, got:\n%s", running, out)
+	}
+	if !strings.Contains(out, "Total reclaimed space:") {
+		t.Fatalf("Expected the reclaimed space to be reported, got:\n%s", out)
+	}
+
+	out, _, _ = dockerCmd(t, "ps", "-a", "-q", "--no-trunc")
+	if strings.Contains(out, stopped) || !strings.Contains(out, running) {
+		t.Fatalf("Expected only %s to be left, got:\n%s", running, out)
+	}
+
+	logDone("container prune - remove stopped containers")
+}
+
+func TestContainerPruneFilters(t *testing.T) {
+	defer deleteAllContainers()
+
+	out, _, _ := dockerCmd(t, "run", "-d", "--label", "janitor=yes", "busybox", "true")
+	labeled := strings.TrimSpace(out)
+	out, _, _ = dockerCmd(t, "run", "-d", "busybox", "true")
+	unlabeled := strings.TrimSpace(out)
+	dockerCmd(t, "wait", labeled, unlabeled)
+
+	// Both containers were created less than an hour ago
+	out, _, _ = dockerCmd(t, "container", "prune", "--force", "--filter", "until=1h")
+	if strings.Contains(out, labeled) || strings.Contains(out, unlabeled) {
+		t.Fatalf("Expected no container to be pruned, got:\n%s", out)
+	}
+
+	out, _, _ = dockerCmd(t, "container", "prune", "--force", "--filter", "label=janitor=yes")
+	if !strings.Contains(out, labeled) || strings.Contains(out, unlabeled) {
+		t.Fatalf("Expected only %s to be pruned, got:\n%s", labeled, out)
+	}
+
+	logDone("container prune - filter by until and label")
+}
+
+func TestImagePrune(t *testing.T) {
+	name := "testimageprune"
+	defer deleteImages(name)
+	dangling, err := buildImage(name, `FROM busybox
+LABEL pruned=yes
+RUN echo first > /file`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := buildImage(name, `FROM busybox
+LABEL pruned=yes
+RUN echo second > /file`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, _ := dockerCmd(t, "image", "prune", "--force", "--filter", "label=pruned=yes")
+	if !strings.Contains(out, "Deleted: "+dangling) {
+		t.Fatalf("Expected %s to be pruned, got:\n%s", dangling, out)
+	}
+	if strings.Contains(out, tagged) {
+		t.Fatalf("Expected %s not to be pruned, got:\n%s", tagged, out)
+	}
+	if err := imageExists(dangling); err == nil {
+		t.Fatalf("Expected %s to be removed", dangling)
+	}
+
+	out, _, _ = dockerCmd(t, "image", "prune", "--all", "--force", "--filter", "label=pruned=yes")
+	if !strings.Contains(out, "Untagged: "+name+":latest") || !strings.Contains(out, "Deleted: "+tagged) {
+		t.Fatalf("Expected %s to be pruned, got:\n%s", tagged, out)
+	}
+	if err := imageExists("busybox"); err != nil {
+		t.Fatal("Expected busybox not to be pruned")
+	}
+
+	logDone("image prune - remove dangling and unused images")
+}
+
+func TestVolumePrune(t *testing.T) {
+	defer deleteAllContainers()
+
+	dockerCmd(t, "run", "--name", "withvolume", "-v", "/foo", "busybox", "true")
+	out, _, _ := dockerCmd(t, "inspect", "--format", "{{index .Volumes \"/foo\"}}", "withvolume")
+	volume := filepath.Base(strings.TrimSpace(out))
+
+	out, _, _ = dockerCmd(t, "volume", "prune", "--force")
+	if strings.Contains(out, volume) {
+		t.Fatalf("Expected the volume of a stopped container not to be pruned, got:\n%s", out)
+	}
+
+	dockerCmd(t, "rm", "withvolume")
+	out, _, _ = dockerCmd(t, "volume", "prune", "--force")
+	if !strings.Contains(out, volume) {
+		t.Fatalf("Expected %s to be pruned, got:\n%s", volume, out)
+	}
+
+	logDone("volume prune - remove unused volumes")
+}
+
+func TestSystemPrune(t *testing.T) {
+	defer deleteAllContainers()
+
+	out, _, _ := dockerCmd(t, "run", "-d", "-v", "/foo", "busybox", "true")
+	stopped := strings.TrimSpace(out)
+	dockerCmd(t, "wait", stopped)
+	out, _, _ = dockerCmd(t, "inspect", "--format", "{{index .Volumes \"/foo\"}}", stopped)
+	volume := filepath.Base(strings.TrimSpace(out))
+
+	out, _, _ = dockerCmd(t, "system", "prune", "--force", "--volumes")
+	if !strings.Contains(out, stopped) || !strings.Contains(out, volume) {
+		t.Fatalf("Expected %s and its volume to be pruned, got:\n%s", stopped, out)
+	}
+
+	logDone("system prune - remove containers and their volumes")
+}
@@ -3323,3 +3323,27 @@ func TestRunVolumesFromRestartAfterRemoved(t *testing.T) {
 
 	logDone("run - can restart a volumes-from container after producer is removed")
 }
+
+func TestRunPullNever(t *testing.T) {
+	defer deleteAllContainers()
+
+	out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "run", "--pull=never", "busybox", "true"))
+	if err != nil {
+		t.Fatal(out, err)
+	}
+
+	out, _, err = runCommandWithOutput(exec.Command(dockerBinary, "run", "--pull=never", "docker-pull-never-missing", "true"))
+	if err == nil {
+		t.Fatalf("expected the run of a missing image to fail: %s", out)
+	}
+	if strings.Contains(out, "Unable to find image") || !strings.Contains(out, "No such image") {
+		t.Fatalf("expected the missing image not to be pulled: %s", out)
+	}
+
+	out, _, err = runCommandWithOutput(exec.Command(dockerBinary, "run", "--pull=sometimes", "busybox", "true"))
+	if err == nil || !strings.Contains(out, "Invalid pull policy") {
+		t.Fatalf("expected an invalid pull policy to be rejected: %s", out)
+	}
+
+	logDone("run - --pull=never does not pull missing images")
+}
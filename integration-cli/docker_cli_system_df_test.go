@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSystemDf(t *testing.T) {
+	defer deleteAllContainers()
+
+	// Pruning invalidates the cached disk usage
+	dockerCmd(t, "container", "prune", "--force")
+	out, _, _ := dockerCmd(t, "run", "-d", "--name", "dftop", "-v", "/foo", "busybox", "top")
+	id := strings.TrimSpace(out)
+
+	out, _, _ = dockerCmd(t, "system", "df")
+	for _, row := range []string{"TYPE", "Images", "Containers", "Local Volumes", "Logs"} {
+		if !strings.Contains(out, row) {
+			t.Fatalf("Expected %q in the output, got:\n%s", row, out)
+		}
+	}
+
+	out, _, _ = dockerCmd(t, "system", "df", "-v")
+	for _, s := range []string{"busybox", id[:12], "dftop", "Running"} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("Expected %q in the verbose output, got:\n%s", s, out)
+		}
+	}
+
+	logDone("system df - show disk usage")
+}
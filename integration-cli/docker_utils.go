@@ -619,6 +619,22 @@ func fakeStorage(files map[string]string) (FakeStorage, error) {
 	return fakeStorageWithContext(ctx)
 }
 
+// fakeBinaryStorage returns a file server like fakeStorage for files whose
+// content is not text, such as archives.
+func fakeBinaryStorage(archives map[string]*bytes.Buffer) (FakeStorage, error) {
+	ctx, err := fakeContextWithFiles(nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, content := range archives {
+		if err := ioutil.WriteFile(filepath.Join(ctx.Dir, name), content.Bytes(), 0644); err != nil {
+			ctx.Close()
+			return nil, err
+		}
+	}
+	return fakeStorageWithContext(ctx)
+}
+
 // fakeStorageWithContext returns either a local or remote (at daemon machine) file server
 func fakeStorageWithContext(ctx *FakeContext) (FakeStorage, error) {
 	if isLocalDaemon {
@@ -33,8 +33,18 @@ type (
 		IncludeFiles    []string
 		ExcludePatterns []string
 		Compression     Compression
-		NoLchown        bool
-		Name            string
+		// CompressionLevel is the gzip level, the default level if 0.
+		CompressionLevel int
+		NoLchown         bool
+		// ChownOpts, if set, owns the unpacked files by its UID and GID
+		// instead of the owners recorded in the archive.
+		ChownOpts *TarChownOptions
+		Name      string
+	}
+
+	// TarChownOptions are the owner of the files unpacked by Untar.
+	TarChownOptions struct {
+		UID, GID int
 	}
 
 	// Archiver allows the reuse of most utility functions of this package
@@ -130,16 +140,27 @@ func DecompressStream(archive io.Reader) (io.ReadCloser, error) {
 	}
 }
 
+// CompressStream returns a writer that compresses what is written to it
+// into dest. Gzip streams are compressed at the default level.
 func CompressStream(dest io.WriteCloser, compression Compression) (io.WriteCloser, error) {
+	return CompressStreamLevel(dest, compression, gzip.DefaultCompression)
+}
+
+// CompressStreamLevel is like CompressStream, with the level of gzip
+// streams, which are compressed in parallel.
+func CompressStreamLevel(dest io.WriteCloser, compression Compression, level int) (io.WriteCloser, error) {
 	p := pools.BufioWriter32KPool
-	buf := p.Get(dest)
 	switch compression {
 	case Uncompressed:
+		buf := p.Get(dest)
 		writeBufWrapper := p.NewWriteCloserWrapper(buf, buf)
 		return writeBufWrapper, nil
 	case Gzip:
-		gzWriter := gzip.NewWriter(dest)
-		writeBufWrapper := p.NewWriteCloserWrapper(buf, gzWriter)
+		gzWriter, err := NewParallelGzipWriter(dest, level)
+		if err != nil {
+			return nil, err
+		}
+		writeBufWrapper := p.NewWriteCloserWrapper(p.Get(dest), gzWriter)
 		return writeBufWrapper, nil
 	case Bzip2, Xz:
 		// archive/bzip2 does not support writing, and there is no xz support at all
@@ -263,7 +284,7 @@ func (ta *tarAppender) addTarFile(path, name string) error {
 	return nil
 }
 
-func createTarFile(path, extractDir string, hdr *tar.Header, reader io.Reader, Lchown bool) error {
+func createTarFile(path, extractDir string, hdr *tar.Header, reader io.Reader, Lchown bool, chownOpts *TarChownOptions) error {
 	// hdr.Mode is in linux format, which we can use for sycalls,
 	// but for os.Foo() calls we need the mode converted to os.FileMode,
 	// so use hdrInfo.Mode() (they differ for e.g. setuid bits)
@@ -338,7 +359,11 @@ func createTarFile(path, extractDir string, hdr *tar.Header, reader io.Reader, L
 		return fmt.Errorf("Unhandled tar header type %d\n", hdr.Typeflag)
 	}
 
-	if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil && Lchown {
+	uid, gid := hdr.Uid, hdr.Gid
+	if chownOpts != nil {
+		uid, gid = chownOpts.UID, chownOpts.GID
+	}
+	if err := os.Lchown(path, uid, gid); err != nil && Lchown {
 		return err
 	}
 
@@ -397,7 +422,11 @@ func escapeName(name string) string {
 func TarWithOptions(srcPath string, options *TarOptions) (io.ReadCloser, error) {
 	pipeReader, pipeWriter := io.Pipe()
 
-	compressWriter, err := CompressStream(pipeWriter, options.Compression)
+	level := options.CompressionLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	compressWriter, err := CompressStreamLevel(pipeWriter, options.Compression, level)
 	if err != nil {
 		return nil, err
 	}
@@ -560,7 +589,7 @@ loop:
 			}
 		}
 		trBuf.Reset(tr)
-		if err := createTarFile(path, dest, hdr, trBuf, !options.NoLchown); err != nil {
+		if err := createTarFile(path, dest, hdr, trBuf, !options.NoLchown, options.ChownOpts); err != nil {
 			return err
 		}
 
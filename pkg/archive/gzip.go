@@ -0,0 +1,195 @@
+package archive
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const (
+	// gzipBlockSize is the size of the blocks compressed in parallel.
+	gzipBlockSize = 1 << 20
+	// gzipDictSize is the size of the window of deflate, the end of a block
+	// that the next one refers to.
+	gzipDictSize = 32 << 10
+)
+
+var errGzipClosed = errors.New("gzip: write to a closed writer")
+
+// ParallelGzipWriter compresses blocks of what is written to it on several
+// cores, and writes them as a single standard gzip stream. Each block is
+// deflated with the end of the previous one as dictionary and ends on a
+// byte boundary, so that they can be concatenated, which costs little
+// compression ratio. The output only depends on the input and the level.
+type ParallelGzipWriter struct {
+	w         io.Writer
+	level     int
+	blockSize int
+	block     []byte
+	dict      []byte
+	crc       uint32
+	size      uint32
+	closed    bool
+
+	// blocks are the results of the blocks being compressed, in order.
+	blocks chan chan gzipBlock
+	done   chan struct{}
+
+	sync.Mutex
+	err error
+}
+
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+// NewParallelGzipWriter returns a ParallelGzipWriter that writes to w at
+// level, one of the levels of compress/gzip. As with gzip.Writer, it must be
+// closed to write the end of the stream, which does not close w.
+func NewParallelGzipWriter(w io.Writer, level int) (*ParallelGzipWriter, error) {
+	return newParallelGzipWriter(w, level, gzipBlockSize, runtime.NumCPU())
+}
+
+func newParallelGzipWriter(w io.Writer, level, blockSize, concurrency int) (*ParallelGzipWriter, error) {
+	if level < gzip.DefaultCompression || level > gzip.BestCompression {
+		return nil, fmt.Errorf("Invalid gzip compression level: %d", level)
+	}
+	z := &ParallelGzipWriter{
+		w:         w,
+		level:     level,
+		blockSize: blockSize,
+		blocks:    make(chan chan gzipBlock, concurrency),
+		done:      make(chan struct{}),
+	}
+	go z.writeBlocks()
+	return z, nil
+}
+
+// writeBlocks writes the gzip header, then the compressed blocks as they
+// are done, until the writer is closed.
+func (z *ParallelGzipWriter) writeBlocks() {
+	defer close(z.done)
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	switch z.level {
+	case gzip.BestCompression:
+		header[8] = 2
+	case gzip.BestSpeed:
+		header[8] = 4
+	}
+	if _, err := z.w.Write(header); err != nil {
+		z.setErr(err)
+	}
+	for result := range z.blocks {
+		block := <-result
+		if z.getErr() != nil {
+			continue
+		}
+		if block.err != nil {
+			z.setErr(block.err)
+			continue
+		}
+		if _, err := z.w.Write(block.data); err != nil {
+			z.setErr(err)
+		}
+	}
+}
+
+func (z *ParallelGzipWriter) setErr(err error) {
+	z.Lock()
+	defer z.Unlock()
+	if z.err == nil {
+		z.err = err
+	}
+}
+
+func (z *ParallelGzipWriter) getErr() error {
+	z.Lock()
+	defer z.Unlock()
+	return z.err
+}
+
+// Write buffers p into blocks, and compresses the blocks that are full.
+func (z *ParallelGzipWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errGzipClosed
+	}
+	if err := z.getErr(); err != nil {
+		return 0, err
+	}
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+	n := 0
+	for len(p) > 0 {
+		if z.block == nil {
+			z.block = make([]byte, 0, z.blockSize)
+		}
+		m := copy(z.block[len(z.block):cap(z.block)], p)
+		z.block = z.block[:len(z.block)+m]
+		p = p[m:]
+		n += m
+		if len(z.block) == cap(z.block) {
+			z.compress(false)
+		}
+	}
+	return n, nil
+}
+
+// compress starts compressing the current block, waiting if too many
+// blocks are being compressed already. The last block ends the deflate
+// stream.
+func (z *ParallelGzipWriter) compress(last bool) {
+	block, dict, level := z.block, z.dict, z.level
+	result := make(chan gzipBlock, 1)
+	z.blocks <- result
+	go func() {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriterDict(&buf, level, dict)
+		if err == nil {
+			_, err = fw.Write(block)
+		}
+		if err == nil {
+			if last {
+				err = fw.Close()
+			} else {
+				// Align the block on a byte for the next one to follow
+				err = fw.Flush()
+			}
+		}
+		result <- gzipBlock{data: buf.Bytes(), err: err}
+	}()
+
+	// Blocks are not modified once compressed, so the next one can refer
+	// to the end of this one without a copy.
+	z.dict = block
+	if len(block) > gzipDictSize {
+		z.dict = block[len(block)-gzipDictSize:]
+	}
+	z.block = nil
+}
+
+// Close compresses the last block and writes the end of the gzip stream.
+func (z *ParallelGzipWriter) Close() error {
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	z.compress(true)
+	close(z.blocks)
+	<-z.done
+	if err := z.getErr(); err != nil {
+		return err
+	}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], z.size)
+	_, err := z.w.Write(trailer)
+	return err
+}
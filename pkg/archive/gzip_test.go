@@ -0,0 +1,230 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// compressibleData returns size bytes of text-like data, with repetitions
+// within the window of deflate and random bytes.
+func compressibleData(size int) []byte {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"layer", "image", "docker", "container", "registry", "/usr/lib/", "\n", " ", "0644"}
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		if r.Intn(50) == 0 {
+			b := make([]byte, r.Intn(32))
+			r.Read(b)
+			buf.Write(b)
+		} else {
+			buf.WriteString(words[r.Intn(len(words))])
+		}
+	}
+	return buf.Bytes()[:size]
+}
+
+func parallelGzip(t *testing.T, data []byte, level, blockSize, concurrency int) []byte {
+	var buf bytes.Buffer
+	z, err := newParallelGzipWriter(&buf, level, blockSize, concurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in pieces that do not match the blocks
+	for p := data; len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := z.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParallelGzipWriter(t *testing.T) {
+	data := compressibleData(300 << 10)
+	for _, size := range []int{0, 1, 64 << 10, 100<<10 + 7, len(data)} {
+		for _, level := range []int{gzip.DefaultCompression, gzip.NoCompression, gzip.BestSpeed, gzip.BestCompression} {
+			compressed := parallelGzip(t, data[:size], level, 64<<10, 4)
+			if DetectCompression(compressed) != Gzip {
+				t.Fatalf("Expected gzip output at level %d", level)
+			}
+			r, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The reader checks the size and CRC of the trailer
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("Error reading %d bytes compressed at level %d: %s", size, level, err)
+			}
+			if !bytes.Equal(out, data[:size]) {
+				t.Fatalf("Expected %d bytes compressed at level %d to be read back", size, level)
+			}
+		}
+	}
+}
+
+func TestParallelGzipWriterDeterministic(t *testing.T) {
+	data := compressibleData(300 << 10)
+	expected := parallelGzip(t, data, gzip.DefaultCompression, 64<<10, 1)
+	if compressed := parallelGzip(t, data, gzip.DefaultCompression, 64<<10, 8); !bytes.Equal(compressed, expected) {
+		t.Fatal("Expected the output not to depend on the number of blocks compressed at once")
+	}
+	if len(expected) > len(data)/2 {
+		t.Fatalf("Expected the data to be compressed, got %d bytes out of %d", len(expected), len(data))
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestParallelGzipWriterErrors(t *testing.T) {
+	if _, err := NewParallelGzipWriter(ioutil.Discard, 10); err == nil {
+		t.Fatal("Expected an invalid level to fail")
+	}
+
+	z, err := newParallelGzipWriter(failingWriter{}, gzip.DefaultCompression, 1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := compressibleData(64 << 10)
+	if _, err := io.Copy(z, bytes.NewReader(data)); err != nil && err.Error() != "disk full" {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if err := z.Close(); err == nil || err.Error() != "disk full" {
+		t.Fatalf("Expected the write error to be returned, got %v", err)
+	}
+	if _, err := z.Write(data); err != errGzipClosed {
+		t.Fatalf("Expected writing to a closed writer to fail, got %v", err)
+	}
+}
+
+func TestCompressStreamLevel(t *testing.T) {
+	data := compressibleData(100 << 10)
+	for _, c := range []struct {
+		compression Compression
+		level       int
+	}{
+		{Uncompressed, 0},
+		{Gzip, gzip.BestSpeed},
+		{Gzip, gzip.BestCompression},
+	} {
+		var buf bytes.Buffer
+		w, err := CompressStreamLevel(nopWriteCloser{&buf}, c.compression, c.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if DetectCompression(buf.Bytes()) != c.compression {
+			t.Fatalf("Expected %s output", (&c.compression).Extension())
+		}
+		r, err := DecompressStream(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("Expected the %s stream to be read back", (&c.compression).Extension())
+		}
+	}
+	if _, err := CompressStreamLevel(nopWriteCloser{ioutil.Discard}, Xz, 0); err == nil {
+		t.Fatal("Expected xz compression to be unsupported")
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// benchmarkGzip compresses 16MB of data with the writers of newWriter, and
+// reports the compression ratio along with the throughput.
+func benchmarkGzip(b *testing.B, newWriter func(io.Writer) (io.WriteCloser, error)) {
+	data := compressibleData(16 << 20)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	var size int64
+	for i := 0; i < b.N; i++ {
+		c := &countingWriter{}
+		w, err := newWriter(c)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+		size = c.n
+	}
+	b.ReportMetric(float64(len(data))/float64(size), "ratio")
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func benchmarkGzipWriter(b *testing.B, level int) {
+	benchmarkGzip(b, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	})
+}
+
+func benchmarkParallelGzipWriter(b *testing.B, level int) {
+	benchmarkGzip(b, func(w io.Writer) (io.WriteCloser, error) {
+		return NewParallelGzipWriter(w, level)
+	})
+}
+
+func BenchmarkGzipWriterBestSpeed(b *testing.B) {
+	benchmarkGzipWriter(b, gzip.BestSpeed)
+}
+
+func BenchmarkGzipWriterDefault(b *testing.B) {
+	benchmarkGzipWriter(b, gzip.DefaultCompression)
+}
+
+func BenchmarkGzipWriterBestCompression(b *testing.B) {
+	benchmarkGzipWriter(b, gzip.BestCompression)
+}
+
+func BenchmarkParallelGzipWriterBestSpeed(b *testing.B) {
+	benchmarkParallelGzipWriter(b, gzip.BestSpeed)
+}
+
+func BenchmarkParallelGzipWriterDefault(b *testing.B) {
+	benchmarkParallelGzipWriter(b, gzip.DefaultCompression)
+}
+
+func BenchmarkParallelGzipWriterBestCompression(b *testing.B) {
+	benchmarkParallelGzipWriter(b, gzip.BestCompression)
+}
@@ -0,0 +1,41 @@
+package timeutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetTimestamp parses value as a point in time. value can be a Go duration
+// string such as "10m" or "24h", which is taken as relative to reference,
+// a Unix timestamp with optional fractional seconds, or an RFC 3339 date or
+// prefix of one, such as "2006-01-02" or "2006-01-02T15:04", interpreted in
+// the local time zone unless it includes one.
+func GetTimestamp(value string, reference time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("Invalid timestamp: must not be empty")
+	}
+	if d, err := time.ParseDuration(value); value != "0" && err == nil {
+		return reference.Add(-d), nil
+	}
+
+	if sec, err := strconv.ParseFloat(value, 64); err == nil && !strings.Contains(value, "-") {
+		return time.Unix(int64(sec), int64((sec-float64(int64(sec)))*1e9)), nil
+	}
+
+	format := RFC3339NanoFixed
+	if strings.ContainsAny(value, "Z+") || strings.Count(value, "-") > 2 {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t, nil
+		}
+	}
+	if len(value) < len(format) {
+		format = format[:len(value)]
+	}
+	t, err := time.ParseInLocation(format, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid timestamp %q: must be a duration, a Unix timestamp or an RFC 3339 date", value)
+	}
+	return t, nil
+}
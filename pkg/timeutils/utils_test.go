@@ -0,0 +1,40 @@
+package timeutils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetTimestamp(t *testing.T) {
+	now := time.Date(2015, 3, 10, 12, 30, 0, 0, time.UTC)
+	cases := []struct {
+		in       string
+		expected time.Time
+	}{
+		{"10m", now.Add(-10 * time.Minute)},
+		{"24h", now.Add(-24 * time.Hour)},
+		{"1426000000", time.Unix(1426000000, 0)},
+		{"1426000000.5", time.Unix(1426000000, 5e8)},
+		{"2015-03-01T10:00:00Z", time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{"2015-03-01T10:00:00+02:00", time.Date(2015, 3, 1, 8, 0, 0, 0, time.UTC)},
+		{"2015-03-01T10:00:00-02:00", time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"2015-03-01", time.Date(2015, 3, 1, 0, 0, 0, 0, time.Local)},
+		{"2015-03-01T10:00", time.Date(2015, 3, 1, 10, 0, 0, 0, time.Local)},
+	}
+	for _, c := range cases {
+		ts, err := GetTimestamp(c.in, now)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.in, err)
+			continue
+		}
+		if !ts.Equal(c.expected) {
+			t.Errorf("%s: expected %s, got %s", c.in, c.expected, ts)
+		}
+	}
+
+	for _, in := range []string{"", "yesterday", "2015-13-01"} {
+		if _, err := GetTimestamp(in, now); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
@@ -12,7 +12,12 @@ var (
 
 // IsGitURL returns true if the provided str is a git repository URL.
 func IsGitURL(str string) bool {
-	if IsURL(str) && strings.HasSuffix(str, ".git") {
+	// a fragment selects a ref and a subdirectory, see utils.GitClone
+	repo := str
+	if i := strings.LastIndex(str, "#"); i >= 0 {
+		repo = str[:i]
+	}
+	if IsURL(str) && strings.HasSuffix(repo, ".git") {
 		return true
 	}
 	for _, prefix := range validPrefixes {
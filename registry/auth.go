@@ -9,9 +9,9 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/utils"
@@ -32,43 +32,73 @@ type AuthConfig struct {
 	Auth          string `json:"auth"`
 	Email         string `json:"email"`
 	ServerAddress string `json:"serveraddress,omitempty"`
+	// CredsHelper names the docker-credential-<helper> program which keeps
+	// the username and password instead of the config file.
+	CredsHelper string `json:"credsHelper,omitempty"`
 }
 
 type ConfigFile struct {
 	Configs  map[string]AuthConfig `json:"configs,omitempty"`
 	rootPath string
+	// helperCreds holds the credentials last fetched from or stored with
+	// the credential helpers, so that only the entries that changed are
+	// stored again.
+	helperCreds map[string]AuthConfig
 }
 
 type RequestAuthorization struct {
 	authConfig       *AuthConfig
 	registryEndpoint *Endpoint
-	resource         string
-	scope            string
-	actions          []string
+	scopes           []string
 
-	tokenLock       sync.Mutex
-	tokenCache      string
-	tokenExpiration time.Time
+	tokenLock sync.Mutex
+	// tokenKey is the cache key of the last token used, nil if the
+	// registry does not ask for tokens.
+	tokenKey *tokenCacheKey
 }
 
 func NewRequestAuthorization(authConfig *AuthConfig, registryEndpoint *Endpoint, resource, scope string, actions []string) *RequestAuthorization {
-	return &RequestAuthorization{
+	auth := &RequestAuthorization{
 		authConfig:       authConfig,
 		registryEndpoint: registryEndpoint,
-		resource:         resource,
-		scope:            scope,
-		actions:          actions,
 	}
+	auth.AddScope(resource, scope, actions)
+	return auth
 }
 
-func (auth *RequestAuthorization) getToken() (string, error) {
+// AddScope asks for access to another resource with the same tokens.
+func (auth *RequestAuthorization) AddScope(resource, scope string, actions []string) {
 	auth.tokenLock.Lock()
 	defer auth.tokenLock.Unlock()
-	now := time.Now()
-	if now.Before(auth.tokenExpiration) {
-		log.Debugf("Using cached token for %s", auth.authConfig.Username)
-		return auth.tokenCache, nil
+	newScope := fmt.Sprintf("%s:%s:%s", resource, scope, strings.Join(actions, ","))
+	for _, s := range auth.scopes {
+		if s == newScope {
+			return
+		}
 	}
+	auth.scopes = append(auth.scopes, newScope)
+	sort.Strings(auth.scopes)
+}
+
+// WithScope returns an authorization that also asks for access to another
+// resource, e.g. to pull from the source repository of a cross-repository
+// operation. auth itself is left unchanged, so that the requests sharing it
+// do not get tokens with the extra scope.
+func (auth *RequestAuthorization) WithScope(resource, scope string, actions []string) *RequestAuthorization {
+	auth.tokenLock.Lock()
+	other := &RequestAuthorization{
+		authConfig:       auth.authConfig,
+		registryEndpoint: auth.registryEndpoint,
+		scopes:           append([]string(nil), auth.scopes...),
+	}
+	auth.tokenLock.Unlock()
+	other.AddScope(resource, scope, actions)
+	return other
+}
+
+func (auth *RequestAuthorization) getToken() (string, error) {
+	auth.tokenLock.Lock()
+	defer auth.tokenLock.Unlock()
 
 	client := &http.Client{
 		Transport: &http.Transport{
@@ -83,18 +113,24 @@ func (auth *RequestAuthorization) getToken() (string, error) {
 		case "basic":
 			// no token necessary
 		case "bearer":
-			log.Debugf("Getting bearer token with %s for %s", challenge.Parameters, auth.authConfig.Username)
 			params := map[string]string{}
 			for k, v := range challenge.Parameters {
 				params[k] = v
 			}
-			params["scope"] = fmt.Sprintf("%s:%s:%s", auth.resource, auth.scope, strings.Join(auth.actions, ","))
-			token, err := getToken(auth.authConfig.Username, auth.authConfig.Password, params, auth.registryEndpoint, client, factory)
+			params["scope"] = strings.Join(auth.scopes, " ")
+			key := newTokenCacheKey(params, auth.authConfig)
+			auth.tokenKey = &key
+			if token, ok := tokens.get(key); ok {
+				log.Debugf("Using cached token for %s", auth.authConfig.Username)
+				return token, nil
+			}
+
+			log.Debugf("Getting bearer token with %s for %s", params, auth.authConfig.Username)
+			token, expiresIn, err := getToken(auth.authConfig.Username, auth.authConfig.Password, params, auth.registryEndpoint, client, factory)
 			if err != nil {
 				return "", err
 			}
-			auth.tokenCache = token
-			auth.tokenExpiration = now.Add(time.Minute)
+			tokens.add(key, token, expiresIn)
 
 			return token, nil
 		default:
@@ -102,12 +138,22 @@ func (auth *RequestAuthorization) getToken() (string, error) {
 		}
 	}
 
-	// Do not expire cache since there are no challenges which use a token
-	auth.tokenExpiration = time.Now().Add(time.Hour * 24)
-
 	return "", nil
 }
 
+// invalidate drops the bearer token of req from the cache after the registry
+// rejected it. It returns false if req was not sent with a token.
+func (auth *RequestAuthorization) invalidate(req *http.Request) bool {
+	auth.tokenLock.Lock()
+	defer auth.tokenLock.Unlock()
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if auth.tokenKey == nil || token == req.Header.Get("Authorization") {
+		return false
+	}
+	tokens.remove(*auth.tokenKey, token)
+	return true
+}
+
 func (auth *RequestAuthorization) Authorize(req *http.Request) error {
 	token, err := auth.getToken()
 	if err != nil {
@@ -187,9 +233,13 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 		configFile.Configs[IndexServerAddress()] = authConfig
 	} else {
 		for k, authConfig := range configFile.Configs {
-			authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
-			if err != nil {
-				return &configFile, err
+			// The credentials kept by a helper are fetched by GetAuthConfig
+			// once they are needed
+			if authConfig.CredsHelper == "" {
+				authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
+				if err != nil {
+					return &configFile, err
+				}
 			}
 			authConfig.Auth = ""
 			authConfig.ServerAddress = k
@@ -211,7 +261,19 @@ func SaveConfig(configFile *ConfigFile) error {
 	for k, authConfig := range configFile.Configs {
 		authCopy := authConfig
 
-		authCopy.Auth = encodeAuth(&authCopy)
+		if authCopy.CredsHelper != "" {
+			stored := configFile.helperCreds[k]
+			if authCopy.Username != "" && (authCopy.Username != stored.Username || authCopy.Password != stored.Password) {
+				authCopy.ServerAddress = k
+				if err := storeCredentials(authCopy.CredsHelper, &authCopy); err != nil {
+					return err
+				}
+				configFile.setHelperCreds(k, authCopy)
+			}
+			authCopy.Auth = ""
+		} else {
+			authCopy.Auth = encodeAuth(&authCopy)
+		}
 		authCopy.Username = ""
 		authCopy.Password = ""
 		authCopy.ServerAddress = ""
@@ -229,6 +291,71 @@ func SaveConfig(configFile *ConfigFile) error {
 	return nil
 }
 
+// RemoveAuthConfig forgets the credentials of serverAddress. Entries using a
+// credential helper are kept so later logins still go through the helper.
+func RemoveAuthConfig(configFile *ConfigFile, serverAddress string) error {
+	authConfig, ok := configFile.Configs[serverAddress]
+	if !ok {
+		return nil
+	}
+	if authConfig.CredsHelper == "" {
+		delete(configFile.Configs, serverAddress)
+		return nil
+	}
+	if err := eraseCredentials(authConfig.CredsHelper, serverAddress); err != nil {
+		return err
+	}
+	authConfig.Username = ""
+	authConfig.Password = ""
+	configFile.Configs[serverAddress] = authConfig
+	configFile.setHelperCreds(serverAddress, authConfig)
+	return nil
+}
+
+// GetAuthConfig returns the auth config of key. The credentials kept by a
+// credential helper are fetched the first time they are needed, so that
+// only the helpers of the registries in use are run.
+func (config *ConfigFile) GetAuthConfig(key string) AuthConfig {
+	authConfig, ok := config.Configs[key]
+	if !ok || authConfig.CredsHelper == "" || authConfig.Username != "" {
+		return authConfig
+	}
+	if _, fetched := config.helperCreds[key]; fetched {
+		return authConfig
+	}
+	username, password, err := getCredentials(authConfig.CredsHelper, key)
+	if err != nil && err != errCredentialsNotFound {
+		log.Warnf("Error getting the credentials of %s: %s", key, err)
+		return authConfig
+	}
+	authConfig.Username = username
+	authConfig.Password = password
+	config.Configs[key] = authConfig
+	config.setHelperCreds(key, authConfig)
+	return authConfig
+}
+
+// AllCredentials returns a copy of config with the credentials of every
+// registry, for the daemon which may need any of them, e.g. to pull the
+// images of a build. The copy does not refer to the helpers, which only
+// run on the client.
+func (config *ConfigFile) AllCredentials() *ConfigFile {
+	all := &ConfigFile{Configs: make(map[string]AuthConfig, len(config.Configs))}
+	for k := range config.Configs {
+		authConfig := config.GetAuthConfig(k)
+		authConfig.CredsHelper = ""
+		all.Configs[k] = authConfig
+	}
+	return all
+}
+
+func (config *ConfigFile) setHelperCreds(key string, authConfig AuthConfig) {
+	if config.helperCreds == nil {
+		config.helperCreds = make(map[string]AuthConfig)
+	}
+	config.helperCreds[key] = authConfig
+}
+
 // Login tries to register/login to the registry server.
 func Login(authConfig *AuthConfig, registryEndpoint *Endpoint, factory *utils.HTTPRequestFactory) (string, error) {
 	// Separates the v2 registry login logic from the v1 logic.
@@ -422,7 +549,7 @@ func tryV2BasicAuthLogin(authConfig *AuthConfig, params map[string]string, regis
 }
 
 func tryV2TokenAuthLogin(authConfig *AuthConfig, params map[string]string, registryEndpoint *Endpoint, client *http.Client, factory *utils.HTTPRequestFactory) error {
-	token, err := getToken(authConfig.Username, authConfig.Password, params, registryEndpoint, client, factory)
+	token, _, err := getToken(authConfig.Username, authConfig.Password, params, registryEndpoint, client, factory)
 	if err != nil {
 		return err
 	}
@@ -451,8 +578,8 @@ func tryV2TokenAuthLogin(authConfig *AuthConfig, params map[string]string, regis
 func (config *ConfigFile) ResolveAuthConfig(index *IndexInfo) AuthConfig {
 	configKey := index.GetAuthConfigKey()
 	// First try the happy case
-	if c, found := config.Configs[configKey]; found || index.Official {
-		return c
+	if _, found := config.Configs[configKey]; found || index.Official {
+		return config.GetAuthConfig(configKey)
 	}
 
 	convertToHostname := func(url string) string {
@@ -470,9 +597,9 @@ func (config *ConfigFile) ResolveAuthConfig(index *IndexInfo) AuthConfig {
 
 	// Maybe they have a legacy config file, we will iterate the keys converting
 	// them to the new format and testing
-	for registry, config := range config.Configs {
+	for registry := range config.Configs {
 		if configKey == convertToHostname(registry) {
-			return config
+			return config.GetAuthConfig(registry)
 		}
 	}
 
@@ -48,7 +48,7 @@ func IndexServerName() string {
 // the current process.
 func (options *Options) InstallFlags() {
 	options.Mirrors = opts.NewListOpts(ValidateMirror)
-	flag.Var(&options.Mirrors, []string{"-registry-mirror"}, "Preferred Docker registry mirror")
+	flag.Var(&options.Mirrors, []string{"-registry-mirror"}, "Preferred registry mirror ([host=]URL)")
 	options.InsecureRegistries = opts.NewListOpts(ValidateIndexName)
 	flag.Var(&options.InsecureRegistries, []string{"-insecure-registry"}, "Enable insecure registry communication")
 }
@@ -74,6 +74,9 @@ func (ipnet *netIPNet) UnmarshalJSON(b []byte) (err error) {
 type ServiceConfig struct {
 	InsecureRegistryCIDRs []*netIPNet           `json:"InsecureRegistryCIDRs"`
 	IndexConfigs          map[string]*IndexInfo `json:"IndexConfigs"`
+	// Mirrors holds the mirrors of the registries other than the official
+	// index, in the order they are tried.
+	Mirrors map[string][]string `json:"Mirrors"`
 }
 
 // NewServiceConfig returns a new instance of ServiceConfig
@@ -95,6 +98,18 @@ func NewServiceConfig(options *Options) *ServiceConfig {
 	config := &ServiceConfig{
 		InsecureRegistryCIDRs: make([]*netIPNet, 0),
 		IndexConfigs:          make(map[string]*IndexInfo, 0),
+		Mirrors:               make(map[string][]string),
+	}
+	// Split --registry-mirror into mirrors of the official index and
+	// mirrors of other registries.
+	officialMirrors := make([]string, 0)
+	for _, m := range options.Mirrors.GetAll() {
+		indexName, mirror := splitMirror(m)
+		if indexName == "" {
+			officialMirrors = append(officialMirrors, mirror)
+			continue
+		}
+		config.Mirrors[indexName] = append(config.Mirrors[indexName], mirror)
 	}
 	// Split --insecure-registry into CIDR and registry-specific settings.
 	for _, r := range options.InsecureRegistries.GetAll() {
@@ -107,7 +122,7 @@ func NewServiceConfig(options *Options) *ServiceConfig {
 			// Assume `host:port` if not CIDR.
 			config.IndexConfigs[r] = &IndexInfo{
 				Name:     r,
-				Mirrors:  make([]string, 0),
+				Mirrors:  config.mirrorsFor(r),
 				Secure:   false,
 				Official: false,
 			}
@@ -117,7 +132,7 @@ func NewServiceConfig(options *Options) *ServiceConfig {
 	// Configure public registry.
 	config.IndexConfigs[IndexServerName()] = &IndexInfo{
 		Name:     IndexServerName(),
-		Mirrors:  options.Mirrors.GetAll(),
+		Mirrors:  officialMirrors,
 		Secure:   true,
 		Official: true,
 	}
@@ -174,22 +189,69 @@ func (config *ServiceConfig) isSecureIndex(indexName string) bool {
 	return true
 }
 
-// ValidateMirror validates an HTTP(S) registry mirror
+// mirrorsFor returns the mirrors of the registry indexName, which is not the
+// official index.
+func (config *ServiceConfig) mirrorsFor(indexName string) []string {
+	mirrors := make([]string, len(config.Mirrors[indexName]))
+	copy(mirrors, config.Mirrors[indexName])
+	return mirrors
+}
+
+// splitMirror splits a mirror validated by ValidateMirror into the registry
+// it mirrors and its URL. The registry is empty for mirrors of the official
+// index.
+func splitMirror(val string) (string, string) {
+	parts := strings.SplitN(val, "=", 2)
+	if len(parts) == 1 {
+		return "", val
+	}
+	return parts[0], parts[1]
+}
+
+// ValidateMirror validates an HTTP(S) registry mirror. Mirrors of the
+// official index are given as a URL. Mirrors of other registries are given as
+// registry=URL, and mirror the whole registry instead of its layers only.
 func ValidateMirror(val string) (string, error) {
+	if parts := strings.SplitN(val, "=", 2); len(parts) == 2 {
+		indexName, err := ValidateIndexName(parts[0])
+		if err != nil {
+			return "", err
+		}
+		if indexName == "" || strings.Contains(indexName, "/") {
+			return "", fmt.Errorf("Invalid registry %q for mirror %s", parts[0], parts[1])
+		}
+		if indexName == IndexServerName() {
+			return ValidateMirror(parts[1])
+		}
+		uri, err := validateMirrorURL(parts[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s=%s://%s", indexName, uri.Scheme, uri.Host), nil
+	}
+
+	uri, err := validateMirrorURL(val)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s/v1/", uri.Scheme, uri.Host), nil
+}
+
+func validateMirrorURL(val string) (*url.URL, error) {
 	uri, err := url.Parse(val)
 	if err != nil {
-		return "", fmt.Errorf("%s is not a valid URI", val)
+		return nil, fmt.Errorf("%s is not a valid URI", val)
 	}
 
 	if uri.Scheme != "http" && uri.Scheme != "https" {
-		return "", fmt.Errorf("Unsupported scheme %s", uri.Scheme)
+		return nil, fmt.Errorf("Unsupported scheme %s", uri.Scheme)
 	}
 
 	if uri.Path != "" || uri.RawQuery != "" || uri.Fragment != "" {
-		return "", fmt.Errorf("Unsupported path/query/fragment at end of the URI")
+		return nil, fmt.Errorf("Unsupported path/query/fragment at end of the URI")
 	}
 
-	return fmt.Sprintf("%s://%s/v1/", uri.Scheme, uri.Host), nil
+	return uri, nil
 }
 
 // ValidateIndexName validates an index name.
@@ -275,7 +337,7 @@ func (config *ServiceConfig) NewIndexInfo(indexName string) (*IndexInfo, error)
 	// Construct a non-configured index info.
 	index := &IndexInfo{
 		Name:     indexName,
-		Mirrors:  make([]string, 0),
+		Mirrors:  config.mirrorsFor(indexName),
 		Official: false,
 	}
 	index.Secure = config.isSecureIndex(indexName)
@@ -371,6 +433,12 @@ func ParseRepositoryInfo(reposName string) (*RepositoryInfo, error) {
 	return emptyServiceConfig.NewRepositoryInfo(reposName)
 }
 
+// ParseIndexInfo returns the IndexInfo of indexName, but lacks registry
+// configuration.
+func ParseIndexInfo(indexName string) (*IndexInfo, error) {
+	return emptyServiceConfig.NewIndexInfo(indexName)
+}
+
 // NormalizeLocalName transforms a repository name into a normalize LocalName
 // Passes through the name without transformation on error (image id, etc)
 func NormalizeLocalName(name string) string {
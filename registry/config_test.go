@@ -47,3 +47,69 @@ func TestValidateMirror(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateRegistryMirror(t *testing.T) {
+	valid := map[string]string{
+		"registry.corp:5000=https://mirror-1.com":    "registry.corp:5000=https://mirror-1.com",
+		"registry.corp=http://localhost:5000":        "registry.corp=http://localhost:5000",
+		"docker.io=https://mirror-1.com":             "https://mirror-1.com/v1/",
+		"index.docker.io=https://mirror-1.com":       "https://mirror-1.com/v1/",
+		"127.0.0.1:5000=https://mirror-1.com:5000":   "127.0.0.1:5000=https://mirror-1.com:5000",
+		"registry.corp:5000=https://127.0.0.1:12345": "registry.corp:5000=https://127.0.0.1:12345",
+	}
+
+	invalid := []string{
+		"=https://mirror-1.com",
+		"registry.corp=",
+		"registry.corp=ftp://mirror-1.com",
+		"registry.corp=https://mirror-1.com/v2/",
+		"https://registry.corp/=https://mirror-1.com",
+	}
+
+	for address, expected := range valid {
+		if ret, err := ValidateMirror(address); err != nil || ret != expected {
+			t.Errorf("ValidateMirror(`%s`) got %s %v, expected %s", address, ret, err, expected)
+		}
+	}
+
+	for _, address := range invalid {
+		if ret, err := ValidateMirror(address); err == nil || ret != "" {
+			t.Errorf("ValidateMirror(`%s`) got %s %v", address, ret, err)
+		}
+	}
+}
+
+func TestNewServiceConfigRegistryMirrors(t *testing.T) {
+	config := makeServiceConfig([]string{
+		"https://hub-mirror.com/v1/",
+		"registry.corp:5000=https://mirror-1.com",
+		"insecure.corp=http://mirror-3.com",
+		"registry.corp:5000=https://mirror-2.com",
+	}, []string{"insecure.corp"})
+
+	expected := map[string][]string{
+		IndexServerName():    {"https://hub-mirror.com/v1/"},
+		"registry.corp:5000": {"https://mirror-1.com", "https://mirror-2.com"},
+		"insecure.corp":      {"http://mirror-3.com"},
+		"other.corp":         {},
+	}
+	for indexName, mirrors := range expected {
+		index, err := config.NewIndexInfo(indexName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(index.Mirrors) != len(mirrors) {
+			t.Fatalf("Expected mirrors %v for %s, got %v", mirrors, indexName, index.Mirrors)
+		}
+		for i := range mirrors {
+			if index.Mirrors[i] != mirrors[i] {
+				t.Fatalf("Expected mirrors %v for %s, got %v", mirrors, indexName, index.Mirrors)
+			}
+		}
+	}
+
+	// Mirrors do not change the security of the registry they mirror
+	if index, _ := config.NewIndexInfo("insecure.corp"); index.Secure {
+		t.Fatal("Expected insecure.corp to stay insecure")
+	}
+}
@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Credential helpers are programs named docker-credential-<helper> which
+// keep registry credentials out of the config file. They are run with the
+// action as their only argument and talk JSON on stdin and stdout:
+//
+//	store: reads {"ServerURL": ..., "Username": ..., "Secret": ...}
+//	get:   reads the server address, writes {"Username": ..., "Secret": ...}
+//	erase: reads the server address
+//
+// On failure a helper exits with a non-zero status and writes the error
+// message on stdout.
+const credsHelperPrefix = "docker-credential-"
+
+// credsHelperNotFound is the message of helpers without credentials for a
+// server address.
+const credsHelperNotFound = "credentials not found in native keychain"
+
+var errCredentialsNotFound = errors.New("credentials not found")
+
+type helperCredentials struct {
+	ServerURL string `json:"ServerURL,omitempty"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// runCredsHelper runs the action of helper with input on its stdin and
+// returns what it wrote on stdout.
+func runCredsHelper(helper, action string, input []byte) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(credsHelperPrefix+helper, action)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String())
+		if msg == credsHelperNotFound {
+			return nil, errCredentialsNotFound
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("error running %s%s %s: %s", credsHelperPrefix, helper, action, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// storeCredentials hands the username and password of authConfig to helper.
+func storeCredentials(helper string, authConfig *AuthConfig) error {
+	b, err := json.Marshal(helperCredentials{
+		ServerURL: authConfig.ServerAddress,
+		Username:  authConfig.Username,
+		Secret:    authConfig.Password,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = runCredsHelper(helper, "store", b)
+	return err
+}
+
+// getCredentials returns the username and password helper keeps for
+// serverAddress, or errCredentialsNotFound.
+func getCredentials(helper, serverAddress string) (string, string, error) {
+	out, err := runCredsHelper(helper, "get", []byte(serverAddress))
+	if err != nil {
+		return "", "", err
+	}
+	var creds helperCredentials
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", "", fmt.Errorf("invalid output of %s%s get: %s", credsHelperPrefix, helper, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// eraseCredentials makes helper forget the credentials of serverAddress.
+func eraseCredentials(helper, serverAddress string) error {
+	_, err := runCredsHelper(helper, "erase", []byte(serverAddress))
+	if err == errCredentialsNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubCredsHelper keeps the credentials of one server in a file next to it.
+const stubCredsHelper = `#!/bin/sh
+store="$(dirname "$0")/creds"
+case "$1" in
+store)
+	cat > "$store"
+	;;
+get)
+	if [ ! -f "$store" ]; then
+		echo "credentials not found in native keychain"
+		exit 1
+	fi
+	cat "$store"
+	;;
+erase)
+	rm -f "$store"
+	;;
+*)
+	echo "unknown action: $1"
+	exit 1
+	;;
+esac
+`
+
+// setupCredsHelper installs docker-credential-stub in PATH and returns the
+// file where it keeps credentials.
+func setupCredsHelper(t *testing.T, dir string) (string, func()) {
+	helper := filepath.Join(dir, credsHelperPrefix+"stub")
+	if err := ioutil.WriteFile(helper, []byte(stubCredsHelper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return filepath.Join(dir, "creds"), func() { os.Setenv("PATH", path) }
+}
+
+func TestCredsHelper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-test-creds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, restore := setupCredsHelper(t, dir)
+	defer restore()
+
+	if _, _, err := getCredentials("stub", "registry.example.com"); err != errCredentialsNotFound {
+		t.Fatalf("Expected %q, got %v", errCredentialsNotFound, err)
+	}
+	authConfig := &AuthConfig{Username: "docker-user", Password: "docker-pass", ServerAddress: "registry.example.com"}
+	if err := storeCredentials("stub", authConfig); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"ServerURL":"registry.example.com","Username":"docker-user","Secret":"docker-pass"}` {
+		t.Fatalf("Unexpected stored credentials: %s", b)
+	}
+	username, password, err := getCredentials("stub", "registry.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "docker-user" || password != "docker-pass" {
+		t.Fatalf("Expected docker-user:docker-pass, got %s:%s", username, password)
+	}
+	if err := eraseCredentials("stub", "registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Fatal("Expected the credentials to be erased")
+	}
+
+	if _, err := runCredsHelper("stub", "list", nil); err == nil || !strings.Contains(err.Error(), "unknown action: list") {
+		t.Fatalf("Expected the helper's error message, got %v", err)
+	}
+	if _, _, err := getCredentials("missing", "registry.example.com"); err == nil {
+		t.Fatal("Expected an error for a missing helper")
+	}
+}
+
+func TestConfigFileCredsHelper(t *testing.T) {
+	configFile, err := setupTempConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configFile.rootPath)
+	store, restore := setupCredsHelper(t, configFile.rootPath)
+	defer restore()
+
+	configFile.Configs["testIndex"] = AuthConfig{
+		Username:    "helper-user",
+		Password:    "helper-pass",
+		Email:       "helper@docker.io",
+		CredsHelper: "stub",
+	}
+	if err := SaveConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(configFile.rootPath, CONFIGFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), encodeAuth(&AuthConfig{Username: "helper-user", Password: "helper-pass"})) {
+		t.Fatal("Expected the credentials of testIndex not to be in the config file")
+	}
+
+	// The helper is only run for the registries in use
+	loaded, err := LoadConfig(configFile.rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authConfig := loaded.Configs["testIndex"]; authConfig.Username != "" {
+		t.Fatalf("Expected the credentials of testIndex not to be fetched yet, got %+v", authConfig)
+	}
+	authConfig := loaded.GetAuthConfig("testIndex")
+	if authConfig.Username != "helper-user" || authConfig.Password != "helper-pass" || authConfig.Email != "helper@docker.io" {
+		t.Fatalf("Unexpected auth config loaded through the helper: %+v", authConfig)
+	}
+	if authConfig := loaded.GetAuthConfig(IndexServerAddress()); authConfig.Username != "docker-user" {
+		t.Fatalf("Expected docker-user for the index, got %q", authConfig.Username)
+	}
+
+	// Only the credentials that changed are stored again
+	if err := os.Remove(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Fatal("Expected the unchanged credentials not to be stored again")
+	}
+	authConfig.Password = "new-pass"
+	loaded.Configs["testIndex"] = authConfig
+	if err := SaveConfig(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(store); err != nil || !strings.Contains(string(b), "new-pass") {
+		t.Fatalf("Expected the new password to be stored, got %q (%v)", b, err)
+	}
+
+	// The daemon gets the credentials without the helper
+	all := loaded.AllCredentials()
+	if authConfig := all.Configs["testIndex"]; authConfig.Password != "new-pass" || authConfig.CredsHelper != "" {
+		t.Fatalf("Unexpected auth config for the daemon: %+v", authConfig)
+	}
+
+	// Logging out erases the credentials but keeps the helper
+	if err := RemoveAuthConfig(loaded, "testIndex"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Fatal("Expected the credentials to be erased")
+	}
+	if err := SaveConfig(loaded); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = LoadConfig(configFile.rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authConfig := loaded.Configs["testIndex"]; authConfig.CredsHelper != "stub" || authConfig.Username != "" {
+		t.Fatalf("Expected an empty entry using the helper, got %+v", authConfig)
+	}
+}
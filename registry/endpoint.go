@@ -55,6 +55,25 @@ func NewEndpoint(index *IndexInfo) (*Endpoint, error) {
 	return endpoint, nil
 }
 
+// NewMirrorEndpoint returns the endpoint of a mirror served at index with
+// scheme, "http" or "https" as given with --registry-mirror. Unlike other
+// endpoints, it does not fall back to the other scheme. The certificate of
+// an https mirror is verified unless index is insecure.
+func NewMirrorEndpoint(index *IndexInfo, scheme string) (*Endpoint, error) {
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("Unsupported scheme %s", scheme)
+	}
+	endpoint, err := newEndpoint(scheme+"://"+index.Name, index.Secure)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("pinging registry mirror %s", endpoint)
+	if _, err := endpoint.Ping(); err != nil {
+		return nil, fmt.Errorf("invalid registry mirror %s: %v", endpoint, err)
+	}
+	return endpoint, nil
+}
+
 func validateEndpoint(endpoint *Endpoint) error {
 	log.Debugf("pinging registry endpoint %s", endpoint)
 
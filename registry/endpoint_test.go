@@ -90,3 +90,47 @@ func TestValidateEndpointAmbiguousAPIVersion(t *testing.T) {
 		t.Fatalf("expected endpoint to validate to %d, got %d", APIVersion2, testEndpoint.Version)
 	}
 }
+
+func TestNewMirrorEndpoint(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Docker-Distribution-API-Version", "registry/2.0")
+	})
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	mirror := func(server *httptest.Server, secure bool) *IndexInfo {
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &IndexInfo{Name: u.Host, Secure: secure}
+	}
+
+	// An http mirror is used without TLS, even if it is not insecure
+	endpoint, err := NewMirrorEndpoint(mirror(httpServer, true), "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.URL.Scheme != "http" || endpoint.Version != APIVersion2 {
+		t.Fatalf("Expected an http v2 endpoint, got %s", endpoint)
+	}
+
+	// An https mirror never falls back to http
+	if _, err := NewMirrorEndpoint(mirror(httpServer, false), "https"); err == nil {
+		t.Fatal("Expected an https mirror served over http to fail")
+	}
+
+	// The certificate of an https mirror is verified unless it is insecure
+	if _, err := NewMirrorEndpoint(mirror(tlsServer, true), "https"); err == nil {
+		t.Fatal("Expected the unknown certificate of the mirror to be refused")
+	}
+	endpoint, err = NewMirrorEndpoint(mirror(tlsServer, false), "https")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.URL.Scheme != "https" {
+		t.Fatalf("Expected an https endpoint, got %s", endpoint)
+	}
+}
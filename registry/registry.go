@@ -18,9 +18,10 @@ import (
 )
 
 var (
-	ErrAlreadyExists = errors.New("Image already exists")
-	ErrDoesNotExist  = errors.New("Image does not exist")
-	errLoginRequired = errors.New("Authentication is required.")
+	ErrAlreadyExists    = errors.New("Image already exists")
+	ErrDoesNotExist     = errors.New("Image does not exist")
+	ErrDeleteNotAllowed = errors.New("The registry does not allow deleting manifests")
+	errLoginRequired    = errors.New("Authentication is required.")
 )
 
 type TimeoutType uint32
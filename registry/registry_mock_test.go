@@ -310,6 +310,11 @@ func handlerGetImage(w http.ResponseWriter, r *http.Request) {
 	writeHeaders(w)
 	layerSize := len(layer["layer"])
 	w.Header().Add("X-Docker-Size", strconv.Itoa(layerSize))
+	if vars["action"] == "layer" {
+		// Supports resuming downloads with ranges
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(layer["layer"]))
+		return
+	}
 	io.WriteString(w, layer[vars["action"]])
 }
 
@@ -2,7 +2,10 @@ package registry
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
@@ -221,6 +224,75 @@ func TestGetRemoteImageLayer(t *testing.T) {
 	}
 }
 
+func TestGetRemoteImageLayerAt(t *testing.T) {
+	r := spawnTestRegistrySession(t)
+	layer := testLayers[imageID]["layer"]
+	for _, offset := range []int64{0, 5, int64(len(layer))} {
+		body, start, err := r.GetRemoteImageLayerAt(imageID, makeURL("/v1/"), token, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start != offset || string(data) != layer[offset:] {
+			t.Fatalf("Expected %d bytes from %d, got %d bytes from %d", len(layer)-int(offset), offset, len(data), start)
+		}
+	}
+
+	if _, _, err := r.GetRemoteImageLayerAt("abcdef", makeURL("/v1/"), token, 0); err == nil {
+		t.Fatal("Expected image not found error")
+	}
+}
+
+func TestGetRemoteImageLayerAtWrongRange(t *testing.T) {
+	const layer = "0123456789"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Ignore the offset and always send the content from the start
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(layer)-1, len(layer)))
+			w.WriteHeader(206)
+		}
+		io.WriteString(w, layer)
+	}))
+	defer ts.Close()
+
+	r := spawnTestRegistrySession(t)
+	body, start, err := r.GetRemoteImageLayerAt(imageID, ts.URL+"/v1/", token, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 0 || string(data) != layer {
+		t.Fatalf("Expected the whole layer from 0, got %q from %d", data, start)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for header, expected := range map[string][2]int64{
+		"bytes 0-99/200":    {0, 200},
+		"bytes 100-199/200": {100, 200},
+		"bytes */200":       {0, 200},
+	} {
+		start, size, err := parseContentRange(header)
+		if err != nil {
+			t.Fatalf("%s: %s", header, err)
+		}
+		if start != expected[0] || size != expected[1] {
+			t.Fatalf("%s: expected %v, got [%d %d]", header, expected, start, size)
+		}
+	}
+	if _, _, err := parseContentRange("bytes 0-99"); err == nil {
+		t.Fatal("Expected an error for an invalid Content-Range")
+	}
+}
+
 func TestGetRemoteTags(t *testing.T) {
 	r := spawnTestRegistrySession(t)
 	tags, err := r.GetRemoteTags([]string{makeURL("/v1/")}, REPO, token)
@@ -1,6 +1,9 @@
 package registry
 
 import (
+	"sort"
+	"strings"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/engine"
 )
@@ -11,6 +14,8 @@ import (
 //
 //  'auth': Authenticate against the public registry
 //  'search': Search for images on the public registry
+//  'catalog': List the repositories of a v2 registry
+//  'remote_tags': List the tags of a repository on a v2 registry
 //  'pull': Download images from any registry (TODO)
 //  'push': Upload images to any registry (TODO)
 type Service struct {
@@ -29,6 +34,8 @@ func NewService(options *Options) *Service {
 func (s *Service) Install(eng *engine.Engine) error {
 	eng.Register("auth", s.Auth)
 	eng.Register("search", s.Search)
+	eng.Register("catalog", s.Catalog)
+	eng.Register("remote_tags", s.RemoteTags)
 	eng.Register("resolve_repository", s.ResolveRepository)
 	eng.Register("resolve_index", s.ResolveIndex)
 	eng.Register("registry_config", s.GetRegistryConfig)
@@ -135,6 +142,142 @@ func (s *Service) Search(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+// Catalog lists the repositories of a v2 registry whose name contains the
+// search term.
+//
+// Argument syntax: catalog REGISTRY
+//
+// Option environment:
+//	'term': only list the repositories whose name contains the term.
+//
+//	'authConfig': json-encoded credentials to authenticate against the registry.
+//
+//	'metaHeaders': extra HTTP headers to include in the request to the registry.
+//		The headers should be passed as a json-encoded dictionary.
+//
+// Output:
+//	Each repository is sent as a separate message (using engine.Table), with
+//	its full 'name' including the registry, in alphabetical order.
+func (s *Service) Catalog(job *engine.Job) engine.Status {
+	if n := len(job.Args); n != 1 {
+		return job.Errorf("Usage: %s REGISTRY", job.Name)
+	}
+	var (
+		term        = job.Getenv("term")
+		metaHeaders = map[string][]string{}
+		authConfig  = &AuthConfig{}
+	)
+	job.GetenvJson("authConfig", authConfig)
+	job.GetenvJson("metaHeaders", &metaHeaders)
+
+	index, err := s.Config.NewIndexInfo(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	if index.Official {
+		return job.Errorf("%s does not list its repositories, search it with a term instead", index.Name)
+	}
+	endpoint, err := NewEndpoint(index)
+	if err != nil {
+		return job.Error(err)
+	}
+	r, err := NewSession(authConfig, HTTPRequestFactory(metaHeaders), endpoint, true)
+	if err != nil {
+		return job.Error(err)
+	}
+	endpoint, err = r.V2RegistryEndpoint(index)
+	if err != nil {
+		return job.Errorf("Listing repositories requires a v2 registry: %s", err)
+	}
+	auth, err := r.GetV2CatalogAuthorization(endpoint)
+	if err != nil {
+		return job.Errorf("error getting authorization: %s", err)
+	}
+	repositories, err := r.GetV2Catalog(endpoint, auth)
+	if err != nil {
+		return job.Errorf("Error listing the repositories of %s: %s", index.Name, err)
+	}
+
+	sort.Strings(repositories)
+	outs := engine.NewTable("", len(repositories))
+	for _, name := range repositories {
+		if !strings.Contains(name, term) {
+			continue
+		}
+		out := &engine.Env{}
+		out.Set("name", index.Name+"/"+name)
+		outs.Add(out)
+	}
+	if _, err := outs.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// RemoteTags lists the tags of a repository on a v2 registry.
+//
+// Argument syntax: remote_tags NAME
+//
+// Option environment:
+//	'authConfig': json-encoded credentials to authenticate against the registry.
+//
+//	'metaHeaders': extra HTTP headers to include in the request to the registry.
+//		The headers should be passed as a json-encoded dictionary.
+//
+// Output:
+//	Each tag is sent as a separate message (using engine.Table), with its
+//	'Tag', in alphabetical order.
+func (s *Service) RemoteTags(job *engine.Job) engine.Status {
+	if n := len(job.Args); n != 1 {
+		return job.Errorf("Usage: %s NAME", job.Name)
+	}
+	var (
+		metaHeaders = map[string][]string{}
+		authConfig  = &AuthConfig{}
+	)
+	job.GetenvJson("authConfig", authConfig)
+	job.GetenvJson("metaHeaders", &metaHeaders)
+
+	repoInfo, err := s.Config.NewRepositoryInfo(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	endpoint, err := repoInfo.GetEndpoint()
+	if err != nil {
+		return job.Error(err)
+	}
+	r, err := NewSession(authConfig, HTTPRequestFactory(metaHeaders), endpoint, true)
+	if err != nil {
+		return job.Error(err)
+	}
+	endpoint, err = r.V2RegistryEndpoint(repoInfo.Index)
+	if err != nil {
+		return job.Errorf("Listing tags requires a v2 registry: %s", err)
+	}
+	auth, err := r.GetV2Authorization(endpoint, repoInfo.RemoteName, true)
+	if err != nil {
+		return job.Errorf("error getting authorization: %s", err)
+	}
+	tags, err := r.GetV2RemoteTags(endpoint, repoInfo.RemoteName, auth)
+	if err == ErrDoesNotExist {
+		return job.Errorf("No such repository: %s", repoInfo.CanonicalName)
+	} else if err != nil {
+		return job.Errorf("Error listing the tags of %s: %s", repoInfo.CanonicalName, err)
+	}
+
+	sort.Strings(tags)
+	outs := engine.NewTable("", len(tags))
+	for _, tag := range tags {
+		out := &engine.Env{}
+		out.Set("Tag", tag)
+		outs.Add(out)
+	}
+	if _, err := outs.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
 // ResolveRepository splits a repository name into its components
 // and configuration of the associated registry.
 func (s *Service) ResolveRepository(job *engine.Job) engine.Status {
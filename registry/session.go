@@ -68,6 +68,60 @@ func (r *Session) doRequest(req *http.Request) (*http.Response, *http.Client, er
 	return doRequest(req, r.jar, r.timeout, r.indexEndpoint.IsSecure)
 }
 
+// doRangeRequest sends req asking for the content from offset on. It returns
+// the response and the offset its body starts at, which is 0 if the server
+// does not support ranges. If offset is already the size of the content, the
+// response has an empty body starting at offset.
+func (r *Session) doRangeRequest(req *http.Request, offset int64) (*http.Response, int64, error) {
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, _, err := r.doRequest(req)
+	if err != nil || offset == 0 {
+		return res, 0, err
+	}
+	switch res.StatusCode {
+	case 206:
+		if start, _, err := parseContentRange(res.Header.Get("Content-Range")); err == nil && start == offset {
+			return res, offset, nil
+		}
+		// Appending a range that does not start at offset would corrupt
+		// the content
+		log.Debugf("Unexpected Content-Range %q for offset %d, restarting from 0", res.Header.Get("Content-Range"), offset)
+	case 416:
+		if _, size, _ := parseContentRange(res.Header.Get("Content-Range")); size == offset {
+			res.Body.Close()
+			res.StatusCode = 206
+			res.Body = ioutil.NopCloser(bytes.NewReader(nil))
+			return res, offset, nil
+		}
+		// The content changed since the first bytes were downloaded
+	default:
+		return res, 0, nil
+	}
+	res.Body.Close()
+	req.Header.Del("Range")
+	res, _, err = r.doRequest(req)
+	return res, 0, err
+}
+
+// parseContentRange parses a Content-Range header such as "bytes 0-99/200"
+// or "bytes */200", and returns the first byte and the size of the content.
+func parseContentRange(header string) (int64, int64, error) {
+	var (
+		start int64
+		size  int64
+	)
+	if _, err := fmt.Sscanf(header, "bytes */%d", &size); err == nil {
+		return 0, size, nil
+	}
+	var end int64
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return 0, 0, fmt.Errorf("Invalid Content-Range %q", header)
+	}
+	return start, size, nil
+}
+
 // Retrieve the history of a given image from the Registry.
 // Return a list of the parent's json (requested image included)
 func (r *Session) GetRemoteHistory(imgID, registry string, token []string) ([]string, error) {
@@ -200,6 +254,28 @@ func (r *Session) GetRemoteImageLayer(imgID, registry string, token []string, im
 	return res.Body, nil
 }
 
+// GetRemoteImageLayerAt fetches the layer of an image from offset on. It
+// returns the layer and the offset it starts at, which is 0 if the registry
+// cannot resume downloads. Unlike GetRemoteImageLayer, it does not retry.
+func (r *Session) GetRemoteImageLayerAt(imgID, registry string, token []string, offset int64) (io.ReadCloser, int64, error) {
+	imageURL := fmt.Sprintf("%simages/%s/layer", registry, imgID)
+	req, err := r.reqFactory.NewRequest("GET", imageURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error while getting from the server: %s\n", err)
+	}
+	setTokenAuth(req, token)
+	res, start, err := r.doRangeRequest(req, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.StatusCode != 200 && res.StatusCode != 206 {
+		res.Body.Close()
+		return nil, 0, fmt.Errorf("Server error: Status %d while fetching image layer (%s)",
+			res.StatusCode, imgID)
+	}
+	return res.Body, start, nil
+}
+
 func (r *Session) GetRemoteTags(registries []string, repository string, token []string) (map[string]string, error) {
 	if strings.Count(repository, "/") == 0 {
 		// This will be removed once the Registry supports auto-resolution on
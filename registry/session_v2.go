@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/registry/v2"
@@ -45,6 +48,33 @@ func (r *Session) V2RegistryEndpoint(index *IndexInfo) (ep *Endpoint, err error)
 	return
 }
 
+// doAuthorizedRequest authorizes and sends req.
+func (r *Session) doAuthorizedRequest(req *http.Request, auth *RequestAuthorization) (*http.Response, error) {
+	res, _, err := r.doAuthorizedRangeRequest(req, auth, 0)
+	return res, err
+}
+
+// doAuthorizedRangeRequest authorizes req and sends it with doRangeRequest. If
+// the registry rejects the bearer token, e.g. because it was revoked, the
+// token is dropped from the cache and req is sent once more with a new one,
+// unless it has a body which cannot be replayed.
+func (r *Session) doAuthorizedRangeRequest(req *http.Request, auth *RequestAuthorization, offset int64) (*http.Response, int64, error) {
+	if err := auth.Authorize(req); err != nil {
+		return nil, 0, err
+	}
+	res, start, err := r.doRangeRequest(req, offset)
+	if err != nil || res.StatusCode != 401 || !auth.invalidate(req) || req.Body != nil {
+		return res, start, err
+	}
+	res.Body.Close()
+
+	log.Debugf("[registry] Token rejected by %s, retrying with a new one", req.URL.Host)
+	if err := auth.Authorize(req); err != nil {
+		return nil, 0, err
+	}
+	return r.doRangeRequest(req, offset)
+}
+
 // GetV2Authorization gets the authorization needed to the given image
 // If readonly access is requested, then only the authorization may
 // only be used for Get operations.
@@ -58,6 +88,15 @@ func (r *Session) GetV2Authorization(ep *Endpoint, imageName string, readOnly bo
 	return NewRequestAuthorization(r.GetAuthConfig(true), ep, "repository", imageName, scopes), nil
 }
 
+// GetV2DeleteAuthorization gets the authorization needed to resolve tags of
+// the given image and delete its manifests.
+func (r *Session) GetV2DeleteAuthorization(ep *Endpoint, imageName string) (auth *RequestAuthorization, err error) {
+	scopes := []string{"pull", "delete"}
+
+	log.Debugf("Getting authorization for %s %s", imageName, scopes)
+	return NewRequestAuthorization(r.GetAuthConfig(true), ep, "repository", imageName, scopes), nil
+}
+
 //
 // 1) Check if TarSum of each layer exists /v2/
 //  1.a) if 200, continue
@@ -78,10 +117,12 @@ func (r *Session) GetV2ImageManifest(ep *Endpoint, imageName, tagName string, au
 	if err != nil {
 		return nil, "", err
 	}
-	if err := auth.Authorize(req); err != nil {
-		return nil, "", err
-	}
-	res, _, err := r.doRequest(req)
+	// Registries only return manifest lists to clients that accept them
+	req.Header.Add("Accept", MediaTypeManifestList)
+	req.Header.Add("Accept", MediaTypeManifest)
+	req.Header.Add("Accept", MediaTypeSignedManifest)
+	req.Header.Add("Accept", "application/json")
+	res, err := r.doAuthorizedRequest(req, auth)
 	if err != nil {
 		return nil, "", err
 	}
@@ -106,9 +147,16 @@ func (r *Session) GetV2ImageManifest(ep *Endpoint, imageName, tagName string, au
 // - Failed with no error (continue to Push the Blob)
 // - Failed with error
 func (r *Session) HeadV2ImageBlob(ep *Endpoint, imageName, sumType, sum string, auth *RequestAuthorization) (bool, error) {
+	exists, _, err := r.StatV2ImageBlob(ep, imageName, sumType, sum, auth)
+	return exists, err
+}
+
+// StatV2ImageBlob is like HeadV2ImageBlob, and also returns the size of the
+// blob if it exists, or -1 if the registry does not tell.
+func (r *Session) StatV2ImageBlob(ep *Endpoint, imageName, sumType, sum string, auth *RequestAuthorization) (bool, int64, error) {
 	routeURL, err := getV2Builder(ep).BuildBlobURL(imageName, sumType+":"+sum)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 
 	method := "HEAD"
@@ -116,28 +164,25 @@ func (r *Session) HeadV2ImageBlob(ep *Endpoint, imageName, sumType, sum string,
 
 	req, err := r.reqFactory.NewRequest(method, routeURL, nil)
 	if err != nil {
-		return false, err
-	}
-	if err := auth.Authorize(req); err != nil {
-		return false, err
+		return false, 0, err
 	}
-	res, _, err := r.doRequest(req)
+	res, err := r.doAuthorizedRequest(req, auth)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 	res.Body.Close() // close early, since we're not needing a body on this call .. yet?
 	switch {
 	case res.StatusCode >= 200 && res.StatusCode < 400:
 		// return something indicating no push needed
-		return true, nil
+		return true, res.ContentLength, nil
 	case res.StatusCode == 401:
-		return false, errLoginRequired
+		return false, 0, errLoginRequired
 	case res.StatusCode == 404:
 		// return something indicating blob push needed
-		return false, nil
+		return false, 0, nil
 	}
 
-	return false, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying head request for %s - %s:%s", res.StatusCode, imageName, sumType, sum), res)
+	return false, 0, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying head request for %s - %s:%s", res.StatusCode, imageName, sumType, sum), res)
 }
 
 func (r *Session) GetV2ImageBlob(ep *Endpoint, imageName, sumType, sum string, blobWrtr io.Writer, auth *RequestAuthorization) error {
@@ -152,10 +197,7 @@ func (r *Session) GetV2ImageBlob(ep *Endpoint, imageName, sumType, sum string, b
 	if err != nil {
 		return err
 	}
-	if err := auth.Authorize(req); err != nil {
-		return err
-	}
-	res, _, err := r.doRequest(req)
+	res, err := r.doAuthorizedRequest(req, auth)
 	if err != nil {
 		return err
 	}
@@ -172,37 +214,54 @@ func (r *Session) GetV2ImageBlob(ep *Endpoint, imageName, sumType, sum string, b
 }
 
 func (r *Session) GetV2ImageBlobReader(ep *Endpoint, imageName, sumType, sum string, auth *RequestAuthorization) (io.ReadCloser, int64, error) {
+	body, _, size, err := r.GetV2ImageBlobReaderAt(ep, imageName, sumType, sum, 0, auth)
+	return body, size, err
+}
+
+// GetV2ImageBlobReaderAt returns a reader for a blob from offset on, the
+// offset it starts at, which is 0 if the registry cannot resume downloads,
+// and the size of the whole blob.
+func (r *Session) GetV2ImageBlobReaderAt(ep *Endpoint, imageName, sumType, sum string, offset int64, auth *RequestAuthorization) (io.ReadCloser, int64, int64, error) {
 	routeURL, err := getV2Builder(ep).BuildBlobURL(imageName, sumType+":"+sum)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, 0, err
 	}
 
 	method := "GET"
 	log.Debugf("[registry] Calling %q %s", method, routeURL)
 	req, err := r.reqFactory.NewRequest(method, routeURL, nil)
 	if err != nil {
-		return nil, 0, err
-	}
-	if err := auth.Authorize(req); err != nil {
-		return nil, 0, err
+		return nil, 0, 0, err
 	}
-	res, _, err := r.doRequest(req)
+	res, start, err := r.doAuthorizedRangeRequest(req, auth, offset)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, 0, err
 	}
-	if res.StatusCode != 200 {
+	if res.StatusCode != 200 && res.StatusCode != 206 {
+		res.Body.Close()
 		if res.StatusCode == 401 {
-			return nil, 0, errLoginRequired
+			return nil, 0, 0, errLoginRequired
+		}
+		return nil, 0, 0, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to pull %s blob - %s:%s", res.StatusCode, imageName, sumType, sum), res)
+	}
+	if res.StatusCode == 206 {
+		if contentRange := res.Header.Get("Content-Range"); contentRange != "" {
+			_, size, err := parseContentRange(contentRange)
+			if err != nil {
+				res.Body.Close()
+				return nil, 0, 0, err
+			}
+			return res.Body, start, size, nil
 		}
-		return nil, 0, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to pull %s blob - %s:%s", res.StatusCode, imageName, sumType, sum), res)
 	}
 	lenStr := res.Header.Get("Content-Length")
 	l, err := strconv.ParseInt(lenStr, 10, 64)
 	if err != nil {
-		return nil, 0, err
+		res.Body.Close()
+		return nil, 0, 0, err
 	}
 
-	return res.Body, l, err
+	return res.Body, start, start + l, nil
 }
 
 // Push the image to the server for storage.
@@ -220,10 +279,7 @@ func (r *Session) PutV2ImageBlob(ep *Endpoint, imageName, sumType, sumStr string
 		return err
 	}
 
-	if err := auth.Authorize(req); err != nil {
-		return err
-	}
-	res, _, err := r.doRequest(req)
+	res, err := r.doAuthorizedRequest(req, auth)
 	if err != nil {
 		return err
 	}
@@ -238,10 +294,7 @@ func (r *Session) PutV2ImageBlob(ep *Endpoint, imageName, sumType, sumStr string
 	queryParams := req.URL.Query()
 	queryParams.Add("digest", sumType+":"+sumStr)
 	req.URL.RawQuery = queryParams.Encode()
-	if err := auth.Authorize(req); err != nil {
-		return err
-	}
-	res, _, err = r.doRequest(req)
+	res, err = r.doAuthorizedRequest(req, auth)
 	if err != nil {
 		return err
 	}
@@ -262,8 +315,69 @@ func (r *Session) PutV2ImageBlob(ep *Endpoint, imageName, sumType, sumStr string
 	return nil
 }
 
+// MountV2ImageBlob asks the registry to make the blob of fromName available
+// in imageName without uploading it again. It returns false if the registry
+// did not mount the blob, in which case it has to be uploaded.
+func (r *Session) MountV2ImageBlob(ep *Endpoint, imageName, fromName, sumType, sumStr string, auth *RequestAuthorization) (bool, error) {
+	routeURL, err := getV2Builder(ep).BuildBlobUploadURL(imageName, url.Values{
+		"mount": {sumType + ":" + sumStr},
+		"from":  {fromName},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	log.Debugf("[registry] Calling %q %s", "POST", routeURL)
+	req, err := r.reqFactory.NewRequest("POST", routeURL, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := r.doAuthorizedRequest(req, auth)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case 201:
+		return true, nil
+	case 202:
+		// The registry could not mount the blob and started an upload
+		// instead, which is not needed if the mount is retried elsewhere.
+		if location := res.Header.Get("Location"); location != "" {
+			if req, err := r.reqFactory.NewRequest("DELETE", location, nil); err == nil {
+				if res, err := r.doAuthorizedRequest(req, auth); err == nil {
+					res.Body.Close()
+				}
+			}
+		}
+		return false, nil
+	case 401:
+		return false, errLoginRequired
+	case 404:
+		return false, nil
+	}
+	return false, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to mount %s blob from %s - %s:%s", res.StatusCode, imageName, fromName, sumType, sumStr), res)
+}
+
 // Finally Push the (signed) manifest of the blobs we've just pushed
 func (r *Session) PutV2ImageManifest(ep *Endpoint, imageName, tagName string, manifestRdr io.Reader, auth *RequestAuthorization) (string, error) {
+	return r.putV2Manifest(ep, imageName, tagName, MediaTypeSignedManifest, manifestRdr, auth)
+}
+
+// PutV2Schema2Manifest pushes a schema 2 manifest, whose config and layers
+// must already be in the repository.
+func (r *Session) PutV2Schema2Manifest(ep *Endpoint, imageName, tagName string, manifestRdr io.Reader, auth *RequestAuthorization) (string, error) {
+	return r.putV2Manifest(ep, imageName, tagName, MediaTypeManifest, manifestRdr, auth)
+}
+
+// PutV2ManifestList pushes a manifest list, whose manifests must already be
+// in the repository.
+func (r *Session) PutV2ManifestList(ep *Endpoint, imageName, tagName string, listRdr io.Reader, auth *RequestAuthorization) (string, error) {
+	return r.putV2Manifest(ep, imageName, tagName, MediaTypeManifestList, listRdr, auth)
+}
+
+func (r *Session) putV2Manifest(ep *Endpoint, imageName, tagName, mediaType string, manifestRdr io.Reader, auth *RequestAuthorization) (string, error) {
 	routeURL, err := getV2Builder(ep).BuildManifestURL(imageName, tagName)
 	if err != nil {
 		return "", err
@@ -275,10 +389,8 @@ func (r *Session) PutV2ImageManifest(ep *Endpoint, imageName, tagName string, ma
 	if err != nil {
 		return "", err
 	}
-	if err := auth.Authorize(req); err != nil {
-		return "", err
-	}
-	res, _, err := r.doRequest(req)
+	req.Header.Set("Content-Type", mediaType)
+	res, err := r.doAuthorizedRequest(req, auth)
 	if err != nil {
 		return "", err
 	}
@@ -300,47 +412,170 @@ func (r *Session) PutV2ImageManifest(ep *Endpoint, imageName, tagName string, ma
 	return res.Header.Get(DockerDigestHeader), nil
 }
 
-type remoteTags struct {
-	name string
-	tags []string
-}
-
-// Given a repository name, returns a json array of string tags
-func (r *Session) GetV2RemoteTags(ep *Endpoint, imageName string, auth *RequestAuthorization) ([]string, error) {
-	routeURL, err := getV2Builder(ep).BuildTagsURL(imageName)
+// DeleteV2ImageManifest deletes the manifest with the given digest from the
+// repository, which also removes the tags referring to it. Registries only
+// delete manifests by digest, and only if deletes were enabled.
+func (r *Session) DeleteV2ImageManifest(ep *Endpoint, imageName, digest string, auth *RequestAuthorization) error {
+	routeURL, err := getV2Builder(ep).BuildManifestURL(imageName, digest)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	method := "GET"
+	method := "DELETE"
 	log.Debugf("[registry] Calling %q %s", method, routeURL)
 
 	req, err := r.reqFactory.NewRequest(method, routeURL, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if err := auth.Authorize(req); err != nil {
+	res, err := r.doAuthorizedRequest(req, auth)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case 200, 202:
+		return nil
+	case 401:
+		return errLoginRequired
+	case 404:
+		return ErrDoesNotExist
+	case 405:
+		return ErrDeleteNotAllowed
+	}
+	errBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Unexpected response from server: %q %#v", errBody, res.Header)
+	return utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to delete %s@%s", res.StatusCode, imageName, digest), res)
+}
+
+// v2PageSize is the number of entries requested per page of the lists of
+// repositories and tags.
+const v2PageSize = 100
+
+type remoteCatalog struct {
+	Repositories []string `json:"repositories"`
+}
+
+type remoteTags struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// GetV2CatalogAuthorization gets the authorization needed to list the
+// repositories of the registry.
+func (r *Session) GetV2CatalogAuthorization(ep *Endpoint) (auth *RequestAuthorization, err error) {
+	log.Debugf("Getting authorization for the catalog of %s", ep)
+	return NewRequestAuthorization(r.GetAuthConfig(true), ep, "registry", "catalog", []string{"*"}), nil
+}
+
+// GetV2Catalog returns the names of the repositories of the registry, from all
+// the pages of its catalog.
+func (r *Session) GetV2Catalog(ep *Endpoint, auth *RequestAuthorization) ([]string, error) {
+	routeURL, err := getV2Builder(ep).BuildCatalogURL(url.Values{"n": {strconv.Itoa(v2PageSize)}})
+	if err != nil {
 		return nil, err
 	}
-	res, _, err := r.doRequest(req)
+
+	var repositories []string
+	err = r.getV2List(routeURL, auth, "the catalog", func(body io.Reader) error {
+		var page remoteCatalog
+		if err := json.NewDecoder(body).Decode(&page); err != nil {
+			return err
+		}
+		repositories = append(repositories, page.Repositories...)
+		return nil
+	})
+	return repositories, err
+}
+
+// Given a repository name, returns a json array of string tags
+func (r *Session) GetV2RemoteTags(ep *Endpoint, imageName string, auth *RequestAuthorization) ([]string, error) {
+	routeURL, err := getV2Builder(ep).BuildTagsURL(imageName, url.Values{"n": {strconv.Itoa(v2PageSize)}})
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		if res.StatusCode == 401 {
-			return nil, errLoginRequired
-		} else if res.StatusCode == 404 {
-			return nil, ErrDoesNotExist
+
+	var tags []string
+	err = r.getV2List(routeURL, auth, imageName, func(body io.Reader) error {
+		var page remoteTags
+		if err := json.NewDecoder(body).Decode(&page); err != nil {
+			return err
+		}
+		tags = append(tags, page.Tags...)
+		return nil
+	})
+	return tags, err
+}
+
+// getV2List fetches routeURL and the following pages of the list it returns,
+// decoding each page with decode. Registries link to the next page with a
+// Link header, and leave it out on the last page.
+func (r *Session) getV2List(routeURL string, auth *RequestAuthorization, what string, decode func(io.Reader) error) error {
+	for routeURL != "" {
+		method := "GET"
+		log.Debugf("[registry] Calling %q %s", method, routeURL)
+
+		req, err := r.reqFactory.NewRequest(method, routeURL, nil)
+		if err != nil {
+			return err
+		}
+		res, err := r.doAuthorizedRequest(req, auth)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != 200 {
+			res.Body.Close()
+			if res.StatusCode == 401 {
+				return errLoginRequired
+			} else if res.StatusCode == 404 {
+				return ErrDoesNotExist
+			}
+			return utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to fetch for %s", res.StatusCode, what), res)
+		}
+		err = decode(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("Error while decoding the http response: %s", err)
 		}
-		return nil, utils.NewHTTPRequestError(fmt.Sprintf("Server error: %d trying to fetch for %s", res.StatusCode, imageName), res)
+
+		next, err := nextPageURL(req.URL, res.Header)
+		if err != nil {
+			return err
+		}
+		if next == routeURL {
+			return fmt.Errorf("The registry links %s to itself as its next page", routeURL)
+		}
+		routeURL = next
 	}
+	return nil
+}
 
-	decoder := json.NewDecoder(res.Body)
-	var remote remoteTags
-	err = decoder.Decode(&remote)
-	if err != nil {
-		return nil, fmt.Errorf("Error while decoding the http response: %s", err)
+// nextPageURL returns the URL of the next page of a paginated list, from a
+// Link header such as `</v2/_catalog?last=b&n=2>; rel="next"`, or "" on the
+// last page.
+func nextPageURL(base *url.URL, header http.Header) (string, error) {
+	for _, value := range header[http.CanonicalHeaderKey("Link")] {
+		for _, link := range strings.Split(value, ",") {
+			params := strings.Split(link, ";")
+			target := strings.TrimSpace(params[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range params[1:] {
+				param = strings.Replace(strings.TrimSpace(param), " ", "", -1)
+				if param != `rel="next"` && param != "rel=next" {
+					continue
+				}
+				u, err := url.Parse(target[1 : len(target)-1])
+				if err != nil {
+					return "", fmt.Errorf("Invalid Link header %q: %s", value, err)
+				}
+				return base.ResolveReference(u).String(), nil
+			}
+		}
 	}
-	return remote.tags, nil
+	return "", nil
 }
@@ -0,0 +1,213 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/registry/v2"
+	"github.com/docker/docker/utils"
+)
+
+func newTestV2Session(t *testing.T, serverURL string) (*Session, *Endpoint) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoint{URL: u, Version: APIVersion2, URLBuilder: v2.NewURLBuilder(u)}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Session{
+		authConfig:    &AuthConfig{},
+		reqFactory:    utils.NewHTTPRequestFactory(),
+		indexEndpoint: ep,
+		jar:           jar,
+	}
+	return r, ep
+}
+
+func TestGetV2CatalogPages(t *testing.T) {
+	pages := map[string]string{
+		"":    `{"repositories": ["a", "b"]}`,
+		"b":   `{"repositories": ["c", "d"]}`,
+		"d":   `{"repositories": ["e"]}`,
+		"bar": `{"name": "foo/bar", "tags": ["1.0", "latest"]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last := r.URL.Query().Get("last")
+		switch r.URL.Path {
+		case "/v2/_catalog":
+		case "/v2/foo/bar/tags/list":
+			last = "bar"
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		page, exists := pages[last]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if next := map[string]string{"": "b", "b": "d"}[last]; next != "" {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=2>; rel="next"`, next))
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	r, ep := newTestV2Session(t, server.URL)
+	auth, err := r.GetV2CatalogAuthorization(ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repositories, err := r.GetV2Catalog(ep, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(repositories, expected) {
+		t.Fatalf("Expected %v, got %v", expected, repositories)
+	}
+
+	auth, err = r.GetV2Authorization(ep, "foo/bar", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := r.GetV2RemoteTags(ep, "foo/bar", auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"1.0", "latest"}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("Expected %v, got %v", expected, tags)
+	}
+	if _, err := r.GetV2RemoteTags(ep, "foo/unknown", auth); err != ErrDoesNotExist {
+		t.Fatalf("Expected ErrDoesNotExist, got %v", err)
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	base, err := url.Parse("https://registry.example.com/v2/_catalog?n=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ header, expected string }{
+		{"", ""},
+		{`</v2/_catalog?last=b&n=2>; rel="next"`, "https://registry.example.com/v2/_catalog?last=b&n=2"},
+		{`<https://other.example.com/v2/_catalog?last=b>;rel=next`, "https://other.example.com/v2/_catalog?last=b"},
+		{`</v2/_catalog?n=2>; rel="first", </v2/_catalog?last=d&n=2>; rel="next"`, "https://registry.example.com/v2/_catalog?last=d&n=2"},
+		{`</v2/_catalog?last=b&n=2>; rel="prev"`, ""},
+	} {
+		h := http.Header{}
+		if c.header != "" {
+			h.Set("Link", c.header)
+		}
+		next, err := nextPageURL(base, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != c.expected {
+			t.Fatalf("Expected %q for %q, got %q", c.expected, c.header, next)
+		}
+	}
+}
+
+func TestDeleteV2ImageManifest(t *testing.T) {
+	var (
+		status  int
+		deleted string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+		deleted = r.URL.Path
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	r, ep := newTestV2Session(t, server.URL)
+	auth, err := r.GetV2DeleteAuthorization(ep, "foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := "sha256:" + imageID
+	for _, c := range []struct {
+		status int
+		err    error
+	}{
+		{http.StatusAccepted, nil},
+		{http.StatusNotFound, ErrDoesNotExist},
+		{http.StatusMethodNotAllowed, ErrDeleteNotAllowed},
+		{http.StatusUnauthorized, errLoginRequired},
+	} {
+		status = c.status
+		if err := r.DeleteV2ImageManifest(ep, "foo/bar", digest, auth); err != c.err {
+			t.Fatalf("Expected %v for status %d, got %v", c.err, c.status, err)
+		}
+		if expected := "/v2/foo/bar/manifests/" + digest; deleted != expected {
+			t.Fatalf("Expected %s to be deleted, got %s", expected, deleted)
+		}
+	}
+}
+
+func TestMountV2ImageBlob(t *testing.T) {
+	var (
+		status    int
+		cancelled bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			if r.URL.Path != "/v2/foo/bar/blobs/uploads/" {
+				t.Errorf("Unexpected upload path %s", r.URL.Path)
+			}
+			if from := r.URL.Query().Get("from"); from != "foo/base" {
+				t.Errorf("Expected to mount from foo/base, got %q", from)
+			}
+			if status == http.StatusAccepted {
+				w.Header().Set("Location", "http://"+r.Host+"/v2/foo/bar/blobs/uploads/1234")
+			}
+			w.WriteHeader(status)
+		case "DELETE":
+			cancelled = r.URL.Path == "/v2/foo/bar/blobs/uploads/1234"
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected %s request", r.Method)
+		}
+	}))
+	defer server.Close()
+
+	r, ep := newTestV2Session(t, server.URL)
+	auth, err := r.GetV2Authorization(ep, "foo/bar", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		status  int
+		mounted bool
+		err     error
+	}{
+		{http.StatusCreated, true, nil},
+		{http.StatusAccepted, false, nil},
+		{http.StatusNotFound, false, nil},
+		{http.StatusUnauthorized, false, errLoginRequired},
+	} {
+		status = c.status
+		mounted, err := r.MountV2ImageBlob(ep, "foo/bar", "foo/base", "sha256", imageID, auth)
+		if err != c.err {
+			t.Fatalf("Expected %v for status %d, got %v", c.err, c.status, err)
+		}
+		if mounted != c.mounted {
+			t.Fatalf("Expected mounted to be %t for status %d", c.mounted, c.status)
+		}
+	}
+	if !cancelled {
+		t.Fatal("Expected the upload started instead of the mount to be cancelled")
+	}
+}
@@ -7,23 +7,31 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/utils"
 )
 
+// defaultTokenExpiration is the lifetime of tokens whose response has no
+// expires_in.
+const defaultTokenExpiration = 60 * time.Second
+
 type tokenResponse struct {
-	Token string `json:"token"`
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
 }
 
-func getToken(username, password string, params map[string]string, registryEndpoint *Endpoint, client *http.Client, factory *utils.HTTPRequestFactory) (token string, err error) {
+// getToken requests a token for the scope of params from the authorization
+// server of a bearer challenge. It returns the token and its lifetime.
+func getToken(username, password string, params map[string]string, registryEndpoint *Endpoint, client *http.Client, factory *utils.HTTPRequestFactory) (token string, expiresIn time.Duration, err error) {
 	realm, ok := params["realm"]
 	if !ok {
-		return "", errors.New("no realm specified for token auth challenge")
+		return "", 0, errors.New("no realm specified for token auth challenge")
 	}
 
 	realmURL, err := url.Parse(realm)
 	if err != nil {
-		return "", fmt.Errorf("invalid token auth challenge realm: %s", err)
+		return "", 0, fmt.Errorf("invalid token auth challenge realm: %s", err)
 	}
 
 	if realmURL.Scheme == "" {
@@ -36,7 +44,7 @@ func getToken(username, password string, params map[string]string, registryEndpo
 
 	req, err := factory.NewRequest("GET", realmURL.String(), nil)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	reqParams := req.URL.Query()
@@ -60,24 +68,28 @@ func getToken(username, password string, params map[string]string, registryEndpo
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("token auth attempt for registry %s: %s request failed with status: %d %s", registryEndpoint, req.URL, resp.StatusCode, http.StatusText(resp.StatusCode))
+		return "", 0, fmt.Errorf("token auth attempt for registry %s: %s request failed with status: %d %s", registryEndpoint, req.URL, resp.StatusCode, http.StatusText(resp.StatusCode))
 	}
 
 	decoder := json.NewDecoder(resp.Body)
 
 	tr := new(tokenResponse)
 	if err = decoder.Decode(tr); err != nil {
-		return "", fmt.Errorf("unable to decode token response: %s", err)
+		return "", 0, fmt.Errorf("unable to decode token response: %s", err)
 	}
 
 	if tr.Token == "" {
-		return "", errors.New("authorization server did not include a token in the response")
+		return "", 0, errors.New("authorization server did not include a token in the response")
 	}
 
-	return tr.Token, nil
+	expiresIn = defaultTokenExpiration
+	if tr.ExpiresIn > 0 {
+		expiresIn = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	return tr.Token, expiresIn, nil
 }
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// tokenCacheKey identifies the tokens an authorization server issued for a
+// scope and a set of credentials.
+type tokenCacheKey struct {
+	realm   string
+	service string
+	scope   string
+	// credentials is a digest of the username and password, so clients
+	// sharing a daemon never get each other's tokens.
+	credentials string
+}
+
+func newTokenCacheKey(params map[string]string, authConfig *AuthConfig) tokenCacheKey {
+	sum := sha256.Sum256([]byte(authConfig.Username + ":" + authConfig.Password))
+	return tokenCacheKey{
+		realm:       params["realm"],
+		service:     params["service"],
+		scope:       params["scope"],
+		credentials: hex.EncodeToString(sum[:]),
+	}
+}
+
+type cachedToken struct {
+	token      string
+	expiration time.Time
+}
+
+// tokenCache keeps bearer tokens until shortly before they expire, so the
+// sessions of concurrent pulls and pushes share them.
+type tokenCache struct {
+	sync.Mutex
+	tokens map[tokenCacheKey]cachedToken
+}
+
+// tokens is the cache of the daemon.
+var tokens = newTokenCache()
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{tokens: make(map[tokenCacheKey]cachedToken)}
+}
+
+// get returns the token of key, if it is still valid.
+func (c *tokenCache) get(key tokenCacheKey) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	t, exists := c.tokens[key]
+	if !exists {
+		return "", false
+	}
+	if !time.Now().Before(t.expiration) {
+		delete(c.tokens, key)
+		return "", false
+	}
+	return t.token, true
+}
+
+// add caches token for key. It is dropped when a tenth of its lifetime is
+// left, so requests don't race with its expiration.
+func (c *tokenCache) add(key tokenCacheKey, token string, expiresIn time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	for k, t := range c.tokens {
+		if !now.Before(t.expiration) {
+			delete(c.tokens, k)
+		}
+	}
+	c.tokens[key] = cachedToken{
+		token:      token,
+		expiration: now.Add(expiresIn - expiresIn/10),
+	}
+}
+
+// remove drops token from the cache, e.g. after the registry rejected it.
+// A newer token for key is kept.
+func (c *tokenCache) remove(key tokenCacheKey, token string) {
+	c.Lock()
+	defer c.Unlock()
+	if t, exists := c.tokens[key]; exists && t.token == token {
+		delete(c.tokens, key)
+	}
+}
@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/registry/v2"
+	"github.com/docker/docker/utils"
+)
+
+func TestTokenCache(t *testing.T) {
+	c := newTokenCache()
+	key := newTokenCacheKey(map[string]string{"realm": "https://auth.example.com/token", "scope": "repository:foo:pull"}, &AuthConfig{})
+	if _, ok := c.get(key); ok {
+		t.Fatal("Expected no token in an empty cache")
+	}
+
+	c.add(key, "token", time.Minute)
+	if token, ok := c.get(key); !ok || token != "token" {
+		t.Fatalf("Expected the cached token, got %q", token)
+	}
+	other := newTokenCacheKey(map[string]string{"realm": "https://auth.example.com/token", "scope": "repository:foo:pull"}, &AuthConfig{Username: "user", Password: "pass"})
+	if _, ok := c.get(other); ok {
+		t.Fatal("Expected tokens not to be shared between credentials")
+	}
+
+	// Rejecting an older token keeps the current one
+	c.remove(key, "old-token")
+	if _, ok := c.get(key); !ok {
+		t.Fatal("Expected the token to be kept")
+	}
+	c.remove(key, "token")
+	if _, ok := c.get(key); ok {
+		t.Fatal("Expected the token to be removed")
+	}
+
+	c.add(key, "token", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := c.get(key); ok {
+		t.Fatal("Expected the token to expire")
+	}
+}
+
+// testTokenServer issues tokens for a registry which only accepts the last
+// one issued.
+type testTokenServer struct {
+	sync.Mutex
+	issued    int
+	scopes    []string
+	expiresIn int
+}
+
+func (s *testTokenServer) current() string {
+	return fmt.Sprintf("token-%d", s.issued)
+}
+
+func (s *testTokenServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.issued++
+	s.scopes = r.URL.Query()["scope"]
+	fmt.Fprintf(w, `{"token": %q, "expires_in": %d}`, s.current(), s.expiresIn)
+}
+
+func (s *testTokenServer) serveRegistry(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if r.Header.Get("Authorization") != "Bearer "+s.current() {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestRequestAuthorizationTokenCache(t *testing.T) {
+	s := &testTokenServer{expiresIn: 300}
+	tokenServer := httptest.NewServer(http.HandlerFunc(s.serveToken))
+	defer tokenServer.Close()
+	registryServer := httptest.NewServer(http.HandlerFunc(s.serveRegistry))
+	defer registryServer.Close()
+
+	u, err := url.Parse(registryServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &Endpoint{
+		URL:     u,
+		Version: APIVersion2,
+		AuthChallenges: []*AuthorizationChallenge{
+			{Scheme: "bearer", Parameters: map[string]string{"realm": tokenServer.URL, "service": "registry.test"}},
+		},
+		URLBuilder: v2.NewURLBuilder(u),
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Session{
+		authConfig:    &AuthConfig{},
+		reqFactory:    utils.NewHTTPRequestFactory(),
+		indexEndpoint: ep,
+		jar:           jar,
+	}
+
+	stat := func(auth *RequestAuthorization) {
+		exists, _, err := r.StatV2ImageBlob(ep, "foo", "sha256", "abc", auth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatal("Expected the blob to exist")
+		}
+	}
+
+	// Sessions for the same scope share the token
+	stat(NewRequestAuthorization(r.authConfig, ep, "repository", "foo", []string{"pull"}))
+	stat(NewRequestAuthorization(r.authConfig, ep, "repository", "foo", []string{"pull"}))
+	if s.issued != 1 {
+		t.Fatalf("Expected 1 token request, got %d", s.issued)
+	}
+
+	// A revoked token is refreshed
+	s.Lock()
+	s.issued++
+	s.Unlock()
+	stat(NewRequestAuthorization(r.authConfig, ep, "repository", "foo", []string{"pull"}))
+	if s.issued != 3 {
+		t.Fatalf("Expected a new token after a 401, got %d requests", s.issued-1)
+	}
+
+	// Scopes of several repositories are requested together
+	auth := NewRequestAuthorization(r.authConfig, ep, "repository", "foo", []string{"pull", "push"})
+	stat(auth.WithScope("repository", "bar", []string{"pull"}))
+	if expected := []string{"repository:bar:pull", "repository:foo:pull,push"}; !reflect.DeepEqual(s.scopes, expected) {
+		t.Fatalf("Expected scopes %v, got %v", expected, s.scopes)
+	}
+	// The extra scope is not added to the authorization it was made from
+	stat(auth)
+	if expected := []string{"repository:foo:pull,push"}; !reflect.DeepEqual(s.scopes, expected) {
+		t.Fatalf("Expected scopes %v, got %v", expected, s.scopes)
+	}
+}
@@ -1,5 +1,7 @@
 package registry
 
+import "encoding/json"
+
 type SearchResult struct {
 	StarCount   int    `json:"star_count"`
 	IsOfficial  bool   `json:"is_official"`
@@ -49,6 +51,86 @@ type ManifestData struct {
 	SchemaVersion int                `json:"schemaVersion"`
 }
 
+const (
+	// MediaTypeSignedManifest is the media type of signed schema 1 manifests.
+	MediaTypeSignedManifest = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	// MediaTypeManifest is the media type of schema 2 manifests.
+	MediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	// MediaTypeManifestList is the media type of manifest lists, which point
+	// to a manifest per platform.
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	// MediaTypeImageConfig is the media type of the config blob of schema 2
+	// manifests.
+	MediaTypeImageConfig = "application/vnd.docker.container.image.v1+json"
+	// MediaTypeLayer is the media type of layers pushed as uncompressed tar
+	// archives.
+	MediaTypeLayer = "application/vnd.docker.image.rootfs.diff.tar"
+	// MediaTypeLayerGzip is the media type of gzipped layers.
+	MediaTypeLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Descriptor points to a blob or a manifest.
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+// Schema2Manifest describes an image by a config blob and its layers, from
+// the base layer to the top one. Unlike schema 1 manifests, it is not signed,
+// so pushing the same image again gives the same digest.
+type Schema2Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// ImageConfig is the config blob of schema 2 manifests.
+type ImageConfig struct {
+	Architecture string `json:"architecture,omitempty"`
+	OS           string `json:"os,omitempty"`
+	RootFS       RootFS `json:"rootfs"`
+	// Images holds the JSON of the image of each layer, from the base layer
+	// to the top one, like the history of schema 1 manifests.
+	Images []json.RawMessage `json:"images,omitempty"`
+}
+
+// RootFS lists the digests of the uncompressed layers of an image.
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// ManifestList is a manifest that points to the manifests of an image for
+// several platforms.
+type ManifestList struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	MediaType     string                `json:"mediaType"`
+	Manifests     []*ManifestDescriptor `json:"manifests"`
+}
+
+// ManifestDescriptor points to the manifest of an image for a platform.
+type ManifestDescriptor struct {
+	Descriptor
+	Platform Platform `json:"platform"`
+}
+
+// Platform describes the platform an image runs on.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
 type APIVersion int
 
 func (av APIVersion) String() string {
@@ -6,6 +6,7 @@ import "github.com/gorilla/mux"
 // registered. These symbols can be used to look up a route based on the name.
 const (
 	RouteNameBase            = "base"
+	RouteNameCatalog         = "catalog"
 	RouteNameManifest        = "manifest"
 	RouteNameTags            = "tags"
 	RouteNameBlob            = "blob"
@@ -14,6 +15,7 @@ const (
 )
 
 var allEndpoints = []string{
+	RouteNameCatalog,
 	RouteNameManifest,
 	RouteNameTags,
 	RouteNameBlob,
@@ -33,6 +35,11 @@ func Router() *mux.Router {
 		Path("/v2/").
 		Name(RouteNameBase)
 
+	// GET	/v2/_catalog	Catalog	Fetch the names of the repositories in the registry.
+	router.
+		Path("/v2/_catalog").
+		Name(RouteNameCatalog)
+
 	// GET      /v2/<name>/manifest/<reference>	Image Manifest	Fetch the image manifest identified by name and reference where reference can be a tag or digest.
 	// PUT      /v2/<name>/manifest/<reference>	Image Manifest	Upload the image manifest identified by name and reference where reference can be a tag or digest.
 	// DELETE   /v2/<name>/manifest/<reference>	Image Manifest	Delete the image identified by name and reference where reference can be a tag or digest.
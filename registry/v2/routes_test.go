@@ -59,6 +59,11 @@ func TestRouter(t *testing.T) {
 				"reference": "bar",
 			},
 		},
+		{
+			RouteName:  RouteNameCatalog,
+			RequestURI: "/v2/_catalog",
+			Vars:       map[string]string{},
+		},
 		{
 			RouteName:  RouteNameManifest,
 			RequestURI: "/v2/foo/bar/manifests/tag",
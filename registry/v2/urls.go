@@ -62,8 +62,22 @@ func (ub *URLBuilder) BuildBaseURL() (string, error) {
 	return baseURL.String(), nil
 }
 
-// BuildTagsURL constructs a url to list the tags in the named repository.
-func (ub *URLBuilder) BuildTagsURL(name string) (string, error) {
+// BuildCatalogURL constructs a url to list the repositories in the registry,
+// including any url values.
+func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameCatalog)
+
+	catalogURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(catalogURL, values...).String(), nil
+}
+
+// BuildTagsURL constructs a url to list the tags in the named repository,
+// including any url values.
+func (ub *URLBuilder) BuildTagsURL(name string, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
 
 	tagsURL, err := route.URL("name", name)
@@ -71,7 +85,7 @@ func (ub *URLBuilder) BuildTagsURL(name string) (string, error) {
 		return "", err
 	}
 
-	return tagsURL.String(), nil
+	return appendValuesURL(tagsURL, values...).String(), nil
 }
 
 // BuildManifestURL constructs a url for the manifest identified by name and reference.
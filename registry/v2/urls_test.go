@@ -27,6 +27,20 @@ func TestURLBuilder(t *testing.T) {
 				return urlBuilder.BuildBaseURL()
 			},
 		},
+		{
+			description:  "test catalog url",
+			expectedPath: "/v2/_catalog",
+			build: func() (string, error) {
+				return urlBuilder.BuildCatalogURL()
+			},
+		},
+		{
+			description:  "test catalog url with page size",
+			expectedPath: "/v2/_catalog?n=100",
+			build: func() (string, error) {
+				return urlBuilder.BuildCatalogURL(url.Values{"n": []string{"100"}})
+			},
+		},
 		{
 			description:  "test tags url",
 			expectedPath: "/v2/foo/bar/tags/list",
@@ -34,6 +48,13 @@ func TestURLBuilder(t *testing.T) {
 				return urlBuilder.BuildTagsURL("foo/bar")
 			},
 		},
+		{
+			description:  "test tags url with page size",
+			expectedPath: "/v2/foo/bar/tags/list?n=100",
+			build: func() (string, error) {
+				return urlBuilder.BuildTagsURL("foo/bar", url.Values{"n": []string{"100"}})
+			},
+		},
 		{
 			description:  "test manifest url",
 			expectedPath: "/v2/foo/bar/manifests/tag",
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/symlink"
+	"github.com/docker/docker/pkg/urlutil"
+)
+
+// GitClone clones the repository at remoteURL into a new temporary
+// directory and returns that directory along with the path of the build
+// context inside it. The caller is responsible for removing the directory.
+//
+// A branch, tag or commit and a subdirectory of the repository can be
+// selected with a fragment, as in "https://host/repo.git#ref:subdir". Both
+// parts are optional: "#ref" selects only a ref, and "#:subdir" selects only
+// a subdirectory of the default branch.
+func GitClone(remoteURL string) (string, string, error) {
+	repo, ref, subdir := ParseGitURL(remoteURL)
+	if !urlutil.IsGitTransport(repo) {
+		repo = "https://" + repo
+	}
+
+	root, err := ioutil.TempDir("", "docker-build-git")
+	if err != nil {
+		return "", "", err
+	}
+
+	contextDir, err := checkoutGit(root, repo, ref, subdir)
+	if err != nil {
+		os.RemoveAll(root)
+		return "", "", err
+	}
+	return root, contextDir, nil
+}
+
+// ParseGitURL splits a git URL into the repository, the ref and the
+// subdirectory selected by its fragment.
+func ParseGitURL(remoteURL string) (repo, ref, subdir string) {
+	repo = remoteURL
+	if i := strings.LastIndex(remoteURL, "#"); i >= 0 {
+		repo = remoteURL[:i]
+		fragment := remoteURL[i+1:]
+		ref = fragment
+		if j := strings.Index(fragment, ":"); j >= 0 {
+			ref, subdir = fragment[:j], fragment[j+1:]
+		}
+	}
+	return repo, ref, subdir
+}
+
+func checkoutGit(root, repo, ref, subdir string) (string, error) {
+	// The ref is given to git, which must not take it for an option
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("Invalid git ref %q, it must not start with \"-\"", ref)
+	}
+	if strings.HasPrefix(subdir, "-") {
+		return "", fmt.Errorf("Invalid git subdirectory %q, it must not start with \"-\"", subdir)
+	}
+
+	if output, err := exec.Command("git", "clone", "--recursive", repo, root).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("Error trying to use git: %s (%s)", err, output)
+	}
+
+	if ref != "" {
+		// Branches, tags and commits reachable from them are available
+		// after the clone. Anything else, such as a pull request ref, has to
+		// be fetched first.
+		if _, err := gitWithinDir(root, "checkout", ref, "--"); err != nil {
+			if output, err := gitWithinDir(root, "fetch", "origin", "--", ref); err != nil {
+				return "", fmt.Errorf("Error fetching git ref %s: %s (%s)", ref, err, output)
+			}
+			if output, err := gitWithinDir(root, "checkout", "FETCH_HEAD"); err != nil {
+				return "", fmt.Errorf("Error checking out git ref %s: %s (%s)", ref, err, output)
+			}
+		}
+		if output, err := gitWithinDir(root, "submodule", "update", "--init", "--recursive"); err != nil {
+			return "", fmt.Errorf("Error updating git submodules: %s (%s)", err, output)
+		}
+	}
+
+	if subdir == "" {
+		return root, nil
+	}
+
+	contextDir, err := symlink.FollowSymlinkInScope(filepath.Join(root, subdir), root)
+	if err != nil {
+		return "", fmt.Errorf("Error setting git context, %q not within git root: %s", subdir, err)
+	}
+	fi, err := os.Stat(contextDir)
+	if err != nil {
+		return "", fmt.Errorf("Error setting git context, %q does not exist in the repository", subdir)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("Error setting git context, %q is not a directory", subdir)
+	}
+	return contextDir, nil
+}
+
+func gitWithinDir(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseGitURL(t *testing.T) {
+	cases := []struct {
+		url, repo, ref, subdir string
+	}{
+		{"https://github.com/docker/docker.git", "https://github.com/docker/docker.git", "", ""},
+		{"https://github.com/docker/docker.git#v1.5.0", "https://github.com/docker/docker.git", "v1.5.0", ""},
+		{"https://github.com/docker/docker.git#v1.5.0:docs", "https://github.com/docker/docker.git", "v1.5.0", "docs"},
+		{"git@github.com:docker/docker.git#:docs/sources", "git@github.com:docker/docker.git", "", "docs/sources"},
+	}
+	for _, c := range cases {
+		repo, ref, subdir := ParseGitURL(c.url)
+		if repo != c.repo || ref != c.ref || subdir != c.subdir {
+			t.Fatalf("%s: expected (%q, %q, %q), got (%q, %q, %q)", c.url, c.repo, c.ref, c.subdir, repo, ref, subdir)
+		}
+	}
+}
+
+func TestCheckoutGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo, err := ioutil.TempDir("", "docker-test-git-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		if output, err := gitWithinDir(repo, args...); err != nil {
+			t.Fatalf("git %v: %s (%s)", args, err, output)
+		}
+	}
+	git("init")
+	writeFile := func(name, content string) {
+		if err := os.MkdirAll(filepath.Join(repo, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("Dockerfile", "FROM scratch\n")
+	writeFile("sub/Dockerfile", "FROM busybox\n")
+	git("add", "-A")
+	git("commit", "-m", "first")
+	git("tag", "v1")
+	writeFile("sub/Dockerfile", "FROM busybox:latest\n")
+	git("commit", "-am", "second")
+	git("checkout", "-b", "feature")
+	writeFile("sub/Dockerfile", "FROM busybox:feature\n")
+	git("commit", "-am", "feature")
+	git("checkout", "-")
+
+	cases := []struct {
+		ref, subdir, file, content string
+	}{
+		{"", "", "Dockerfile", "FROM scratch\n"},
+		{"", "sub", "Dockerfile", "FROM busybox:latest\n"},
+		{"v1", "sub", "Dockerfile", "FROM busybox\n"},
+		{"feature", "sub", "Dockerfile", "FROM busybox:feature\n"},
+	}
+	for _, c := range cases {
+		root, err := ioutil.TempDir("", "docker-test-git-clone")
+		if err != nil {
+			t.Fatal(err)
+		}
+		contextDir, err := checkoutGit(root, repo, c.ref, c.subdir)
+		if err != nil {
+			os.RemoveAll(root)
+			t.Fatalf("#%s:%s: %v", c.ref, c.subdir, err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(contextDir, c.file))
+		os.RemoveAll(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != c.content {
+			t.Fatalf("#%s:%s: expected %q, got %q", c.ref, c.subdir, c.content, b)
+		}
+	}
+
+	// Refs and subdirectories are never passed to git as options
+	pwned := filepath.Join(repo, "pwned")
+	for _, c := range []struct{ ref, subdir string }{
+		{"--upload-pack=touch " + pwned, ""},
+		{"-b", "sub"},
+		{"", "--help"},
+	} {
+		root, err := ioutil.TempDir("", "docker-test-git-clone")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = checkoutGit(root, repo, c.ref, c.subdir)
+		os.RemoveAll(root)
+		if err == nil {
+			t.Fatalf("#%s:%s: expected an error", c.ref, c.subdir)
+		}
+		if _, err := os.Stat(pwned); err == nil {
+			t.Fatalf("#%s:%s: git ran the upload pack of the ref", c.ref, c.subdir)
+		}
+	}
+
+	for _, subdir := range []string{"nosuchdir", "Dockerfile", "../.."} {
+		root, err := ioutil.TempDir("", "docker-test-git-clone")
+		if err != nil {
+			t.Fatal(err)
+		}
+		contextDir, err := checkoutGit(root, repo, "", subdir)
+		os.RemoveAll(root)
+		if err == nil && subdir != "../.." {
+			t.Fatalf("expected an error for subdirectory %q", subdir)
+		}
+		if err == nil && contextDir != root {
+			t.Fatalf("expected %q to stay within the repository, got %s", subdir, contextDir)
+		}
+	}
+}
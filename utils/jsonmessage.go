@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/docker/docker/pkg/common"
 	"github.com/docker/docker/pkg/term"
 	"github.com/docker/docker/pkg/timeutils"
 	"github.com/docker/docker/pkg/units"
@@ -76,16 +77,58 @@ func (p *JSONProgress) String() string {
 	return pbBox + numbersBox + timeLeftBox
 }
 
+// JSONBuildStep describes the progress of a single Dockerfile instruction.
+// It is only sent by the builder when structured progress was requested.
+type JSONBuildStep struct {
+	// Event is one of "start", "container" or "end".
+	Event       string `json:"event"`
+	Step        int    `json:"step"`
+	Instruction string `json:"instruction,omitempty"`
+	// Args are the parsed arguments of the instruction, as printed after it.
+	Args     []string `json:"args,omitempty"`
+	Original string   `json:"original,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	// Trigger is set for steps run from the ONBUILD triggers of the base image.
+	Trigger     bool          `json:"trigger,omitempty"`
+	Cache       string        `json:"cache,omitempty"` // "hit" or "miss", empty when the step does not use the cache
+	ImageID     string        `json:"imageId,omitempty"`
+	ContainerID string        `json:"containerId,omitempty"`
+	Duration    time.Duration `json:"duration,omitempty"`
+}
+
+// String returns the text the builder prints for the step when structured
+// progress is not in use.
+func (s *JSONBuildStep) String() string {
+	switch s.Event {
+	case "start":
+		return fmt.Sprintf("Step %d : %s %s\n", s.Step, s.Instruction, strings.Join(s.Args, " "))
+	case "container":
+		return fmt.Sprintf(" ---> Running in %s\n", common.TruncateID(s.ContainerID))
+	case "end":
+		if s.Trigger {
+			return ""
+		}
+		str := ""
+		if s.Cache == "hit" {
+			str += " ---> Using cache\n"
+		}
+		return str + fmt.Sprintf(" ---> %s\n", common.TruncateID(s.ImageID))
+	}
+	return ""
+}
+
 type JSONMessage struct {
-	Stream          string        `json:"stream,omitempty"`
-	Status          string        `json:"status,omitempty"`
-	Progress        *JSONProgress `json:"progressDetail,omitempty"`
-	ProgressMessage string        `json:"progress,omitempty"` //deprecated
-	ID              string        `json:"id,omitempty"`
-	From            string        `json:"from,omitempty"`
-	Time            int64         `json:"time,omitempty"`
-	Error           *JSONError    `json:"errorDetail,omitempty"`
-	ErrorMessage    string        `json:"error,omitempty"` //deprecated
+	Stream          string         `json:"stream,omitempty"`
+	Stderr          string         `json:"stderr,omitempty"`
+	Status          string         `json:"status,omitempty"`
+	Progress        *JSONProgress  `json:"progressDetail,omitempty"`
+	ProgressMessage string         `json:"progress,omitempty"` //deprecated
+	ID              string         `json:"id,omitempty"`
+	From            string         `json:"from,omitempty"`
+	Time            int64          `json:"time,omitempty"`
+	BuildStep       *JSONBuildStep `json:"buildStep,omitempty"`
+	Error           *JSONError     `json:"errorDetail,omitempty"`
+	ErrorMessage    string         `json:"error,omitempty"` //deprecated
 }
 
 func (jm *JSONMessage) Display(out io.Writer, isTerminal bool) error {
@@ -95,6 +138,14 @@ func (jm *JSONMessage) Display(out io.Writer, isTerminal bool) error {
 		}
 		return jm.Error
 	}
+	if jm.BuildStep != nil {
+		fmt.Fprint(out, jm.BuildStep.String())
+		return nil
+	}
+	if jm.Stderr != "" {
+		fmt.Fprintf(out, "\033[91m%s\033[0m", jm.Stderr)
+		return nil
+	}
 	var endl string
 	if isTerminal && jm.Stream == "" && jm.Progress != nil {
 		// <ESC>[2K = erase entire current line
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -36,3 +37,27 @@ func TestProgress(t *testing.T) {
 		t.Fatalf("Expected %q, got %q", expected, jp4.String())
 	}
 }
+
+func TestDisplayBuildStep(t *testing.T) {
+	steps := []struct {
+		step     JSONBuildStep
+		expected string
+	}{
+		{JSONBuildStep{Event: "start", Step: 0, Instruction: "FROM", Args: []string{"busybox"}, Original: "from busybox"}, "Step 0 : FROM busybox\n"},
+		{JSONBuildStep{Event: "start", Step: 1, Instruction: "CMD", Args: []string{"sh", "-c"}, Original: `CMD ["sh", "-c"]`}, "Step 1 : CMD sh -c\n"},
+		{JSONBuildStep{Event: "start", Step: 2, Instruction: "ONBUILD", Args: []string{"run", "make"}, Original: "ONBUILD RUN make"}, "Step 2 : ONBUILD run make\n"},
+		{JSONBuildStep{Event: "container", ContainerID: "0123456789abcdef"}, " ---> Running in 0123456789ab\n"},
+		{JSONBuildStep{Event: "end", Cache: "hit", ImageID: "fedcba9876543210"}, " ---> Using cache\n ---> fedcba987654\n"},
+		{JSONBuildStep{Event: "end", Trigger: true, ImageID: "fedcba9876543210"}, ""},
+	}
+	for _, s := range steps {
+		out := &bytes.Buffer{}
+		jm := JSONMessage{BuildStep: &s.step}
+		if err := jm.Display(out, false); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != s.expected {
+			t.Fatalf("Expected %q, got %q", s.expected, out.String())
+		}
+	}
+}
@@ -30,6 +30,32 @@ func (sf *StreamFormatter) FormatStream(str string) []byte {
 	return []byte(str + "\r")
 }
 
+// FormatStderr formats output that a build step wrote to stderr. Without
+// JSON it is highlighted in the text stream.
+func (sf *StreamFormatter) FormatStderr(str string) []byte {
+	if sf.json {
+		b, err := json.Marshal(&JSONMessage{Stderr: str})
+		if err != nil {
+			return sf.FormatError(err)
+		}
+		return append(b, streamNewlineBytes...)
+	}
+	return []byte("\033[91m" + str + "\033[0m" + "\r")
+}
+
+// FormatBuildStep formats a structured build progress event. Without JSON
+// the text the builder would have printed for it is returned instead.
+func (sf *StreamFormatter) FormatBuildStep(step *JSONBuildStep) []byte {
+	if sf.json {
+		b, err := json.Marshal(&JSONMessage{BuildStep: step})
+		if err != nil {
+			return sf.FormatError(err)
+		}
+		return append(b, streamNewlineBytes...)
+	}
+	return []byte(step.String() + "\r")
+}
+
 func (sf *StreamFormatter) FormatStatus(id, format string, a ...interface{}) []byte {
 	str := fmt.Sprintf(format, a...)
 	if sf.json {
@@ -119,3 +145,19 @@ func (sf *StderrFormater) Write(buf []byte) (int, error) {
 	}
 	return len(buf), err
 }
+
+// StructuredStderrFormater keeps what is written to it apart from the
+// regular output stream, see FormatStderr.
+type StructuredStderrFormater struct {
+	io.Writer
+	*StreamFormatter
+}
+
+func (sf *StructuredStderrFormater) Write(buf []byte) (int, error) {
+	formattedBuf := sf.StreamFormatter.FormatStderr(string(buf))
+	n, err := sf.Writer.Write(formattedBuf)
+	if n != len(formattedBuf) {
+		return n, io.ErrShortWrite
+	}
+	return len(buf), err
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestFormatStream(t *testing.T) {
@@ -65,3 +66,41 @@ func TestFormatProgress(t *testing.T) {
 		t.Fatal("Original progress not equals progress from FormatProgress")
 	}
 }
+
+func TestFormatStderr(t *testing.T) {
+	sf := NewStreamFormatter(true)
+	res := sf.FormatStderr("oops")
+	if string(res) != `{"stderr":"oops"}`+"\r\n" {
+		t.Fatalf("%q", res)
+	}
+}
+
+func TestFormatBuildStep(t *testing.T) {
+	sf := NewStreamFormatter(true)
+	step := &JSONBuildStep{
+		Event:       "end",
+		Step:        1,
+		Instruction: "RUN",
+		Args:        []string{"true"},
+		Original:    "RUN true",
+		Line:        2,
+		Cache:       "miss",
+		ImageID:     "0123456789abcdef",
+		ContainerID: "fedcba9876543210",
+		Duration:    time.Second,
+	}
+	res := sf.FormatBuildStep(step)
+	msg := &JSONMessage{}
+	if err := json.Unmarshal(res, msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg.BuildStep, step) {
+		t.Fatalf("Original step not equals step from FormatBuildStep: %+v", msg.BuildStep)
+	}
+
+	sf = NewStreamFormatter(false)
+	res = sf.FormatBuildStep(step)
+	if string(res) != " ---> 0123456789ab\n\r" {
+		t.Fatalf("%q", res)
+	}
+}
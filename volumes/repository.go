@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/graphdriver"
@@ -68,6 +69,7 @@ func (r *Repository) newVolume(path string, writable bool) (*Volume, error) {
 		Path:        path,
 		repository:  r,
 		Writable:    writable,
+		Created:     time.Now().UTC(),
 		containers:  make(map[string]struct{}),
 		configPath:  r.configPath + "/" + id,
 		IsBindMount: isBindMount,
@@ -117,6 +119,17 @@ func (r *Repository) Get(path string) *Volume {
 	return vol
 }
 
+// List returns all the volumes in the repository.
+func (r *Repository) List() []*Volume {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	volumes := make([]*Volume, 0, len(r.volumes))
+	for _, v := range r.volumes {
+		volumes = append(volumes, v)
+	}
+	return volumes
+}
+
 func (r *Repository) get(path string) *Volume {
 	path, err := filepath.EvalSymlinks(path)
 	if err != nil {
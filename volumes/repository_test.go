@@ -85,6 +85,44 @@ func TestRepositoryGet(t *testing.T) {
 	}
 }
 
+func TestRepositoryList(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if volumes := repo.List(); len(volumes) != 0 {
+		t.Fatalf("expected no volumes, got %d", len(volumes))
+	}
+
+	v1, err := repo.FindOrCreateVolume("", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := repo.FindOrCreateVolume(filepath.Join(root, "test"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumes := repo.List()
+	if len(volumes) != 2 {
+		t.Fatalf("expected 2 volumes, got %d", len(volumes))
+	}
+	for _, v := range volumes {
+		if v != v1 && v != v2 {
+			t.Fatalf("unexpected volume %s", v.Path)
+		}
+	}
+	if v1.Created.IsZero() {
+		t.Fatalf("expected volume creation time to be set")
+	}
+}
+
 func TestRepositoryDelete(t *testing.T) {
 	root, err := ioutil.TempDir(os.TempDir(), "volumes")
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"path"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/symlink"
@@ -18,6 +19,7 @@ type Volume struct {
 	Path        string
 	IsBindMount bool
 	Writable    bool
+	Created     time.Time
 	containers  map[string]struct{}
 	configPath  string
 	repository  *Repository